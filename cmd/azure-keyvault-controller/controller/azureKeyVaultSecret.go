@@ -24,10 +24,13 @@ import (
 	"fmt"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/tools/cache"
@@ -64,14 +67,31 @@ func (c *Controller) initAzureKeyVaultSecret() {
 				return
 			}
 
-			// If akvs has not changed and has secret output, add to akv queue to check if secret has changed in akv
+			// If akvs has not changed and has secret output, add to akv queue to check if secret has changed in akv,
+			// unless already scheduled for a later refresh
 			if newAkvs.ResourceVersion == oldAkvs.ResourceVersion && c.akvsHasOutputDefined(newAkvs) {
+				key, err := cache.MetaNamespaceKeyFunc(new)
+				if err != nil {
+					utilruntime.HandleError(err)
+					return
+				}
+				if !c.scheduler.IsDue(key) {
+					klog.V(6).InfoS("azurekeyvaultsecret not due for refresh from azure key vault", "azurekeyvaultsecret", klog.KObj(newAkvs))
+					return
+				}
+
 				klog.V(4).InfoS("adding to azure key vault queue to check if secret has changed in azure key vault", "azurekeyvaultsecret", klog.KObj(newAkvs))
 				syncCounter.WithLabelValues("update", "AzureKeyVault").Inc()
 				queue.Enqueue(c.azureKeyVaultQueue.GetQueue(), new)
 				return
 			}
 
+			// Status updates made by the controller itself does not require another sync
+			if isStatusOnlyChange(oldAkvs, newAkvs) {
+				klog.V(6).InfoS("only status of azurekeyvaultsecret changed - skipping", "azurekeyvaultsecret", klog.KObj(newAkvs))
+				return
+			}
+
 			if c.akvsHasOutputDefined(newAkvs) || c.akvsHasOutputDefined(oldAkvs) {
 				klog.V(4).InfoS("azurekeyvaultsecret changed - adding to queue", "azurekeyvaultsecret", klog.KObj(newAkvs))
 				syncCounter.WithLabelValues("update", "AzureKeyVaultSecret").Inc()
@@ -90,7 +110,7 @@ func (c *Controller) initAzureKeyVaultSecret() {
 				syncCounter.WithLabelValues("delete", "AzureKeyVaultSecret").Inc()
 				queue.Enqueue(c.akvsCrdQueue.GetQueue(), obj)
 
-				err = c.deleteKubernetesValues(akvs)
+				err = c.deleteKubernetesValues(c.ctx, akvs)
 				if err != nil {
 					klog.ErrorS(err, "failed to delete secret data from azurekeyvaultsecret", "azurekeyvaultsecret", klog.KObj(akvs))
 				}
@@ -102,6 +122,7 @@ func (c *Controller) initAzureKeyVaultSecret() {
 					return
 				}
 				c.azureKeyVaultQueue.GetQueue().Forget(key)
+				c.scheduler.Forget(key)
 			}
 		},
 	})
@@ -121,7 +142,7 @@ func (c *Controller) syncDeletedAzureKeyVaultSecret(key string) error {
 
 	var outputObject metav1.Object
 	if c.akvsHasOutputSecret(akvs) {
-		secret, err := c.getOrCreateKubernetesSecret(akvs)
+		secret, err := c.getOrCreateKubernetesSecret(c.ctx, akvs)
 		if err != nil {
 			return err
 		}
@@ -132,7 +153,7 @@ func (c *Controller) syncDeletedAzureKeyVaultSecret(key string) error {
 	}
 
 	if c.akvsHasOutputConfigMap(akvs) {
-		cm, err := c.getOrCreateKubernetesConfigMap(akvs)
+		cm, err := c.getOrCreateKubernetesConfigMap(c.ctx, akvs)
 		if err != nil {
 			return err
 		}
@@ -151,9 +172,8 @@ func (c *Controller) syncDeletedAzureKeyVaultSecret(key string) error {
 	return nil
 }
 
-func (c *Controller) syncAzureKeyVaultSecret(key string) error {
+func (c *Controller) syncAzureKeyVaultSecret(key string) (err error) {
 	var akvs *akv.AzureKeyVaultSecret
-	var err error
 
 	klog.V(4).InfoS("processing azurekeyvaultsecret", "key", key)
 	if akvs, err = c.getAzureKeyVaultSecret(key); err != nil {
@@ -162,10 +182,11 @@ func (c *Controller) syncAzureKeyVaultSecret(key string) error {
 		}
 		return err
 	}
+	defer c.recordSyncFailure(akvs, &err)
 
 	var outputObject metav1.Object
 	if c.akvsHasOutputSecret(akvs) {
-		secret, err := c.getOrCreateKubernetesSecret(akvs)
+		secret, err := c.getOrCreateKubernetesSecret(c.ctx, akvs)
 		if err != nil {
 			return err
 		}
@@ -176,7 +197,7 @@ func (c *Controller) syncAzureKeyVaultSecret(key string) error {
 	}
 
 	if c.akvsHasOutputConfigMap(akvs) {
-		cm, err := c.getOrCreateKubernetesConfigMap(akvs)
+		cm, err := c.getOrCreateKubernetesConfigMap(c.ctx, akvs)
 		if err != nil {
 			return err
 		}
@@ -189,19 +210,22 @@ func (c *Controller) syncAzureKeyVaultSecret(key string) error {
 	if !isOwnedBy(outputObject, akvs) { // checks if the object has a controllerRef set to the given owner
 		msg := fmt.Sprintf(MessageResourceExists, outputObject.GetName())
 		c.recorder.Event(akvs, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf(msg)
+		return newSyncError(ReasonOutputConflict, fmt.Errorf(msg))
 	}
 
+	c.scheduleRefresh(key, akvs)
 	return nil
 }
 
-func (c *Controller) syncAzureKeyVault(key string) error {
+func (c *Controller) syncAzureKeyVault(key string) (err error) {
 	var akvs *akv.AzureKeyVaultSecret
-	var err error
 	var secretName string
 	var cmName string
 	var cmHash string
 	var secretHash string
+	var secretUpdated bool
+	var cmUpdated bool
+	var objectStatus *ObjectStatus
 
 	klog.V(4).InfoS("checking state of azurekeyvaultsecret in azure key vault", "key", key)
 	if akvs, err = c.getAzureKeyVaultSecret(key); err != nil {
@@ -210,17 +234,19 @@ func (c *Controller) syncAzureKeyVault(key string) error {
 		}
 		return err
 	}
+	defer c.recordSyncFailure(akvs, &err)
 
 	if c.akvsHasOutputSecret(akvs) {
 		klog.V(4).InfoS("getting secret value from azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
-		secretValue, err := c.getSecretFromKeyVault(akvs)
+		secretValue, secretObjectStatus, err := c.getSecretFromKeyVault(c.ctx, akvs)
 		if err != nil {
 			msg := fmt.Sprintf(FailedAzureKeyVault, akvs.Name, akvs.Spec.Vault.Name)
 			c.recorder.Event(akvs, corev1.EventTypeWarning, ErrAzureVault, msg)
-			return fmt.Errorf(msg)
+			return newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("%s, error: %+v", msg, err))
 		}
 
 		secretHash = getMD5HashOfByteValues(secretValue)
+		objectStatus = secretObjectStatus
 
 		klog.V(4).InfoS("checking if secret value has changed in azure", "azurekeyvaultsecret", klog.KObj(akvs))
 		if akvs.Status.SecretHash != secretHash {
@@ -241,7 +267,7 @@ func (c *Controller) syncAzureKeyVault(key string) error {
 			} else {
 				updatedSecret, err := createNewSecretFromExisting(akvs, secretValue, existingSecret)
 				if err != nil {
-					return fmt.Errorf("failed to update existing secret %s, error: %+v", akvs.Spec.Output.Secret.Name, err)
+					return newSyncError(ReasonOutputConflict, fmt.Errorf("failed to update existing secret %s, error: %+v", akvs.Spec.Output.Secret.Name, err))
 				}
 				secret, err := c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Update(context.TODO(), updatedSecret, metav1.UpdateOptions{})
 				if err != nil {
@@ -249,6 +275,7 @@ func (c *Controller) syncAzureKeyVault(key string) error {
 				}
 
 				secretName = secret.Name
+				secretUpdated = true
 				klog.InfoS("secret changed - any resources (like pods) using this secret must be restarted to pick up the new value - details: https://github.com/kubernetes/kubernetes/issues/22368", "azurekeyvaultsecret", klog.KObj(secret), "secret", klog.KObj(akvs))
 			}
 		}
@@ -256,14 +283,15 @@ func (c *Controller) syncAzureKeyVault(key string) error {
 
 	if c.akvsHasOutputConfigMap(akvs) {
 		klog.V(4).InfoS("getting secret value from azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
-		cmValue, err := c.getConfigMapFromKeyVault(akvs)
+		cmValue, cmObjectStatus, err := c.getConfigMapFromKeyVault(c.ctx, akvs)
 		if err != nil {
 			msg := fmt.Sprintf(FailedAzureKeyVault, akvs.Name, akvs.Spec.Vault.Name)
 			c.recorder.Event(akvs, corev1.EventTypeWarning, ErrAzureVault, msg)
-			return fmt.Errorf(msg)
+			return newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("%s, error: %+v", msg, err))
 		}
 
 		cmHash = getMD5HashOfStringValues(cmValue)
+		objectStatus = cmObjectStatus
 
 		klog.V(4).InfoS("checking if secret value has changed in azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
 		if akvs.Status.ConfigMapHash != cmHash {
@@ -283,34 +311,73 @@ func (c *Controller) syncAzureKeyVault(key string) error {
 			} else {
 				updatedCm, err := createNewConfigMapFromExisting(akvs, cmValue, existingCm)
 				if err != nil {
-					return fmt.Errorf("failed to update existing configmap %s, error: %+v", akvs.Spec.Output.ConfigMap.Name, err)
+					return newSyncError(ReasonOutputConflict, fmt.Errorf("failed to update existing configmap %s, error: %+v", akvs.Spec.Output.ConfigMap.Name, err))
 				}
 				cm, err := c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Update(context.TODO(), updatedCm, metav1.UpdateOptions{})
 				if err != nil {
 					return fmt.Errorf("failed to update configmap, error: %+v", err)
 				}
 				cmName = cm.Name
+				cmUpdated = true
 				klog.InfoS("configmap changed - any resources (like pods) using this configmap must be restarted to pick up the new value - details: https://github.com/kubernetes/kubernetes/issues/22368", "azurekeyvaultsecret", klog.KObj(akvs), "configmap", klog.KObj(cm))
 			}
 		}
 	}
 
 	klog.V(4).InfoS("updating status", "azurekeyvaultsecret", klog.KObj(akvs))
-	if err = c.updateAzureKeyVaultSecretStatus(akvs, secretName, cmName, secretHash, cmHash); err != nil {
+	if err = c.updateSyncStatus(akvs, nil, c.outputStatus(secretName, cmName, secretHash, cmHash, objectStatus)); err != nil {
 		return err
 	}
 
+	if secretUpdated {
+		if err := c.rolloutWorkloads(akvs, outputKindSecret, secretName, secretHash); err != nil {
+			klog.ErrorS(err, "failed to restart workloads using secret", "azurekeyvaultsecret", klog.KObj(akvs), "secret", klog.KRef(akvs.Namespace, secretName))
+		}
+	}
+	if cmUpdated {
+		if err := c.rolloutWorkloads(akvs, outputKindConfigMap, cmName, cmHash); err != nil {
+			klog.ErrorS(err, "failed to restart workloads using configmap", "azurekeyvaultsecret", klog.KObj(akvs), "configmap", klog.KRef(akvs.Namespace, cmName))
+		}
+	}
+
 	klog.V(4).InfoS("sync successful", "azurekeyvaultsecret", klog.KObj(akvs))
 	c.recorder.Event(akvs, corev1.EventTypeNormal, SuccessSynced, MessageAzureKeyVaultSecretSyncedWithAzureKeyVault)
+	c.scheduleRefresh(key, akvs)
 	return nil
 }
 
-func (c *Controller) deleteKubernetesValues(akvs *akv.AzureKeyVaultSecret) error {
+// recordSyncFailure updates the status of the azurekeyvaultsecret if a sync failed. A successful
+// sync updates the status together with its outputs instead.
+func (c *Controller) recordSyncFailure(akvs *akv.AzureKeyVaultSecret, syncErr *error) {
+	if *syncErr == nil {
+		return
+	}
+	if err := c.updateSyncStatus(akvs, *syncErr, nil); err != nil {
+		klog.ErrorS(err, "failed to update sync status", "azurekeyvaultsecret", klog.KObj(akvs))
+	}
+}
+
+// isStatusOnlyChange returns true if nothing but the status of the azurekeyvaultsecret has changed
+func isStatusOnlyChange(old, new *akv.AzureKeyVaultSecret) bool {
+	return old.Generation == new.Generation &&
+		old.DeletionTimestamp.Equal(new.DeletionTimestamp) &&
+		equality.Semantic.DeepEqual(old.Labels, new.Labels) &&
+		equality.Semantic.DeepEqual(old.Annotations, new.Annotations)
+}
+
+// scheduleRefresh adds the azurekeyvaultsecret to the azure key vault queue when next due for refresh
+func (c *Controller) scheduleRefresh(key string, akvs *akv.AzureKeyVaultSecret) {
+	delay := c.scheduler.Schedule(key, akvs)
+	klog.V(4).InfoS("scheduled refresh from azure key vault", "azurekeyvaultsecret", klog.KObj(akvs), "delay", delay)
+	c.azureKeyVaultQueue.GetQueue().AddAfter(key, delay)
+}
+
+func (c *Controller) deleteKubernetesValues(ctx context.Context, akvs *akv.AzureKeyVaultSecret) error {
 	if c.akvsHasOutputSecret(akvs) {
-		return c.deleteKubernetesSecretValues(akvs)
+		return c.deleteKubernetesSecretValues(ctx, akvs)
 	}
 	if c.akvsHasOutputConfigMap(akvs) {
-		return c.deleteKubernetesConfigMapValues(akvs)
+		return c.deleteKubernetesConfigMapValues(ctx, akvs)
 	}
 	return nil
 }
@@ -359,48 +426,102 @@ func (c *Controller) isOwnedByAzureKeyVaultSecret(obj metav1.Object) bool {
 	return false
 }
 
-func (c *Controller) getSecretFromKeyVault(azureKeyVaultSecret *akv.AzureKeyVaultSecret) (map[string][]byte, error) {
+func (c *Controller) getSecretFromKeyVault(ctx context.Context, azureKeyVaultSecret *akv.AzureKeyVaultSecret) (map[string][]byte, *ObjectStatus, error) {
 	var secretHandler KubernetesHandler
 
+	if azureKeyVaultSecret.Spec.Vault.Object.Selector != nil && azureKeyVaultSecret.Spec.Vault.Object.Type != akv.AzureKeyVaultObjectTypeSecret {
+		return nil, nil, fmt.Errorf("selector not supported for azure key vault object type '%s'", azureKeyVaultSecret.Spec.Vault.Object.Type)
+	}
+
+	vaultService, err := c.vaultServiceFor(azureKeyVaultSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	switch azureKeyVaultSecret.Spec.Vault.Object.Type {
 	case akv.AzureKeyVaultObjectTypeSecret:
 		transformator, err := transformers.CreateTransformator(&azureKeyVaultSecret.Spec.Output)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if azureKeyVaultSecret.Spec.Vault.Object.Selector != nil {
+			secretHandler = NewAzureSecretSelectorHandler(azureKeyVaultSecret, vaultService, *transformator, c.syncedSecretValues(azureKeyVaultSecret))
+		} else if azureKeyVaultSecret.Spec.Vault.Object.VersionHistoryLimit > 1 {
+			secretHandler = NewAzureSecretVersionsHandler(azureKeyVaultSecret, vaultService, *transformator)
+		} else {
+			secretHandler = NewAzureSecretHandler(azureKeyVaultSecret, vaultService, *transformator)
 		}
-		secretHandler = NewAzureSecretHandler(azureKeyVaultSecret, c.vaultService, *transformator)
 	case akv.AzureKeyVaultObjectTypeCertificate:
-		secretHandler = NewAzureCertificateHandler(azureKeyVaultSecret, c.vaultService)
+		secretHandler = NewAzureCertificateHandler(azureKeyVaultSecret, vaultService)
 	case akv.AzureKeyVaultObjectTypeKey:
-		secretHandler = NewAzureKeyHandler(azureKeyVaultSecret, c.vaultService)
+		secretHandler = NewAzureKeyHandler(azureKeyVaultSecret, vaultService)
 	case akv.AzureKeyVaultObjectTypeMultiKeyValueSecret:
-		secretHandler = NewAzureMultiKeySecretHandler(azureKeyVaultSecret, c.vaultService)
+		secretHandler = NewAzureMultiKeySecretHandler(azureKeyVaultSecret, vaultService)
 	default:
-		return nil, fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
+		return nil, nil, fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
 	}
-	return secretHandler.HandleSecret()
+	// the vault cache must not serve objects older than the refresh interval of the AzureKeyVaultSecret
+	return secretHandler.HandleSecret(vault.WithMaxAge(ctx, c.scheduler.interval(azureKeyVaultSecret)))
 }
 
-func (c *Controller) getConfigMapFromKeyVault(azureKeyVaultSecret *akv.AzureKeyVaultSecret) (map[string]string, error) {
+func (c *Controller) getConfigMapFromKeyVault(ctx context.Context, azureKeyVaultSecret *akv.AzureKeyVaultSecret) (map[string]string, *ObjectStatus, error) {
 	var cmHandler KubernetesHandler
 
+	if azureKeyVaultSecret.Spec.Vault.Object.Selector != nil && azureKeyVaultSecret.Spec.Vault.Object.Type != akv.AzureKeyVaultObjectTypeSecret {
+		return nil, nil, fmt.Errorf("selector not supported for azure key vault object type '%s'", azureKeyVaultSecret.Spec.Vault.Object.Type)
+	}
+
+	vaultService, err := c.vaultServiceFor(azureKeyVaultSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	switch azureKeyVaultSecret.Spec.Vault.Object.Type {
 	case akv.AzureKeyVaultObjectTypeSecret:
 		transformator, err := transformers.CreateTransformator(&azureKeyVaultSecret.Spec.Output)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if azureKeyVaultSecret.Spec.Vault.Object.Selector != nil {
+			cmHandler = NewAzureSecretSelectorHandler(azureKeyVaultSecret, vaultService, *transformator, c.syncedConfigMapValues(azureKeyVaultSecret))
+		} else if azureKeyVaultSecret.Spec.Vault.Object.VersionHistoryLimit > 1 {
+			cmHandler = NewAzureSecretVersionsHandler(azureKeyVaultSecret, vaultService, *transformator)
+		} else {
+			cmHandler = NewAzureSecretHandler(azureKeyVaultSecret, vaultService, *transformator)
 		}
-		cmHandler = NewAzureSecretHandler(azureKeyVaultSecret, c.vaultService, *transformator)
 	case akv.AzureKeyVaultObjectTypeCertificate:
-		cmHandler = NewAzureCertificateHandler(azureKeyVaultSecret, c.vaultService)
+		cmHandler = NewAzureCertificateHandler(azureKeyVaultSecret, vaultService)
 	case akv.AzureKeyVaultObjectTypeKey:
-		cmHandler = NewAzureKeyHandler(azureKeyVaultSecret, c.vaultService)
+		cmHandler = NewAzureKeyHandler(azureKeyVaultSecret, vaultService)
 	case akv.AzureKeyVaultObjectTypeMultiKeyValueSecret:
-		cmHandler = NewAzureMultiKeySecretHandler(azureKeyVaultSecret, c.vaultService)
+		cmHandler = NewAzureMultiKeySecretHandler(azureKeyVaultSecret, vaultService)
 	default:
-		return nil, fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
+		return nil, nil, fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
 	}
-	return cmHandler.HandleConfigMap()
+	// the vault cache must not serve objects older than the refresh interval of the AzureKeyVaultSecret
+	return cmHandler.HandleConfigMap(vault.WithMaxAge(ctx, c.scheduler.interval(azureKeyVaultSecret)))
+}
+
+// vaultServiceFor returns the Azure Key Vault service authenticating with the azure identity
+// of the AzureKeyVaultSecret if set and bound to its namespace, otherwise with the AzureKeyVaultCredential in its namespace
+// if any, falling back to the credentials of the controller
+func (c *Controller) vaultServiceFor(azureKeyVaultSecret *akv.AzureKeyVaultSecret) (vault.Service, error) {
+	azureIdentity := azureKeyVaultSecret.Spec.Vault.AzureIdentity.Name
+	if azureIdentity != "" {
+		if c.identityServices == nil {
+			return nil, fmt.Errorf("azure identity '%s' set, but azure identities are not enabled for the controller", azureIdentity)
+		}
+		if err := c.identityBindings.Authorize(azureKeyVaultSecret.Namespace, azureIdentity); err != nil {
+			return nil, err
+		}
+		return c.identityServices.Get(azureIdentity)
+	}
+
+	service, err := c.credentialServiceFor(azureKeyVaultSecret)
+	if err != nil || service != nil {
+		return service, err
+	}
+	return c.vaultService, nil
 }
 
 func (c *Controller) getAzureKeyVaultSecret(key string) (*akv.AzureKeyVaultSecret, error) {
@@ -417,6 +538,52 @@ func (c *Controller) getAzureKeyVaultSecret(key string) (*akv.AzureKeyVaultSecre
 	return azureKeyVaultSecret, err
 }
 
+// syncedSecretValues returns the values of the selected secrets in the output secret
+func (c *Controller) syncedSecretValues(akvs *akv.AzureKeyVaultSecret) map[string]string {
+	secret, err := c.secretsLister.Secrets(akvs.Namespace).Get(determineSecretName(akvs))
+	if err != nil {
+		return nil
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return syncedSelectorValues(akvs, data, akvs.Status.SecretHash)
+}
+
+// syncedConfigMapValues returns the values of the selected secrets in the output configmap
+func (c *Controller) syncedConfigMapValues(akvs *akv.AzureKeyVaultSecret) map[string]string {
+	cm, err := c.configMapsLister.ConfigMaps(akvs.Namespace).Get(determineConfigMapName(akvs))
+	if err != nil {
+		return nil
+	}
+	return syncedSelectorValues(akvs, cm.Data, akvs.Status.ConfigMapHash)
+}
+
+// syncedSelectorValues returns the values of the selected secrets recorded in status from data,
+// if the last sync of the current generation succeeded and data still has the values synced,
+// so that selected secrets not updated in Azure Key Vault since need not be fetched again
+func syncedSelectorValues(akvs *akv.AzureKeyVaultSecret, data map[string]string, hash string) map[string]string {
+	ready := meta.FindStatusCondition(akvs.Status.Conditions, akv.AzureKeyVaultSecretConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != akvs.Generation {
+		return nil
+	}
+
+	synced := make(map[string]string)
+	for name := range selectedVersions(akvs.Status.ObjectVersions) {
+		dataKey := selectorDataKey(akvs.Spec.Vault.Object.Selector, name)
+		value, ok := data[dataKey]
+		if !ok {
+			return nil
+		}
+		synced[dataKey] = value
+	}
+	if getMD5HashOfStringValues(synced) != hash {
+		return nil
+	}
+	return synced
+}
+
 func hasAzureKeyVaultSecretChangedForSecret(akvs *akv.AzureKeyVaultSecret, akvsValues map[string][]byte, secret *corev1.Secret) bool {
 	// check if secret type has changed
 	secretType := determineSecretType(akvs)
@@ -453,47 +620,65 @@ func hasAzureKeyVaultSecretChangedForConfigMap(akvs *akv.AzureKeyVaultSecret, ak
 	return false
 }
 
-func (c *Controller) updateAzureKeyVaultSecretStatus(akvs *akv.AzureKeyVaultSecret, secretName, cmName, secretHash, cmHash string) error {
-	akvsCopy := akvs.DeepCopy()
-	if secretName != "" {
-		akvsCopy.Status.SecretName = secretName
-		akvsCopy.Status.SecretHash = secretHash
-	}
-	if cmName != "" {
-		akvsCopy.Status.ConfigMapName = cmName
-		akvsCopy.Status.ConfigMapHash = cmHash
+// outputStatus returns a function recording the outputs and object synced from Azure Key Vault in the
+// status of an AzureKeyVaultSecret
+func (c *Controller) outputStatus(secretName, cmName, secretHash, cmHash string, objectStatus *ObjectStatus) func(status *akv.AzureKeyVaultSecretStatus) {
+	now := c.clock.Now()
+	return func(status *akv.AzureKeyVaultSecretStatus) {
+		if secretName != "" {
+			status.SecretName = secretName
+			status.SecretHash = secretHash
+		}
+		if cmName != "" {
+			status.ConfigMapName = cmName
+			status.ConfigMapHash = cmHash
+		}
+		setObjectStatus(status, objectStatus)
+		status.LastAzureUpdate = now
 	}
-	akvsCopy.Status.LastAzureUpdate = c.clock.Now()
-
-	_, err := c.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(akvs.Namespace).UpdateStatus(context.TODO(), akvsCopy, metav1.UpdateOptions{})
-	return err
 }
 
-func (c *Controller) updateAzureKeyVaultSecretStatusForSecret(akvs *akv.AzureKeyVaultSecret, secretHash string) error {
+func (c *Controller) updateAzureKeyVaultSecretStatusForSecret(akvs *akv.AzureKeyVaultSecret, secretHash string, objectStatus *ObjectStatus) error {
 	secretName := determineSecretName(akvs)
 	now := c.clock.Now()
 
 	akvsCopy := akvs.DeepCopy()
 	akvsCopy.Status.SecretName = secretName
 	akvsCopy.Status.SecretHash = secretHash
+	setObjectStatus(&akvsCopy.Status, objectStatus)
 	akvsCopy.Status.LastAzureUpdate = now
 
 	_, err := c.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(akvs.Namespace).UpdateStatus(context.TODO(), akvsCopy, metav1.UpdateOptions{})
 	return err
 }
 
-func (c *Controller) updateAzureKeyVaultSecretStatusForConfigMap(akvs *akv.AzureKeyVaultSecret, cmHash string) error {
+func (c *Controller) updateAzureKeyVaultSecretStatusForConfigMap(akvs *akv.AzureKeyVaultSecret, cmHash string, objectStatus *ObjectStatus) error {
 	cmName := determineConfigMapName(akvs)
 
 	akvsCopy := akvs.DeepCopy()
 	akvsCopy.Status.ConfigMapName = cmName
 	akvsCopy.Status.ConfigMapHash = cmHash
+	setObjectStatus(&akvsCopy.Status, objectStatus)
 	akvsCopy.Status.LastAzureUpdate = c.clock.Now()
 
 	_, err := c.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(akvs.Namespace).UpdateStatus(context.TODO(), akvsCopy, metav1.UpdateOptions{})
 	return err
 }
 
+// setObjectStatus records the resolved version and expiry of the object in Azure Key Vault
+func setObjectStatus(status *akv.AzureKeyVaultSecretStatus, objectStatus *ObjectStatus) {
+	if objectStatus == nil {
+		return
+	}
+	status.ObjectVersion = objectStatus.Version
+	status.ObjectVersions = objectStatus.Versions
+	status.ObjectExpires = nil
+	if objectStatus.Expires != nil {
+		expires := metav1.NewTime(*objectStatus.Expires)
+		status.ObjectExpires = &expires
+	}
+}
+
 func handleKeyVaultError(err error, key string) bool {
 	exit := false
 	if err != nil {
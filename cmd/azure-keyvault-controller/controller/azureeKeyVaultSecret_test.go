@@ -17,11 +17,16 @@ limitations under the License.
 package controller
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	fakeVault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client/fake"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestNullLookup(t *testing.T) {
@@ -52,6 +57,7 @@ someOtherKey: someOtherValue`
 
 func TestSyncAzureKeyVaultMultiKeyVauleJson(t *testing.T) {
 	c := &Controller{
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
 		vaultService: &fakeVault.AkvsService{
 			FakeSecret: fakeJsonSecret,
 		},
@@ -68,7 +74,7 @@ func TestSyncAzureKeyVaultMultiKeyVauleJson(t *testing.T) {
 		},
 	}
 
-	res, err := c.getSecretFromKeyVault(akvs)
+	res, _, err := c.getSecretFromKeyVault(context.Background(), akvs)
 	if err != nil {
 		t.Error(err)
 	}
@@ -97,6 +103,7 @@ func TestSyncAzureKeyVaultMultiKeyVauleJson(t *testing.T) {
 
 func TestSyncAzureKeyVaultMultiKeyVauleYaml(t *testing.T) {
 	c := &Controller{
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
 		vaultService: &fakeVault.AkvsService{
 			FakeSecret: fakeYamlSecret,
 		},
@@ -113,7 +120,7 @@ func TestSyncAzureKeyVaultMultiKeyVauleYaml(t *testing.T) {
 		},
 	}
 
-	res, err := c.getSecretFromKeyVault(akvs)
+	res, _, err := c.getSecretFromKeyVault(context.Background(), akvs)
 	if err != nil {
 		t.Error(err)
 	}
@@ -142,6 +149,7 @@ func TestSyncAzureKeyVaultMultiKeyVauleYaml(t *testing.T) {
 
 func TestSyncAzureKeyVaultMultiKeyVauleDoesNotAllowOutputSecretType(t *testing.T) {
 	c := &Controller{
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
 		vaultService: &fakeVault.AkvsService{
 			FakeSecret: fakeYamlSecret,
 		},
@@ -163,7 +171,7 @@ func TestSyncAzureKeyVaultMultiKeyVauleDoesNotAllowOutputSecretType(t *testing.T
 		},
 	}
 
-	res, err := c.getSecretFromKeyVault(akvs)
+	res, _, err := c.getSecretFromKeyVault(context.Background(), akvs)
 	if err != nil {
 		t.Error(err)
 	}
@@ -189,3 +197,159 @@ func TestSyncAzureKeyVaultMultiKeyVauleDoesNotAllowOutputSecretType(t *testing.T
 		t.Error("expected value of key 'someOtherKey' to be 'someOtherValue'")
 	}
 }
+
+func TestGetSecretFromKeyVaultWithAzureIdentity(t *testing.T) {
+	var identities []string
+	c := &Controller{
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
+		vaultService: &fakeVault.AkvsService{
+			FakeSecret: "default",
+		},
+		identityServices: vault.NewIdentityServicePool(func(azureIdentity string) (vault.Service, error) {
+			identities = append(identities, azureIdentity)
+			return &fakeVault.AkvsService{FakeSecret: azureIdentity}, nil
+		}),
+		identityBindings: identity.Bindings{"team1": {"team1": true}},
+	}
+
+	akvs := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "team1"},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Object: akv.AzureKeyVaultObject{
+					Name: "my-secret",
+					Type: akv.AzureKeyVaultObjectTypeSecret,
+				},
+			},
+			Output: akv.AzureKeyVaultOutput{
+				Secret: akv.AzureKeyVaultOutputSecret{DataKey: "value"},
+			},
+		},
+	}
+
+	res, _, err := c.getSecretFromKeyVault(context.Background(), akvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res["value"]) != "default" {
+		t.Errorf("expected secret from default service, got '%s'", res["value"])
+	}
+
+	akvs.Spec.Vault.AzureIdentity.Name = "team1"
+	for i := 0; i < 2; i++ {
+		res, _, err = c.getSecretFromKeyVault(context.Background(), akvs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res["value"]) != "team1" {
+			t.Errorf("expected secret from service of azure identity 'team1', got '%s'", res["value"])
+		}
+	}
+	if len(identities) != 1 {
+		t.Errorf("expected service for azure identity to be created once, got %d", len(identities))
+	}
+
+	c.identityServices = nil
+	if _, _, err := c.getSecretFromKeyVault(context.Background(), akvs); err == nil {
+		t.Error("expected error when azure identities are not enabled")
+	}
+}
+
+func TestGetSecretFromKeyVaultWithUnboundAzureIdentity(t *testing.T) {
+	var identities []string
+	c := &Controller{
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
+		identityServices: vault.NewIdentityServicePool(func(azureIdentity string) (vault.Service, error) {
+			identities = append(identities, azureIdentity)
+			return &fakeVault.AkvsService{FakeSecret: azureIdentity}, nil
+		}),
+		identityBindings: identity.Bindings{"team1": {"team1": true}},
+	}
+
+	akvs := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "team2"},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Object:        akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				AzureIdentity: akv.AzureIdentity{Name: "team1"},
+			},
+			Output: akv.AzureKeyVaultOutput{
+				Secret: akv.AzureKeyVaultOutputSecret{DataKey: "value"},
+			},
+		},
+	}
+
+	if _, _, err := c.getSecretFromKeyVault(context.Background(), akvs); err == nil {
+		t.Error("expected namespace team2 to be refused azure identity of team1")
+	}
+	if len(identities) != 0 {
+		t.Errorf("expected no service to be created for an unbound azure identity, got %v", identities)
+	}
+}
+
+func TestSyncedSelectorValues(t *testing.T) {
+	secret := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, Generation: 2},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Object: akv.AzureKeyVaultObject{
+					Type:     akv.AzureKeyVaultObjectTypeSecret,
+					Selector: &akv.AzureKeyVaultObjectSelector{NamePrefix: "app-", TrimPrefix: true},
+				},
+			},
+		},
+	}
+	synced := map[string]string{"a": "a", "b": "b"}
+	setSyncStatus(&secret.Status, 2, nil)
+	secret.Status.ObjectVersions = []string{"app-a/1@1609459200", "app-b/2@1609459200"}
+
+	tests := []struct {
+		name       string
+		generation int64
+		data       map[string]string
+		hash       string
+		expected   map[string]string
+	}{
+		{
+			name:       "synced",
+			generation: 2,
+			data:       map[string]string{"a": "a", "b": "b", "other": "other"},
+			hash:       getMD5HashOfStringValues(synced),
+			expected:   synced,
+		},
+		{
+			name:       "changed since last sync",
+			generation: 3,
+			data:       synced,
+			hash:       getMD5HashOfStringValues(synced),
+		},
+		{
+			name:       "output changed",
+			generation: 2,
+			data:       map[string]string{"a": "a", "b": "changed"},
+			hash:       getMD5HashOfStringValues(synced),
+		},
+		{
+			name:       "output missing key",
+			generation: 2,
+			data:       map[string]string{"a": "a"},
+			hash:       getMD5HashOfStringValues(map[string]string{"a": "a"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			akvs := secret.DeepCopy()
+			akvs.Generation = tt.generation
+			values := syncedSelectorValues(akvs, tt.data, tt.hash)
+			if len(values) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, values)
+			}
+			for k, v := range tt.expected {
+				if values[k] != v {
+					t.Errorf("expected '%s' for key '%s', got '%s'", v, k, values[k])
+				}
+			}
+		})
+	}
+}
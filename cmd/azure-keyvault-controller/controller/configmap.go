@@ -70,7 +70,7 @@ func (c *Controller) getConfigMap(ns, name string) (*corev1.ConfigMap, error) {
 	return cm, err
 }
 
-func (c *Controller) deleteKubernetesConfigMapValues(akvs *akv.AzureKeyVaultSecret) error {
+func (c *Controller) deleteKubernetesConfigMapValues(ctx context.Context, akvs *akv.AzureKeyVaultSecret) error {
 	cm, err := c.getConfigMap(akvs.Namespace, akvs.Spec.Output.ConfigMap.Name)
 	if errors.IsNotFound(err) {
 		return nil
@@ -78,7 +78,7 @@ func (c *Controller) deleteKubernetesConfigMapValues(akvs *akv.AzureKeyVaultSecr
 
 	cmData := cm.Data
 
-	data, err := c.getConfigMapFromKeyVault(akvs)
+	data, _, err := c.getConfigMapFromKeyVault(ctx, akvs)
 	if err != nil {
 		return err
 	}
@@ -92,16 +92,17 @@ func (c *Controller) deleteKubernetesConfigMapValues(akvs *akv.AzureKeyVaultSecr
 		return err
 	}
 
-	cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Update(context.TODO(), newCM, metav1.UpdateOptions{})
+	cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Update(ctx, newCM, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Controller) getOrCreateKubernetesConfigMap(akvs *akv.AzureKeyVaultSecret) (*corev1.ConfigMap, error) {
+func (c *Controller) getOrCreateKubernetesConfigMap(ctx context.Context, akvs *akv.AzureKeyVaultSecret) (*corev1.ConfigMap, error) {
 	var cm *corev1.ConfigMap
 	var cmValues map[string]string
+	var objectStatus *ObjectStatus
 	var err error
 
 	cmName := akvs.Spec.Output.ConfigMap.Name
@@ -115,17 +116,17 @@ func (c *Controller) getOrCreateKubernetesConfigMap(akvs *akv.AzureKeyVaultSecre
 		if errors.IsNotFound(err) {
 			klog.V(4).InfoS("configmap was not found", "configmap", klog.KRef(akvs.Namespace, cmName))
 			klog.V(4).InfoS("getting configmap value from azure key vault", "configmap", klog.KRef(akvs.Namespace, cmName))
-			cmValues, err = c.getConfigMapFromKeyVault(akvs)
+			cmValues, objectStatus, err = c.getConfigMapFromKeyVault(ctx, akvs)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get configmap from azure key vault for configmap '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err)
+				return nil, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("failed to get configmap from azure key vault for configmap '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err))
 			}
 
-			if cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Create(context.TODO(), createNewConfigMap(akvs, cmValues), metav1.CreateOptions{}); err != nil {
+			if cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Create(ctx, createNewConfigMap(akvs, cmValues), metav1.CreateOptions{}); err != nil {
 				return nil, fmt.Errorf("failed to create new configmap, err: %+v", err)
 			}
 
 			klog.InfoS("updating status for azurekeyvaultsecret", "azurekeyvaultsecret", klog.KObj(akvs))
-			if err = c.updateAzureKeyVaultSecretStatusForConfigMap(akvs, getMD5HashOfStringValues(cmValues)); err != nil {
+			if err = c.updateAzureKeyVaultSecretStatusForConfigMap(akvs, getMD5HashOfStringValues(cmValues), objectStatus); err != nil {
 				return nil, fmt.Errorf("failed to update status for azurekeyvaultsecret %s, error: %+v", akvs.Name, err)
 			}
 
@@ -135,9 +136,9 @@ func (c *Controller) getOrCreateKubernetesConfigMap(akvs *akv.AzureKeyVaultSecre
 
 	// get updated secret values from azure key vault
 	klog.V(4).InfoS("getting secret from azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
-	cmValues, err = c.getConfigMapFromKeyVault(akvs)
+	cmValues, objectStatus, err = c.getConfigMapFromKeyVault(ctx, akvs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err)
+		return nil, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err))
 	}
 
 	if cmName != cm.Name {
@@ -147,12 +148,12 @@ func (c *Controller) getOrCreateKubernetesConfigMap(akvs *akv.AzureKeyVaultSecre
 		// Only delete if this akvs is the only owner
 		if !hasMultipleOwners(cm.GetOwnerReferences()) {
 			// Delete configmap
-			if err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}); err != nil {
+			if err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil {
 				return nil, err
 			}
 		}
 		// Recreate configmap under new Name
-		if cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Create(context.TODO(), createNewConfigMap(akvs, cmValues), metav1.CreateOptions{}); err != nil {
+		if cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Create(ctx, createNewConfigMap(akvs, cmValues), metav1.CreateOptions{}); err != nil {
 			return nil, err
 		}
 		return cm, nil
@@ -163,10 +164,10 @@ func (c *Controller) getOrCreateKubernetesConfigMap(akvs *akv.AzureKeyVaultSecre
 
 		updatedCM, err := createNewConfigMapFromExisting(akvs, cmValues, cm)
 		if err != nil {
-			return nil, err
+			return nil, newSyncError(ReasonOutputConflict, err)
 		}
 
-		cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Update(context.TODO(), updatedCM, metav1.UpdateOptions{})
+		cm, err = c.kubeclientset.CoreV1().ConfigMaps(akvs.Namespace).Update(ctx, updatedCM, metav1.UpdateOptions{})
 		if err == nil {
 			klog.InfoS("configmap updated", "azurekeyvaultsecret", klog.KObj(akvs), "configmap", klog.KObj(cm))
 		}
@@ -20,6 +20,7 @@ limitations under the License.
 package controller
 
 import (
+	"context"
 	"time"
 
 	"github.com/appscode/go/runtime"
@@ -31,13 +32,17 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	appslisters "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 	"kmodules.xyz/client-go/tools/queue"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akvcs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
 	keyvaultScheme "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned/scheme"
@@ -76,6 +81,26 @@ const (
 	// is synced successfully after getting updated secret from Azure Key Vault
 	MessageAzureKeyVaultSecretSyncedWithAzureKeyVault = "AzureKeyVaultSecret synced to Kubernetes Secret successfully with change from Azure Key Vault"
 
+	// RolloutTriggered is used as part of the Event 'reason' when a workload is restarted
+	// because a Secret or ConfigMap it uses changed in Azure Key Vault
+	RolloutTriggered = "RolloutTriggered"
+
+	// RolloutRequired is used as part of the Event 'reason' when a workload should be restarted,
+	// but rollouts are only reported as events
+	RolloutRequired = "RolloutRequired"
+
+	// ErrRollout is used as part of the Event 'reason' when restarting a workload fails
+	ErrRollout = "ErrRollout"
+
+	// MessageRolloutTriggered is the message used for an Event fired when a workload is restarted
+	MessageRolloutTriggered = "Restarted to pick up changes to %s '%s' from Azure Key Vault"
+
+	// MessageRolloutRequired is the message used for an Event fired when a workload should be restarted
+	MessageRolloutRequired = "Must be restarted to pick up changes to %s '%s' from Azure Key Vault"
+
+	// MessageRolloutFailed is the message used for an Event fired when restarting a workload fails
+	MessageRolloutFailed = "Failed to restart to pick up changes to %s '%s' from Azure Key Vault: %v"
+
 	ControllerName = "Akv2k8s controller"
 )
 
@@ -107,6 +132,8 @@ type Controller struct {
 	kubeclientset       kubernetes.Interface
 	akvsClient          akvcs.Interface
 	vaultService        vault.Service
+	identityServices    *vault.IdentityServicePool
+	identityBindings    identity.Bindings
 	recorder            record.EventRecorder
 	kubeInformerFactory informers.SharedInformerFactory
 	namespaceAkvsLabel  string
@@ -124,8 +151,27 @@ type Controller struct {
 	akvsCrdDeletionQueue      *queue.Worker
 	azureKeyVaultQueue        *queue.Worker
 
-	options *Options
-	clock   Timer
+	// AzureKeyVaultCredential
+	azureKeyVaultCredentialLister listers.AzureKeyVaultCredentialLister
+	credentialServices            *credentialServices
+
+	// Deployments, StatefulSets and DaemonSets restarted on changes when rollouts are enabled
+	workloadInformerFactory informers.SharedInformerFactory
+	deploymentsLister       appslisters.DeploymentLister
+	statefulSetsLister      appslisters.StatefulSetLister
+	daemonSetsLister        appslisters.DaemonSetLister
+	rolloutQueue            *queue.Worker
+	pendingRollouts         *pendingRollouts
+	rolloutLimiter          flowcontrol.RateLimiter
+	rolloutDelay            time.Duration
+
+	options   *Options
+	clock     Timer
+	scheduler *refreshScheduler
+
+	// ctx is cancelled when the controller shuts down, cancelling any
+	// in-flight requests to Azure Key Vault
+	ctx context.Context
 }
 
 // Options contains options for the controller
@@ -134,10 +180,41 @@ type Options struct {
 	MaxNumRequeues int
 	ResyncPeriod   time.Duration
 	AkvsRef        corev1.ObjectReference
+
+	// DefaultRefreshInterval is how often to check Azure Key Vault for changes
+	// to AzureKeyVaultSecrets not specifying a refresh interval
+	DefaultRefreshInterval time.Duration
+
+	// RefreshJitter is the maximum fraction of the refresh interval randomly
+	// added to each refresh, spreading requests to Azure Key Vault over time
+	RefreshJitter float64
+
+	// RolloutMode controls whether workloads using a Secret or ConfigMap
+	// changed in Azure Key Vault are restarted
+	RolloutMode RolloutMode
+
+	// RolloutQPS and RolloutBurst limit how fast workloads are restarted
+	RolloutQPS   float32
+	RolloutBurst int
+
+	// WorkloadInformerFactory is used to list workloads to restart when rollouts are
+	// enabled. If nil, the informer factory for Secrets and ConfigMaps is used.
+	WorkloadInformerFactory informers.SharedInformerFactory
+
+	// AzureIdentityBindings are the azure identities AzureKeyVaultSecrets in each namespace
+	// may use. AzureKeyVaultSecrets with an azure identity not bound to their namespace fail.
+	AzureIdentityBindings identity.Bindings
+
+	// NewCredentialVaultService creates the Azure Key Vault service for credentials
+	// from an AzureKeyVaultCredential. If nil, AzureKeyVaultCredentials are ignored
+	NewCredentialVaultService func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service
 }
 
-// NewController returns a new AzureKeyVaultSecret controller
-func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvInformerFactory akvInformers.SharedInformerFactory, kubeInformerFactory informers.SharedInformerFactory, recorder record.EventRecorder, vaultService vault.Service, options *Options) *Controller {
+// NewController returns a new AzureKeyVaultSecret controller. AzureKeyVaultSecrets
+// without an azure identity use vaultService, others get their service from
+// identityServices if bound to their namespace in options. If identityServices is nil, azure
+// identities are not supported.
+func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvInformerFactory akvInformers.SharedInformerFactory, kubeInformerFactory informers.SharedInformerFactory, recorder record.EventRecorder, vaultService vault.Service, identityServices *vault.IdentityServicePool, options *Options) *Controller {
 	// Create event broadcaster
 	// Add azure-keyvault-controller types to the default Kubernetes Scheme so Events can be
 	// logged for azure-keyvault-controller types.
@@ -149,6 +226,9 @@ func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvI
 		recorder:      recorder,
 		vaultService:  vaultService,
 
+		identityServices: identityServices,
+		identityBindings: options.AzureIdentityBindings,
+
 		akvsInformerFactory: akvInformerFactory,
 		kubeInformerFactory: kubeInformerFactory,
 
@@ -158,6 +238,21 @@ func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvI
 
 		options: options,
 		clock:   &Clock{},
+		ctx:     context.Background(),
+	}
+
+	controller.scheduler = newRefreshScheduler(controller.clock, options.DefaultRefreshInterval, options.RefreshJitter)
+	if options.RolloutMode == RolloutModeEvent || options.RolloutMode == RolloutModeEnabled {
+		workloadInformerFactory := options.WorkloadInformerFactory
+		if workloadInformerFactory == nil {
+			workloadInformerFactory = kubeInformerFactory
+		}
+		controller.initRollouts(workloadInformerFactory)
+	}
+
+	if options.NewCredentialVaultService != nil {
+		controller.azureKeyVaultCredentialLister = akvInformerFactory.AzureKeyVault().V2beta1().AzureKeyVaultCredentials().Lister()
+		controller.credentialServices = newCredentialServices(options.NewCredentialVaultService)
 	}
 
 	controller.akvsCrdQueue = queue.New("AzureKeyVaultSecrets", options.MaxNumRequeues, options.NumThreads, controller.syncAzureKeyVaultSecret)
@@ -166,6 +261,7 @@ func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvI
 
 	klog.InfoS("setting up event handlers")
 	controller.initAzureKeyVaultSecret()
+	controller.initAzureKeyVaultCredential()
 
 	return controller
 }
@@ -174,10 +270,17 @@ func NewController(client kubernetes.Interface, akvsClient akvcs.Interface, akvI
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.ctx = ctx
+
 	// Start the informer factories to begin populating the informer caches
 	klog.InfoS("starting azurekeyvaultsecret controller")
 	c.akvsInformerFactory.Start(stopCh)
 	c.kubeInformerFactory.Start(stopCh)
+	if c.workloadInformerFactory != nil {
+		c.workloadInformerFactory.Start(stopCh)
+	}
 
 	// Wait for all involved caches to be synced, before processing items from the queue is started
 	for _, v := range c.akvsInformerFactory.WaitForCacheSync(stopCh) {
@@ -192,6 +295,14 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 			return
 		}
 	}
+	if c.workloadInformerFactory != nil {
+		for _, v := range c.workloadInformerFactory.WaitForCacheSync(stopCh) {
+			if !v {
+				runtime.HandleError(errors.Errorf("timed out waiting for caches to sync"))
+				return
+			}
+		}
+	}
 
 	klog.InfoS("starting azure key vault secret queue")
 	c.akvsCrdQueue.Run(stopCh)
@@ -202,6 +313,11 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	klog.InfoS("starting azure key vault queue")
 	c.azureKeyVaultQueue.Run(stopCh)
 
+	if c.rolloutQueue != nil {
+		klog.InfoS("starting rollout queue")
+		c.rolloutQueue.Run(stopCh)
+	}
+
 	klog.InfoS("started workers")
 	<-stopCh
 	klog.InfoS("Shutting down workers")
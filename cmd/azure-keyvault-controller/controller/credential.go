@@ -0,0 +1,214 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+// credentialService is an Azure Key Vault service created from an
+// AzureKeyVaultCredential and its Secret at the given resource versions
+type credentialService struct {
+	credentialVersion string
+	secretVersion     string
+	service           vault.Service
+}
+
+// credentialServices holds one Azure Key Vault service per AzureKeyVaultCredential,
+// replaced when the AzureKeyVaultCredential or its Secret changes
+type credentialServices struct {
+	newService func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service
+	mutex      sync.Mutex
+	services   map[string]credentialService
+}
+
+func newCredentialServices(newService func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service) *credentialServices {
+	return &credentialServices{
+		newService: newService,
+		services:   make(map[string]credentialService),
+	}
+}
+
+func (s *credentialServices) get(credential *akv.AzureKeyVaultCredential, secret *corev1.Secret) (vault.Service, error) {
+	key := credential.Namespace + "/" + credential.Name
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cached, ok := s.services[key]; ok && cached.credentialVersion == credential.ResourceVersion && cached.secretVersion == secret.ResourceVersion {
+		return cached.service, nil
+	}
+
+	credentials, err := newServicePrincipal(credential, secret).GetAzureKeyVaultCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials from azurekeyvaultcredential '%s', error: %+v", key, err)
+	}
+
+	service := s.newService(credentials)
+	s.services[key] = credentialService{
+		credentialVersion: credential.ResourceVersion,
+		secretVersion:     secret.ResourceVersion,
+		service:           service,
+	}
+	return service, nil
+}
+
+func (s *credentialServices) delete(credential *akv.AzureKeyVaultCredential) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.services, credential.Namespace+"/"+credential.Name)
+}
+
+func newServicePrincipal(credential *akv.AzureKeyVaultCredential, secret *corev1.Secret) credentialprovider.ServicePrincipal {
+	sp := credentialprovider.ServicePrincipal{
+		Cloud:    credential.Spec.Cloud,
+		TenantID: credential.Spec.TenantID,
+		ClientID: string(secret.Data[akv.AzureKeyVaultCredentialClientIDKey]),
+	}
+
+	switch credential.Spec.Type {
+	case akv.AzureKeyVaultCredentialTypeClientSecret:
+		sp.ClientSecret = string(secret.Data[akv.AzureKeyVaultCredentialClientSecretKey])
+	case akv.AzureKeyVaultCredentialTypeClientCertificate:
+		sp.ClientCertificate = secret.Data[akv.AzureKeyVaultCredentialClientCertificateKey]
+		sp.ClientCertificatePassword = string(secret.Data[akv.AzureKeyVaultCredentialClientCertificatePasswordKey])
+	case akv.AzureKeyVaultCredentialTypeFederatedToken:
+		sp.FederatedToken = string(secret.Data[akv.AzureKeyVaultCredentialFederatedTokenKey])
+	}
+	return sp
+}
+
+func (c *Controller) initAzureKeyVaultCredential() {
+	if c.credentialServices == nil {
+		return
+	}
+
+	c.akvsInformerFactory.AzureKeyVault().V2beta1().AzureKeyVaultCredentials().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueAzureKeyVaultSecretsForCredential(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldCredential, ok := old.(*akv.AzureKeyVaultCredential)
+			if !ok {
+				return
+			}
+			newCredential, ok := new.(*akv.AzureKeyVaultCredential)
+			if !ok || oldCredential.ResourceVersion == newCredential.ResourceVersion {
+				return
+			}
+			c.enqueueAzureKeyVaultSecretsForCredential(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if credential, ok := obj.(*akv.AzureKeyVaultCredential); ok {
+				c.credentialServices.delete(credential)
+			}
+			c.enqueueAzureKeyVaultSecretsForCredential(obj)
+		},
+	})
+}
+
+// enqueueAzureKeyVaultSecretsForCredential refreshes all AzureKeyVaultSecrets in the namespace
+// of an AzureKeyVaultCredential, so they are synced with the changed credentials
+func (c *Controller) enqueueAzureKeyVaultSecretsForCredential(obj interface{}) {
+	credential, ok := obj.(*akv.AzureKeyVaultCredential)
+	if !ok {
+		klog.ErrorS(nil, "failed to convert to azurekeyvaultcredential", "object", obj)
+		return
+	}
+
+	akvsList, err := c.azureKeyVaultSecretLister.AzureKeyVaultSecrets(credential.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "failed to list azurekeyvaultsecrets", "namespace", credential.Namespace)
+		return
+	}
+
+	for _, akvs := range akvsList {
+		if akvs.Spec.Vault.AzureIdentity.Name != "" || !c.akvsHasOutputDefined(akvs) {
+			continue
+		}
+		klog.V(4).InfoS("azurekeyvaultcredential changed - adding to azure key vault queue", "azurekeyvaultcredential", klog.KObj(credential), "azurekeyvaultsecret", klog.KObj(akvs))
+		syncCounter.WithLabelValues("update", "AzureKeyVaultCredential").Inc()
+		queue.Enqueue(c.azureKeyVaultQueue.GetQueue(), akvs)
+	}
+}
+
+// credentialServiceFor returns the Azure Key Vault service for the AzureKeyVaultCredential in the
+// namespace of the AzureKeyVaultSecret, or nil if the namespace has no AzureKeyVaultCredential
+func (c *Controller) credentialServiceFor(azureKeyVaultSecret *akv.AzureKeyVaultSecret) (vault.Service, error) {
+	if c.credentialServices == nil {
+		return nil, nil
+	}
+
+	credentials, err := c.azureKeyVaultCredentialLister.AzureKeyVaultCredentials(azureKeyVaultSecret.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(credentials) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("found %d azurekeyvaultcredentials in namespace '%s', only one is supported per namespace", len(credentials), azureKeyVaultSecret.Namespace)
+	}
+
+	credential := credentials[0]
+	secret, err := c.getCredentialSecret(credential)
+	if err != nil {
+		return nil, err
+	}
+	return c.credentialServices.get(credential, secret)
+}
+
+func (c *Controller) getCredentialSecret(credential *akv.AzureKeyVaultCredential) (*corev1.Secret, error) {
+	name := credential.Spec.SecretRef.Name
+	if name == "" {
+		return nil, fmt.Errorf("secretRef not set for azurekeyvaultcredential '%s/%s'", credential.Namespace, credential.Name)
+	}
+
+	secret, err := c.secretsLister.Secrets(credential.Namespace).Get(name)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// The secret may be filtered out of the informer cache by object labels
+	secret, err = c.kubeclientset.CoreV1().Secrets(credential.Namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret '%s' for azurekeyvaultcredential '%s/%s', error: %+v", name, credential.Namespace, credential.Name, err)
+	}
+	return secret, nil
+}
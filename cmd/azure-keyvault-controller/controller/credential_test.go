@@ -0,0 +1,174 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	fakeVault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client/fake"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	listers "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/listers/azurekeyvault/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type credentialTestController struct {
+	*Controller
+	credentials cache.Indexer
+	secrets     cache.Indexer
+	created     []credentialprovider.AzureKeyVaultCredentials
+}
+
+func newCredentialTestController() *credentialTestController {
+	tc := &credentialTestController{
+		credentials: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		secrets:     cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+	}
+	tc.Controller = &Controller{
+		kubeclientset:                 kubefake.NewSimpleClientset(),
+		vaultService:                  &fakeVault.AkvsService{FakeSecret: "default"},
+		secretsLister:                 corelisters.NewSecretLister(tc.secrets),
+		azureKeyVaultCredentialLister: listers.NewAzureKeyVaultCredentialLister(tc.credentials),
+		credentialServices: newCredentialServices(func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+			tc.created = append(tc.created, credentials)
+			return &fakeVault.AkvsService{FakeSecret: "credential"}
+		}),
+		scheduler: newRefreshScheduler(&fakeClock{now: time.Now()}, DefaultRefreshInterval, 0),
+		ctx:       context.Background(),
+	}
+	return tc
+}
+
+func newTestCredential(namespace, name string) *akv.AzureKeyVaultCredential {
+	return &akv.AzureKeyVaultCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "1"},
+		Spec: akv.AzureKeyVaultCredentialSpec{
+			Type:      akv.AzureKeyVaultCredentialTypeClientSecret,
+			TenantID:  "tenant",
+			SecretRef: akv.AzureKeyVaultCredentialSecretRef{Name: "sp"},
+		},
+	}
+}
+
+func newTestCredentialSecret(namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sp", Namespace: namespace, ResourceVersion: "1"},
+		Data: map[string][]byte{
+			akv.AzureKeyVaultCredentialClientIDKey:     []byte("client"),
+			akv.AzureKeyVaultCredentialClientSecretKey: []byte("secret"),
+		},
+	}
+}
+
+func newCredentialTestAkvs(namespace string) *akv.AzureKeyVaultSecret {
+	return &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-akvs", Namespace: namespace},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Object: akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+			},
+			Output: akv.AzureKeyVaultOutput{
+				Secret: akv.AzureKeyVaultOutputSecret{DataKey: "value"},
+			},
+		},
+	}
+}
+
+func getTestSecretValue(t *testing.T, c *Controller, akvs *akv.AzureKeyVaultSecret) string {
+	res, _, err := c.getSecretFromKeyVault(context.Background(), akvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(res["value"])
+}
+
+func TestVaultServiceForNamespaceCredential(t *testing.T) {
+	tc := newCredentialTestController()
+	tc.credentials.Add(newTestCredential("team1", "credential"))
+	tc.secrets.Add(newTestCredentialSecret("team1"))
+
+	if value := getTestSecretValue(t, tc.Controller, newCredentialTestAkvs("team2")); value != "default" {
+		t.Errorf("expected namespace without credential to use controller credentials, got '%s'", value)
+	}
+
+	akvs := newCredentialTestAkvs("team1")
+	for i := 0; i < 2; i++ {
+		if value := getTestSecretValue(t, tc.Controller, akvs); value != "credential" {
+			t.Errorf("expected namespace credential to be used, got '%s'", value)
+		}
+	}
+	if len(tc.created) != 1 {
+		t.Fatalf("expected service for credential to be created once, got %d", len(tc.created))
+	}
+
+	// Changing the secret recreates the service
+	secret := newTestCredentialSecret("team1")
+	secret.ResourceVersion = "2"
+	tc.secrets.Update(secret)
+	getTestSecretValue(t, tc.Controller, akvs)
+	if len(tc.created) != 2 {
+		t.Errorf("expected service to be recreated when secret changed, got %d", len(tc.created))
+	}
+}
+
+func TestVaultServiceForNamespaceCredentialErrors(t *testing.T) {
+	tc := newCredentialTestController()
+	akvs := newCredentialTestAkvs("team1")
+
+	tc.credentials.Add(newTestCredential("team1", "credential"))
+	if _, err := tc.vaultServiceFor(akvs); err == nil {
+		t.Error("expected error when secret of credential does not exist")
+	}
+
+	secret := newTestCredentialSecret("team1")
+	delete(secret.Data, akv.AzureKeyVaultCredentialClientSecretKey)
+	tc.secrets.Add(secret)
+	if _, err := tc.vaultServiceFor(akvs); err == nil {
+		t.Error("expected error when secret has no client secret")
+	}
+
+	tc.secrets.Update(newTestCredentialSecret("team1"))
+	tc.credentials.Add(newTestCredential("team1", "other"))
+	if _, err := tc.vaultServiceFor(akvs); err == nil {
+		t.Error("expected error with multiple credentials in namespace")
+	}
+}
+
+func TestNewServicePrincipal(t *testing.T) {
+	credential := newTestCredential("default", "credential")
+	credential.Spec.Type = akv.AzureKeyVaultCredentialTypeFederatedToken
+	secret := &corev1.Secret{Data: map[string][]byte{
+		akv.AzureKeyVaultCredentialClientIDKey:       []byte("client"),
+		akv.AzureKeyVaultCredentialClientSecretKey:   []byte("ignored"),
+		akv.AzureKeyVaultCredentialFederatedTokenKey: []byte("token"),
+	}}
+
+	sp := newServicePrincipal(credential, secret)
+	if sp.TenantID != "tenant" || sp.ClientID != "client" || sp.FederatedToken != "token" {
+		t.Errorf("unexpected service principal %+v", sp)
+	}
+	if sp.ClientSecret != "" {
+		t.Error("expected only the key of the credential type to be used")
+	}
+}
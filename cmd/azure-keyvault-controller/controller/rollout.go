@@ -0,0 +1,398 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+// RolloutMode controls whether workloads using a Secret or ConfigMap changed
+// in Azure Key Vault are restarted
+type RolloutMode string
+
+const (
+	// RolloutModeDisabled never restarts workloads
+	RolloutModeDisabled RolloutMode = "disabled"
+
+	// RolloutModeEvent only records an event on workloads that should be restarted
+	RolloutModeEvent RolloutMode = "event"
+
+	// RolloutModeEnabled restarts workloads by patching their pod template
+	RolloutModeEnabled RolloutMode = "enabled"
+)
+
+const (
+	// RolloutOnChangeAnnotation can be set on a workload to a comma separated list of
+	// AzureKeyVaultSecrets, restarting the workload when any of them changes in Azure Key Vault
+	RolloutOnChangeAnnotation = "akv2k8s.io/rollout-on-change"
+
+	// OutputHashAnnotation is set on the pod template of restarted workloads to the
+	// hash of the changed Secret or ConfigMap, triggering a rolling restart
+	OutputHashAnnotation = "akv2k8s.io/output-hash"
+
+	// DefaultRolloutQPS and DefaultRolloutBurst limit how fast workloads are restarted
+	DefaultRolloutQPS   = 1
+	DefaultRolloutBurst = 5
+)
+
+const (
+	outputKindSecret    = "Secret"
+	outputKindConfigMap = "ConfigMap"
+)
+
+// workload is a Deployment, StatefulSet or DaemonSet
+type workload struct {
+	kind     string
+	object   runtime.Object
+	meta     metav1.Object
+	template *corev1.PodTemplateSpec
+}
+
+// rollout is a pending restart of a workload using a Secret or ConfigMap changed in Azure Key Vault
+type rollout struct {
+	akvs       string
+	outputKind string
+	outputName string
+	hash       string
+}
+
+// pendingRollouts are the rollouts in the rollout queue by workload key, keeping
+// only the latest rollout for each workload
+type pendingRollouts struct {
+	mutex    sync.Mutex
+	rollouts map[string]rollout
+}
+
+func newPendingRollouts() *pendingRollouts {
+	return &pendingRollouts{rollouts: make(map[string]rollout)}
+}
+
+func (p *pendingRollouts) add(key string, r rollout) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rollouts[key] = r
+}
+
+func (p *pendingRollouts) get(key string) (rollout, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	r, ok := p.rollouts[key]
+	return r, ok
+}
+
+// done removes the rollout, unless replaced by a later rollout of the workload
+func (p *pendingRollouts) done(key string, r rollout) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.rollouts[key] == r {
+		delete(p.rollouts, key)
+	}
+}
+
+// newRolloutLimiter returns the rate limiter for restarting workloads, and how long to
+// wait before trying again when rate limited
+func newRolloutLimiter(qps float32, burst int) (flowcontrol.RateLimiter, time.Duration) {
+	if qps <= 0 {
+		qps = DefaultRolloutQPS
+	}
+	if burst <= 0 {
+		burst = DefaultRolloutBurst
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(qps, burst), time.Duration(float64(time.Second) / float64(qps))
+}
+
+// initRollouts sets up listing workloads from informerFactory and the queue restarting them
+func (c *Controller) initRollouts(informerFactory informers.SharedInformerFactory) {
+	c.workloadInformerFactory = informerFactory
+	c.deploymentsLister = informerFactory.Apps().V1().Deployments().Lister()
+	c.statefulSetsLister = informerFactory.Apps().V1().StatefulSets().Lister()
+	c.daemonSetsLister = informerFactory.Apps().V1().DaemonSets().Lister()
+
+	c.rolloutLimiter, c.rolloutDelay = newRolloutLimiter(c.options.RolloutQPS, c.options.RolloutBurst)
+	c.pendingRollouts = newPendingRollouts()
+	c.rolloutQueue = queue.New("Rollouts", c.options.MaxNumRequeues, 1, c.syncRollout)
+}
+
+// rolloutWorkloads queues a restart of the workloads in the namespace of the azurekeyvaultsecret
+// using its output Secret or ConfigMap, or opted in using the rollout annotation
+func (c *Controller) rolloutWorkloads(akvs *akv.AzureKeyVaultSecret, outputKind, outputName, hash string) error {
+	if c.options == nil || c.options.RolloutMode == "" || c.options.RolloutMode == RolloutModeDisabled {
+		return nil
+	}
+
+	workloads, err := c.listWorkloads(akvs.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list workloads in namespace %s, error: %+v", akvs.Namespace, err)
+	}
+
+	for _, w := range workloads {
+		if !w.usesOutput(akvs, outputKind, outputName) {
+			continue
+		}
+		if w.template.Annotations[OutputHashAnnotation] == hash {
+			klog.V(4).InfoS("workload already restarted with current hash", "kind", w.kind, "workload", klog.KObj(w.meta), "azurekeyvaultsecret", klog.KObj(akvs))
+			continue
+		}
+
+		if c.options.RolloutMode == RolloutModeEvent {
+			klog.InfoS("workload must be restarted to pick up changes from azure key vault", "kind", w.kind, "workload", klog.KObj(w.meta), "azurekeyvaultsecret", klog.KObj(akvs))
+			c.recorder.Eventf(w.object, corev1.EventTypeNormal, RolloutRequired, MessageRolloutRequired, outputKind, outputName)
+			continue
+		}
+
+		key := workloadKey(w.kind, w.meta.GetNamespace(), w.meta.GetName())
+		c.pendingRollouts.add(key, rollout{akvs: akvs.Name, outputKind: outputKind, outputName: outputName, hash: hash})
+		c.rolloutQueue.GetQueue().Add(key)
+		klog.V(4).InfoS("workload queued for restart", "kind", w.kind, "workload", klog.KObj(w.meta), "azurekeyvaultsecret", klog.KObj(akvs))
+	}
+	return nil
+}
+
+// syncRollout restarts the workload with a pending rollout. Rate limited rollouts are
+// requeued rather than waited for, not to hold up the worker.
+func (c *Controller) syncRollout(key string) error {
+	r, ok := c.pendingRollouts.get(key)
+	if !ok {
+		return nil
+	}
+	if !c.rolloutLimiter.TryAccept() {
+		c.rolloutQueue.GetQueue().AddAfter(key, c.rolloutDelay)
+		return nil
+	}
+
+	w, err := c.getWorkload(key)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.pendingRollouts.done(key, r)
+			return nil
+		}
+		return err
+	}
+	if w.template.Annotations[OutputHashAnnotation] == r.hash {
+		c.pendingRollouts.done(key, r)
+		return nil
+	}
+
+	patch, err := newOutputHashPatch(r.hash)
+	if err != nil {
+		return err
+	}
+	if err := c.patchWorkload(c.ctx, w, patch); err != nil {
+		klog.ErrorS(err, "failed to restart workload", "kind", w.kind, "workload", klog.KObj(w.meta), "azurekeyvaultsecret", klog.KRef(w.meta.GetNamespace(), r.akvs))
+		c.recorder.Eventf(w.object, corev1.EventTypeWarning, ErrRollout, MessageRolloutFailed, r.outputKind, r.outputName, err)
+		return err
+	}
+	c.pendingRollouts.done(key, r)
+
+	klog.InfoS("workload restarted to pick up changes from azure key vault", "kind", w.kind, "workload", klog.KObj(w.meta), "azurekeyvaultsecret", klog.KRef(w.meta.GetNamespace(), r.akvs))
+	c.recorder.Eventf(w.object, corev1.EventTypeNormal, RolloutTriggered, MessageRolloutTriggered, r.outputKind, r.outputName)
+	return nil
+}
+
+func workloadKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+func (c *Controller) listWorkloads(namespace string) ([]workload, error) {
+	var workloads []workload
+
+	deployments, err := c.deploymentsLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments {
+		workloads = append(workloads, newDeploymentWorkload(d))
+	}
+
+	statefulSets, err := c.statefulSetsLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statefulSets {
+		workloads = append(workloads, newStatefulSetWorkload(s))
+	}
+
+	daemonSets, err := c.daemonSetsLister.DaemonSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range daemonSets {
+		workloads = append(workloads, newDaemonSetWorkload(ds))
+	}
+	return workloads, nil
+}
+
+// getWorkload gets the workload with a key from workloadKey
+func (c *Controller) getWorkload(key string) (*workload, error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid workload key '%s'", key)
+	}
+	kind, namespace, name := parts[0], parts[1], parts[2]
+
+	switch kind {
+	case "Deployment":
+		d, err := c.deploymentsLister.Deployments(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		w := newDeploymentWorkload(d)
+		return &w, nil
+	case "StatefulSet":
+		s, err := c.statefulSetsLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		w := newStatefulSetWorkload(s)
+		return &w, nil
+	case "DaemonSet":
+		ds, err := c.daemonSetsLister.DaemonSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		w := newDaemonSetWorkload(ds)
+		return &w, nil
+	default:
+		return nil, fmt.Errorf("workload kind '%s' not supported", kind)
+	}
+}
+
+func (c *Controller) patchWorkload(ctx context.Context, w *workload, data []byte) error {
+	apps := c.kubeclientset.AppsV1()
+	namespace, name := w.meta.GetNamespace(), w.meta.GetName()
+
+	var err error
+	switch w.kind {
+	case "Deployment":
+		_, err = apps.Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	case "StatefulSet":
+		_, err = apps.StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	case "DaemonSet":
+		_, err = apps.DaemonSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	default:
+		err = fmt.Errorf("workload kind '%s' not supported", w.kind)
+	}
+	return err
+}
+
+func newDeploymentWorkload(d *appsv1.Deployment) workload {
+	return workload{kind: "Deployment", object: d, meta: d, template: &d.Spec.Template}
+}
+
+func newStatefulSetWorkload(s *appsv1.StatefulSet) workload {
+	return workload{kind: "StatefulSet", object: s, meta: s, template: &s.Spec.Template}
+}
+
+func newDaemonSetWorkload(ds *appsv1.DaemonSet) workload {
+	return workload{kind: "DaemonSet", object: ds, meta: ds, template: &ds.Spec.Template}
+}
+
+// usesOutput returns true if the workload uses the Secret or ConfigMap, or has opted in
+// to be restarted when the azurekeyvaultsecret changes
+func (w *workload) usesOutput(akvs *akv.AzureKeyVaultSecret, outputKind, outputName string) bool {
+	if value, ok := w.meta.GetAnnotations()[RolloutOnChangeAnnotation]; ok {
+		for _, name := range strings.Split(value, ",") {
+			if strings.TrimSpace(name) == akvs.Name {
+				return true
+			}
+		}
+	}
+	return podSpecUsesOutput(&w.template.Spec, outputKind, outputName)
+}
+
+// podSpecUsesOutput returns true if the pod spec references the Secret or ConfigMap
+// through volumes, envFrom or env.valueFrom
+func podSpecUsesOutput(spec *corev1.PodSpec, outputKind, outputName string) bool {
+	for _, volume := range spec.Volumes {
+		switch outputKind {
+		case outputKindSecret:
+			if volume.Secret != nil && volume.Secret.SecretName == outputName {
+				return true
+			}
+		case outputKindConfigMap:
+			if volume.ConfigMap != nil && volume.ConfigMap.Name == outputName {
+				return true
+			}
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if outputKind == outputKindSecret && source.Secret != nil && source.Secret.Name == outputName {
+					return true
+				}
+				if outputKind == outputKindConfigMap && source.ConfigMap != nil && source.ConfigMap.Name == outputName {
+					return true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if outputKind == outputKindSecret && envFrom.SecretRef != nil && envFrom.SecretRef.Name == outputName {
+				return true
+			}
+			if outputKind == outputKindConfigMap && envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == outputName {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if outputKind == outputKindSecret && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == outputName {
+				return true
+			}
+			if outputKind == outputKindConfigMap && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == outputName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newOutputHashPatch(hash string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						OutputHashAnnotation: hash,
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,272 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newRolloutTestController(t *testing.T, mode RolloutMode, objects ...runtime.Object) (*Controller, *kubefake.Clientset, *record.FakeRecorder) {
+	client := kubefake.NewSimpleClientset(objects...)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		kubeclientset: client,
+		recorder:      recorder,
+		options:       &Options{RolloutMode: mode, RolloutQPS: 100, RolloutBurst: 10, MaxNumRequeues: 5},
+		ctx:           context.Background(),
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	c.initRollouts(informerFactory)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+	client.ClearActions()
+	return c, client, recorder
+}
+
+// processRollouts syncs the rollouts in the rollout queue, returning the keys processed
+func processRollouts(t *testing.T, c *Controller) []string {
+	var keys []string
+	q := c.rolloutQueue.GetQueue()
+	for q.Len() > 0 {
+		key, _ := q.Get()
+		if err := c.syncRollout(key.(string)); err != nil {
+			t.Error(err)
+		}
+		q.Done(key)
+		keys = append(keys, key.(string))
+	}
+	return keys
+}
+
+func newRolloutTestDeployment(name string, annotations map[string]string, spec corev1.PodSpec) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{Spec: spec},
+		},
+	}
+}
+
+func getTemplateHash(t *testing.T, client *kubefake.Clientset, name string) string {
+	deployment, err := client.AppsV1().Deployments("default").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deployment.Spec.Template.Annotations[OutputHashAnnotation]
+}
+
+func TestRolloutWorkloads(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{ObjectMeta: metav1.ObjectMeta{Name: "my-akvs", Namespace: "default"}}
+
+	usingSecret := newRolloutTestDeployment("using-secret", nil, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			EnvFrom: []corev1.EnvFromSource{{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "my-secret"}},
+			}},
+		}},
+	})
+	optedIn := newRolloutTestDeployment("opted-in", map[string]string{RolloutOnChangeAnnotation: "other, my-akvs"}, corev1.PodSpec{})
+	unrelated := newRolloutTestDeployment("unrelated", nil, corev1.PodSpec{
+		Containers: []corev1.Container{{
+			EnvFrom: []corev1.EnvFromSource{{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other-secret"}},
+			}},
+		}},
+	})
+
+	c, client, _ := newRolloutTestController(t, RolloutModeEnabled, usingSecret, optedIn, unrelated)
+	if err := c.rolloutWorkloads(akvs, outputKindSecret, "my-secret", "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected workloads to only be listed from informers, got %d requests", len(actions))
+	}
+	if hash := getTemplateHash(t, client, "using-secret"); hash != "" {
+		t.Errorf("expected deployment to be restarted by the rollout queue, got hash '%s'", hash)
+	}
+	processRollouts(t, c)
+
+	if hash := getTemplateHash(t, client, "using-secret"); hash != "new-hash" {
+		t.Errorf("expected deployment using secret to be restarted, got hash '%s'", hash)
+	}
+	if hash := getTemplateHash(t, client, "opted-in"); hash != "new-hash" {
+		t.Errorf("expected opted in deployment to be restarted, got hash '%s'", hash)
+	}
+	if hash := getTemplateHash(t, client, "unrelated"); hash != "" {
+		t.Errorf("expected unrelated deployment not to be restarted, got hash '%s'", hash)
+	}
+}
+
+func TestRolloutWorkloadsEventMode(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{ObjectMeta: metav1.ObjectMeta{Name: "my-akvs", Namespace: "default"}}
+	usingConfigMap := newRolloutTestDeployment("using-configmap", nil, corev1.PodSpec{
+		Volumes: []corev1.Volume{{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "my-configmap"}},
+			},
+		}},
+	})
+
+	c, client, recorder := newRolloutTestController(t, RolloutModeEvent, usingConfigMap)
+	if err := c.rolloutWorkloads(akvs, outputKindConfigMap, "my-configmap", "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if c.rolloutQueue.GetQueue().Len() != 0 {
+		t.Error("expected no rollouts to be queued in event mode")
+	}
+
+	if hash := getTemplateHash(t, client, "using-configmap"); hash != "" {
+		t.Errorf("expected deployment not to be restarted in event mode, got hash '%s'", hash)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal "+RolloutRequired) {
+			t.Errorf("expected %s event, got '%s'", RolloutRequired, event)
+		}
+	default:
+		t.Error("expected event to be recorded")
+	}
+}
+
+func TestRolloutWorkloadsDisabled(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{ObjectMeta: metav1.ObjectMeta{Name: "my-akvs", Namespace: "default"}}
+	c, client, _ := newRolloutTestController(t, RolloutModeDisabled)
+
+	if err := c.rolloutWorkloads(akvs, outputKindSecret, "my-secret", "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no requests when rollouts are disabled, got %d", len(actions))
+	}
+}
+
+func TestSyncRolloutRequeuesWhenRateLimited(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{ObjectMeta: metav1.ObjectMeta{Name: "my-akvs", Namespace: "default"}}
+	first := newRolloutTestDeployment("first", map[string]string{RolloutOnChangeAnnotation: "my-akvs"}, corev1.PodSpec{})
+	second := newRolloutTestDeployment("second", map[string]string{RolloutOnChangeAnnotation: "my-akvs"}, corev1.PodSpec{})
+
+	c, client, _ := newRolloutTestController(t, RolloutModeEnabled, first, second)
+	c.rolloutLimiter, c.rolloutDelay = newRolloutLimiter(0.001, 1)
+	if err := c.rolloutWorkloads(akvs, outputKindSecret, "my-secret", "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second rollout is requeued rather than waiting for the rate limiter
+	done := make(chan []string)
+	go func() { done <- processRollouts(t, c) }()
+	select {
+	case keys := <-done:
+		if len(keys) != 2 {
+			t.Errorf("expected both rollouts to be processed, got %v", keys)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected rate limited rollout not to block")
+	}
+
+	restarted := 0
+	for _, name := range []string{"first", "second"} {
+		_, pending := c.pendingRollouts.get(workloadKey("Deployment", "default", name))
+		if getTemplateHash(t, client, name) == "new-hash" {
+			restarted++
+		} else if !pending {
+			t.Errorf("expected rate limited rollout of %s to still be pending", name)
+		}
+	}
+	if restarted != 1 {
+		t.Errorf("expected one deployment to be restarted within the rate limit, got %d", restarted)
+	}
+}
+
+func TestPodSpecUsesOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       corev1.PodSpec
+		outputKind string
+		expected   bool
+	}{
+		{
+			name: "secret volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "my-output"}},
+			}}},
+			outputKind: outputKindSecret,
+			expected:   true,
+		},
+		{
+			name: "projected configmap volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{{
+						ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "my-output"}},
+					}},
+				}},
+			}}},
+			outputKind: outputKindConfigMap,
+			expected:   true,
+		},
+		{
+			name: "secret key ref in init container",
+			spec: corev1.PodSpec{InitContainers: []corev1.Container{{
+				Env: []corev1.EnvVar{{
+					Name: "VALUE",
+					ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "my-output"},
+						Key:                  "value",
+					}},
+				}},
+			}}},
+			outputKind: outputKindSecret,
+			expected:   true,
+		},
+		{
+			name: "configmap with same name as secret",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{
+					ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "my-output"}},
+				}},
+			}}},
+			outputKind: outputKindSecret,
+			expected:   false,
+		},
+	}
+
+	for _, test := range tests {
+		if actual := podSpecUsesOutput(&test.spec, test.outputKind, "my-output"); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+)
+
+// DefaultRefreshInterval is how often AzureKeyVaultSecrets without a
+// refresh interval are refreshed from Azure Key Vault
+const DefaultRefreshInterval = 30 * time.Second
+
+// refreshScheduler keeps track of when each AzureKeyVaultSecret is next
+// due to be refreshed from Azure Key Vault
+type refreshScheduler struct {
+	mu  sync.Mutex
+	due map[string]time.Time
+
+	clock           Timer
+	defaultInterval time.Duration
+	jitter          float64
+	random          func() float64
+}
+
+func newRefreshScheduler(clock Timer, defaultInterval time.Duration, jitter float64) *refreshScheduler {
+	if defaultInterval <= 0 {
+		defaultInterval = DefaultRefreshInterval
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	return &refreshScheduler{
+		due:             make(map[string]time.Time),
+		clock:           clock,
+		defaultInterval: defaultInterval,
+		jitter:          jitter,
+		random:          rand.Float64,
+	}
+}
+
+// Schedule records when akvs is next due to be refreshed and returns the
+// delay until then, being the refresh interval plus a random jitter
+func (s *refreshScheduler) Schedule(key string, akvs *akv.AzureKeyVaultSecret) time.Duration {
+	interval := s.interval(akvs)
+	delay := interval + time.Duration(s.jitter*s.random()*float64(interval))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.due[key] = s.clock.Now().Time.Add(delay)
+	return delay
+}
+
+// IsDue returns true if the AzureKeyVaultSecret should be refreshed now,
+// which is also the case if it has never been scheduled
+func (s *refreshScheduler) IsDue(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due, ok := s.due[key]
+	return !ok || !s.clock.Now().Time.Before(due)
+}
+
+// Forget stops tracking the AzureKeyVaultSecret
+func (s *refreshScheduler) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.due, key)
+}
+
+func (s *refreshScheduler) interval(akvs *akv.AzureKeyVaultSecret) time.Duration {
+	if akvs.Spec.RefreshInterval != nil && akvs.Spec.RefreshInterval.Duration > 0 {
+		return akvs.Spec.RefreshInterval.Duration
+	}
+	return s.defaultInterval
+}
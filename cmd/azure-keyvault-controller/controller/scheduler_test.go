@@ -0,0 +1,83 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() metav1.Time {
+	return metav1.Time{Time: c.now}
+}
+
+func TestRefreshSchedulerUsesRefreshInterval(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	scheduler := newRefreshScheduler(clock, time.Minute, 0)
+
+	akvs := &akv.AzureKeyVaultSecret{}
+	if delay := scheduler.Schedule("default/a", akvs); delay != time.Minute {
+		t.Errorf("expected default refresh interval, got %v", delay)
+	}
+
+	akvs.Spec.RefreshInterval = &metav1.Duration{Duration: time.Hour}
+	if delay := scheduler.Schedule("default/b", akvs); delay != time.Hour {
+		t.Errorf("expected refresh interval from spec, got %v", delay)
+	}
+}
+
+func TestRefreshSchedulerIsDue(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	scheduler := newRefreshScheduler(clock, time.Minute, 0)
+
+	if !scheduler.IsDue("default/a") {
+		t.Error("expected unscheduled azurekeyvaultsecret to be due")
+	}
+
+	scheduler.Schedule("default/a", &akv.AzureKeyVaultSecret{})
+	if scheduler.IsDue("default/a") {
+		t.Error("expected azurekeyvaultsecret not to be due right after refresh")
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	if !scheduler.IsDue("default/a") {
+		t.Error("expected azurekeyvaultsecret to be due after refresh interval")
+	}
+
+	scheduler.Schedule("default/a", &akv.AzureKeyVaultSecret{})
+	scheduler.Forget("default/a")
+	if !scheduler.IsDue("default/a") {
+		t.Error("expected forgotten azurekeyvaultsecret to be due")
+	}
+}
+
+func TestRefreshSchedulerJitter(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	scheduler := newRefreshScheduler(clock, time.Minute, 0.5)
+	scheduler.random = func() float64 { return 0.5 }
+
+	if delay := scheduler.Schedule("default/a", &akv.AzureKeyVaultSecret{}); delay != time.Minute+15*time.Second {
+		t.Errorf("expected refresh interval plus jitter, got %v", delay)
+	}
+}
@@ -71,7 +71,7 @@ func (c *Controller) getSecret(ns, name string) (*corev1.Secret, error) {
 	return secret, err
 }
 
-func (c *Controller) deleteKubernetesSecretValues(akvs *akv.AzureKeyVaultSecret) error {
+func (c *Controller) deleteKubernetesSecretValues(ctx context.Context, akvs *akv.AzureKeyVaultSecret) error {
 	secret, err := c.getSecret(akvs.Namespace, akvs.Spec.Output.Secret.Name)
 	if errors.IsNotFound(err) {
 		return nil
@@ -79,7 +79,7 @@ func (c *Controller) deleteKubernetesSecretValues(akvs *akv.AzureKeyVaultSecret)
 
 	secretData := secret.Data
 
-	data, err := c.getSecretFromKeyVault(akvs)
+	data, _, err := c.getSecretFromKeyVault(ctx, akvs)
 	if err != nil {
 		return err
 	}
@@ -93,16 +93,17 @@ func (c *Controller) deleteKubernetesSecretValues(akvs *akv.AzureKeyVaultSecret)
 		return err
 	}
 
-	secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Update(context.TODO(), newSecret, metav1.UpdateOptions{})
+	secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Update(ctx, newSecret, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Controller) getOrCreateKubernetesSecret(akvs *akv.AzureKeyVaultSecret) (*corev1.Secret, error) {
+func (c *Controller) getOrCreateKubernetesSecret(ctx context.Context, akvs *akv.AzureKeyVaultSecret) (*corev1.Secret, error) {
 	var secret *corev1.Secret
 	var secretValues map[string][]byte
+	var objectStatus *ObjectStatus
 	var err error
 
 	secretName := akvs.Spec.Output.Secret.Name
@@ -113,17 +114,17 @@ func (c *Controller) getOrCreateKubernetesSecret(akvs *akv.AzureKeyVaultSecret)
 	klog.V(4).InfoS("get or create secret", "secret", klog.KRef(akvs.Namespace, secretName))
 	if secret, err = c.secretsLister.Secrets(akvs.Namespace).Get(secretName); err != nil {
 		if errors.IsNotFound(err) {
-			secretValues, err = c.getSecretFromKeyVault(akvs)
+			secretValues, objectStatus, err = c.getSecretFromKeyVault(ctx, akvs)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err)
+				return nil, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err))
 			}
 
-			if secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Create(context.TODO(), createNewSecret(akvs, secretValues), metav1.CreateOptions{}); err != nil {
+			if secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Create(ctx, createNewSecret(akvs, secretValues), metav1.CreateOptions{}); err != nil {
 				return nil, err
 			}
 
 			klog.InfoS("updating status for azurekeyvaultsecret", "azurekeyvaultsecret", klog.KObj(akvs))
-			if err = c.updateAzureKeyVaultSecretStatusForSecret(akvs, getMD5HashOfByteValues(secretValues)); err != nil {
+			if err = c.updateAzureKeyVaultSecretStatusForSecret(akvs, getMD5HashOfByteValues(secretValues), objectStatus); err != nil {
 				return nil, err
 			}
 
@@ -132,9 +133,9 @@ func (c *Controller) getOrCreateKubernetesSecret(akvs *akv.AzureKeyVaultSecret)
 	}
 
 	// get updated secret values from azure key vault
-	secretValues, err = c.getSecretFromKeyVault(akvs)
+	secretValues, objectStatus, err = c.getSecretFromKeyVault(ctx, akvs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err)
+		return nil, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("failed to get secret from Azure Key Vault for secret '%s'/'%s', error: %+v", akvs.Namespace, akvs.Name, err))
 	}
 
 	if secretName != secret.Name {
@@ -144,13 +145,13 @@ func (c *Controller) getOrCreateKubernetesSecret(akvs *akv.AzureKeyVaultSecret)
 		// Only delete if this akvs is the only owner
 		if !hasMultipleOwners(secret.GetOwnerReferences()) {
 			// Delete secret
-			if err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{}); err != nil {
+			if err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil {
 				return nil, err
 			}
 		}
 
 		// Recreate secret under new Name
-		if secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Create(context.TODO(), createNewSecret(akvs, secretValues), metav1.CreateOptions{}); err != nil {
+		if secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Create(ctx, createNewSecret(akvs, secretValues), metav1.CreateOptions{}); err != nil {
 			return nil, err
 		}
 		return secret, nil
@@ -161,9 +162,9 @@ func (c *Controller) getOrCreateKubernetesSecret(akvs *akv.AzureKeyVaultSecret)
 
 		updatedSecret, err := createNewSecretFromExisting(akvs, secretValues, secret)
 		if err != nil {
-			return nil, err
+			return nil, newSyncError(ReasonOutputConflict, err)
 		}
-		secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Update(context.TODO(), updatedSecret, metav1.UpdateOptions{})
+		secret, err = c.kubeclientset.CoreV1().Secrets(akvs.Namespace).Update(ctx, updatedSecret, metav1.UpdateOptions{})
 		if err == nil {
 			klog.InfoS("secret updated", "azurekeyvaultsecret", klog.KObj(akvs), "secret", klog.KObj(secret))
 		}
@@ -17,23 +17,53 @@ limitations under the License.
 package controller
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
 	yaml "gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
+// pemContentType is the content type Azure Key Vault sets on secrets backing pem certificates
+const pemContentType = "application/x-pem-file"
+
+// selectorConcurrency is the max number of selected secrets fetched from Azure Key Vault at a time
+const selectorConcurrency = 10
+
 // KubernetesSecretHandler handles getting and formatting secrets from Azure Key Vault to Kubernetes
 type KubernetesHandler interface {
-	HandleSecret() (map[string][]byte, error)
-	HandleConfigMap() (map[string]string, error)
+	HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error)
+	HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error)
+}
+
+// ObjectStatus has the state of the Azure Key Vault object synced by a KubernetesHandler
+type ObjectStatus struct {
+	// Version is the resolved version of the object in Azure Key Vault
+	Version string
+	// Expires is when the object expires in Azure Key Vault, if set
+	Expires *time.Time
+	// Versions are the versions synced from Azure Key Vault when syncing version history, newest first,
+	// or the versions of the selected secrets when using a selector
+	Versions []string
+}
+
+func newObjectStatus(metadata vault.ObjectMetadata) *ObjectStatus {
+	return &ObjectStatus{
+		Version: metadata.Version,
+		Expires: metadata.Expires,
+	}
 }
 
 // azureSecretHandler handles getting and formatting Azure Key Vault Secret from Azure Key Vault to Kubernetes
@@ -43,6 +73,22 @@ type azureSecretHandler struct {
 	transformator transformers.Transformator
 }
 
+// azureSecretVersionsHandler handles getting and formatting the latest versions of an Azure Key Vault Secret from Azure Key Vault to Kubernetes
+type azureSecretVersionsHandler struct {
+	secretSpec    *akv.AzureKeyVaultSecret
+	vaultService  vault.Service
+	transformator transformers.Transformator
+}
+
+// azureSecretSelectorHandler handles getting and formatting multiple Azure Key Vault Secrets selected by name or tags from Azure Key Vault to Kubernetes
+type azureSecretSelectorHandler struct {
+	secretSpec    *akv.AzureKeyVaultSecret
+	vaultService  vault.Service
+	transformator transformers.Transformator
+	// synced are the values of the selected secrets by data key from the last sync
+	synced map[string]string
+}
+
 // azureCertificateHandler handles getting and formatting Azure Key Vault Certificate from Azure Key Vault to Kubernetes
 type azureCertificateHandler struct {
 	secretSpec   *akv.AzureKeyVaultSecret
@@ -70,6 +116,26 @@ func NewAzureSecretHandler(secretSpec *akv.AzureKeyVaultSecret, vaultService vau
 	}
 }
 
+// NewAzureSecretVersionsHandler return a new AzureSecretVersionsHandler
+func NewAzureSecretVersionsHandler(secretSpec *akv.AzureKeyVaultSecret, vaultService vault.Service, transformator transformers.Transformator) *azureSecretVersionsHandler {
+	return &azureSecretVersionsHandler{
+		secretSpec:    secretSpec,
+		vaultService:  vaultService,
+		transformator: transformator,
+	}
+}
+
+// NewAzureSecretSelectorHandler return a new AzureSecretSelectorHandler, reusing the synced values
+// of selected secrets not updated in Azure Key Vault since the last sync
+func NewAzureSecretSelectorHandler(secretSpec *akv.AzureKeyVaultSecret, vaultService vault.Service, transformator transformers.Transformator, synced map[string]string) *azureSecretSelectorHandler {
+	return &azureSecretSelectorHandler{
+		secretSpec:    secretSpec,
+		vaultService:  vaultService,
+		transformator: transformator,
+		synced:        synced,
+	}
+}
+
 // NewAzureCertificateHandler return a new AzureCertificateHandler
 func NewAzureCertificateHandler(secretSpec *akv.AzureKeyVaultSecret, vaultService vault.Service) *azureCertificateHandler {
 	return &azureCertificateHandler{
@@ -95,28 +161,28 @@ func NewAzureMultiKeySecretHandler(secretSpec *akv.AzureKeyVaultSecret, vaultSer
 }
 
 // Handle getting and formating Azure Key Vault Secret from Azure Key Vault to Kubernetes
-func (h *azureSecretHandler) HandleSecret() (map[string][]byte, error) {
+func (h *azureSecretHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
 	if h.secretSpec.Spec.Vault.Object.Type == akv.AzureKeyVaultObjectTypeMultiKeyValueSecret && h.secretSpec.Spec.Output.Secret.DataKey != "" {
 		klog.InfoS("output data key ignored - vault object type is multi key and will use its own keys", klog.KObj(h.secretSpec))
 	}
 
 	values := make(map[string][]byte)
 
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+	azureSecret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	secret, err = h.transformator.Transform(secret)
+	secret, err := h.transformator.Transform(azureSecret.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	switch h.secretSpec.Spec.Output.Secret.Type {
 	case corev1.SecretTypeBasicAuth:
 		creds := strings.Split(secret, ":")
 		if len(creds) != 2 {
-			return nil, fmt.Errorf("unable to handle azure key vault secret as basic auth - check that formatting is correct 'username:password'")
+			return nil, nil, fmt.Errorf("unable to handle azure key vault secret as basic auth - check that formatting is correct 'username:password'")
 		}
 		values[corev1.BasicAuthUsernameKey] = []byte(creds[0])
 		values[corev1.BasicAuthPasswordKey] = []byte(creds[1])
@@ -131,61 +197,325 @@ func (h *azureSecretHandler) HandleSecret() (map[string][]byte, error) {
 		values[corev1.SSHAuthPrivateKey] = []byte(secret)
 
 	case corev1.SecretTypeTLS:
-		pfxRaw, err := base64.StdEncoding.DecodeString(secret)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to decode base64 encoded secret, error: %+v", err)
-		}
-		cert, err := vault.NewCertificateFromPfx(pfxRaw, h.secretSpec.Spec.Output.Secret.ChainOrder == "ensureserverfirst")
+		cert, err := newCertificateFromSecret(secret, azureSecret.ContentType, h.secretSpec.Spec.Output.Secret.ChainOrder == "ensureserverfirst")
 		if err != nil {
-			return nil, fmt.Errorf("Error while processing secret content as pfx, error: %+v", err)
+			return nil, nil, err
 		}
 		if values[corev1.TLSCertKey], err = cert.ExportPublicKeyAsPem(); err != nil {
-			return nil, fmt.Errorf("Error exporting public key, error: %+v", err)
+			return nil, nil, fmt.Errorf("Error exporting public key, error: %+v", err)
 		}
 		if values[corev1.TLSPrivateKeyKey], err = cert.ExportPrivateKeyAsPem(); err != nil {
-			return nil, fmt.Errorf("Error exporting private key, error: %+v", err)
+			return nil, nil, fmt.Errorf("Error exporting private key, error: %+v", err)
 		}
 
 	default:
 		if h.secretSpec.Spec.Vault.Object.Type != akv.AzureKeyVaultObjectTypeMultiKeyValueSecret &&
 			h.secretSpec.Spec.Output.Secret.DataKey == "" {
-			return nil, fmt.Errorf("no datakey spesified for output secret")
+			return nil, nil, fmt.Errorf("no datakey spesified for output secret")
 		}
 		values[h.secretSpec.Spec.Output.Secret.DataKey] = []byte(secret)
 	}
 
-	return values, nil
+	return values, newObjectStatus(azureSecret.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Secret from Azure Key Vault to Kubernetes
-func (h *azureSecretHandler) HandleConfigMap() (map[string]string, error) {
+func (h *azureSecretHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
 	if h.secretSpec.Spec.Vault.Object.Type == akv.AzureKeyVaultObjectTypeMultiKeyValueSecret && h.secretSpec.Spec.Output.ConfigMap.DataKey != "" {
 		klog.InfoS("output data key ignored - vault object type is multi key and will use its own keys", klog.KObj(h.secretSpec))
 	}
 
 	values := make(map[string]string)
 
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+	azureSecret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	secret, err = h.transformator.Transform(secret)
+	secret, err := h.transformator.Transform(azureSecret.Value)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if h.secretSpec.Spec.Vault.Object.Type != akv.AzureKeyVaultObjectTypeMultiKeyValueSecret &&
 		h.secretSpec.Spec.Output.ConfigMap.DataKey == "" {
-		return nil, fmt.Errorf("no datakey spesified for output configmap")
+		return nil, nil, fmt.Errorf("no datakey spesified for output configmap")
 	}
 	values[h.secretSpec.Spec.Output.ConfigMap.DataKey] = secret
 
-	return values, nil
+	return values, newObjectStatus(azureSecret.ObjectMetadata), nil
+}
+
+// Handle getting and formating the latest versions of an Azure Key Vault Secret from Azure Key Vault to Kubernetes
+func (h *azureSecretVersionsHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
+	switch h.secretSpec.Spec.Output.Secret.Type {
+	case "", corev1.SecretTypeOpaque:
+	default:
+		return nil, nil, fmt.Errorf("versionHistoryLimit not supported for output secret of type '%s'", h.secretSpec.Spec.Output.Secret.Type)
+	}
+
+	if h.secretSpec.Spec.Output.Secret.DataKey == "" {
+		return nil, nil, fmt.Errorf("no datakey spesified for output secret")
+	}
+
+	versions, objectStatus, err := h.getVersions(ctx, h.secretSpec.Spec.Output.Secret.DataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string][]byte)
+	for k, v := range versions {
+		values[k] = []byte(v)
+	}
+	return values, objectStatus, nil
+}
+
+// Handle getting and formating the latest versions of an Azure Key Vault Secret from Azure Key Vault to Kubernetes
+func (h *azureSecretVersionsHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
+	if h.secretSpec.Spec.Output.ConfigMap.DataKey == "" {
+		return nil, nil, fmt.Errorf("no datakey spesified for output configmap")
+	}
+	return h.getVersions(ctx, h.secretSpec.Spec.Output.ConfigMap.DataKey)
+}
+
+// getVersions returns the latest versions of the secret, with the newest under dataKey
+// and older versions under dataKey suffixed with -previous, -previous-2 and so on
+func (h *azureSecretVersionsHandler) getVersions(ctx context.Context, dataKey string) (map[string]string, *ObjectStatus, error) {
+	if h.secretSpec.Spec.Vault.Object.Version != "" {
+		return nil, nil, fmt.Errorf("cannot use versionHistoryLimit together with a specific version")
+	}
+
+	secrets, err := h.vaultService.GetSecretVersions(ctx, &h.secretSpec.Spec.Vault, h.secretSpec.Spec.Vault.Object.VersionHistoryLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, nil, fmt.Errorf("no enabled versions of secret '%s' found in azure key vault", h.secretSpec.Spec.Vault.Object.Name)
+	}
+
+	values := make(map[string]string)
+	versions := make([]string, 0, len(secrets))
+	for i, secret := range secrets {
+		value, err := h.transformator.Transform(secret.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[versionedDataKey(dataKey, i)] = value
+		versions = append(versions, secret.Version)
+	}
+
+	objectStatus := newObjectStatus(secrets[0].ObjectMetadata)
+	objectStatus.Versions = versions
+	return values, objectStatus, nil
+}
+
+// versionedDataKey returns the data key for the version at index, where 0 is the newest
+func versionedDataKey(dataKey string, index int) string {
+	switch index {
+	case 0:
+		return dataKey
+	case 1:
+		return dataKey + "-previous"
+	default:
+		return fmt.Sprintf("%s-previous-%d", dataKey, index)
+	}
+}
+
+// Handle getting and formating selected Azure Key Vault Secrets from Azure Key Vault to Kubernetes
+func (h *azureSecretSelectorHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
+	switch h.secretSpec.Spec.Output.Secret.Type {
+	case "", corev1.SecretTypeOpaque:
+	default:
+		return nil, nil, fmt.Errorf("selector not supported for output secret of type '%s'", h.secretSpec.Spec.Output.Secret.Type)
+	}
+
+	selected, objectStatus, err := h.getSelectedSecrets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string][]byte)
+	for k, v := range selected {
+		values[k] = []byte(v)
+	}
+	return values, objectStatus, nil
+}
+
+// Handle getting and formating selected Azure Key Vault Secrets from Azure Key Vault to Kubernetes
+func (h *azureSecretSelectorHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
+	return h.getSelectedSecrets(ctx)
+}
+
+// selectedSecret is a secret in Azure Key Vault matching a selector
+type selectedSecret struct {
+	dataKey  string
+	metadata vault.ObjectMetadata
+}
+
+// getSelectedSecrets returns the value of each selected secret by data key, with the
+// earliest expiry and the version of each selected secret in status. Only secrets
+// updated in Azure Key Vault since the last sync are fetched.
+func (h *azureSecretSelectorHandler) getSelectedSecrets(ctx context.Context) (map[string]string, *ObjectStatus, error) {
+	selector := h.secretSpec.Spec.Vault.Object.Selector
+
+	var nameRegex *regexp.Regexp
+	if selector.NameRegex != "" {
+		var err error
+		if nameRegex, err = regexp.Compile(selector.NameRegex); err != nil {
+			return nil, nil, fmt.Errorf("invalid selector name regex '%s', error: %+v", selector.NameRegex, err)
+		}
+	}
+
+	secrets, err := h.vaultService.ListSecrets(ctx, &h.secretSpec.Spec.Vault)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	syncedVersions := selectedVersions(h.secretSpec.Status.ObjectVersions)
+	values := make(map[string]string)
+	names := make(map[string]string)
+	objectStatus := &ObjectStatus{}
+	var changed []selectedSecret
+
+	for _, metadata := range secrets {
+		if !selectorMatches(selector, nameRegex, metadata) {
+			continue
+		}
+
+		dataKey := selectorDataKey(selector, metadata.Name)
+		if other, exists := names[dataKey]; exists {
+			return nil, nil, fmt.Errorf("secrets '%s' and '%s' both map to data key '%s'", other, metadata.Name, dataKey)
+		}
+		names[dataKey] = metadata.Name
+
+		// the list has no versions, so a secret is unchanged if it was not updated since the last sync
+		syncedVersion, synced := syncedVersions[metadata.Name]
+		value, hasValue := h.synced[dataKey]
+		if !synced || !hasValue || metadata.Updated == nil || !strings.HasSuffix(syncedVersion, updatedSuffix(metadata.Updated)) {
+			changed = append(changed, selectedSecret{dataKey: dataKey, metadata: metadata})
+			continue
+		}
+
+		values[dataKey] = value
+		objectStatus.Versions = append(objectStatus.Versions, syncedVersion)
+		setEarliestExpires(objectStatus, metadata.Expires)
+	}
+
+	fetched, err := h.getChangedSecrets(ctx, changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, selected := range changed {
+		value, err := h.transformator.Transform(fetched[i].Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[selected.dataKey] = value
+		objectStatus.Versions = append(objectStatus.Versions, selectedVersion(selected.metadata.Name, fetched[i].Version, selected.metadata.Updated))
+		setEarliestExpires(objectStatus, fetched[i].Expires)
+	}
+	sort.Strings(objectStatus.Versions)
+
+	if len(values) == 0 {
+		klog.InfoS("no secrets in azure key vault matched selector", "azurekeyvaultsecret", klog.KObj(h.secretSpec), "vault", h.secretSpec.Spec.Vault.Name)
+	}
+	klog.V(4).InfoS("fetched changed secrets matching selector", "azurekeyvaultsecret", klog.KObj(h.secretSpec), "selected", len(values), "fetched", len(changed))
+	return values, objectStatus, nil
+}
+
+// getChangedSecrets gets the selected secrets from Azure Key Vault, at most selectorConcurrency
+// at a time, returning them in the same order
+func (h *azureSecretSelectorHandler) getChangedSecrets(ctx context.Context, changed []selectedSecret) ([]*vault.Secret, error) {
+	secrets := make([]*vault.Secret, len(changed))
+	errs := make([]error, len(changed))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, selectorConcurrency)
+	for i := range changed {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			vaultSpec := h.secretSpec.Spec.Vault.DeepCopy()
+			vaultSpec.Object.Name = changed[i].metadata.Name
+			vaultSpec.Object.Selector = nil
+
+			if secrets[i], errs[i] = h.vaultService.GetSecret(ctx, vaultSpec); errs[i] != nil {
+				errs[i] = fmt.Errorf("failed to get secret '%s' from azure key vault, error: %w", changed[i].metadata.Name, errs[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// selectedVersion returns the version of a selected secret as recorded in status, with
+// the time it was last updated to compare against the listed secrets on the next sync
+func selectedVersion(name, version string, updated *time.Time) string {
+	return fmt.Sprintf("%s/%s%s", name, version, updatedSuffix(updated))
+}
+
+func updatedSuffix(updated *time.Time) string {
+	if updated == nil {
+		return ""
+	}
+	return fmt.Sprintf("@%d", updated.Unix())
+}
+
+// selectedVersions returns the versions of selected secrets recorded in status by secret name
+func selectedVersions(versions []string) map[string]string {
+	selected := make(map[string]string, len(versions))
+	for _, version := range versions {
+		if i := strings.Index(version, "/"); i > 0 {
+			selected[version[:i]] = version
+		}
+	}
+	return selected
+}
+
+func setEarliestExpires(objectStatus *ObjectStatus, expires *time.Time) {
+	if expires != nil && (objectStatus.Expires == nil || expires.Before(*objectStatus.Expires)) {
+		objectStatus.Expires = expires
+	}
+}
+
+// selectorMatches returns true if the secret matches all filters in the selector
+func selectorMatches(selector *akv.AzureKeyVaultObjectSelector, nameRegex *regexp.Regexp, metadata vault.ObjectMetadata) bool {
+	if !strings.HasPrefix(metadata.Name, selector.NamePrefix) {
+		return false
+	}
+	if nameRegex != nil && !nameRegex.MatchString(metadata.Name) {
+		return false
+	}
+	for k, v := range selector.Tags {
+		if tag, ok := metadata.Tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// selectorDataKey returns the data key to use in Kubernetes for a selected secret
+func selectorDataKey(selector *akv.AzureKeyVaultObjectSelector, name string) string {
+	if dataKey, ok := selector.KeyMapping[name]; ok {
+		return dataKey
+	}
+	if selector.TrimPrefix {
+		return strings.TrimPrefix(name, selector.NamePrefix)
+	}
+	return name
 }
 
 // Handle getting and formating Azure Key Vault Certificate from Azure Key Vault to Kubernetes
-func (h *azureCertificateHandler) HandleSecret() (map[string][]byte, error) {
+func (h *azureCertificateHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
 	values := make(map[string][]byte)
 	var err error
 	options := vault.CertificateOptions{
@@ -194,143 +524,197 @@ func (h *azureCertificateHandler) HandleSecret() (map[string][]byte, error) {
 	}
 
 	if !options.ExportPrivateKey && h.secretSpec.Spec.Output.Secret.DataKey == "" {
-		return nil, fmt.Errorf("no datakey specified for output secret")
+		return nil, nil, fmt.Errorf("no datakey specified for output secret")
 	}
 
-	cert, err := h.vaultService.GetCertificate(&h.secretSpec.Spec.Vault, &options)
+	cert, err := h.vaultService.GetCertificate(ctx, &h.secretSpec.Spec.Vault, &options)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if h.secretSpec.Spec.Output.Secret.Type == corev1.SecretTypeOpaque {
 		values[h.secretSpec.Spec.Output.Secret.DataKey] = cert.ExportRaw()
 	} else if options.ExportPrivateKey {
 		if values[corev1.TLSCertKey], err = cert.ExportPublicKeyAsPem(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if values[corev1.TLSPrivateKeyKey], err = cert.ExportPrivateKeyAsPem(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
 		values[h.secretSpec.Spec.Output.Secret.DataKey], err = cert.ExportPublicKeyAsPem()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	return values, nil
+	return values, newObjectStatus(cert.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Certificate from Azure Key Vault to Kubernetes
-func (h *azureCertificateHandler) HandleConfigMap() (map[string]string, error) {
+func (h *azureCertificateHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
 	values := make(map[string]string)
 	var err error
 
-	cert, err := h.vaultService.GetCertificate(&h.secretSpec.Spec.Vault, nil)
+	cert, err := h.vaultService.GetCertificate(ctx, &h.secretSpec.Spec.Vault, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	value, err := cert.ExportPublicKeyAsPem()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	values[h.secretSpec.Spec.Output.ConfigMap.DataKey] = string(value)
 
-	return values, nil
+	return values, newObjectStatus(cert.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Key from Azure Key Vault to Kubernetes
-func (h *azureKeyHandler) HandleSecret() (map[string][]byte, error) {
-	key, err := h.vaultService.GetKey(&h.secretSpec.Spec.Vault)
+func (h *azureKeyHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
+	key, err := h.vaultService.GetKey(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	value, err := exportKey(key, h.secretSpec.Spec.Output.Secret.KeyFormat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export key '%s', error: %+v", h.secretSpec.Spec.Vault.Object.Name, err)
 	}
 
 	values := make(map[string][]byte)
-	values[h.secretSpec.Spec.Output.Secret.DataKey] = []byte(key)
-	return values, nil
+	values[h.secretSpec.Spec.Output.Secret.DataKey] = value
+	return values, newObjectStatus(key.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Key from Azure Key Vault to Kubernetes
-func (h *azureKeyHandler) HandleConfigMap() (map[string]string, error) {
-	key, err := h.vaultService.GetKey(&h.secretSpec.Spec.Vault)
+func (h *azureKeyHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
+	key, err := h.vaultService.GetKey(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	value, err := exportKey(key, h.secretSpec.Spec.Output.ConfigMap.KeyFormat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export key '%s', error: %+v", h.secretSpec.Spec.Vault.Object.Name, err)
 	}
 
 	values := make(map[string]string)
-	values[h.secretSpec.Spec.Output.ConfigMap.DataKey] = key
-	return values, nil
+	values[h.secretSpec.Spec.Output.ConfigMap.DataKey] = string(value)
+	return values, newObjectStatus(key.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Secret containing mulitple values from Azure Key Vault to Kubernetes
-func (h *azureMultiValueSecretHandler) HandleSecret() (map[string][]byte, error) {
+func (h *azureMultiValueSecretHandler) HandleSecret(ctx context.Context) (map[string][]byte, *ObjectStatus, error) {
 	values := make(map[string][]byte)
 
-	if h.secretSpec.Spec.Vault.Object.ContentType == "" {
-		return nil, fmt.Errorf("cannot use '%s' without also specifying content type", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret)
-	}
-
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+	secret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var dat map[string]string
-
-	switch h.secretSpec.Spec.Vault.Object.ContentType {
-	case akv.AzureKeyVaultObjectContentTypeJSON:
-		if err := json.Unmarshal([]byte(secret), &dat); err != nil {
-			return nil, err
-		}
-	case akv.AzureKeyVaultObjectContentTypeYaml:
-		if err := yaml.Unmarshal([]byte(secret), &dat); err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("content type '%s' not supported", h.secretSpec.Spec.Vault.Object.ContentType)
+	dat, err := h.parseSecret(secret)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	for k, v := range dat {
 		values[k] = []byte(v)
 	}
 
-	return values, nil
+	return values, newObjectStatus(secret.ObjectMetadata), nil
 }
 
 // Handle getting and formating Azure Key Vault Secret containing mulitple values from Azure Key Vault to Kubernetes
-func (h *azureMultiValueSecretHandler) HandleConfigMap() (map[string]string, error) {
+func (h *azureMultiValueSecretHandler) HandleConfigMap(ctx context.Context) (map[string]string, *ObjectStatus, error) {
 	values := make(map[string]string)
 
-	if h.secretSpec.Spec.Vault.Object.ContentType == "" {
-		return nil, fmt.Errorf("cannot use '%s' without also specifying content type", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret)
+	secret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+	dat, err := h.parseSecret(secret)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	for k, v := range dat {
+		values[k] = v
+	}
+
+	return values, newObjectStatus(secret.ObjectMetadata), nil
+}
+
+// exportKey exports the public part of a key in the given format, defaulting
+// to the base64url encoded RSA modulus for backwards compatibility
+func exportKey(key *vault.Key, format akv.AzureKeyVaultKeyFormat) ([]byte, error) {
+	switch format {
+	case "":
+		if key.JSONWebKey.N == nil {
+			return nil, fmt.Errorf("key has no rsa modulus, use a key format to export other key types")
+		}
+		return []byte(*key.JSONWebKey.N), nil
+	case akv.AzureKeyVaultKeyFormatPem:
+		return key.ExportPublicKeyAsPem()
+	case akv.AzureKeyVaultKeyFormatJWK:
+		return key.ExportPublicKeyAsJWK()
+	case akv.AzureKeyVaultKeyFormatJWKS:
+		return key.ExportPublicKeyAsJWKS()
+	default:
+		return nil, fmt.Errorf("key format '%s' not supported", format)
+	}
+}
+
+// parseSecret parses a secret containing multiple values using the content type
+// from the spec, falling back to the content type set on the secret in Azure Key Vault
+func (h *azureMultiValueSecretHandler) parseSecret(secret *vault.Secret) (map[string]string, error) {
+	contentType := h.secretSpec.Spec.Vault.Object.ContentType
+	if contentType == "" {
+		switch akv.AzureKeyVaultObjectContentType(secret.ContentType) {
+		case akv.AzureKeyVaultObjectContentTypeJSON, akv.AzureKeyVaultObjectContentTypeYaml:
+			contentType = akv.AzureKeyVaultObjectContentType(secret.ContentType)
+		default:
+			return nil, fmt.Errorf("cannot use '%s' without also specifying content type", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret)
+		}
 	}
 
 	var dat map[string]string
 
-	switch h.secretSpec.Spec.Vault.Object.ContentType {
+	switch contentType {
 	case akv.AzureKeyVaultObjectContentTypeJSON:
-		if err := json.Unmarshal([]byte(secret), &dat); err != nil {
+		if err := json.Unmarshal([]byte(secret.Value), &dat); err != nil {
 			return nil, err
 		}
 	case akv.AzureKeyVaultObjectContentTypeYaml:
-		if err := yaml.Unmarshal([]byte(secret), &dat); err != nil {
+		if err := yaml.Unmarshal([]byte(secret.Value), &dat); err != nil {
 			return nil, err
 		}
 	default:
-		return nil, fmt.Errorf("content type '%s' not supported", h.secretSpec.Spec.Vault.Object.ContentType)
+		return nil, fmt.Errorf("content type '%s' not supported", contentType)
 	}
+	return dat, nil
+}
 
-	for k, v := range dat {
-		values[k] = v
+// newCertificateFromSecret parses a certificate stored as a secret, either as
+// pem if the content type in Azure Key Vault says so, or as base64 encoded pfx
+func newCertificateFromSecret(secret string, contentType string, ensureServerFirst bool) (*vault.Certificate, error) {
+	if contentType == pemContentType {
+		cert, err := vault.NewCertificateFromPem(secret)
+		if err != nil {
+			return nil, fmt.Errorf("Error while processing secret content as pem, error: %+v", err)
+		}
+		return cert, nil
 	}
 
-	return values, nil
+	pfxRaw, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode base64 encoded secret, error: %+v", err)
+	}
+	cert, err := vault.NewCertificateFromPfx(pfxRaw, ensureServerFirst)
+	if err != nil {
+		return nil, fmt.Errorf("Error while processing secret content as pfx, error: %+v", err)
+	}
+	return cert, nil
 }
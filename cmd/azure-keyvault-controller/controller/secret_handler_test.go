@@ -17,9 +17,20 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
@@ -34,20 +45,52 @@ var (
 )
 
 type fakeVaultService struct {
-	fakeSecretValue string
-	fakeCertValue   string
+	fakeSecretValue       string
+	fakeSecretContentType string
+	fakeCertValue         string
+	fakeKey               keyvault.JSONWebKey
+	fakeSecretVersions    []string
+	fakeSecretList        []vault.ObjectMetadata
+	fakeSecretValues      map[string]string
+
+	mutex sync.Mutex
+	// gets are the names of the secrets got
+	gets []string
 }
 
-func (f *fakeVaultService) GetSecret(secret *akv.AzureKeyVault) (string, error) {
-	if f.fakeSecretValue != "" {
-		return f.fakeSecretValue, nil
+func (f *fakeVaultService) GetSecret(ctx context.Context, secret *akv.AzureKeyVault) (*vault.Secret, error) {
+	f.mutex.Lock()
+	f.gets = append(f.gets, secret.Object.Name)
+	f.mutex.Unlock()
+
+	if value, ok := f.fakeSecretValues[secret.Object.Name]; ok {
+		return &vault.Secret{Value: value}, nil
 	}
-	return "", nil
+	return &vault.Secret{
+		ObjectMetadata: vault.ObjectMetadata{ContentType: f.fakeSecretContentType},
+		Value:          f.fakeSecretValue,
+	}, nil
+}
+func (f *fakeVaultService) GetSecretVersions(ctx context.Context, secret *akv.AzureKeyVault, limit int) ([]*vault.Secret, error) {
+	var secrets []*vault.Secret
+	for i, value := range f.fakeSecretVersions {
+		if i >= limit {
+			break
+		}
+		secrets = append(secrets, &vault.Secret{
+			ObjectMetadata: vault.ObjectMetadata{Version: fmt.Sprintf("v%d", len(f.fakeSecretVersions)-i)},
+			Value:          value,
+		})
+	}
+	return secrets, nil
+}
+func (f *fakeVaultService) ListSecrets(ctx context.Context, secret *akv.AzureKeyVault) ([]vault.ObjectMetadata, error) {
+	return f.fakeSecretList, nil
 }
-func (f *fakeVaultService) GetKey(secret *akv.AzureKeyVault) (string, error) {
-	return "", nil
+func (f *fakeVaultService) GetKey(ctx context.Context, secret *akv.AzureKeyVault) (*vault.Key, error) {
+	return &vault.Key{JSONWebKey: f.fakeKey}, nil
 }
-func (f *fakeVaultService) GetCertificate(secret *akv.AzureKeyVault, options *vault.CertificateOptions) (*vault.Certificate, error) {
+func (f *fakeVaultService) GetCertificate(ctx context.Context, secret *akv.AzureKeyVault, options *vault.CertificateOptions) (*vault.Certificate, error) {
 	if f.fakeCertValue != "" {
 		return vault.NewCertificateFromPem(f.fakeCertValue)
 	}
@@ -84,7 +127,7 @@ secondValue: some second value data`,
 	secret.Spec.Vault.Object.ContentType = "application/x-yaml"
 
 	handler := NewAzureMultiKeySecretHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -92,7 +135,7 @@ secondValue: some second value data`,
 		t.Errorf("number of values returned should be 2 but were %d", len(values))
 	}
 
-	valuesCM, err := handler.HandleConfigMap()
+	valuesCM, _, err := handler.HandleConfigMap(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -109,7 +152,7 @@ func TestHandleSecretWithNoDataKey(t *testing.T) {
 	secret := secret()
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err == nil {
 		t.Error("Should fail when no datakey is spesified")
 	}
@@ -126,7 +169,7 @@ func TestHandleConfigMapWithNoDataKey(t *testing.T) {
 	secret := secret()
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleConfigMap()
+	values, _, err := handler.HandleConfigMap(context.Background())
 	if err == nil {
 		t.Error("Should fail when no datakey is spesified")
 	}
@@ -145,7 +188,7 @@ func TestHandleCertificateWithTlsOutput(t *testing.T) {
 	secret.Spec.Output.Secret.Type = corev1.SecretTypeTLS
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -173,7 +216,7 @@ func TestHandlePubliKeyCertificateOnlyWithTlsOutput(t *testing.T) {
 	secret.Spec.Output.Secret.Type = corev1.SecretTypeTLS
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	_, err := handler.HandleSecret()
+	_, _, err := handler.HandleSecret(context.Background())
 	if err == nil {
 		t.Error("Handler should fail because there are no private key in certificate")
 	}
@@ -189,7 +232,7 @@ func TestHandlePubliKeyCertificateWithDataKey(t *testing.T) {
 	secret.Spec.Output.Secret.DataKey = "mykey"
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error("Should have returned error because there is no private key")
 	}
@@ -213,7 +256,7 @@ func TestHandleCertificateFailureWithNoOutputDataKey(t *testing.T) {
 	secret.Spec.Vault.Object.Type = "certificate"
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err == nil {
 		t.Error("Handler should fail because there are no dataKey defined")
 	}
@@ -232,7 +275,7 @@ func TestHandleCertificateWithOutputDataKey(t *testing.T) {
 	secret.Spec.Output.Secret.DataKey = "my-key"
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -258,7 +301,7 @@ func TestHandleCertificateWithRawOutput(t *testing.T) {
 	secret.Spec.Output.Secret.Type = corev1.SecretTypeOpaque
 
 	handler := NewAzureCertificateHandler(secret, fakeVault)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -285,7 +328,7 @@ func TestHandleSecretWithBasicAuthOutput(t *testing.T) {
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -314,7 +357,7 @@ func TestHandleSecretWithDockerConfigJsonAsOutput(t *testing.T) {
 
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -340,7 +383,7 @@ func TestHandleSecretWithDockerConfigAsOutput(t *testing.T) {
 
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -366,7 +409,7 @@ func TestHandleSecretWithSSHAuthAsOutput(t *testing.T) {
 
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -392,7 +435,7 @@ func TestHandleSecretWithTypeTLSAsOutput(t *testing.T) {
 
 	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
 	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
-	values, err := handler.HandleSecret()
+	values, _, err := handler.HandleSecret(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -409,3 +452,299 @@ func TestHandleSecretWithTypeTLSAsOutput(t *testing.T) {
 		t.Errorf("there should be a value stored for key '%s'", corev1.TLSPrivateKeyKey)
 	}
 }
+
+func TestHandleMultiValueSecretWithContentTypeFromKeyVault(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretValue:       `{"firstValue": "some first value data", "secondValue": "some second value data"}`,
+		fakeSecretContentType: "application/x-json",
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Type = "multi-value-secret"
+
+	handler := NewAzureMultiKeySecretHandler(secret, fakeVault)
+	values, _, err := handler.HandleSecret(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if len(values) != 2 {
+		t.Errorf("number of values returned should be 2 but were %d", len(values))
+	}
+}
+
+func TestHandleSecretWithTypeTLSAsOutputFromPem(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretValue:       pemCert,
+		fakeSecretContentType: "application/x-pem-file",
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Type = "secret"
+	secret.Spec.Output.Secret.Type = corev1.SecretTypeTLS
+
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	handler := NewAzureSecretHandler(secret, fakeVault, *transformator)
+	values, _, err := handler.HandleSecret(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if values[corev1.TLSCertKey] == nil {
+		t.Errorf("there should be a value stored for key '%s'", corev1.TLSCertKey)
+	}
+	if values[corev1.TLSPrivateKeyKey] == nil {
+		t.Errorf("there should be a value stored for key '%s'", corev1.TLSPrivateKeyKey)
+	}
+}
+
+func TestHandleKeyWithPemKeyFormat(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(privateKey.X.Bytes())
+	y := base64.RawURLEncoding.EncodeToString(privateKey.Y.Bytes())
+
+	fakeVault := &fakeVaultService{
+		fakeKey: keyvault.JSONWebKey{Kty: keyvault.EC, Crv: keyvault.P256, X: &x, Y: &y},
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Type = "key"
+	secret.Spec.Output.Secret.DataKey = "key.pem"
+	secret.Spec.Output.Secret.KeyFormat = akv.AzureKeyVaultKeyFormatPem
+
+	handler := NewAzureKeyHandler(secret, fakeVault)
+	values, _, err := handler.HandleSecret(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(values["key.pem"])
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("expected pem formatted public key, got '%s'", values["key.pem"])
+	}
+
+	secret.Spec.Output.Secret.KeyFormat = ""
+	if _, _, err := handler.HandleSecret(context.Background()); err == nil {
+		t.Error("expected error exporting ec key without key format")
+	}
+}
+
+func TestHandleKeyConfigMapWithPemKeyFormat(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(privateKey.X.Bytes())
+	y := base64.RawURLEncoding.EncodeToString(privateKey.Y.Bytes())
+
+	fakeVault := &fakeVaultService{
+		fakeKey: keyvault.JSONWebKey{Kty: keyvault.EC, Crv: keyvault.P256, X: &x, Y: &y},
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Type = "key"
+	secret.Spec.Output.ConfigMap.Name = "my-configmap"
+	secret.Spec.Output.ConfigMap.DataKey = "key.pem"
+	secret.Spec.Output.ConfigMap.KeyFormat = akv.AzureKeyVaultKeyFormatPem
+
+	handler := NewAzureKeyHandler(secret, fakeVault)
+	values, _, err := handler.HandleConfigMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode([]byte(values["key.pem"]))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("expected pem formatted public key in configmap, got '%s'", values["key.pem"])
+	}
+
+	secret.Spec.Output.ConfigMap.KeyFormat = ""
+	if _, _, err := handler.HandleConfigMap(context.Background()); err == nil {
+		t.Error("expected error exporting ec key to configmap without key format")
+	}
+}
+
+func TestHandleSecretVersions(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretVersions: []string{"third", "second", "first"},
+	}
+
+	secret := secret()
+	secret.Spec.Output.Secret.DataKey = "password"
+	secret.Spec.Output.ConfigMap.DataKey = "password"
+	secret.Spec.Vault.Object.VersionHistoryLimit = 3
+
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	handler := NewAzureSecretVersionsHandler(secret, fakeVault, *transformator)
+	values, status, err := handler.HandleSecret(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"password":            "third",
+		"password-previous":   "second",
+		"password-previous-2": "first",
+	}
+	if len(values) != len(expected) {
+		t.Errorf("expected %d values, got %d", len(expected), len(values))
+	}
+	for k, v := range expected {
+		if string(values[k]) != v {
+			t.Errorf("expected '%s' for key '%s', got '%s'", v, k, values[k])
+		}
+	}
+	if status.Version != "v3" || len(status.Versions) != 3 || status.Versions[2] != "v1" {
+		t.Errorf("expected synced versions in status, got %+v", status)
+	}
+
+	secret.Spec.Vault.Object.VersionHistoryLimit = 2
+	valuesCM, _, err := handler.HandleConfigMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valuesCM) != 2 || valuesCM["password-previous"] != "second" {
+		t.Errorf("expected two latest versions, got %+v", valuesCM)
+	}
+}
+
+func TestHandleSecretVersionsWithTLSOutput(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretVersions: []string{pfxCert},
+	}
+
+	secret := secret()
+	secret.Spec.Output.Secret.Type = corev1.SecretTypeTLS
+	secret.Spec.Vault.Object.VersionHistoryLimit = 2
+
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	handler := NewAzureSecretVersionsHandler(secret, fakeVault, *transformator)
+	if _, _, err = handler.HandleSecret(context.Background()); err == nil {
+		t.Error("expected error for versionHistoryLimit with tls output")
+	}
+}
+
+func TestHandleSecretSelector(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretList: []vault.ObjectMetadata{
+			{Name: "app-db-password", Tags: map[string]string{"env": "prod"}},
+			{Name: "app-api-key", Tags: map[string]string{"env": "prod"}},
+			{Name: "app-test-key", Tags: map[string]string{"env": "test"}},
+			{Name: "other-secret", Tags: map[string]string{"env": "prod"}},
+		},
+		fakeSecretValues: map[string]string{
+			"app-db-password": "db-password",
+			"app-api-key":     "api-key",
+			"app-test-key":    "test-key",
+			"other-secret":    "other",
+		},
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Name = ""
+	secret.Spec.Vault.Object.Selector = &akv.AzureKeyVaultObjectSelector{
+		NamePrefix: "app-",
+		Tags:       map[string]string{"env": "prod"},
+		TrimPrefix: true,
+		KeyMapping: map[string]string{"app-api-key": "API_KEY"},
+	}
+
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	handler := NewAzureSecretSelectorHandler(secret, fakeVault, *transformator, nil)
+	values, _, err := handler.HandleSecret(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"db-password": "db-password",
+		"API_KEY":     "api-key",
+	}
+	if len(values) != len(expected) {
+		t.Errorf("expected %d values, got %d", len(expected), len(values))
+	}
+	for k, v := range expected {
+		if string(values[k]) != v {
+			t.Errorf("expected '%s' for key '%s', got '%s'", v, k, values[k])
+		}
+	}
+
+	secret.Spec.Vault.Object.Selector = &akv.AzureKeyVaultObjectSelector{
+		NameRegex: "-key$",
+	}
+	valuesCM, _, err := handler.HandleConfigMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valuesCM) != 2 || valuesCM["app-test-key"] != "test-key" {
+		t.Errorf("expected secrets matching regex, got %+v", valuesCM)
+	}
+}
+
+func TestHandleSecretSelectorWithDuplicateDataKeys(t *testing.T) {
+	fakeVault := &fakeVaultService{
+		fakeSecretList: []vault.ObjectMetadata{{Name: "a-key"}, {Name: "b-key"}},
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Selector = &akv.AzureKeyVaultObjectSelector{
+		KeyMapping: map[string]string{"a-key": "key", "b-key": "key"},
+	}
+
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	handler := NewAzureSecretSelectorHandler(secret, fakeVault, *transformator, nil)
+	if _, _, err = handler.HandleSecret(context.Background()); err == nil {
+		t.Error("expected error for secrets mapping to the same data key")
+	}
+}
+
+func TestHandleSecretSelectorOnlyFetchesChangedSecrets(t *testing.T) {
+	updated := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	rotated := updated.Add(time.Hour)
+	fakeVault := &fakeVaultService{
+		fakeSecretList: []vault.ObjectMetadata{
+			{Name: "app-a", Updated: &updated},
+			{Name: "app-b", Updated: &updated},
+			{Name: "app-c"},
+		},
+		fakeSecretValues: map[string]string{"app-a": "a", "app-b": "b", "app-c": "c"},
+	}
+
+	secret := secret()
+	secret.Spec.Vault.Object.Selector = &akv.AzureKeyVaultObjectSelector{NamePrefix: "app-"}
+	transformator, err := transformers.CreateTransformator(&secret.Spec.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, objectStatus, err := NewAzureSecretSelectorHandler(secret, fakeVault, *transformator, nil).HandleConfigMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fakeVault.gets) != 3 {
+		t.Errorf("expected all selected secrets to be fetched on first sync, got %v", fakeVault.gets)
+	}
+	expectedVersions := []string{fmt.Sprintf("app-a/@%d", updated.Unix()), fmt.Sprintf("app-b/@%d", updated.Unix()), "app-c/"}
+	if !reflect.DeepEqual(objectStatus.Versions, expectedVersions) {
+		t.Errorf("expected versions %v, got %v", expectedVersions, objectStatus.Versions)
+	}
+
+	// app-b is updated in azure key vault, while app-c has no updated time to compare
+	secret.Status.ObjectVersions = objectStatus.Versions
+	fakeVault.gets = nil
+	fakeVault.fakeSecretList[1].Updated = &rotated
+	fakeVault.fakeSecretValues["app-b"] = "b2"
+	values["app-a"] = "synced"
+
+	values, _, err = NewAzureSecretSelectorHandler(secret, fakeVault, *transformator, values).HandleConfigMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(fakeVault.gets)
+	if !reflect.DeepEqual(fakeVault.gets, []string{"app-b", "app-c"}) {
+		t.Errorf("expected only changed secrets to be fetched, got %v", fakeVault.gets)
+	}
+	expected := map[string]string{"app-a": "synced", "app-b": "b2", "app-c": "c"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	goerrors "errors"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// ReasonSynced is the condition reason used when a AzureKeyVaultSecret is synced successfully
+	ReasonSynced = "Synced"
+
+	// ReasonAzureKeyVaultError is the condition reason used when getting the object from Azure Key Vault fails
+	ReasonAzureKeyVaultError = "AzureKeyVaultError"
+
+	// ReasonOutputConflict is the condition reason used when the output Secret or ConfigMap
+	// exists and cannot be managed by the AzureKeyVaultSecret
+	ReasonOutputConflict = "OutputConflict"
+
+	// ReasonNoConflict is the condition reason used when the output Secret or ConfigMap is managed
+	// by the AzureKeyVaultSecret
+	ReasonNoConflict = "NoConflict"
+
+	// ReasonSyncFailed is the condition reason used when a sync fails for any other reason
+	ReasonSyncFailed = "SyncFailed"
+)
+
+// syncError is an error from syncing a AzureKeyVaultSecret, carrying the
+// reason reported in its status conditions
+type syncError struct {
+	reason string
+	err    error
+}
+
+func newSyncError(reason string, err error) error {
+	return &syncError{reason: reason, err: err}
+}
+
+func (e *syncError) Error() string {
+	return e.err.Error()
+}
+
+func (e *syncError) Unwrap() error {
+	return e.err
+}
+
+// syncErrorReason returns the condition reason for err
+func syncErrorReason(err error) string {
+	var syncErr *syncError
+	if goerrors.As(err, &syncErr) {
+		return syncErr.reason
+	}
+	return ReasonSyncFailed
+}
+
+// updateSyncStatus records the outcome of a sync in the status of the AzureKeyVaultSecret,
+// together with any changes by updateOutput, in a single update of the status. The status is
+// only updated if anything has changed.
+func (c *Controller) updateSyncStatus(akvs *akv.AzureKeyVaultSecret, syncErr error, updateOutput func(status *akv.AzureKeyVaultSecretStatus)) error {
+	current := akvs
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if current == nil {
+			latest, err := c.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(akvs.Namespace).Get(context.TODO(), akvs.Name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			current = latest
+		}
+
+		akvsCopy := current.DeepCopy()
+		if updateOutput != nil {
+			updateOutput(&akvsCopy.Status)
+		}
+		setSyncStatus(&akvsCopy.Status, current.Generation, syncErr)
+		if equality.Semantic.DeepEqual(current.Status, akvsCopy.Status) {
+			return nil
+		}
+
+		_, err := c.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(akvs.Namespace).UpdateStatus(context.TODO(), akvsCopy, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			// Status was updated since the sync started, so retry with the latest version
+			current = nil
+		}
+		return err
+	})
+}
+
+// setSyncStatus sets conditions, observed generation and failure tracking
+// in status from the outcome of a sync
+func setSyncStatus(status *akv.AzureKeyVaultSecretStatus, generation int64, syncErr error) {
+	status.ObservedGeneration = generation
+
+	if syncErr == nil {
+		status.LastError = ""
+		status.FailureCount = 0
+		setCondition(status, akv.AzureKeyVaultSecretConditionSyncedFromAzure, metav1.ConditionTrue, generation, ReasonSynced, "Object synced from Azure Key Vault")
+		setCondition(status, akv.AzureKeyVaultSecretConditionOutputConflict, metav1.ConditionFalse, generation, ReasonNoConflict, "Output is managed by this AzureKeyVaultSecret")
+		setCondition(status, akv.AzureKeyVaultSecretConditionReady, metav1.ConditionTrue, generation, ReasonSynced, "AzureKeyVaultSecret synced successfully")
+		return
+	}
+
+	reason := syncErrorReason(syncErr)
+	status.LastError = syncErr.Error()
+	status.FailureCount++
+
+	switch reason {
+	case ReasonAzureKeyVaultError:
+		setCondition(status, akv.AzureKeyVaultSecretConditionSyncedFromAzure, metav1.ConditionFalse, generation, reason, status.LastError)
+	case ReasonOutputConflict:
+		setCondition(status, akv.AzureKeyVaultSecretConditionSyncedFromAzure, metav1.ConditionTrue, generation, ReasonSynced, "Object synced from Azure Key Vault")
+		setCondition(status, akv.AzureKeyVaultSecretConditionOutputConflict, metav1.ConditionTrue, generation, reason, status.LastError)
+	}
+	setCondition(status, akv.AzureKeyVaultSecretConditionReady, metav1.ConditionFalse, generation, reason, status.LastError)
+}
+
+func setCondition(status *akv.AzureKeyVaultSecretStatus, conditionType string, conditionStatus metav1.ConditionStatus, generation int64, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	akvfake "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetSyncStatusFailureAndRecovery(t *testing.T) {
+	status := &akv.AzureKeyVaultSecretStatus{}
+
+	setSyncStatus(status, 2, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("vault unavailable")))
+	setSyncStatus(status, 2, newSyncError(ReasonAzureKeyVaultError, fmt.Errorf("vault unavailable")))
+
+	if status.ObservedGeneration != 2 {
+		t.Errorf("expected observed generation 2, got %d", status.ObservedGeneration)
+	}
+	if status.FailureCount != 2 {
+		t.Errorf("expected failure count 2, got %d", status.FailureCount)
+	}
+	if status.LastError != "vault unavailable" {
+		t.Errorf("expected last error 'vault unavailable', got '%s'", status.LastError)
+	}
+	if !meta.IsStatusConditionFalse(status.Conditions, akv.AzureKeyVaultSecretConditionReady) {
+		t.Error("expected Ready condition to be false")
+	}
+	synced := meta.FindStatusCondition(status.Conditions, akv.AzureKeyVaultSecretConditionSyncedFromAzure)
+	if synced == nil || synced.Status != metav1.ConditionFalse || synced.Reason != ReasonAzureKeyVaultError {
+		t.Errorf("expected SyncedFromAzure condition to be false with reason %s, got %+v", ReasonAzureKeyVaultError, synced)
+	}
+
+	setSyncStatus(status, 3, nil)
+
+	if status.FailureCount != 0 || status.LastError != "" {
+		t.Errorf("expected failure count and last error to be reset, got %d and '%s'", status.FailureCount, status.LastError)
+	}
+	for _, conditionType := range []string{akv.AzureKeyVaultSecretConditionReady, akv.AzureKeyVaultSecretConditionSyncedFromAzure} {
+		if !meta.IsStatusConditionTrue(status.Conditions, conditionType) {
+			t.Errorf("expected %s condition to be true", conditionType)
+		}
+	}
+	if !meta.IsStatusConditionFalse(status.Conditions, akv.AzureKeyVaultSecretConditionOutputConflict) {
+		t.Error("expected OutputConflict condition to be false")
+	}
+	if ready := meta.FindStatusCondition(status.Conditions, akv.AzureKeyVaultSecretConditionReady); ready.ObservedGeneration != 3 {
+		t.Errorf("expected Ready condition for generation 3, got %d", ready.ObservedGeneration)
+	}
+}
+
+func TestSetSyncStatusOutputConflict(t *testing.T) {
+	status := &akv.AzureKeyVaultSecretStatus{}
+	setSyncStatus(status, 1, newSyncError(ReasonOutputConflict, fmt.Errorf("secret exists")))
+
+	if !meta.IsStatusConditionTrue(status.Conditions, akv.AzureKeyVaultSecretConditionOutputConflict) {
+		t.Error("expected OutputConflict condition to be true")
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, akv.AzureKeyVaultSecretConditionSyncedFromAzure) {
+		t.Error("expected SyncedFromAzure condition to be true")
+	}
+	ready := meta.FindStatusCondition(status.Conditions, akv.AzureKeyVaultSecretConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != ReasonOutputConflict {
+		t.Errorf("expected Ready condition to be false with reason %s, got %+v", ReasonOutputConflict, ready)
+	}
+}
+
+func TestSetSyncStatusUnknownError(t *testing.T) {
+	status := &akv.AzureKeyVaultSecretStatus{}
+	setSyncStatus(status, 1, fmt.Errorf("something failed"))
+
+	ready := meta.FindStatusCondition(status.Conditions, akv.AzureKeyVaultSecretConditionReady)
+	if ready == nil || ready.Reason != ReasonSyncFailed {
+		t.Errorf("expected Ready condition with reason %s, got %+v", ReasonSyncFailed, ready)
+	}
+	if meta.FindStatusCondition(status.Conditions, akv.AzureKeyVaultSecretConditionSyncedFromAzure) != nil {
+		t.Error("expected SyncedFromAzure condition not to be set")
+	}
+}
+
+func TestUpdateSyncStatus(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "my-secret",
+			Namespace:  "default",
+			Generation: 4,
+		},
+	}
+	client := akvfake.NewSimpleClientset(akvs)
+	c := &Controller{akvsClient: client}
+
+	if err := c.updateSyncStatus(akvs, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := client.AzureKeyVaultV2beta1().AzureKeyVaultSecrets("default").Get(context.TODO(), "my-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.ObservedGeneration != 4 {
+		t.Errorf("expected observed generation 4, got %d", updated.Status.ObservedGeneration)
+	}
+	if !meta.IsStatusConditionTrue(updated.Status.Conditions, akv.AzureKeyVaultSecretConditionReady) {
+		t.Error("expected Ready condition to be true")
+	}
+
+	// Unchanged status should not be written again
+	client.ClearActions()
+	if err := c.updateSyncStatus(updated, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no updates of unchanged status, got %d actions", len(actions))
+	}
+}
+
+func TestUpdateSyncStatusWithOutputs(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "my-secret",
+			Namespace:  "default",
+			Generation: 2,
+		},
+	}
+	client := akvfake.NewSimpleClientset(akvs)
+	c := &Controller{akvsClient: client, clock: &fakeClock{now: time.Now()}}
+
+	client.ClearActions()
+	if err := c.updateSyncStatus(akvs, nil, c.outputStatus("my-secret", "", "hash", "", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if actions := client.Actions(); len(actions) != 1 {
+		t.Fatalf("expected outputs and sync status to be updated together, got %d actions", len(actions))
+	}
+
+	updated, err := client.AzureKeyVaultV2beta1().AzureKeyVaultSecrets("default").Get(context.TODO(), "my-secret", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.SecretHash != "hash" || updated.Status.SecretName != "my-secret" {
+		t.Errorf("expected output in status, got secret '%s' with hash '%s'", updated.Status.SecretName, updated.Status.SecretHash)
+	}
+	if !meta.IsStatusConditionTrue(updated.Status.Conditions, akv.AzureKeyVaultSecretConditionReady) {
+		t.Error("expected Ready condition to be true")
+	}
+}
@@ -24,7 +24,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/spf13/viper"
 
@@ -46,6 +45,7 @@ import (
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/cmd/azure-keyvault-controller/controller"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	clientset "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
@@ -68,6 +68,20 @@ func initConfig() {
 	viper.SetDefault("auth_type", "azureCloudConfig")
 	viper.SetDefault("metrics_enabled", false)
 	viper.SetDefault("metrics_port", "9000")
+	viper.SetDefault("vault_request_timeout", "30s")
+	viper.SetDefault("vault_cache_enabled", true)
+	viper.SetDefault("vault_cache_ttl", "30s")
+	viper.SetDefault("vault_cache_versioned_ttl", "1h")
+	viper.SetDefault("vault_cache_negative_ttl", "30s")
+	viper.SetDefault("resync_period", "30s")
+	viper.SetDefault("default_refresh_interval", "30s")
+	viper.SetDefault("refresh_jitter", 0.1)
+	viper.SetDefault("rollout_mode", string(controller.RolloutModeDisabled))
+	viper.SetDefault("rollout_qps", controller.DefaultRolloutQPS)
+	viper.SetDefault("rollout_burst", controller.DefaultRolloutBurst)
+	viper.SetDefault("azure_identities_enabled", false)
+	viper.SetDefault("azure_identity_bindings", "")
+	viper.SetDefault("azure_key_vault_credentials_enabled", false)
 
 	viper.AutomaticEnv()
 }
@@ -155,8 +169,9 @@ func main() {
 			options.LabelSelector = labelSelectorAppender(options.LabelSelector, objectLabelSet)
 		}))
 	}
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30, kubeInformerOptions...)
-	azureKeyVaultSecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(azureKeyVaultSecretClient, time.Second*30, akvInformerOptions...)
+	resyncPeriod := viper.GetDuration("resync_period")
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeInformerOptions...)
+	azureKeyVaultSecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(azureKeyVaultSecretClient, resyncPeriod, akvInformerOptions...)
 
 	klog.InfoS("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
@@ -177,6 +192,12 @@ func main() {
 			klog.ErrorS(err, "failed to create credentials provider from environment for azure key vault")
 			os.Exit(1)
 		}
+	case "workloadIdentity":
+		vaultAuth, err = getCredentialsFromWorkloadIdentity()
+		if err != nil {
+			klog.ErrorS(err, "failed to create workload identity credentials provider for azure key vault")
+			os.Exit(1)
+		}
 	default:
 		klog.ErrorS(nil, "auth type not supported", "type", authType)
 		os.Exit(1)
@@ -188,12 +209,72 @@ func main() {
 		os.Exit(1)
 	}
 
-	vaultService := vault.NewService(vaultAuth)
+	vaultService := newVaultService(vaultAuth)
+
+	var identityServices *vault.IdentityServicePool
+	var identityBindings identity.Bindings
+	if viper.GetBool("azure_identities_enabled") {
+		nodeName := viper.GetString("node_name")
+		if nodeName == "" {
+			klog.ErrorS(nil, "env var NODE_NAME required when azure identities are enabled")
+			os.Exit(1)
+		}
+
+		identityProvider, err := credentialprovider.NewUserAssignedManagedIdentityProvider(cloudconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to create user assigned managed identity provider", "file", cloudconfig)
+			os.Exit(1)
+		}
+
+		identityBindings, err = identity.ParseBindings(viper.GetString("azure_identity_bindings"))
+		if err != nil {
+			klog.ErrorS(err, "invalid azure identity bindings", "env", "AZURE_IDENTITY_BINDINGS")
+			os.Exit(1)
+		}
+		if len(identityBindings) == 0 {
+			klog.InfoS("no azure identities bound to any namespace - set AZURE_IDENTITY_BINDINGS to allow azurekeyvaultsecrets to use azure identities")
+		}
+
+		klog.InfoS("azure identities enabled", "node", nodeName, "namespaces", identityBindings.Namespaces())
+		identityServices = vault.NewIdentityServicePool(func(azureIdentity string) (vault.Service, error) {
+			credentials, err := identityProvider.GetAzureKeyVaultCredentials(azureIdentity, nodeName)
+			if err != nil {
+				return nil, err
+			}
+			return newVaultService(credentials), nil
+		})
+	}
+
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
+	rolloutMode := controller.RolloutMode(viper.GetString("rollout_mode"))
+	switch rolloutMode {
+	case controller.RolloutModeDisabled, controller.RolloutModeEvent, controller.RolloutModeEnabled:
+	default:
+		klog.ErrorS(nil, "rollout mode not supported", "mode", rolloutMode)
+		os.Exit(1)
+	}
+
 	options := &controller.Options{
-		MaxNumRequeues: 5,
-		NumThreads:     1,
+		MaxNumRequeues:         5,
+		NumThreads:             1,
+		ResyncPeriod:           resyncPeriod,
+		DefaultRefreshInterval: viper.GetDuration("default_refresh_interval"),
+		RefreshJitter:          viper.GetFloat64("refresh_jitter"),
+		RolloutMode:            rolloutMode,
+		RolloutQPS:             float32(viper.GetFloat64("rollout_qps")),
+		RolloutBurst:           viper.GetInt("rollout_burst"),
+		AzureIdentityBindings:  identityBindings,
+	}
+
+	if rolloutMode != controller.RolloutModeDisabled {
+		// workloads to restart do not have the object labels, so they are listed in the watched namespace only
+		options.WorkloadInformerFactory = kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeinformers.WithNamespace(watchNamespace))
+	}
+
+	if viper.GetBool("azure_key_vault_credentials_enabled") {
+		klog.InfoS("azurekeyvaultcredentials enabled")
+		options.NewCredentialVaultService = newVaultService
 	}
 
 	controller := controller.NewController(
@@ -203,11 +284,30 @@ func main() {
 		kubeInformerFactory,
 		recorder,
 		vaultService,
+		identityServices,
 		options)
 
 	controller.Run(stopCh)
 }
 
+// newVaultService creates an Azure Key Vault service using credentials,
+// cached if vault caching is enabled. Objects are never cached for longer than the
+// refresh interval of the AzureKeyVaultSecret requesting them, even if the ttl is longer.
+func newVaultService(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+	vaultService := vault.NewService(credentials, viper.GetDuration("vault_request_timeout"))
+	if !viper.GetBool("vault_cache_enabled") {
+		return vaultService
+	}
+
+	cacheOptions := vault.CacheOptions{
+		TTL:          viper.GetDuration("vault_cache_ttl"),
+		VersionedTTL: viper.GetDuration("vault_cache_versioned_ttl"),
+		NegativeTTL:  viper.GetDuration("vault_cache_negative_ttl"),
+	}
+	klog.V(4).InfoS("caching azure key vault objects", "ttl", cacheOptions.TTL, "versionedTTL", cacheOptions.VersionedTTL, "negativeTTL", cacheOptions.NegativeTTL)
+	return vault.NewCachedService(vaultService, cacheOptions)
+}
+
 func validateCredentials(credentials credentialprovider.Credentials) error {
 	klog.V(4).InfoS("checking credentials by getting authorizer")
 	_, err := credentials.Authorizer()
@@ -264,3 +364,12 @@ func getCredentialsFromEnvironment() (credentialprovider.AzureKeyVaultCredential
 
 	return provider.GetAzureKeyVaultCredentials()
 }
+
+func getCredentialsFromWorkloadIdentity() (credentialprovider.AzureKeyVaultCredentials, error) {
+	provider, err := credentialprovider.NewFromWorkloadIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workload identity credentials provider, error: %+v", err)
+	}
+
+	return provider.GetAzureKeyVaultCredentials()
+}
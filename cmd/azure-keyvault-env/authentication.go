@@ -31,12 +31,16 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
 	"k8s.io/klog/v2"
 )
 
+// authServiceRequestTimeout is longer than the auth service takes to resolve the secrets of a pod
+const authServiceRequestTimeout = time.Minute
+
 func createHTTPClientWithTrustedCAAndMtls(caCert, clientCert, clientKey []byte) (*http.Client, error) {
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(caCert)
@@ -53,7 +57,7 @@ func createHTTPClientWithTrustedCAAndMtls(caCert, clientCert, clientKey []byte)
 	tlsConf.BuildNameToCertificate()
 
 	tlsClient := &http.Client{
-		Timeout: time.Second * 10,
+		Timeout: authServiceRequestTimeout,
 		Transport: &http.Transport{
 			TLSClientConfig: tlsConf,
 		},
@@ -87,88 +91,131 @@ func createMtlsClient(clientCertDir string) (*http.Client, error) {
 	return client, nil
 }
 
-func getCredentials(useAuthService bool, authServiceAddress string, authServiceValidationAddress string, clientCertDir string) (credentialprovider.AzureKeyVaultCredentials, error) {
-	if useAuthService {
-		startupCACert, err := ioutil.ReadFile(path.Join(clientCertDir, "ca.crt"))
-		if err != nil {
-			return nil, err
-		}
+// newAuthServiceClient creates a client for the akv2k8s auth service, after making sure the client
+// certificate of the pod is not stale. Returns the client and the service account token of the pod.
+func newAuthServiceClient(authServiceValidationAddress string, clientCertDir string) (*http.Client, string, error) {
+	startupCACert, err := ioutil.ReadFile(path.Join(clientCertDir, "ca.crt"))
+	if err != nil {
+		return nil, "", err
+	}
 
-		validationUrl := fmt.Sprintf("%s/auth/%s/%s?secret=%s", authServiceValidationAddress, config.namespace, config.podName, config.authServiceSecret)
-		klog.InfoS("checking if current auth service credentials are stale", "url", validationUrl)
+	startupClientCert, err := ioutil.ReadFile(path.Join(clientCertDir, "tls.crt"))
+	if err != nil {
+		return nil, "", err
+	}
 
-		stale := false
-		valClient := &http.Client{
-			Timeout: time.Second * 10,
-		}
-		valRes, err := valClient.Get(validationUrl)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check for stale credentials: %w", err)
-		}
-		defer valRes.Body.Close()
-
-		if valRes.StatusCode == http.StatusOK {
-			klog.InfoS("auth service credentials ok", "url", validationUrl)
-		} else if valRes.StatusCode == http.StatusCreated {
-			klog.InfoS("auth service credentials were stale, but now updated - expect some time before the pod gets updated with the new secret", "url", validationUrl)
-			stale = true
-		} else {
-			klog.ErrorS(nil, "failed to validate credentials", "url", validationUrl, "status", valRes.Status, "statusCode", valRes.StatusCode)
-			return nil, fmt.Errorf("failed to validate credentials, got http status code %v", valRes.StatusCode)
-		}
+	token, err := readServiceAccountToken(config.authServiceTokenFile)
+	if err != nil {
+		return nil, "", err
+	}
 
-		if stale {
-			klog.InfoS("checking for updated credentials", "retryTimes", 20)
-			err = retry(20, time.Second*5, func() error {
-				currentCACert, err := ioutil.ReadFile(path.Join(clientCertDir, "ca.crt"))
-				if err != nil {
-					return err
-				}
+	validationUrl := fmt.Sprintf("%s/auth/%s/%s?secret=%s", authServiceValidationAddress, config.namespace, config.podName, config.authServiceSecret)
+	klog.InfoS("checking if current auth service credentials are stale", "url", validationUrl)
 
-				if string(startupCACert) == string(currentCACert) {
-					return fmt.Errorf("credentials are still stale")
-				}
+	stale := false
+	valClient := &http.Client{
+		Timeout: time.Second * 10,
+	}
+	valRes, err := authServiceGet(valClient, validationUrl, token)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to check for stale credentials: %w", err)
+	}
+	defer valRes.Body.Close()
+
+	if valRes.StatusCode == http.StatusOK {
+		klog.InfoS("auth service credentials ok", "url", validationUrl)
+	} else if valRes.StatusCode == http.StatusCreated {
+		klog.InfoS("auth service credentials were stale, but now updated - expect some time before the pod gets updated with the new secret", "url", validationUrl)
+		stale = true
+	} else {
+		klog.ErrorS(nil, "failed to validate credentials", "url", validationUrl, "status", valRes.Status, "statusCode", valRes.StatusCode)
+		return nil, "", fmt.Errorf("failed to validate credentials, got http status code %v", valRes.StatusCode)
+	}
 
-				klog.InfoS("credentials updated - good to go!")
-				return nil
-			})
+	if stale {
+		klog.InfoS("checking for updated credentials", "retryTimes", 20)
+		err = retry(20, time.Second*5, func() error {
+			currentCACert, err := ioutil.ReadFile(path.Join(clientCertDir, "ca.crt"))
+			if err != nil {
+				return err
+			}
 
+			currentClientCert, err := ioutil.ReadFile(path.Join(clientCertDir, "tls.crt"))
 			if err != nil {
-				return nil, fmt.Errorf("credentials was never updated, failedTimes: %v, err: %w", 20, err)
+				return err
 			}
-		}
 
-		client, err := createMtlsClient(clientCertDir)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create mtls http client, err: %w", err)
-		}
+			// Renewed client certificates are signed by the same ca
+			if string(startupCACert) == string(currentCACert) && string(startupClientCert) == string(currentClientCert) {
+				return fmt.Errorf("credentials are still stale")
+			}
 
-		url := fmt.Sprintf("%s/auth/%s/%s", authServiceAddress, config.namespace, config.podName)
-		klog.InfoS("requesting azure key vault oauth token", "url", url)
+			klog.InfoS("credentials updated - good to go!")
+			return nil
+		})
 
-		res, err := client.Get(url)
 		if err != nil {
-			klog.ErrorS(err, "request token failed", "url", url)
-			return nil, fmt.Errorf("request token failed, err: %w", err)
+			return nil, "", fmt.Errorf("credentials was never updated, failedTimes: %v, err: %w", 20, err)
 		}
-		defer res.Body.Close()
+	}
 
-		if res.StatusCode != http.StatusOK {
-			klog.ErrorS(err, "failed to get credentials", "url", url, "status", res.Status, "statusCode", res.StatusCode)
-			return nil, fmt.Errorf("request token failed with status code %v", res.StatusCode)
-		}
+	client, err := createMtlsClient(clientCertDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create mtls http client, err: %w", err)
+	}
+	return client, token, nil
+}
+
+// getSecretsFromAuthService gets the values of the AzureKeyVaultSecrets referenced by the env vars of this pod
+// from the auth service, keyed by env var value
+func getSecretsFromAuthService(authServiceAddress string, authServiceValidationAddress string, clientCertDir string) (map[string]string, error) {
+	client, token, err := newAuthServiceClient(authServiceValidationAddress, clientCertDir)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/secrets/%s/%s", authServiceAddress, config.namespace, config.podName)
+	klog.InfoS("requesting azure key vault secrets", "url", url)
+
+	res, err := authServiceGet(client, url, token)
+	if err != nil {
+		klog.ErrorS(err, "request secrets failed", "url", url)
+		return nil, fmt.Errorf("request secrets failed, err: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		klog.ErrorS(nil, "failed to get secrets", "url", url, "status", res.Status, "statusCode", res.StatusCode)
+		return nil, fmt.Errorf("request secrets failed with status code %v: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secrets map[string]string
+	err = json.NewDecoder(res.Body).Decode(&secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode body, error %w", err)
+	}
+
+	klog.InfoS("successfully received secrets", "count", len(secrets))
+	return secrets, nil
+}
 
-		var creds *credentialprovider.OAuthCredentials
-		err = json.NewDecoder(res.Body).Decode(&creds)
+func getCredentials() (credentialprovider.AzureKeyVaultCredentials, error) {
+	// Not using auth service - getting credentials from workload identity or environment
+	if credentialprovider.IsWorkloadIdentityEnvironment() {
+		klog.InfoS("using workload identity federated token", "file", os.Getenv(credentialprovider.WorkloadIdentityFederatedTokenFileEnv))
+		provider, err := credentialprovider.NewFromWorkloadIdentity()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode body, error %w", err)
+			return nil, fmt.Errorf("failed to create workload identity credentials provider for azure key vault, error: %w", err)
 		}
 
-		klog.InfoS("successfully received oauth token")
+		creds, err := provider.GetAzureKeyVaultCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials for azure key vault, error: %w", err)
+		}
 		return creds, nil
 	}
 
-	// Not using auth service - getting credentials from environment
 	provider, err := credentialprovider.NewFromEnvironment()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create credentials provider for azure key vault, error: %w", err)
@@ -181,6 +228,26 @@ func getCredentials(useAuthService bool, authServiceAddress string, authServiceV
 	return creds, nil
 }
 
+// readServiceAccountToken reads the projected service account token presented to the auth service.
+// The token is rotated by kubelet, so it is read on every attempt.
+func readServiceAccountToken(tokenFile string) (string, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token file '%s', error: %w", tokenFile, err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// authServiceGet requests url, authenticating with the service account token of the pod
+func authServiceGet(client *http.Client, url string, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return client.Do(req)
+}
+
 func verifyPKCS(signature string, plaintext string, pubkey rsa.PublicKey) bool {
 	sig, _ := base64.StdEncoding.DecodeString(signature)
 	hashed := sha256.Sum256([]byte(plaintext))
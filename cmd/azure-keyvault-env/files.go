@@ -0,0 +1,107 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	"k8s.io/klog/v2"
+)
+
+// writeSecretFiles resolves the AzureKeyVaultSecret of each secret file and writes it to dir,
+// returning true if any file was created or changed
+func writeSecretFiles(ctx context.Context, resolver secretResolver, dir string, files []injector.SecretFile) (bool, error) {
+	changed := false
+	for _, file := range files {
+		value := file.Reference()
+		ref, err := injector.ParseReference(value)
+		if err != nil {
+			return changed, fmt.Errorf("invalid secret for secret file '%s', error: %+v", file.Path, err)
+		}
+
+		klog.V(4).InfoS("getting secret value from azure key vault, to write to file", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "file", file.Path)
+		secret, err := resolver.resolve(ctx, value, ref)
+		if err != nil {
+			return changed, fmt.Errorf("failed to read secret '%s' from azure key vault for secret file '%s', error: %+v", ref.Name, file.Path, err)
+		}
+
+		content, err := file.Content(secret)
+		if err != nil {
+			return changed, err
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, content) {
+			klog.V(4).InfoS("secret file unchanged", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "file", path)
+			continue
+		}
+
+		if err := writeSecretFile(path, content, file); err != nil {
+			return changed, fmt.Errorf("failed to write secret file '%s', error: %+v", path, err)
+		}
+		changed = true
+		klog.InfoS("secret written to file", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "file", path, "format", file.Format)
+	}
+	return changed, nil
+}
+
+// writeSecretFile atomically writes content to path with the mode and owner of file,
+// so that a process never sees a partially written file
+func writeSecretFile(path string, content []byte, file injector.SecretFile) error {
+	mode, err := file.FileMode()
+	if err != nil {
+		return err
+	}
+	uid, gid, err := file.Ownership()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	// only chown when an owner is set, as containers not running as root cannot
+	if file.Owner != "" {
+		if err := os.Chown(tmp.Name(), uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner to '%s', error: %+v", file.Owner, err)
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,195 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+)
+
+const testPEM = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func TestWriteSecretFiles(t *testing.T) {
+	owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	secrets := resolvedSecrets{
+		"my-secret@azurekeyvault":         "password",
+		"my-cert@azurekeyvault?tls.crt":   testPEM,
+		"my-cert@azurekeyvault?pfx":       "AQID",
+		"not-pem@azurekeyvault":           "not pem",
+		"invalid-pfx@azurekeyvault?pfx":   "not base64!",
+		"other-secret@azurekeyvault?json": "{}",
+	}
+
+	tests := []struct {
+		name    string
+		file    injector.SecretFile
+		content string
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{
+			name:    "raw",
+			file:    injector.SecretFile{Path: "password", Secret: "my-secret"},
+			content: "password",
+			mode:    0400,
+		},
+		{
+			name:    "raw with query",
+			file:    injector.SecretFile{Path: "config.json", Secret: "other-secret?json", Format: injector.SecretFileFormatRaw},
+			content: "{}",
+			mode:    0400,
+		},
+		{
+			name:    "pem",
+			file:    injector.SecretFile{Path: "tls/tls.crt", Secret: "my-cert?tls.crt", Format: injector.SecretFileFormatPEM},
+			content: testPEM,
+			mode:    0400,
+		},
+		{
+			name:    "pfx",
+			file:    injector.SecretFile{Path: "keystore.pfx", Secret: "my-cert", Format: injector.SecretFileFormatPFX},
+			content: "\x01\x02\x03",
+			mode:    0400,
+		},
+		{
+			name:    "mode",
+			file:    injector.SecretFile{Path: "password", Secret: "my-secret", Mode: "0440"},
+			content: "password",
+			mode:    0440,
+		},
+		{
+			name:    "owner",
+			file:    injector.SecretFile{Path: "password", Secret: "my-secret", Owner: owner},
+			content: "password",
+			mode:    0400,
+		},
+		{
+			name:    "value not in pem format",
+			file:    injector.SecretFile{Path: "tls.crt", Secret: "not-pem", Format: injector.SecretFileFormatPEM},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pfx",
+			file:    injector.SecretFile{Path: "keystore.pfx", Secret: "invalid-pfx", Format: injector.SecretFileFormatPFX},
+			wantErr: true,
+		},
+		{
+			name:    "unresolved secret",
+			file:    injector.SecretFile{Path: "password", Secret: "missing"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			changed, err := writeSecretFiles(context.Background(), secrets, dir, []injector.SecretFile{tt.file})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeSecretFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if changed {
+					t.Error("expected no file to be changed on error")
+				}
+				return
+			}
+			if !changed {
+				t.Error("expected new file to be reported as changed")
+			}
+
+			path := filepath.Join(dir, filepath.FromSlash(tt.file.Path))
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.content {
+				t.Errorf("expected content %q, got %q", tt.content, content)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.mode {
+				t.Errorf("expected mode %o, got %o", tt.mode, info.Mode().Perm())
+			}
+			stat := info.Sys().(*syscall.Stat_t)
+			if int(stat.Uid) != os.Getuid() || int(stat.Gid) != os.Getgid() {
+				t.Errorf("expected file to be owned by %s, got %d:%d", owner, stat.Uid, stat.Gid)
+			}
+
+			if changed, err := writeSecretFiles(context.Background(), secrets, dir, []injector.SecretFile{tt.file}); err != nil || changed {
+				t.Errorf("expected unchanged file not to be rewritten, changed: %t, error: %+v", changed, err)
+			}
+		})
+	}
+}
+
+func TestWriteSecretFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "password")
+	file := injector.SecretFile{Path: "password", Secret: "my-secret"}
+
+	if err := writeSecretFile(path, []byte("old"), file); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if err := writeSecretFile(path, []byte("new"), file); err != nil {
+		t.Fatal(err)
+	}
+
+	// a process reading the file before it was replaced still sees the whole old content
+	old, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != "old" {
+		t.Errorf("expected file to be replaced by rename, open file has %q", old)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "new" {
+		t.Errorf("expected new content, got %q", content)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary file to be removed, got %d files", len(entries))
+	}
+}
+
+func TestWriteSecretFileWithInvalidOwner(t *testing.T) {
+	dir := t.TempDir()
+	file := injector.SecretFile{Path: "password", Secret: "my-secret", Owner: "nobody"}
+	if err := writeSecretFile(filepath.Join(dir, "password"), []byte("password"), file); err == nil {
+		t.Error("expected error for invalid owner")
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected no file to be written, got %d files", len(entries))
+	}
+}
@@ -19,43 +19,47 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s"
-	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
-	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
-	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
-	clientset "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
 	"github.com/spf13/viper"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/rest"
 	jsonlogs "k8s.io/component-base/logs/json"
 	"k8s.io/klog/v2"
 )
 
-const (
-	envLookupKey = "@azurekeyvault"
-)
-
 type injectorConfig struct {
 	namespace                    string
 	podName                      string
 	clientCertDir                string
 	retryTimes                   int
 	waitTimeBetweenRetries       int
+	vaultRequestTimeout          int
 	useAuthService               bool
 	skipArgsValidation           bool
 	authServiceAddress           string
 	authServiceValidationAddress string
 	authServiceSecret            string
+	authServiceTokenFile         string
 	signatureB64                 string
 	pubKeyBase64                 string
+	secretFilesDir               string
+	secretFiles                  []injector.SecretFile
+	refreshInterval              time.Duration
+	reloadSignal                 string
+	reloadProcess                string
+	reloadURL                    string
+	envFromOptions               injector.EnvFromOptions
+	concurrency                  int
 }
 
 var config injectorConfig
@@ -101,31 +105,11 @@ func retry(attempts int, sleep time.Duration, fn func() error) error {
 	return nil
 }
 
-func getSecretFromKeyVault(azureKeyVaultSecret *akv.AzureKeyVaultSecret, query string, vaultService vault.Service) (string, error) {
-	var secretHandler EnvSecretHandler
-
-	switch azureKeyVaultSecret.Spec.Vault.Object.Type {
-	case akv.AzureKeyVaultObjectTypeSecret:
-		transformator, err := transformers.CreateTransformator(&azureKeyVaultSecret.Spec.Output)
-		if err != nil {
-			return "", err
-		}
-		secretHandler = NewAzureKeyVaultSecretHandler(azureKeyVaultSecret, query, *transformator, vaultService)
-	case akv.AzureKeyVaultObjectTypeCertificate:
-		secretHandler = NewAzureKeyVaultCertificateHandler(azureKeyVaultSecret, query, vaultService)
-	case akv.AzureKeyVaultObjectTypeKey:
-		secretHandler = NewAzureKeyVaultKeyHandler(azureKeyVaultSecret, query, vaultService)
-	case akv.AzureKeyVaultObjectTypeMultiKeyValueSecret:
-		secretHandler = NewAzureKeyVaultMultiKeySecretHandler(azureKeyVaultSecret, query, vaultService)
-	default:
-		return "", fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
-	}
-	return secretHandler.Handle()
-}
-
 func initConfig() {
 	viper.SetDefault("env_injector_retries", 3)
 	viper.SetDefault("env_injector_wait_before_retry", 3)
+	viper.SetDefault("env_injector_vault_request_timeout", 30)
+	viper.SetDefault("env_injector_concurrency", 10)
 	viper.SetDefault("env_injector_use_auth_service", true)
 
 	viper.SetDefault("env_injector_skip_args_validation", false)
@@ -188,16 +172,51 @@ func main() {
 		authServiceAddress:           viper.GetString("env_injector_auth_service"),
 		authServiceValidationAddress: viper.GetString("env_injector_auth_service_validation"),
 		authServiceSecret:            viper.GetString("env_injector_auth_service_secret"),
+		authServiceTokenFile:         viper.GetString("env_injector_auth_service_token_file"),
 
 		// optional
 		retryTimes:             viper.GetInt("env_injector_retries"),
 		waitTimeBetweenRetries: viper.GetInt("env_injector_wait_before_retry"),
+		vaultRequestTimeout:    viper.GetInt("env_injector_vault_request_timeout"),
+		concurrency:            viper.GetInt("env_injector_concurrency"),
 		skipArgsValidation:     viper.GetBool("env_injector_skip_args_validation"),
+		secretFilesDir:         viper.GetString("env_injector_secret_files_dir"),
+
+		// sidecar refreshing secret files
+		refreshInterval: viper.GetDuration("env_injector_refresh_interval"),
+		reloadSignal:    viper.GetString("env_injector_reload_signal"),
+		reloadProcess:   viper.GetString("env_injector_reload_process"),
+		reloadURL:       viper.GetString("env_injector_reload_url"),
+	}
+
+	if secretFiles := viper.GetString("env_injector_secret_files"); secretFiles != "" {
+		config.secretFiles, err = injector.ParseSecretFiles(secretFiles)
+		if err != nil {
+			klog.ErrorS(err, "failed to parse secret files", "env", "ENV_INJECTOR_SECRET_FILES")
+			os.Exit(1)
+		}
+	}
+
+	config.envFromOptions = injector.DefaultEnvFromOptions()
+	if envFromOptions := viper.GetString("env_injector_env_from_options"); envFromOptions != "" {
+		if err := json.Unmarshal([]byte(envFromOptions), &config.envFromOptions); err != nil {
+			klog.ErrorS(err, "failed to parse env from options", "env", "ENV_INJECTOR_ENV_FROM_OPTIONS")
+			os.Exit(1)
+		}
+		if err := config.envFromOptions.Validate(); err != nil {
+			klog.ErrorS(err, "invalid env from options", "env", "ENV_INJECTOR_ENV_FROM_OPTIONS")
+			os.Exit(1)
+		}
 	}
 
-	requiredEnvVars := map[string]string{
-		"env_injector_args_signature": config.signatureB64,
-		"env_injector_args_key":       config.pubKeyBase64,
+	requiredEnvVars := map[string]string{}
+
+	// the refresher sidecar runs no command, so has no args to validate
+	if config.refreshInterval > 0 {
+		requiredEnvVars["env_injector_secret_files"] = viper.GetString("env_injector_secret_files")
+	} else {
+		requiredEnvVars["env_injector_args_signature"] = config.signatureB64
+		requiredEnvVars["env_injector_args_key"] = config.pubKeyBase64
 	}
 
 	if config.useAuthService {
@@ -207,13 +226,27 @@ func main() {
 		requiredEnvVars["env_injector_auth_service"] = config.authServiceAddress
 		requiredEnvVars["env_injector_auth_service_validation"] = config.authServiceValidationAddress
 		requiredEnvVars["env_injector_auth_service_secret"] = config.authServiceSecret
+		requiredEnvVars["env_injector_auth_service_token_file"] = config.authServiceTokenFile
+	}
+
+	if len(config.secretFiles) > 0 {
+		requiredEnvVars["env_injector_secret_files_dir"] = config.secretFilesDir
 	}
 
 	// Manual env vars
 	//
 	// env_injector_retries
 	// env_injector_wait_before_retry
+	// env_injector_vault_request_timeout
 	// env_injector_skip_args_validation
+	// env_injector_secret_files
+	// env_injector_secret_files_dir
+	// env_injector_refresh_interval
+	// env_injector_reload_signal
+	// env_injector_reload_process
+	// env_injector_reload_url
+	// env_injector_env_from_options
+	// env_injector_concurrency
 
 	err = validateConfig(requiredEnvVars)
 	if err != nil {
@@ -227,6 +260,26 @@ func main() {
 		klog.InfoS("akv2k8s auth service not enabled - will look for azure key vault credentials locally")
 	}
 
+	if config.refreshInterval > 0 {
+		reloader, err := newReloader(injector.SecretFilesRefresh{
+			Interval:      config.refreshInterval,
+			ReloadSignal:  config.reloadSignal,
+			ReloadProcess: config.reloadProcess,
+			ReloadURL:     config.reloadURL,
+		})
+		if err != nil {
+			klog.ErrorS(err, "invalid reload configuration")
+			os.Exit(1)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		refreshSecretFiles(ctx, config.refreshInterval, reloader, newResolver)
+		klog.InfoS("azure key vault secret files refresher stopped")
+		return
+	}
+
 	if len(os.Args) == 1 {
 		klog.ErrorS(err, "no command is given")
 		os.Exit(1)
@@ -245,105 +298,120 @@ func main() {
 		klog.InfoS("found original container command", "cmd", origCommand, "args", origArgs)
 	}
 
-	creds, err := getCredentials(config.useAuthService, config.authServiceAddress, config.authServiceValidationAddress, config.clientCertDir)
+	// cancel any in-flight requests to azure key vault if the container is stopped
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
+	resolver, err := newResolver()
 	if err != nil {
-		klog.V(4).InfoS("failed to get credentials, will retry", "retryTimes", config.retryTimes)
-		err = retry(config.retryTimes, time.Second*time.Duration(config.waitTimeBetweenRetries), func() error {
-			creds, err = getCredentials(config.useAuthService, config.authServiceAddress, config.authServiceValidationAddress, config.clientCertDir)
-			if err != nil {
-				return err
-			}
-			klog.Info("succeded getting credentials")
-			return nil
-		})
-		if err != nil {
-			klog.ErrorS(err, "failed to get credentials", "failedTimes", config.retryTimes)
-			os.Exit(1)
-		}
+		klog.ErrorS(err, "failed to get credentials", "failedTimes", config.retryTimes)
+		os.Exit(1)
 	}
 
-	vaultService := vault.NewService(creds)
+	environ := os.Environ()
 
-	klog.V(4).InfoS("reading azurekeyvaultsecret's referenced in env variables")
-	cfg, err := rest.InClusterConfig()
+	// resolve all secrets up front, so that each azurekeyvaultsecret is only fetched once
+	refs, err := collectReferences(environ, config.secretFiles)
 	if err != nil {
-		klog.ErrorS(err, "error building kubeconfig")
+		klog.ErrorS(err, "failed to collect azure key vault secret references")
 		os.Exit(1)
 	}
-
-	azureKeyVaultSecretClient, err := clientset.NewForConfig(cfg)
+	secrets, err := resolveReferences(ctx, resolver, refs, config.concurrency)
 	if err != nil {
-		klog.ErrorS(err, "error building azurekeyvaultsecret clientset")
+		klog.ErrorS(err, "failed to read secrets from azure key vault")
 		os.Exit(1)
 	}
 
-	environ := os.Environ()
+	// env vars set explicitly take precedence over keys expanded from a secret, as with envFrom in kubernetes
+	explicit := make(map[string]bool, len(environ))
+	for _, env := range environ {
+		explicit[strings.SplitN(env, "=", 2)[0]] = true
+	}
 
-	for i, env := range environ {
+	injected := make([]string, 0, len(environ))
+	for _, env := range environ {
 		split := strings.SplitN(env, "=", 2)
 		name := split[0]
 		value := split[1]
 
-		// e.g. my-akv-secret-name@azurekeyvault?some-sub-key
-		if strings.Contains(value, envLookupKey) {
-			// e.g. my-akv-secret-name?some-sub-key
-			klog.V(4).InfoS("found env var to get azure key vault secret for", "env", name)
-			akvsName := strings.Join(strings.Split(value, envLookupKey), "")
-
-			if akvsName == "" {
-				klog.ErrorS(fmt.Errorf("error extracting secret name"), "env variable not properly formatted", "env", name, "value", value)
+		// e.g. postgres://{{ akvs "db-user" }}:{{ akvs "db-pass" }}@host/db
+		if injector.IsTemplate(value) {
+			klog.V(4).InfoS("found env var with template to render with azure key vault secrets", "env", name)
+			rendered, err := resolveTemplate(ctx, secrets, name, value)
+			if err != nil {
+				klog.ErrorS(err, "failed to render template with azure key vault secrets", "env", name)
 				os.Exit(1)
 			}
 
-			var secretQuery string
-			if query := strings.Split(akvsName, "?"); len(query) > 1 {
-				if len(query) > 2 {
-					klog.ErrorS(fmt.Errorf("error extracting secret query"), "multiple query elements defined with '?' - only one supported", "secret", akvsName)
-					os.Exit(1)
-				}
-				akvsName = query[0]
-				secretQuery = query[1]
-				klog.V(4).InfoS("found query in env var", "env", name, "value", value, "query", secretQuery)
-			}
+			klog.InfoS("secrets injected into env var from template", "env", name)
+			injected = append(injected, fmt.Sprintf("%s=%s", name, rendered))
+			continue
+		}
 
-			klog.V(4).InfoS("getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, akvsName))
-			akvs, err := azureKeyVaultSecretClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(config.namespace).Get(context.TODO(), akvsName, v1.GetOptions{})
+		// e.g. my-akv-secret-name@azurekeyvault?some-sub-key
+		if injector.IsReference(value) {
+			klog.V(4).InfoS("found env var to get azure key vault secret for", "env", name)
+			ref, err := injector.ParseReference(value)
 			if err != nil {
-				klog.ErrorS(err, "failed to get azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, akvsName))
-				klog.InfoS("will retry getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, akvsName), "retryTimes", config.retryTimes, "delay", config.waitTimeBetweenRetries)
-
-				err = retry(config.retryTimes, time.Second*time.Duration(config.waitTimeBetweenRetries), func() error {
-					akvs, err = azureKeyVaultSecretClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(config.namespace).Get(context.TODO(), akvsName, v1.GetOptions{})
-					if err != nil {
-						klog.V(4).ErrorS(err, "error getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, akvsName))
-						return err
-					}
-					klog.InfoS("succeded getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KObj(akvs))
-					return nil
-				})
-				if err != nil {
-					klog.ErrorS(err, "error getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, akvsName))
-					os.Exit(1)
-				}
+				klog.ErrorS(err, "env variable not properly formatted", "env", name, "value", value)
+				os.Exit(1)
+			}
+			if ref.Query != "" {
+				klog.V(4).InfoS("found query in env var", "env", name, "value", value, "query", ref.Query)
 			}
 
-			klog.V(4).InfoS("getting secret value for from azure key vault, to inject into env var", "azurekeyvaultsecret", klog.KObj(akvs), "env", name)
-			secret, err := getSecretFromKeyVault(akvs, secretQuery, vaultService)
+			klog.V(4).InfoS("getting secret value for from azure key vault, to inject into env var", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "env", name)
+			secret, err := secrets.resolve(ctx, value, ref)
 			if err != nil {
-				klog.ErrorS(err, "failed to read secret from azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
+				klog.ErrorS(err, "failed to read secret from azure key vault", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name))
 				os.Exit(1)
 			}
 
 			if secret == "" {
-				klog.ErrorS(fmt.Errorf("secret value empty"), "secret not found in azure key vault", "azurekeyvaultsecret", klog.KObj(akvs))
+				klog.ErrorS(fmt.Errorf("secret value empty"), "secret not found in azure key vault", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name))
 				os.Exit(1)
-			} else {
-				klog.InfoS("secret injected into env var", "azurekeyvaultsecret", klog.KObj(akvs), "env", name)
-				environ[i] = fmt.Sprintf("%s=%s", name, secret)
 			}
+
+			// e.g. DB_=my-akv-secret-name@azurekeyvault?* injects every key of the secret prefixed with DB_
+			if ref.IsEnvFrom() {
+				expanded, err := injector.ExpandEnvFrom(name, secret, config.envFromOptions)
+				if err != nil {
+					klog.ErrorS(err, "failed to expand keys of secret into env vars", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "env", name)
+					os.Exit(1)
+				}
+
+				names := make([]string, 0, len(expanded))
+				for expandedName := range expanded {
+					names = append(names, expandedName)
+				}
+				sort.Strings(names)
+
+				for _, expandedName := range names {
+					if explicit[expandedName] {
+						klog.InfoS("env var already set, not overridden by key of secret", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "env", expandedName)
+						continue
+					}
+					injected = append(injected, fmt.Sprintf("%s=%s", expandedName, expanded[expandedName]))
+				}
+				klog.InfoS("secret keys injected into env vars", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "prefix", name, "envs", len(names))
+				continue
+			}
+
+			klog.InfoS("secret injected into env var", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "env", name)
+			env = fmt.Sprintf("%s=%s", name, secret)
 		}
+		injected = append(injected, env)
 	}
+	environ = injected
+
+	if len(config.secretFiles) > 0 {
+		if _, err := writeSecretFiles(ctx, secrets, config.secretFilesDir, config.secretFiles); err != nil {
+			klog.ErrorS(err, "failed to write secret files", "dir", config.secretFilesDir)
+			os.Exit(1)
+		}
+	}
+
+	cancel()
 
 	klog.InfoS("starting process with secrets in env vars", "cmd", origCommand, "args", origArgs)
 	err = syscall.Exec(origCommand, origArgs, environ)
@@ -0,0 +1,200 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const reloadURLTimeout = 10 * time.Second
+
+var reloadSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// reloader tells the main process of the pod that secret files have changed, either by
+// sending a signal to it (requires a shared process namespace) or calling a reload url
+type reloader struct {
+	signal  syscall.Signal
+	process string
+	url     string
+	client  *http.Client
+}
+
+// newReloader validates the refresh configuration, which the webhook validated from the annotations
+// of the pod, so that a refresh interval below the minimum never reaches Azure Key Vault
+func newReloader(refresh injector.SecretFilesRefresh) (*reloader, error) {
+	refresh.ReloadSignal = strings.ToUpper(refresh.ReloadSignal)
+	if err := refresh.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &reloader{
+		process: refresh.ReloadProcess,
+		url:     refresh.ReloadURL,
+		client:  &http.Client{Timeout: reloadURLTimeout},
+	}
+	if refresh.ReloadSignal != "" {
+		sig, ok := reloadSignals[refresh.ReloadSignal]
+		if !ok {
+			return nil, fmt.Errorf("unsupported reload signal '%s'", refresh.ReloadSignal)
+		}
+		r.signal = sig
+	}
+	return r, nil
+}
+
+// reload signals the process and calls the reload url, if configured
+func (r *reloader) reload(ctx context.Context) error {
+	if r.signal != 0 {
+		if err := r.signalProcess(); err != nil {
+			return err
+		}
+	}
+	if r.url != "" {
+		if err := r.callURL(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *reloader) signalProcess() error {
+	pids, err := findProcesses(r.process)
+	if err != nil {
+		return fmt.Errorf("failed to find process '%s', error: %+v", r.process, err)
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("found no process named '%s' to signal - make sure the pod shares its process namespace", r.process)
+	}
+
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, r.signal); err != nil {
+			return fmt.Errorf("failed to send %s to process '%s' (pid %d), error: %+v", r.signal, r.process, pid, err)
+		}
+		klog.InfoS("signaled process to reload secret files", "process", r.process, "pid", pid, "signal", r.signal.String())
+	}
+	return nil
+}
+
+func (r *reloader) callURL(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create reload request, error: %+v", err)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call reload url '%s', error: %+v", r.url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("reload url '%s' responded with status %d", r.url, res.StatusCode)
+	}
+	klog.InfoS("called reload url after secret files changed", "url", r.url, "status", res.StatusCode)
+	return nil
+}
+
+// findProcesses returns the pids of processes named name, other than this one
+func findProcesses(name string) ([]int, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, path := range paths {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		// the process may have exited since listing /proc
+		comm, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// refreshSecretFiles re-resolves the secret files every interval until ctx is done, using a resolver
+// from createResolver, rewriting the files that changed and reloading the main process if any did
+func refreshSecretFiles(ctx context.Context, interval time.Duration, r *reloader, createResolver func() (secretResolver, error)) {
+	klog.InfoS("refreshing secret files", "dir", config.secretFilesDir, "files", len(config.secretFiles), "interval", interval)
+
+	// the files were written when the containers started, so wait an interval before the first refresh
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		// a new resolver picks up renewed credentials and the current secrets of the auth service
+		resolver, err := createResolver()
+		if err != nil {
+			klog.ErrorS(err, "failed to create secret resolver, will retry")
+			return
+		}
+
+		refs, err := collectReferences(nil, config.secretFiles)
+		if err != nil {
+			klog.ErrorS(err, "failed to collect secret file references")
+			return
+		}
+		secrets, err := resolveReferences(ctx, resolver, refs, config.concurrency)
+		if err != nil {
+			klog.ErrorS(err, "failed to refresh secret files, will retry")
+			return
+		}
+
+		changed, err := writeSecretFiles(ctx, secrets, config.secretFilesDir, config.secretFiles)
+		if err != nil {
+			klog.ErrorS(err, "failed to refresh secret files, will retry")
+		}
+		if !changed {
+			return
+		}
+
+		if err := r.reload(ctx); err != nil {
+			klog.ErrorS(err, "failed to reload after secret files changed")
+		}
+	}, interval)
+}
@@ -0,0 +1,296 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+)
+
+func TestNewReloader(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh injector.SecretFilesRefresh
+		signal  syscall.Signal
+		wantErr bool
+	}{
+		{
+			name:    "url",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadURL: "http://localhost:8080/-/reload"},
+		},
+		{
+			name:    "signal",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadSignal: "sighup", ReloadProcess: "nginx"},
+			signal:  syscall.SIGHUP,
+		},
+		{
+			name:    "interval below minimum",
+			refresh: injector.SecretFilesRefresh{Interval: injector.MinSecretFilesRefreshInterval - time.Second},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported signal",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadSignal: "SIGKILL", ReloadProcess: "nginx"},
+			wantErr: true,
+		},
+		{
+			name:    "signal without process",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadSignal: "SIGHUP"},
+			wantErr: true,
+		},
+		{
+			name:    "process without signal",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadProcess: "nginx"},
+			wantErr: true,
+		},
+		{
+			name:    "relative url",
+			refresh: injector.SecretFilesRefresh{Interval: time.Minute, ReloadURL: "/-/reload"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newReloader(tt.refresh)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newReloader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && r.signal != tt.signal {
+				t.Errorf("expected signal %s, got %s", tt.signal, r.signal)
+			}
+		})
+	}
+}
+
+func TestReloadURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "not found", status: http.StatusNotFound, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				method = req.Method
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			r, err := newReloader(injector.SecretFilesRefresh{Interval: time.Minute, ReloadURL: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.reload(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if method != http.MethodPost {
+				t.Errorf("expected reload url to be called with POST, got '%s'", method)
+			}
+		})
+	}
+
+	r, err := newReloader(injector.SecretFilesRefresh{Interval: time.Minute, ReloadURL: "http://127.0.0.1:1/-/reload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(context.Background()); err == nil {
+		t.Error("expected error when reload url cannot be reached")
+	}
+}
+
+// startTestProcess starts a process named name, so that it can be found and signaled
+func startTestProcess(t *testing.T, name string) (*exec.Cmd, int) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.Symlink(sleep, path); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(path, "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	// the name of the process changes when it execs
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pids, err := findProcesses(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pids) == 1 && pids[0] == cmd.Process.Pid {
+			return cmd, cmd.Process.Pid
+		}
+	}
+	t.Fatalf("expected to find process '%s' with pid %d", name, cmd.Process.Pid)
+	return nil, 0
+}
+
+func TestFindProcesses(t *testing.T) {
+	if _, err := os.Stat("/proc/self/comm"); err != nil {
+		t.Skip("/proc not available")
+	}
+	startTestProcess(t, "akv2k8s-find")
+
+	self, err := ioutil.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pids, err := findProcesses(string(self[:len(self)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			t.Error("expected own process not to be found")
+		}
+	}
+
+	if pids, err := findProcesses("akv2k8s-missing"); err != nil || len(pids) != 0 {
+		t.Errorf("expected no process to be found, got %v, error: %+v", pids, err)
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	if _, err := os.Stat("/proc/self/comm"); err != nil {
+		t.Skip("/proc not available")
+	}
+	cmd, _ := startTestProcess(t, "akv2k8s-reload")
+
+	r, err := newReloader(injector.SecretFilesRefresh{Interval: time.Minute, ReloadSignal: "SIGHUP", ReloadProcess: "akv2k8s-reload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGHUP {
+		t.Errorf("expected process to be terminated by SIGHUP, got %+v", err)
+	}
+
+	if err := r.reload(context.Background()); err == nil {
+		t.Error("expected error when there is no process to signal")
+	}
+}
+
+func TestRefreshSecretFiles(t *testing.T) {
+	defer func(c injectorConfig) { config = c }(config)
+	config.secretFilesDir = t.TempDir()
+	config.secretFiles = []injector.SecretFile{{Path: "password", Secret: "my-secret"}}
+	config.concurrency = 1
+
+	var reloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&reloads, 1)
+	}))
+	defer server.Close()
+	r, err := newReloader(injector.SecretFilesRefresh{Interval: time.Minute, ReloadURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the files were written when the containers started
+	if _, err := writeSecretFiles(context.Background(), resolvedSecrets{"my-secret@azurekeyvault": "v1"}, config.secretFilesDir, config.secretFiles); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first resolver fails, the second resolves the secret as written and later ones the rotated secret
+	var resolvers int32
+	createResolver := func() (secretResolver, error) {
+		switch atomic.AddInt32(&resolvers, 1) {
+		case 1:
+			return nil, fmt.Errorf("auth service not available")
+		case 2:
+			return resolvedSecrets{"my-secret@azurekeyvault": "v1"}, nil
+		default:
+			return resolvedSecrets{"my-secret@azurekeyvault": "v2"}, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refreshSecretFiles(ctx, 10*time.Millisecond, r, createResolver)
+		close(done)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&resolvers) < 5 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected refresh to stop when context is done")
+	}
+
+	if atomic.LoadInt32(&resolvers) < 5 {
+		t.Fatalf("expected secret files to be refreshed every interval, got %d refreshes", resolvers)
+	}
+	if reloads != 1 {
+		t.Errorf("expected one reload when the secret file changed, got %d", reloads)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(config.secretFilesDir, "password")); string(content) != "v2" {
+		t.Errorf("expected secret file to be refreshed, got %q", content)
+	}
+}
+
+func TestRefreshSecretFilesStopsBeforeFirstInterval(t *testing.T) {
+	r, err := newReloader(injector.SecretFilesRefresh{Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var resolvers int32
+	refreshSecretFiles(ctx, time.Hour, r, func() (secretResolver, error) {
+		atomic.AddInt32(&resolvers, 1)
+		return resolvedSecrets{}, nil
+	})
+	if resolvers != 0 {
+		t.Errorf("expected no refresh before the first interval, got %d", resolvers)
+	}
+}
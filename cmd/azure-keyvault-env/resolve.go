@@ -0,0 +1,139 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	"k8s.io/klog/v2"
+)
+
+// resolvedSecrets are the secrets resolved up front by resolveReferences, keyed by reference
+type resolvedSecrets map[string]string
+
+func (s resolvedSecrets) resolve(ctx context.Context, value string, ref *injector.Reference) (string, error) {
+	secret, ok := s[value]
+	if !ok {
+		return "", fmt.Errorf("secret for '%s' was not resolved", value)
+	}
+	return secret, nil
+}
+
+// collectReferences returns every AzureKeyVaultSecret reference in environ, including templates,
+// and in the secret files, keyed by reference
+func collectReferences(environ []string, files []injector.SecretFile) (map[string]*injector.Reference, error) {
+	var references []string
+	for _, env := range environ {
+		split := strings.SplitN(env, "=", 2)
+		name, value := split[0], split[1]
+
+		switch {
+		case injector.IsTemplate(value):
+			tmpl, err := injector.ParseTemplate(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template in env var %s, error: %+v", name, err)
+			}
+			references = append(references, tmpl.References()...)
+		case injector.IsReference(value):
+			references = append(references, value)
+		}
+	}
+	for _, file := range files {
+		references = append(references, file.Reference())
+	}
+
+	refs := make(map[string]*injector.Reference, len(references))
+	for _, reference := range references {
+		if _, ok := refs[reference]; ok {
+			continue
+		}
+		ref, err := injector.ParseReference(reference)
+		if err != nil {
+			return nil, fmt.Errorf("reference '%s' not properly formatted, error: %+v", reference, err)
+		}
+		refs[reference] = ref
+	}
+	return refs, nil
+}
+
+// resolveReferences resolves refs with at most concurrency AzureKeyVaultSecrets resolved at a time. The references
+// to an AzureKeyVaultSecret are resolved together, so that it is only fetched once. All failures are reported together.
+func resolveReferences(ctx context.Context, resolver secretResolver, refs map[string]*injector.Reference, concurrency int) (resolvedSecrets, error) {
+	byName := make(map[string][]string)
+	for reference, ref := range refs {
+		byName[ref.Name] = append(byName[ref.Name], reference)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+		sort.Strings(byName[name])
+	}
+	sort.Strings(names)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	start := time.Now()
+	secrets := make(resolvedSecrets, len(refs))
+	var failures []string
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, references []string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			for _, reference := range references {
+				secretStart := time.Now()
+				secret, err := resolver.resolve(ctx, reference, refs[reference])
+				duration := time.Since(secretStart)
+
+				mutex.Lock()
+				if err != nil {
+					failures = append(failures, fmt.Sprintf("'%s': %+v", reference, err))
+				} else {
+					secrets[reference] = secret
+				}
+				mutex.Unlock()
+
+				if err != nil {
+					klog.ErrorS(err, "failed to resolve secret", "azurekeyvaultsecret", klog.KRef(config.namespace, name), "query", refs[reference].Query, "duration", duration)
+					continue
+				}
+				klog.InfoS("resolved secret", "azurekeyvaultsecret", klog.KRef(config.namespace, name), "query", refs[reference].Query, "duration", duration)
+			}
+		}(name, byName[name])
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, fmt.Errorf("failed to resolve %d of %d secrets:\n%s", len(failures), len(refs), strings.Join(failures, "\n"))
+	}
+
+	klog.InfoS("resolved secrets", "secrets", len(secrets), "azurekeyvaultsecrets", len(names), "concurrency", concurrency, "duration", time.Since(start))
+	return secrets, nil
+}
@@ -0,0 +1,210 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	fakeVault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client/fake"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	akvfake "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testNamespace = "default"
+
+func newTestAzureKeyVaultSecret(name, object string, objectType akv.AzureKeyVaultObjectType) *akv.AzureKeyVaultSecret {
+	akvs := &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Name:   "my-vault",
+				Object: akv.AzureKeyVaultObject{Name: object, Type: objectType},
+			},
+		},
+	}
+	if objectType == akv.AzureKeyVaultObjectTypeMultiKeyValueSecret {
+		akvs.Spec.Vault.Object.ContentType = akv.AzureKeyVaultObjectContentTypeJSON
+	}
+	return akvs
+}
+
+// newTestResolver returns a local resolver for the AzureKeyVaultSecrets, getting objects from vaultService
+// through the same cache as in the pod
+func newTestResolver(t *testing.T, vaultService vault.Service, azureKeyVaultSecrets ...*akv.AzureKeyVaultSecret) *localResolver {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.namespace = testNamespace
+	config.retryTimes = 0
+
+	objects := make([]runtime.Object, 0, len(azureKeyVaultSecrets))
+	for _, akvs := range azureKeyVaultSecrets {
+		objects = append(objects, akvs)
+	}
+	return &localResolver{
+		client:               akvfake.NewSimpleClientset(objects...),
+		vaultService:         vault.NewCachedService(vaultService, vault.CacheOptions{TTL: resolverCacheTTL}),
+		azureKeyVaultSecrets: make(map[string]*akv.AzureKeyVaultSecret),
+	}
+}
+
+func testReferences(t *testing.T, references ...string) map[string]*injector.Reference {
+	var environ []string
+	for i, reference := range references {
+		environ = append(environ, fmt.Sprintf("ENV_%d=%s", i, reference))
+	}
+	refs, err := collectReferences(environ, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+// concurrencyResolver tracks how many secrets are resolved at a time
+type concurrencyResolver struct {
+	secretResolver
+
+	mutex   sync.Mutex
+	current int
+	max     int
+}
+
+func (r *concurrencyResolver) resolve(ctx context.Context, value string, ref *injector.Reference) (string, error) {
+	r.mutex.Lock()
+	r.current++
+	if r.current > r.max {
+		r.max = r.current
+	}
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		r.current--
+		r.mutex.Unlock()
+	}()
+	return r.secretResolver.resolve(ctx, value, ref)
+}
+
+func TestResolveReferencesOnlyFetchesEachObjectOnce(t *testing.T) {
+	vaultService := &fakeVault.AkvsService{
+		FakeSecret: `{"user": "admin", "password": "secret"}`,
+		Delay:      20 * time.Millisecond,
+	}
+	resolver := newTestResolver(t, vaultService,
+		newTestAzureKeyVaultSecret("db", "db-config", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret),
+		newTestAzureKeyVaultSecret("db-copy", "db-config", akv.AzureKeyVaultObjectTypeSecret),
+		newTestAzureKeyVaultSecret("other", "other", akv.AzureKeyVaultObjectTypeSecret),
+	)
+
+	refs := testReferences(t, "db@azurekeyvault?user", "db@azurekeyvault?password", "db-copy@azurekeyvault", "other@azurekeyvault")
+	secrets, err := resolveReferences(context.Background(), resolver, refs, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := resolvedSecrets{
+		"db@azurekeyvault?user":     "admin",
+		"db@azurekeyvault?password": "secret",
+		"db-copy@azurekeyvault":     vaultService.FakeSecret,
+		"other@azurekeyvault":       vaultService.FakeSecret,
+	}
+	for reference, value := range expected {
+		if secrets[reference] != value {
+			t.Errorf("expected '%s' to resolve to '%s', got '%s'", reference, value, secrets[reference])
+		}
+	}
+
+	// db and db-copy reference the same object, so it is fetched once even when resolved concurrently
+	if calls := vaultService.Calls(); calls != 2 {
+		t.Errorf("expected each object to be fetched from azure key vault once, got %d calls", calls)
+	}
+}
+
+func TestResolveReferencesConcurrency(t *testing.T) {
+	tests := []struct {
+		concurrency int
+		expected    int
+	}{
+		{concurrency: 0, expected: 1},
+		{concurrency: 1, expected: 1},
+		{concurrency: 3, expected: 3},
+		{concurrency: 10, expected: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("concurrency %d", tt.concurrency), func(t *testing.T) {
+			var azureKeyVaultSecrets []*akv.AzureKeyVaultSecret
+			var references []string
+			for i := 0; i < 6; i++ {
+				name := fmt.Sprintf("secret-%d", i)
+				azureKeyVaultSecrets = append(azureKeyVaultSecrets, newTestAzureKeyVaultSecret(name, name, akv.AzureKeyVaultObjectTypeSecret))
+				references = append(references, name+"@azurekeyvault")
+			}
+
+			vaultService := &fakeVault.AkvsService{FakeSecret: "value", Delay: 20 * time.Millisecond}
+			resolver := &concurrencyResolver{secretResolver: newTestResolver(t, vaultService, azureKeyVaultSecrets...)}
+
+			secrets, err := resolveReferences(context.Background(), resolver, testReferences(t, references...), tt.concurrency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(secrets) != len(references) {
+				t.Errorf("expected %d secrets, got %d", len(references), len(secrets))
+			}
+			if resolver.max != tt.expected {
+				t.Errorf("expected %d secrets resolved at a time, got %d", tt.expected, resolver.max)
+			}
+		})
+	}
+}
+
+func TestResolveReferencesReportsAllFailures(t *testing.T) {
+	vaultService := &fakeVault.AkvsService{
+		FakeSecret: "value",
+		Errors: map[string]error{
+			"broken": fmt.Errorf("forbidden"),
+		},
+	}
+	resolver := newTestResolver(t, vaultService,
+		newTestAzureKeyVaultSecret("good", "good", akv.AzureKeyVaultObjectTypeSecret),
+		newTestAzureKeyVaultSecret("broken", "broken", akv.AzureKeyVaultObjectTypeSecret),
+	)
+
+	refs := testReferences(t, "good@azurekeyvault", "broken@azurekeyvault", "missing@azurekeyvault")
+	secrets, err := resolveReferences(context.Background(), resolver, refs, 2)
+	if err == nil {
+		t.Fatal("expected error when secrets cannot be resolved")
+	}
+	if secrets != nil {
+		t.Errorf("expected no secrets on failure, got %v", secrets)
+	}
+
+	for _, expected := range []string{"failed to resolve 2 of 3 secrets", "'broken@azurekeyvault'", "forbidden", "'missing@azurekeyvault'"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "good@azurekeyvault") {
+		t.Errorf("expected error to only report failures, got: %s", err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	clientset "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// secretResolver resolves the value of an env var referencing an AzureKeyVaultSecret
+type secretResolver interface {
+	resolve(ctx context.Context, value string, ref *injector.Reference) (string, error)
+}
+
+// newResolver creates a resolver using the auth service if enabled, else credentials available in the pod
+func newResolver() (secretResolver, error) {
+	if config.useAuthService {
+		resolver, err := newAuthServiceResolver()
+		if err != nil {
+			return nil, err
+		}
+		return resolver, nil
+	}
+
+	resolver, err := newLocalResolver()
+	if err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// resolveTemplate resolves every reference in the template value of env var name and renders it,
+// reporting all references that could not be resolved
+func resolveTemplate(ctx context.Context, resolver secretResolver, name, value string) (string, error) {
+	tmpl, err := injector.ParseTemplate(value)
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]string, len(tmpl.References()))
+	var unresolved []string
+	for _, reference := range tmpl.References() {
+		ref, err := injector.ParseReference(reference)
+		if err != nil {
+			return "", err
+		}
+
+		secret, err := resolver.resolve(ctx, reference, ref)
+		if err != nil {
+			klog.ErrorS(err, "failed to resolve reference in template", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "env", name)
+			unresolved = append(unresolved, reference)
+			continue
+		}
+		values[reference] = secret
+	}
+
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("unresolved references in template of env var %s: %s", name, strings.Join(unresolved, ", "))
+	}
+	return tmpl.Execute(values)
+}
+
+// authServiceResolver resolves secrets issued by the akv2k8s auth service, which only
+// hands out the AzureKeyVaultSecrets referenced by the env vars of this pod
+type authServiceResolver struct {
+	secrets map[string]string
+}
+
+func newAuthServiceResolver() (*authServiceResolver, error) {
+	klog.V(4).InfoS("using centralized akv2k8s auth service to get secrets from azure key vault")
+	secrets, err := getSecretsFromAuthService(config.authServiceAddress, config.authServiceValidationAddress, config.clientCertDir)
+	if err != nil {
+		klog.V(4).InfoS("failed to get secrets from auth service, will retry", "retryTimes", config.retryTimes)
+		err = retry(config.retryTimes, time.Second*time.Duration(config.waitTimeBetweenRetries), func() error {
+			secrets, err = getSecretsFromAuthService(config.authServiceAddress, config.authServiceValidationAddress, config.clientCertDir)
+			if err != nil {
+				return err
+			}
+			klog.Info("succeded getting secrets from auth service")
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &authServiceResolver{secrets: secrets}, nil
+}
+
+func (r authServiceResolver) resolve(ctx context.Context, value string, ref *injector.Reference) (string, error) {
+	secret, ok := r.secrets[value]
+	if !ok {
+		return "", fmt.Errorf("auth service did not issue secret for '%s'", value)
+	}
+	return secret, nil
+}
+
+// resolverCacheTTL outlives a resolver, which is created for every run, so that each Azure Key Vault
+// object is only fetched once per run
+const resolverCacheTTL = time.Hour
+
+// localResolver resolves secrets from Azure Key Vault using credentials available in the pod,
+// getting each AzureKeyVaultSecret and Azure Key Vault object only once
+type localResolver struct {
+	client       clientset.Interface
+	vaultService vault.Service
+
+	mutex                sync.Mutex
+	azureKeyVaultSecrets map[string]*akv.AzureKeyVaultSecret
+}
+
+func newLocalResolver() (*localResolver, error) {
+	creds, err := getCredentials()
+	if err != nil {
+		klog.V(4).InfoS("failed to get credentials, will retry", "retryTimes", config.retryTimes)
+		err = retry(config.retryTimes, time.Second*time.Duration(config.waitTimeBetweenRetries), func() error {
+			creds, err = getCredentials()
+			if err != nil {
+				return err
+			}
+			klog.Info("succeded getting credentials")
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	klog.V(4).InfoS("reading azurekeyvaultsecret's referenced in env variables")
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error building kubeconfig: %w", err)
+	}
+
+	azureKeyVaultSecretClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error building azurekeyvaultsecret clientset: %w", err)
+	}
+
+	return &localResolver{
+		client:               azureKeyVaultSecretClient,
+		vaultService:         vault.NewCachedService(vault.NewService(creds, time.Second*time.Duration(config.vaultRequestTimeout)), vault.CacheOptions{TTL: resolverCacheTTL}),
+		azureKeyVaultSecrets: make(map[string]*akv.AzureKeyVaultSecret),
+	}, nil
+}
+
+func (r *localResolver) resolve(ctx context.Context, value string, ref *injector.Reference) (string, error) {
+	r.mutex.Lock()
+	akvs, ok := r.azureKeyVaultSecrets[ref.Name]
+	r.mutex.Unlock()
+	if ok {
+		return injector.GetSecretFromKeyVault(ctx, akvs, ref.Query, r.vaultService)
+	}
+
+	klog.V(4).InfoS("getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name))
+	akvs, err := r.getAzureKeyVaultSecret(ctx, ref.Name)
+	if err != nil {
+		klog.ErrorS(err, "failed to get azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name))
+		klog.InfoS("will retry getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name), "retryTimes", config.retryTimes, "delay", config.waitTimeBetweenRetries)
+
+		err = retry(config.retryTimes, time.Second*time.Duration(config.waitTimeBetweenRetries), func() error {
+			akvs, err = r.getAzureKeyVaultSecret(ctx, ref.Name)
+			if err != nil {
+				klog.V(4).ErrorS(err, "error getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KRef(config.namespace, ref.Name))
+				return err
+			}
+			klog.InfoS("succeded getting azurekeyvaultsecret", "azurekeyvaultsecret", klog.KObj(akvs))
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("error getting azurekeyvaultsecret '%s': %w", ref.Name, err)
+		}
+	}
+
+	r.mutex.Lock()
+	r.azureKeyVaultSecrets[ref.Name] = akvs
+	r.mutex.Unlock()
+
+	return injector.GetSecretFromKeyVault(ctx, akvs, ref.Query, r.vaultService)
+}
+
+func (r *localResolver) getAzureKeyVaultSecret(ctx context.Context, name string) (*akv.AzureKeyVaultSecret, error) {
+	return r.client.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(config.namespace).Get(ctx, name, v1.GetOptions{})
+}
@@ -0,0 +1,66 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	whcontext "github.com/slok/kubewebhook/pkg/webhook/context"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+func TestAzureKeyVaultSecretValidator(t *testing.T) {
+	akvs := &akv.AzureKeyVaultSecret{
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Name: "my-vault",
+				Object: akv.AzureKeyVaultObject{
+					Name: "my-secret",
+					Type: akv.AzureKeyVaultObjectTypeSecret,
+				},
+			},
+			Output: akv.AzureKeyVaultOutput{
+				Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret", DataKey: "value"},
+			},
+		},
+	}
+
+	ctx := whcontext.SetAdmissionRequest(context.Background(), &admissionv1beta1.AdmissionRequest{Operation: admissionv1beta1.Create})
+	if _, res, err := azureKeyVaultSecretValidator(ctx, akvs); err != nil || !res.Valid {
+		t.Fatalf("expected valid azurekeyvaultsecret, got %+v, error: %+v", res, err)
+	}
+
+	akvs.Spec.Output.Secret.DataKey = ""
+	_, res, err := azureKeyVaultSecretValidator(ctx, akvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Fatal("expected azurekeyvaultsecret without data key to be rejected")
+	}
+	if !strings.Contains(res.Message, "spec.output.secret.dataKey") {
+		t.Errorf("expected message to point at spec.output.secret.dataKey, got '%s'", res.Message)
+	}
+
+	ctx = whcontext.SetAdmissionRequest(context.Background(), &admissionv1beta1.AdmissionRequest{Operation: admissionv1beta1.Delete})
+	if _, res, _ := azureKeyVaultSecretValidator(ctx, akvs); !res.Valid {
+		t.Error("expected deletes to always be allowed")
+	}
+}
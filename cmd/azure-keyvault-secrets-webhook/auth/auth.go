@@ -6,14 +6,14 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	akvcs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -21,16 +21,22 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
 type AuthService struct {
-	kubeclient  kubernetes.Interface
-	credentials credentialprovider.Credentials
-	caCert      []byte
-	caKey       []byte
+	kubeclient        kubernetes.Interface
+	akvsClient        akvcs.Interface
+	credentials       credentialprovider.Credentials
+	podIdentity       *PodIdentityProvider
+	newVaultService   func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service
+	vaultCache        *vault.Cache
+	legacyCredentials bool
+	clientCertOptions ClientCertOptions
+	revocations       *RevocationList
+	ca                *CertificateAuthority
+	caRotationBatch   int
 }
 
 func fileExists(filename string) bool {
@@ -41,60 +47,77 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-// NewAuthService creates a new authentication service for akv2k8s
-func NewAuthService(kubeclient kubernetes.Interface, credentials credentialprovider.Credentials) (*AuthService, error) {
-	caCertDir := viper.GetString("ca_cert_dir")
-	if caCertDir == "" {
-		klog.InfoS("missing env var - must exist to use auth service", "env", "CA_CERT_DIR")
-		return nil, fmt.Errorf("no ca cert directory found")
-	}
-
-	caCertFile := filepath.Join(caCertDir, "tls.crt")
-	caKeyFile := filepath.Join(caCertDir, "tls.key")
-	klog.V(4).InfoS("auth service ca cert", "file", caCertFile)
-	klog.V(4).InfoS("auth service ca key", "file", caKeyFile)
-
-	if !fileExists(caCertFile) {
-		klog.InfoS("file does not exist", "file", caCertFile)
-		return nil, fmt.Errorf("file does not exist")
-	}
-
-	if !fileExists(caKeyFile) {
-		klog.InfoS("file does not exist", "file", caKeyFile)
-		return nil, fmt.Errorf("file does not exist")
-	}
-
-	var err error
-	caCert, err := ioutil.ReadFile(caCertFile)
+// NewAuthService creates a new authentication service for akv2k8s, issuing the AzureKeyVaultSecrets referenced
+// by pods using credentials. If podIdentity is set, secrets are instead fetched with the Azure identity bound
+// to the service account of the pod, and pods may request credentials for their own identity.
+func NewAuthService(kubeclient kubernetes.Interface, akvsClient akvcs.Interface, credentials credentialprovider.Credentials, podIdentity *PodIdentityProvider) (*AuthService, error) {
+	ca, err := newCertificateAuthorityFromConfig(kubeclient)
 	if err != nil {
-		klog.ErrorS(err, "failed to read pem file for ca cert", "file", caCertFile)
 		return nil, err
 	}
 
-	if len(caCert) == 0 {
-		klog.InfoS("file is empty ", "file", caCertFile)
-		return nil, fmt.Errorf("file %s is empty", caCertFile)
+	clientCertOptions := ClientCertOptions{
+		KeyType:     viper.GetString("auth_service_client_cert_key_type"),
+		KeySize:     viper.GetInt("auth_service_client_cert_key_size"),
+		Validity:    viper.GetDuration("auth_service_client_cert_validity"),
+		RenewBefore: viper.GetDuration("auth_service_client_cert_renew_before"),
+	}
+	if err := clientCertOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client certificate options, error: %+v", err)
 	}
 
-	caKey, err := ioutil.ReadFile(caKeyFile)
-	if err != nil {
-		klog.ErrorS(err, "failed to read pem file for ca key", "file", caKeyFile)
-		return nil, err
+	legacyCredentials := viper.GetBool("auth_service_legacy_credentials")
+	if legacyCredentials {
+		klog.InfoS("deprecated: auth service issues its own credentials to pods without pod identity, for env-injectors older than the secrets endpoint - disable AUTH_SERVICE_LEGACY_CREDENTIALS once all pods are updated")
 	}
 
-	if len(caKey) == 0 {
-		klog.InfoS("file is empty ", "file", caKeyFile)
-		return nil, fmt.Errorf("file %s is empty", caKeyFile)
+	var vaultCache *vault.Cache
+	if viper.GetBool("vault_cache_enabled") {
+		vaultCache = vault.NewCache(vault.CacheOptions{
+			TTL:          viper.GetDuration("vault_cache_ttl"),
+			VersionedTTL: viper.GetDuration("vault_cache_versioned_ttl"),
+			NegativeTTL:  viper.GetDuration("vault_cache_negative_ttl"),
+		})
 	}
 
 	return &AuthService{
-		kubeclient:  kubeclient,
-		credentials: credentials,
-		caCert:      caCert,
-		caKey:       caKey,
+		kubeclient:        kubeclient,
+		akvsClient:        akvsClient,
+		credentials:       credentials,
+		podIdentity:       podIdentity,
+		newVaultService:   newVaultService,
+		vaultCache:        vaultCache,
+		legacyCredentials: legacyCredentials,
+		clientCertOptions: clientCertOptions,
+		revocations:       NewRevocationList(viper.GetString("auth_service_client_cert_crl_file")),
+		ca:                ca,
+		caRotationBatch:   viper.GetInt("auth_service_ca_rotation_batch_size"),
 	}, nil
 }
 
+// newCertificateAuthorityFromConfig loads the auth service ca from the Kubernetes Secret in CA_SECRET
+// (namespace/name) if set, else from CA_CERT_DIR
+func newCertificateAuthorityFromConfig(kubeclient kubernetes.Interface) (*CertificateAuthority, error) {
+	rotationWindow := viper.GetDuration("auth_service_ca_rotation_window")
+
+	if caSecret := viper.GetString("ca_secret"); caSecret != "" {
+		parts := strings.Split(caSecret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("ca secret '%s' must be on the form namespace/name", caSecret)
+		}
+
+		klog.V(4).InfoS("auth service ca", "secret", caSecret)
+		return NewCertificateAuthorityFromSecret(kubeclient, parts[0], parts[1], rotationWindow)
+	}
+
+	caCertDir := viper.GetString("ca_cert_dir")
+	if caCertDir == "" {
+		klog.InfoS("missing env var - must exist to use auth service", "env", "CA_CERT_DIR")
+		return nil, fmt.Errorf("no ca cert directory found")
+	}
+	return NewCertificateAuthorityFromDir(caCertDir, rotationWindow)
+}
+
 var (
 	authRequestsCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "akv2k8s_auth_requests_total",
@@ -126,6 +149,7 @@ func (a AuthService) AuthHandler(w http.ResponseWriter, r *http.Request) {
 		pod := podData{
 			name:      vars["pod"],
 			namespace: vars["namespace"],
+			token:     bearerToken(r),
 		}
 
 		if pod.name == "" || pod.namespace == "" {
@@ -135,7 +159,7 @@ func (a AuthService) AuthHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err := authorize(a.kubeclient, pod)
+		err := authorize(a.kubeclient, pod, a.legacyCredentials)
 
 		if err != nil {
 			klog.ErrorS(err, "failed to authorize request", "pod", pod.name, "namespace", pod.namespace)
@@ -144,10 +168,18 @@ func (a AuthService) AuthHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		credentials, err := a.credentialsFor(r.Context(), pod)
+		if err != nil {
+			klog.ErrorS(err, "failed to get credentials for pod", "pod", pod.name, "namespace", pod.namespace)
+			http.Error(w, "", http.StatusForbidden)
+			authRequestsFailures.Inc()
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.WriteHeader(http.StatusOK)
 
-		if err := json.NewEncoder(w).Encode(a.credentials); err != nil {
+		if err := json.NewEncoder(w).Encode(credentials); err != nil {
 			klog.ErrorS(err, "failed to json encode token", "pod", pod.name, "namespace", pod.namespace)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
@@ -160,6 +192,25 @@ func (a AuthService) AuthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// credentialsFor returns the credentials of the pod's own identity. The credentials of the auth
+// service are not handed out - pods get their secrets from the SecretsHandler instead - unless
+// legacy credentials are enabled for older env-injectors.
+func (a AuthService) credentialsFor(ctx context.Context, pod podData) (interface{}, error) {
+	if a.podIdentity == nil {
+		if a.legacyCredentials {
+			klog.InfoS("deprecated: issued auth service credentials to pod - update the env-injector of the pod to get secrets from the auth service instead", "pod", pod.name, "namespace", pod.namespace)
+			return a.credentials, nil
+		}
+		return nil, fmt.Errorf("pod identity not enabled - auth service only issues secrets, enable AUTH_SERVICE_LEGACY_CREDENTIALS for older env-injectors")
+	}
+
+	runningPod, err := a.kubeclient.CoreV1().Pods(pod.namespace).Get(ctx, pod.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod '%s' in namespace '%s', error: %+v", pod.name, pod.namespace, err)
+	}
+	return a.podIdentity.GetAzureKeyVaultCredentials(ctx, runningPod)
+}
+
 // AuthValidateHandler validates if a pod has valid credentials for authenticating with the Auth Service.
 // If not it will issue a new Secret for the pod to use when authenticating.
 func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,6 +221,7 @@ func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request)
 		pod := podData{
 			name:       vars["pod"],
 			namespace:  vars["namespace"],
+			token:      bearerToken(r),
 			authSecret: qParams.Get("secret"),
 		}
 
@@ -185,7 +237,7 @@ func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		err := authorize(a.kubeclient, pod)
+		err := authorize(a.kubeclient, pod, a.legacyCredentials)
 		if err != nil {
 			klog.ErrorS(err, "failed to authorize request", "pod", pod.name, "namespace", pod.namespace)
 			http.Error(w, "", http.StatusForbidden)
@@ -199,15 +251,12 @@ func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		uid := types.UID(pod.name)
-		newSecret, err := a.NewPodSecret(runningPod, pod.namespace, uid)
+		newSecret, err := a.newClientCertSecret(pod.authSecret, pod.namespace, runningPod.GetOwnerReferences())
 		if err != nil {
 			klog.ErrorS(err, "failed to create secret", "pod", pod.name, "namespace", pod.namespace)
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
-		// set secret name explicit, and not use generated name
-		newSecret.Name = pod.authSecret
 
 		notFound := false
 		secret, err := a.kubeclient.CoreV1().Secrets(pod.namespace).Get(context.TODO(), pod.authSecret, metav1.GetOptions{})
@@ -230,7 +279,7 @@ func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request)
 				return
 			}
 			w.WriteHeader(http.StatusCreated)
-		} else if string(secret.Data["ca.crt"]) == string(a.caCert) {
+		} else if !a.secretNeedsRenewal(secret) {
 			w.WriteHeader(http.StatusOK)
 		} else {
 			_, err = a.kubeclient.CoreV1().Secrets(pod.namespace).Update(context.TODO(), newSecret, metav1.UpdateOptions{})
@@ -248,20 +297,7 @@ func (a AuthService) AuthValidateHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // NewPodSecret creates a new Kubernetes Secret with a client certificate needed for authenticating with the AuthService
-func (a AuthService) NewPodSecret(pod *corev1.Pod, namespace string, mutationID types.UID) (*corev1.Secret, error) {
-	// Create secret containing CA cert and mTLS credentials
-
-	clientCert, err := generateClientCert(mutationID, 24, a.caCert, a.caKey)
-	if err != nil {
-		return nil, err
-	}
-
-	value := map[string][]byte{
-		"ca.crt":  clientCert.CA,
-		"tls.crt": clientCert.Crt,
-		"tls.key": clientCert.Key,
-	}
-
+func (a AuthService) NewPodSecret(pod *corev1.Pod, namespace string) (*corev1.Secret, error) {
 	name := pod.GetName()
 	ownerReferences := pod.GetOwnerReferences()
 	if name == "" {
@@ -275,38 +311,71 @@ func (a AuthService) NewPodSecret(pod *corev1.Pod, namespace string, mutationID
 		}
 	}
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            fmt.Sprintf("akv2k8s-%s", name),
-			Namespace:       namespace,
-			OwnerReferences: ownerReferences,
-		},
-		Type: corev1.SecretTypeTLS,
-		Data: value,
+	return a.newClientCertSecret(fmt.Sprintf("akv2k8s-%s", name), namespace, ownerReferences)
+}
+
+// verifyClientCert verifies client certificates against the current and trusted previous cas,
+// which may change while the server is running, and rejects client certificates in the revocation list
+func (a AuthService) verifyClientCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no client certificate")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate, error: %+v", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, raw := range rawCerts[1:] {
+		intermediate, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse intermediate certificate, error: %+v", err)
+		}
+		intermediates.AddCert(intermediate)
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.ca.CertPool(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify client certificate, error: %+v", err)
+	}
+
+	for _, chain := range chains {
+		if a.revocations.IsRevoked(chain[0], chain[len(chain)-1]) {
+			klog.InfoS("rejected revoked client certificate", "subject", cert.Subject.String(), "serial", cert.SerialNumber.String())
+			return fmt.Errorf("client certificate revoked")
+		}
 	}
+	return nil
+}
 
-	return secret, nil
+// StartCAWatcher reloads the ca every interval until ctx is done
+func (a AuthService) StartCAWatcher(ctx context.Context, interval time.Duration) {
+	a.ca.Watch(ctx, interval)
 }
 
 // NewMTLSServer creates a new http server with mtls authentication enabled
 func (a AuthService) NewMTLSServer(router http.Handler, url string) *http.Server {
-	clientCertPool := x509.NewCertPool()
-	clientCertPool.AppendCertsFromPEM(a.caCert)
-
+	// Client certificates are verified in VerifyPeerCertificate, as the trusted cas change during ca rotation
 	tlsConfig := &tls.Config{
-		ClientAuth:               tls.RequireAndVerifyClientCert,
-		ClientCAs:                clientCertPool,
+		ClientAuth:               tls.RequireAnyClientCert,
 		PreferServerCipherSuites: true,
 		MinVersion:               tls.VersionTLS12,
+		VerifyPeerCertificate:    a.verifyClientCert,
 	}
 
 	tlsConfig.BuildNameToCertificate()
 
+	// The write timeout is longer than resolving the secrets of a pod takes, so that errors are returned to the pod
 	return &http.Server{
 		Addr:         url,
 		TLSConfig:    tlsConfig,
 		Handler:      router,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: secretsRequestTimeout + 15*time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
 }
@@ -3,9 +3,25 @@ package auth
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// ServiceAccountTokenAudience is the audience of the projected service account token
+// pods must present to the auth service
+const ServiceAccountTokenAudience = "akv2k8s-auth-service"
+
+// Extra user info set by the token review for service account tokens bound to a pod
+const (
+	podNameExtraKey = "authentication.kubernetes.io/pod-name"
+	podUIDExtraKey  = "authentication.kubernetes.io/pod-uid"
 )
 
 type podData struct {
@@ -15,7 +31,10 @@ type podData struct {
 	authSecret string
 }
 
-func authorize(clientset kubernetes.Interface, podData podData) error {
+// authorize checks that env-injection is enabled in the namespace of the pod, that the pod runs the
+// env-injector and that the request has a service account token bound to the pod. If allowWithoutToken
+// is set, only the token review is skipped for requests without token from older env-injectors.
+func authorize(clientset kubernetes.Interface, podData podData, allowWithoutToken bool) error {
 	ns, err := clientset.CoreV1().Namespaces().Get(context.TODO(), podData.namespace, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get namespace '%s', error: %+v", podData.namespace, err)
@@ -54,5 +73,71 @@ func authorize(clientset kubernetes.Interface, podData podData) error {
 		return fmt.Errorf("pod has no env-injector initContainer")
 	}
 
+	if podData.token == "" && allowWithoutToken {
+		klog.InfoS("deprecated: authorized pod without service account token - update the env-injector of the pod", "pod", podData.name, "namespace", podData.namespace)
+		return nil
+	}
+	return authorizeToken(clientset, pod, podData.token)
+}
+
+// authorizeToken validates the service account token presented by a pod with a token review, and
+// verifies that the token is bound to the pod and its service account
+func authorizeToken(clientset kubernetes.Interface, pod *corev1.Pod, token string) error {
+	if token == "" {
+		return fmt.Errorf("no service account token presented by pod '%s' in namespace '%s'", pod.Name, pod.Namespace)
+	}
+
+	review, err := clientset.AuthenticationV1().TokenReviews().Create(context.TODO(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{ServiceAccountTokenAudience},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to review service account token, error: %+v", err)
+	}
+
+	if !review.Status.Authenticated {
+		return fmt.Errorf("service account token not authenticated, error: %s", review.Status.Error)
+	}
+
+	if !hasAudience(review.Status.Audiences, ServiceAccountTokenAudience) {
+		return fmt.Errorf("service account token not valid for audience '%s'", ServiceAccountTokenAudience)
+	}
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+
+	user := review.Status.User
+	if user.Username != fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, serviceAccountName) {
+		return fmt.Errorf("service account token of '%s' does not belong to service account '%s' of pod '%s' in namespace '%s'", user.Username, serviceAccountName, pod.Name, pod.Namespace)
+	}
+
+	podName := user.Extra[podNameExtraKey]
+	podUID := user.Extra[podUIDExtraKey]
+	if len(podName) != 1 || podName[0] != pod.Name || len(podUID) != 1 || types.UID(podUID[0]) != pod.UID {
+		return fmt.Errorf("service account token is not bound to pod '%s' in namespace '%s'", pod.Name, pod.Namespace)
+	}
+
 	return nil
 }
+
+func hasAudience(audiences []string, audience string) bool {
+	for _, a := range audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of the Authorization header of r
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
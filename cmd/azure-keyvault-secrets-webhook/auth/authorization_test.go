@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -12,9 +13,11 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -40,6 +43,11 @@ type fixture struct {
 
 func (f *fixture) initAuthorization() {
 	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+	for _, obj := range f.kubeobjects {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			addTokenReviewReactor(f.kubeclient, "token-"+pod.Name, pod, ServiceAccountTokenAudience)
+		}
+	}
 	k8sInformerNamespaces := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
 
 	for _, d := range f.namespaceLister {
@@ -81,11 +89,39 @@ func createNewNamespace(name string, addLabel bool) *corev1.Namespace {
 	return ns
 }
 
+// addTokenReviewReactor authenticates token as a service account token for audience bound to pod
+func addTokenReviewReactor(client *k8sfake.Clientset, token string, pod *corev1.Pod, audience string) {
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationapi.TokenReview).DeepCopy()
+		if review.Spec.Token != token {
+			return false, nil, nil
+		}
+
+		serviceAccount := pod.Spec.ServiceAccountName
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+		review.Status = authenticationapi.TokenReviewStatus{
+			Authenticated: true,
+			Audiences:     []string{audience},
+			User: authenticationapi.UserInfo{
+				Username: fmt.Sprintf("system:serviceaccount:%s:%s", pod.Namespace, serviceAccount),
+				Extra: map[string]authenticationapi.ExtraValue{
+					podNameExtraKey: {pod.Name},
+					podUIDExtraKey:  {string(pod.UID)},
+				},
+			},
+		}
+		return true, review, nil
+	})
+}
+
 func createPod(name string, namespace string, multipleContainers bool) *corev1.Pod {
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			UID:       types.UID("uid-" + name),
 		},
 	}
 
@@ -120,17 +156,62 @@ func TestMultipleContainersInPod(t *testing.T) {
 	podData := podData{
 		name:      "test",
 		namespace: "test",
-		token:     "asfasdasf",
+		token:     "token-test",
 	}
 
 	f.initAuthorization()
-	err := authorize(f.kubeclient, podData)
+	err := authorize(f.kubeclient, podData, false)
 
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestAuthorizeServiceAccountToken(t *testing.T) {
+	f := newFixture(t)
+
+	ns := createNewNamespace("test", true)
+	pod := createPod("test", ns.Name, false)
+	otherPod := createPod("other", ns.Name, false)
+	f.kubeobjects = append(f.kubeobjects, ns, pod, otherPod)
+	f.initAuthorization()
+
+	tests := []struct {
+		name  string
+		pod   string
+		token string
+	}{
+		{name: "no token", pod: "test"},
+		{name: "unknown token", pod: "test", token: "unknown"},
+		{name: "token of other pod", pod: "test", token: "token-other"},
+	}
+	for _, test := range tests {
+		err := authorize(f.kubeclient, podData{name: test.pod, namespace: "test", token: test.token}, false)
+		if err == nil {
+			t.Errorf("%s: expected authorization to fail", test.name)
+		}
+	}
+
+	wrongAudience := createPod("wrong-audience", ns.Name, false)
+	addTokenReviewReactor(f.kubeclient, "token-wrong-audience", wrongAudience, "api")
+	if _, err := f.kubeclient.CoreV1().Pods(ns.Name).Create(context.TODO(), wrongAudience, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(f.kubeclient, podData{name: "wrong-audience", namespace: "test", token: "token-wrong-audience"}, false); err == nil {
+		t.Error("expected authorization to fail for token of other audience")
+	}
+
+	// A token of the same service account bound to a previous pod with the same name
+	recreated := pod.DeepCopy()
+	recreated.UID = "recreated"
+	if _, err := f.kubeclient.CoreV1().Pods(ns.Name).Update(context.TODO(), recreated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(f.kubeclient, podData{name: "test", namespace: "test", token: "token-test"}, false); err == nil {
+		t.Error("expected authorization to fail for token bound to other pod uid")
+	}
+}
+
 func TestTokenReview(t *testing.T) {
 	config := ensureIntegrationEnvironment(t)
 
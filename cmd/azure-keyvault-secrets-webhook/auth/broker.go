@@ -0,0 +1,285 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	vaultRequestTimeout = 10 * time.Second
+
+	// secretsRequestTimeout limits resolving all secrets of a pod, and must be shorter than the
+	// write timeout of the mtls server for errors to reach the env-injector
+	secretsRequestTimeout = 3 * vaultRequestTimeout
+
+	// secretsConcurrency is the number of AzureKeyVaultSecrets resolved at a time for a pod
+	secretsConcurrency = 10
+
+	// authServiceIdentity partitions the vault cache for requests using the identity of the auth service
+	authServiceIdentity = "auth-service"
+)
+
+var secretsIssuedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "akv2k8s_auth_secrets_issued_total",
+	Help: "The total number of azure key vault secrets issued to pods by the auth service",
+})
+
+func newVaultService(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+	return vault.NewService(credentials, vaultRequestTimeout)
+}
+
+// SecretsHandler handles requests for the AzureKeyVaultSecrets referenced by the env vars of a pod.
+// Only the values of the referenced AzureKeyVaultSecrets are returned, keyed by env var value, and
+// every secret issued is audit logged.
+func (a AuthService) SecretsHandler(w http.ResponseWriter, r *http.Request) {
+	authRequestsCounter.Inc()
+
+	if r.Method != "GET" {
+		authRequestsFailures.Inc()
+		klog.InfoS("invalid request method")
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	pod := podData{
+		name:      vars["pod"],
+		namespace: vars["namespace"],
+		token:     bearerToken(r),
+	}
+
+	if pod.name == "" || pod.namespace == "" {
+		klog.InfoS("failed to parse url parameters", "pod", pod.name, "namespace", pod.namespace)
+		http.Error(w, "", http.StatusBadRequest)
+		authRequestsFailures.Inc()
+		return
+	}
+
+	if err := authorize(a.kubeclient, pod, false); err != nil {
+		klog.ErrorS(err, "failed to authorize request", "pod", pod.name, "namespace", pod.namespace)
+		http.Error(w, "", http.StatusForbidden)
+		authRequestsFailures.Inc()
+		return
+	}
+
+	secrets, err := a.getSecretsForPod(r.Context(), pod)
+	if err != nil {
+		klog.ErrorS(err, "failed to get secrets for pod", "pod", pod.name, "namespace", pod.namespace)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		authRequestsFailures.Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(secrets); err != nil {
+		klog.ErrorS(err, "failed to json encode secrets", "pod", pod.name, "namespace", pod.namespace)
+	}
+}
+
+// getSecretsForPod resolves the AzureKeyVaultSecrets referenced by the env vars of the containers of pod.
+// AzureKeyVaultSecrets are resolved in parallel, and all references failing are reported in the error.
+func (a AuthService) getSecretsForPod(ctx context.Context, pod podData) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretsRequestTimeout)
+	defer cancel()
+
+	runningPod, err := a.kubeclient.CoreV1().Pods(pod.namespace).Get(ctx, pod.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod '%s' in namespace '%s', error: %+v", pod.name, pod.namespace, err)
+	}
+
+	refs := podReferences(runningPod)
+	secrets := make(map[string]string, len(refs))
+	if len(refs) == 0 {
+		return secrets, nil
+	}
+
+	vaultService, err := a.vaultServiceFor(ctx, runningPod)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]string)
+	for value, ref := range refs {
+		byName[ref.Name] = append(byName[ref.Name], value)
+	}
+
+	var failures []string
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, secretsConcurrency)
+
+	for name, values := range byName {
+		wg.Add(1)
+		go func(name string, values []string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			resolved, errs := a.getSecretsForAzureKeyVaultSecret(ctx, pod, name, values, refs, vaultService)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			for value, secret := range resolved {
+				secrets[value] = secret
+			}
+			for value, err := range errs {
+				failures = append(failures, fmt.Sprintf("'%s': %+v", value, err))
+			}
+		}(name, values)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, fmt.Errorf("failed to resolve %d of %d secrets:\n%s", len(failures), len(refs), strings.Join(failures, "\n"))
+	}
+	return secrets, nil
+}
+
+// getSecretsForAzureKeyVaultSecret resolves the references in values to the AzureKeyVaultSecret name,
+// returning the secrets and the errors keyed by reference
+func (a AuthService) getSecretsForAzureKeyVaultSecret(ctx context.Context, pod podData, name string, values []string, refs map[string]*injector.Reference, vaultService vault.Service) (map[string]string, map[string]error) {
+	secrets := make(map[string]string, len(values))
+	errs := make(map[string]error)
+
+	akvs, err := a.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(pod.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		err = fmt.Errorf("failed to get azurekeyvaultsecret '%s' in namespace '%s', error: %+v", name, pod.namespace, err)
+		for _, value := range values {
+			errs[value] = err
+		}
+		return secrets, errs
+	}
+
+	for _, value := range values {
+		ref := refs[value]
+		secret, err := injector.GetSecretFromKeyVault(ctx, akvs, ref.Query, vaultService)
+		if err != nil {
+			klog.ErrorS(err, "failed to get secret for pod", "pod", klog.KRef(pod.namespace, pod.name), "azurekeyvaultsecret", klog.KObj(akvs), "query", ref.Query)
+			errs[value] = fmt.Errorf("failed to get secret for azurekeyvaultsecret '%s' in namespace '%s', error: %+v", name, pod.namespace, err)
+			continue
+		}
+
+		klog.InfoS("audit: issued azure key vault secret to pod",
+			"pod", klog.KRef(pod.namespace, pod.name),
+			"azurekeyvaultsecret", klog.KObj(akvs),
+			"vault", akvs.Spec.Vault.Name,
+			"object", akvs.Spec.Vault.Object.Name,
+			"type", akvs.Spec.Vault.Object.Type,
+			"query", ref.Query)
+		secretsIssuedCounter.Inc()
+		secrets[value] = secret
+	}
+	return secrets, errs
+}
+
+// vaultServiceFor returns a service for Azure Key Vault using the identity of pod if pod identity
+// is enabled, or else the identity of the auth service. Objects are cached per identity, so that
+// pods never get objects from the cache their own identity has no access to.
+func (a AuthService) vaultServiceFor(ctx context.Context, pod *corev1.Pod) (vault.Service, error) {
+	if a.podIdentity != nil {
+		credentials, identity, err := a.podIdentity.getAzureKeyVaultCredentials(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		return a.cachedVaultService(credentials, identity), nil
+	}
+
+	credentials, ok := a.credentials.(credentialprovider.AzureKeyVaultCredentials)
+	if !ok {
+		return nil, fmt.Errorf("auth service credentials are not azure key vault credentials")
+	}
+	return a.cachedVaultService(credentials, authServiceIdentity), nil
+}
+
+func (a AuthService) cachedVaultService(credentials credentialprovider.AzureKeyVaultCredentials, identity string) vault.Service {
+	service := a.newVaultService(credentials)
+	if a.vaultCache == nil {
+		return service
+	}
+	return a.vaultCache.Service(service, identity)
+}
+
+// podReferences returns the AzureKeyVaultSecret references in the env vars, including templates, of all
+// containers and the secret files of pod, keyed by env var value or reference
+func podReferences(pod *corev1.Pod) map[string]*injector.Reference {
+	refs := make(map[string]*injector.Reference)
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if injector.IsTemplate(env.Value) {
+				tmpl, err := injector.ParseTemplate(env.Value)
+				if err != nil {
+					klog.InfoS("skipping invalid template", "env", env.Name, "container", container.Name, "pod", klog.KObj(pod), "error", err.Error())
+					continue
+				}
+				for _, reference := range tmpl.References() {
+					if ref, err := injector.ParseReference(reference); err == nil {
+						refs[reference] = ref
+					}
+				}
+				continue
+			}
+
+			if !injector.IsReference(env.Value) {
+				continue
+			}
+			ref, err := injector.ParseReference(env.Value)
+			if err != nil {
+				klog.InfoS("skipping invalid azurekeyvaultsecret reference", "env", env.Name, "container", container.Name, "pod", klog.KObj(pod), "error", err.Error())
+				continue
+			}
+			refs[env.Value] = ref
+		}
+	}
+
+	if annotation, ok := pod.Annotations[injector.SecretFilesAnnotation]; ok {
+		files, err := injector.ParseSecretFiles(annotation)
+		if err != nil {
+			klog.InfoS("skipping invalid secret files", "annotation", injector.SecretFilesAnnotation, "pod", klog.KObj(pod), "error", err.Error())
+			return refs
+		}
+		for _, file := range files {
+			ref, err := injector.ParseReference(file.Reference())
+			if err != nil {
+				continue
+			}
+			refs[file.Reference()] = ref
+		}
+	}
+	return refs
+}
@@ -0,0 +1,319 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	fakeVault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client/fake"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	akvfake "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned/fake"
+	"github.com/gorilla/mux"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeKeyVaultCredentials struct{}
+
+func (c fakeKeyVaultCredentials) Authorizer() (autorest.Authorizer, error) {
+	return autorest.NullAuthorizer{}, nil
+}
+
+func (c fakeKeyVaultCredentials) Endpoint(keyVaultName string) string {
+	return "https://" + keyVaultName + ".vault.azure.net"
+}
+
+func newBrokerTestAkvs(name string) *akv.AzureKeyVaultSecret {
+	return &akv.AzureKeyVaultSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Name:   "my-vault",
+				Object: akv.AzureKeyVaultObject{Name: name, Type: akv.AzureKeyVaultObjectTypeSecret},
+			},
+		},
+	}
+}
+
+func newBrokerTestService(t *testing.T) *mux.Router {
+	ns := createNewNamespace("test", true)
+	pod := createPod("test", ns.Name, false)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "SECRET", Value: "referenced@azurekeyvault"},
+		{Name: "USERNAME", Value: "basic-auth@azurekeyvault?username"},
+	}
+
+	f := newFixture(t)
+	f.kubeobjects = append(f.kubeobjects, ns, pod)
+	f.initAuthorization()
+
+	authService := &AuthService{
+		kubeclient:  f.kubeclient,
+		akvsClient:  akvfake.NewSimpleClientset(newBrokerTestAkvs("referenced"), newBrokerTestAkvs("basic-auth"), newBrokerTestAkvs("other")),
+		credentials: fakeKeyVaultCredentials{},
+		newVaultService: func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+			return &fakeVault.AkvsService{FakeSecret: "user:password"}
+		},
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/{namespace}/{pod}", authService.AuthHandler)
+	router.HandleFunc("/secrets/{namespace}/{pod}", authService.SecretsHandler)
+	return router
+}
+
+func serveBrokerTestRequest(router *mux.Router, url string, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSecretsHandler(t *testing.T) {
+	router := newBrokerTestService(t)
+
+	recorder := serveBrokerTestRequest(router, "/secrets/test/test", "token-test")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status ok, got %d", recorder.Code)
+	}
+
+	var secrets map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"referenced@azurekeyvault":          "user:password",
+		"basic-auth@azurekeyvault?username": "user",
+	}
+	if len(secrets) != len(expected) {
+		t.Errorf("expected only referenced secrets to be issued, got %v", secrets)
+	}
+	for key, value := range expected {
+		if secrets[key] != value {
+			t.Errorf("expected '%s' for '%s', got '%s'", value, key, secrets[key])
+		}
+	}
+}
+
+func TestSecretsHandlerUnauthorized(t *testing.T) {
+	router := newBrokerTestService(t)
+
+	if recorder := serveBrokerTestRequest(router, "/secrets/test/test", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("expected request without token to be forbidden, got status %d", recorder.Code)
+	}
+}
+
+func TestSecretsHandlerReportsAllFailures(t *testing.T) {
+	ns := createNewNamespace("test", true)
+	pod := createPod("test", ns.Name, false)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "GOOD", Value: "good@azurekeyvault"},
+		{Name: "BROKEN", Value: "broken@azurekeyvault"},
+		{Name: "MISSING", Value: "missing@azurekeyvault"},
+	}
+
+	f := newFixture(t)
+	f.kubeobjects = append(f.kubeobjects, ns, pod)
+	f.initAuthorization()
+
+	vaultService := &fakeVault.AkvsService{
+		FakeSecret: "secret",
+		Errors: map[string]error{
+			"broken": fmt.Errorf("forbidden"),
+		},
+	}
+	authService := &AuthService{
+		kubeclient:  f.kubeclient,
+		akvsClient:  akvfake.NewSimpleClientset(newBrokerTestAkvs("good"), newBrokerTestAkvs("broken")),
+		credentials: fakeKeyVaultCredentials{},
+		newVaultService: func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+			return vaultService
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/secrets/{namespace}/{pod}", authService.SecretsHandler)
+
+	recorder := serveBrokerTestRequest(router, "/secrets/test/test", "token-test")
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status internal server error, got %d", recorder.Code)
+	}
+
+	body := recorder.Body.String()
+	for _, expected := range []string{"failed to resolve 2 of 3 secrets", "'broken@azurekeyvault'", "forbidden", "'missing@azurekeyvault'"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected response to contain %q, got: %s", expected, body)
+		}
+	}
+	if strings.Contains(body, "good@azurekeyvault") {
+		t.Errorf("expected response to only report failures, got: %s", body)
+	}
+}
+
+func TestAuthHandlerDoesNotIssueOwnCredentials(t *testing.T) {
+	router := newBrokerTestService(t)
+
+	if recorder := serveBrokerTestRequest(router, "/auth/test/test", "token-test"); recorder.Code != http.StatusForbidden {
+		t.Errorf("expected auth service credentials not to be issued, got status %d", recorder.Code)
+	}
+}
+
+func TestAuthHandlerIssuesLegacyCredentials(t *testing.T) {
+	ns := createNewNamespace("test", true)
+	pod := createPod("test", ns.Name, false)
+
+	f := newFixture(t)
+	f.kubeobjects = append(f.kubeobjects, ns, pod)
+	f.initAuthorization()
+
+	authService := &AuthService{
+		kubeclient:        f.kubeclient,
+		credentials:       fakeKeyVaultCredentials{},
+		legacyCredentials: true,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/{namespace}/{pod}", authService.AuthHandler)
+
+	if recorder := serveBrokerTestRequest(router, "/auth/test/test", "token-test"); recorder.Code != http.StatusOK {
+		t.Errorf("expected auth service credentials to be issued to older env-injectors, got status %d", recorder.Code)
+	}
+}
+
+func TestAuthHandlerIssuesLegacyCredentialsWithoutToken(t *testing.T) {
+	ns := createNewNamespace("test", true)
+	disabled := createNewNamespace("disabled", false)
+	pod := createPod("test", ns.Name, false)
+	disabledPod := createPod("test", disabled.Name, false)
+
+	f := newFixture(t)
+	f.kubeobjects = append(f.kubeobjects, ns, disabled, pod, disabledPod)
+	f.initAuthorization()
+
+	tests := []struct {
+		name              string
+		url               string
+		legacyCredentials bool
+		expected          int
+	}{
+		{name: "legacy credentials", url: "/auth/test/test", legacyCredentials: true, expected: http.StatusOK},
+		{name: "legacy credentials in namespace without env-injection", url: "/auth/disabled/test", legacyCredentials: true, expected: http.StatusForbidden},
+		{name: "no legacy credentials", url: "/auth/test/test", expected: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		authService := &AuthService{
+			kubeclient:        f.kubeclient,
+			credentials:       fakeKeyVaultCredentials{},
+			legacyCredentials: test.legacyCredentials,
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/auth/{namespace}/{pod}", authService.AuthHandler)
+
+		// older env-injectors do not send a service account token
+		if recorder := serveBrokerTestRequest(router, test.url, ""); recorder.Code != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, recorder.Code)
+		}
+	}
+}
+
+func TestPodReferencesSecretFiles(t *testing.T) {
+	pod := createPod("test", "test", false)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "SECRET", Value: "referenced@azurekeyvault"}}
+	pod.Annotations = map[string]string{
+		injector.SecretFilesAnnotation: `
+- path: tls/tls.key
+  secret: my-cert?tls.key
+- path: keystore.pfx
+  secret: my-cert
+  format: pfx
+`,
+	}
+
+	refs := podReferences(pod)
+	for _, key := range []string{"referenced@azurekeyvault", "my-cert@azurekeyvault?tls.key", "my-cert@azurekeyvault?pfx"} {
+		if _, ok := refs[key]; !ok {
+			t.Errorf("expected reference '%s', got %v", key, refs)
+		}
+	}
+	if len(refs) != 3 {
+		t.Errorf("expected 3 references, got %d", len(refs))
+	}
+}
+
+func TestPodReferencesTemplates(t *testing.T) {
+	pod := createPod("test", "test", false)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "DATABASE_URL", Value: `postgres://{{ akvs "db-creds?username" }}:{{ akvs "db-creds?password" }}@host/db`},
+		{Name: "INVALID", Value: `{{ akvs .Name }}`},
+	}
+
+	refs := podReferences(pod)
+	for _, key := range []string{"db-creds@azurekeyvault?username", "db-creds@azurekeyvault?password"} {
+		if _, ok := refs[key]; !ok {
+			t.Errorf("expected reference '%s', got %v", key, refs)
+		}
+	}
+	if len(refs) != 2 {
+		t.Errorf("expected 2 references, got %d", len(refs))
+	}
+}
+
+func TestSecretsHandlerCachesVaultObjects(t *testing.T) {
+	ns := createNewNamespace("test", true)
+	pod := createPod("test", ns.Name, false)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "SECRET", Value: "referenced@azurekeyvault"}}
+
+	f := newFixture(t)
+	f.kubeobjects = append(f.kubeobjects, ns, pod)
+	f.initAuthorization()
+
+	vaultService := &fakeVault.AkvsService{FakeSecret: "secret"}
+	authService := &AuthService{
+		kubeclient:  f.kubeclient,
+		akvsClient:  akvfake.NewSimpleClientset(newBrokerTestAkvs("referenced")),
+		credentials: fakeKeyVaultCredentials{},
+		newVaultService: func(credentials credentialprovider.AzureKeyVaultCredentials) vault.Service {
+			return vaultService
+		},
+		vaultCache: vault.NewCache(vault.CacheOptions{TTL: time.Minute}),
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/secrets/{namespace}/{pod}", authService.SecretsHandler)
+	for i := 0; i < 3; i++ {
+		if recorder := serveBrokerTestRequest(router, "/secrets/test/test", "token-test"); recorder.Code != http.StatusOK {
+			t.Fatalf("expected status ok, got %d", recorder.Code)
+		}
+	}
+
+	if calls := vaultService.Calls(); calls != 1 {
+		t.Errorf("expected secret to be fetched from azure key vault once, got %d calls", calls)
+	}
+}
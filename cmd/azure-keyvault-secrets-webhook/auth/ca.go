@@ -0,0 +1,238 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+var caRotationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "akv2k8s_auth_ca_rotations_total",
+	Help: "The total number of auth service ca rotations detected",
+})
+
+// caLoader loads the pem encoded ca cert and key
+type caLoader func(ctx context.Context) (cert []byte, key []byte, err error)
+
+type previousCA struct {
+	cert         []byte
+	certificate  *x509.Certificate
+	trustedUntil time.Time
+}
+
+// CertificateAuthority is the ca signing client certificates for the auth service. The ca is reloaded
+// from its source when changed, and the previous ca is trusted for rotationWindow after a rotation,
+// giving the auth service time to re-issue client certificates signed by the previous ca.
+type CertificateAuthority struct {
+	load           caLoader
+	rotationWindow time.Duration
+
+	mutex       sync.RWMutex
+	cert        []byte
+	key         []byte
+	certificate *x509.Certificate
+	previous    []previousCA
+}
+
+// NewCertificateAuthorityFromDir creates a ca loaded from tls.crt and tls.key in dir
+func NewCertificateAuthorityFromDir(dir string, rotationWindow time.Duration) (*CertificateAuthority, error) {
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	klog.V(4).InfoS("auth service ca cert", "file", certFile)
+	klog.V(4).InfoS("auth service ca key", "file", keyFile)
+
+	return newCertificateAuthority(func(ctx context.Context) ([]byte, []byte, error) {
+		if !fileExists(certFile) {
+			return nil, nil, fmt.Errorf("file %s does not exist", certFile)
+		}
+		if !fileExists(keyFile) {
+			return nil, nil, fmt.Errorf("file %s does not exist", keyFile)
+		}
+
+		cert, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read pem file for ca cert %s, error: %+v", certFile, err)
+		}
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read pem file for ca key %s, error: %+v", keyFile, err)
+		}
+		return cert, key, nil
+	}, rotationWindow)
+}
+
+// NewCertificateAuthorityFromSecret creates a ca loaded from tls.crt and tls.key in the Kubernetes Secret name in namespace
+func NewCertificateAuthorityFromSecret(kubeclient kubernetes.Interface, namespace, name string, rotationWindow time.Duration) (*CertificateAuthority, error) {
+	return newCertificateAuthority(func(ctx context.Context) ([]byte, []byte, error) {
+		secret, err := kubeclient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get ca secret '%s' in namespace '%s', error: %+v", name, namespace, err)
+		}
+		return secret.Data["tls.crt"], secret.Data["tls.key"], nil
+	}, rotationWindow)
+}
+
+func newCertificateAuthority(load caLoader, rotationWindow time.Duration) (*CertificateAuthority, error) {
+	ca := &CertificateAuthority{
+		load:           load,
+		rotationWindow: rotationWindow,
+	}
+	if err := ca.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Reload loads the ca from its source. If the ca has changed, the previous ca is trusted for the rotation window.
+func (c *CertificateAuthority) Reload(ctx context.Context) error {
+	cert, key, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+	if len(cert) == 0 || len(key) == 0 {
+		return fmt.Errorf("ca cert or key is empty")
+	}
+
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return fmt.Errorf("invalid ca key pair, error: %+v", err)
+	}
+
+	certificate, err := parseCertificate(cert)
+	if err != nil {
+		return fmt.Errorf("failed to parse ca cert, error: %+v", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.prunePrevious()
+
+	if bytes.Equal(cert, c.cert) && bytes.Equal(key, c.key) {
+		return nil
+	}
+
+	if c.certificate != nil && !bytes.Equal(cert, c.cert) {
+		c.previous = append(c.previous, previousCA{
+			cert:         c.cert,
+			certificate:  c.certificate,
+			trustedUntil: time.Now().Add(c.rotationWindow),
+		})
+		caRotationsCounter.Inc()
+		klog.InfoS("auth service ca rotated", "subject", certificate.Subject.String(), "serial", certificate.SerialNumber.String(), "previousTrustedFor", c.rotationWindow)
+	}
+
+	c.cert = cert
+	c.key = key
+	c.certificate = certificate
+	return nil
+}
+
+// prunePrevious removes previous cas no longer trusted - must be called with lock held
+func (c *CertificateAuthority) prunePrevious() {
+	now := time.Now()
+	trusted := c.previous[:0]
+	for _, previous := range c.previous {
+		if now.Before(previous.trustedUntil) {
+			trusted = append(trusted, previous)
+		} else {
+			klog.InfoS("previous auth service ca no longer trusted", "subject", previous.certificate.Subject.String(), "serial", previous.certificate.SerialNumber.String())
+		}
+	}
+	c.previous = trusted
+}
+
+// Watch reloads the ca every interval until ctx is done
+func (c *CertificateAuthority) Watch(ctx context.Context, interval time.Duration) {
+	klog.InfoS("watching auth service ca for changes", "interval", interval)
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.Reload(ctx); err != nil {
+			klog.ErrorS(err, "failed to reload auth service ca, using current")
+		}
+	}, interval)
+}
+
+// KeyPair returns the pem encoded cert and key of the current ca
+func (c *CertificateAuthority) KeyPair() ([]byte, []byte) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, c.key
+}
+
+// Bundle returns the pem encoded certs of the current and trusted previous cas
+func (c *CertificateAuthority) Bundle() []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	bundle := append([]byte{}, c.cert...)
+	now := time.Now()
+	for _, previous := range c.previous {
+		if now.Before(previous.trustedUntil) {
+			if !bytes.HasSuffix(bundle, []byte("\n")) {
+				bundle = append(bundle, '\n')
+			}
+			bundle = append(bundle, previous.cert...)
+		}
+	}
+	return bundle
+}
+
+// CertPool returns a pool with the current and trusted previous cas
+func (c *CertificateAuthority) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.Bundle())
+	return pool
+}
+
+// Issuer returns the trusted ca that signed cert, and whether it is the current ca
+func (c *CertificateAuthority) Issuer(cert *x509.Certificate) (issuer *x509.Certificate, current bool, err error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if cert.CheckSignatureFrom(c.certificate) == nil {
+		return c.certificate, true, nil
+	}
+
+	now := time.Now()
+	for _, previous := range c.previous {
+		if now.Before(previous.trustedUntil) && cert.CheckSignatureFrom(previous.certificate) == nil {
+			return previous.certificate, false, nil
+		}
+	}
+	return nil, false, fmt.Errorf("certificate not signed by a trusted auth service ca")
+}
+
+// Current returns the parsed cert of the current ca
+func (c *CertificateAuthority) Current() *x509.Certificate {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.certificate
+}
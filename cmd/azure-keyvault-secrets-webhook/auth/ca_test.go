@@ -0,0 +1,242 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func staticCALoader(cert, key []byte) caLoader {
+	return func(ctx context.Context) ([]byte, []byte, error) {
+		return cert, key, nil
+	}
+}
+
+// rotatingCALoader returns the ca currently in cert and key
+func rotatingCALoader(cert, key *[]byte) caLoader {
+	return func(ctx context.Context) ([]byte, []byte, error) {
+		return *cert, *key, nil
+	}
+}
+
+func generateTestCA(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "akv2k8s-test-ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+func issueTestClientCert(t *testing.T, ca *CertificateAuthority) *x509.Certificate {
+	caCert, caKey := ca.KeyPair()
+	clientCert, err := generateClientCert("akv2k8s-test", "default", testClientCertOptions, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCertificate(clientCert.Crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateAuthorityRotation(t *testing.T) {
+	cert, key := caCert, caKey
+	ca, err := newCertificateAuthority(rotatingCALoader(&cert, &key), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldClientCert := issueTestClientCert(t, ca)
+	if _, current, err := ca.Issuer(oldClientCert); err != nil || !current {
+		t.Errorf("expected client certificate issued by current ca, error: %+v", err)
+	}
+
+	cert, key = generateTestCA(t)
+	if err := ca.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := ca.KeyPair(); !bytes.Equal(current, cert) {
+		t.Error("expected new ca to be current after reload")
+	}
+	if bundle := ca.Bundle(); !bytes.Contains(bundle, cert) || !bytes.Contains(bundle, caCert) {
+		t.Error("expected bundle to contain both new and previous ca during rotation")
+	}
+	if _, current, err := ca.Issuer(oldClientCert); err != nil || current {
+		t.Errorf("expected client certificate issued by previous ca to be trusted, error: %+v", err)
+	}
+
+	newClientCert := issueTestClientCert(t, ca)
+	if _, current, err := ca.Issuer(newClientCert); err != nil || !current {
+		t.Errorf("expected client certificate issued by new ca, error: %+v", err)
+	}
+
+	// The rotation window has passed
+	ca.previous[0].trustedUntil = time.Now().Add(-time.Second)
+	if _, _, err := ca.Issuer(oldClientCert); err == nil {
+		t.Error("expected client certificate issued by previous ca not to be trusted after rotation window")
+	}
+	if err := ca.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ca.previous) != 0 || bytes.Contains(ca.Bundle(), caCert) {
+		t.Error("expected previous ca to be removed after rotation window")
+	}
+}
+
+func TestCertificateAuthorityInvalidReload(t *testing.T) {
+	cert, key := caCert, caKey
+	ca, err := newCertificateAuthority(rotatingCALoader(&cert, &key), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key = generateTestCA(t)
+	if err := ca.Reload(context.Background()); err == nil {
+		t.Error("expected error reloading ca with mismatching key")
+	}
+	if current, _ := ca.KeyPair(); !bytes.Equal(current, caCert) {
+		t.Error("expected current ca to be kept when reload fails")
+	}
+}
+
+func TestCertificateAuthorityFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeCA := func(cert, key []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), cert, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "tls.key"), key, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewCertificateAuthorityFromDir(dir, time.Hour); err == nil {
+		t.Error("expected error without ca files")
+	}
+
+	writeCA(caCert, caKey)
+	ca, err := NewCertificateAuthorityFromDir(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCert, newKey := generateTestCA(t)
+	writeCA(newCert, newKey)
+	if err := ca.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := ca.KeyPair(); !bytes.Equal(current, newCert) {
+		t.Error("expected ca to be reloaded from dir")
+	}
+}
+
+func TestCertificateAuthorityFromSecret(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "akv2k8s-ca", Namespace: "akv2k8s"},
+		Data:       map[string][]byte{"tls.crt": caCert, "tls.key": caKey},
+	})
+
+	ca, err := NewCertificateAuthorityFromSecret(client, "akv2k8s", "akv2k8s-ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := ca.KeyPair(); !bytes.Equal(current, caCert) {
+		t.Error("expected ca loaded from secret")
+	}
+
+	if _, err := NewCertificateAuthorityFromSecret(client, "akv2k8s", "missing", time.Hour); err == nil {
+		t.Error("expected error for missing ca secret")
+	}
+}
+
+func TestVerifyClientCertDuringRotation(t *testing.T) {
+	a := newClientCertTestService()
+	cert, key := generateTestCA(t)
+	ca, err := newCertificateAuthority(rotatingCALoader(&cert, &key), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ca = ca
+
+	clientCert := issueTestClientCert(t, ca)
+	if err := a.verifyClientCert([][]byte{clientCert.Raw}, nil); err != nil {
+		t.Errorf("expected client certificate to be verified, error: %+v", err)
+	}
+
+	cert, key = generateTestCA(t)
+	if err := ca.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.verifyClientCert([][]byte{clientCert.Raw}, nil); err != nil {
+		t.Errorf("expected client certificate issued by previous ca to be verified during rotation, error: %+v", err)
+	}
+
+	ca.previous[0].trustedUntil = time.Now().Add(-time.Second)
+	if err := a.verifyClientCert([][]byte{clientCert.Raw}, nil); err == nil {
+		t.Error("expected client certificate issued by previous ca to be rejected after rotation window")
+	}
+
+	revoked := issueTestClientCert(t, ca)
+	a.revocations.Revoke(revoked)
+	if err := a.verifyClientCert([][]byte{revoked.Raw}, nil); err == nil {
+		t.Error("expected revoked client certificate to be rejected")
+	}
+}
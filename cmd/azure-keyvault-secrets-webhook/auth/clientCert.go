@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -11,17 +14,106 @@ import (
 	"math/big"
 	"time"
 
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 )
 
+// Supported key types for client certificates
+const (
+	ClientCertKeyTypeRSA   = "rsa"
+	ClientCertKeyTypeECDSA = "ecdsa"
+)
+
+const minRSAKeySize = 2048
+
 type ClientCertificate struct {
 	CA  []byte
 	Crt []byte
 	Key []byte
 }
 
-func generateClientCert(mutationID types.UID, validMonths int, caCert, caKey []byte) (*ClientCertificate, error) {
+// ClientCertOptions configures the client certificates issued to pods for authenticating with the auth service
+type ClientCertOptions struct {
+	// KeyType is either rsa or ecdsa
+	KeyType string
+	// KeySize is the number of bits for rsa keys, or the curve size (256, 384 or 521) for ecdsa keys
+	KeySize int
+	// Validity is the lifetime of client certificates
+	Validity time.Duration
+	// RenewBefore is how long before expiry client certificates are renewed
+	RenewBefore time.Duration
+}
+
+// Validate checks that the client certificate options are supported
+func (o ClientCertOptions) Validate() error {
+	switch o.KeyType {
+	case ClientCertKeyTypeRSA:
+		if o.KeySize < minRSAKeySize {
+			return fmt.Errorf("rsa key size %d not supported, must be at least %d", o.KeySize, minRSAKeySize)
+		}
+	case ClientCertKeyTypeECDSA:
+		if _, err := ecdsaCurve(o.KeySize); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("client certificate key type '%s' not supported, must be '%s' or '%s'", o.KeyType, ClientCertKeyTypeRSA, ClientCertKeyTypeECDSA)
+	}
+
+	if o.Validity <= 0 {
+		return fmt.Errorf("client certificate validity must be positive")
+	}
+	if o.RenewBefore < 0 || o.RenewBefore >= o.Validity {
+		return fmt.Errorf("client certificate renew before (%s) must be less than validity (%s)", o.RenewBefore, o.Validity)
+	}
+	return nil
+}
+
+// needsRenewal returns true if cert expires within RenewBefore or does not match the configured key type and size
+func (o ClientCertOptions) needsRenewal(cert *x509.Certificate) bool {
+	if time.Now().Add(o.RenewBefore).After(cert.NotAfter) {
+		return true
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return o.KeyType != ClientCertKeyTypeRSA || key.N.BitLen() != o.KeySize
+	case *ecdsa.PublicKey:
+		return o.KeyType != ClientCertKeyTypeECDSA || key.Curve.Params().BitSize != o.KeySize
+	default:
+		return true
+	}
+}
+
+func (o ClientCertOptions) generateKey() (crypto.Signer, error) {
+	if o.KeyType == ClientCertKeyTypeECDSA {
+		curve, err := ecdsaCurve(o.KeySize)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+
+	if o.KeySize < minRSAKeySize {
+		return nil, fmt.Errorf("rsa key size %d not supported, must be at least %d", o.KeySize, minRSAKeySize)
+	}
+	return rsa.GenerateKey(rand.Reader, o.KeySize)
+}
+
+func ecdsaCurve(size int) (elliptic.Curve, error) {
+	switch size {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("ecdsa key size %d not supported, must be 256, 384 or 521", size)
+	}
+}
+
+// generateClientCert creates a client certificate signed by the auth service ca, for the
+// client cert secret with name in namespace
+func generateClientCert(name, namespace string, options ClientCertOptions, caCert, caKey []byte) (*ClientCertificate, error) {
 	klog.V(4).InfoS("creating x509 key pair for ca cert and key")
 
 	ca, err := tls.X509KeyPair(caCert, caKey)
@@ -35,8 +127,8 @@ func generateClientCert(mutationID types.UID, validMonths int, caCert, caKey []b
 		return nil, err
 	}
 
-	klog.V(4).InfoS("generating client key")
-	clientKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	klog.V(4).InfoS("generating client key", "type", options.KeyType, "size", options.KeySize)
+	clientKey, err := options.generateKey()
 	if err != nil {
 		return nil, err
 	}
@@ -52,11 +144,12 @@ func generateClientCert(mutationID types.UID, validMonths int, caCert, caKey []b
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
-			Organization: []string{"akv2k8s"},
-			CommonName:   string(mutationID),
+			Organization:       []string{"akv2k8s"},
+			OrganizationalUnit: []string{namespace},
+			CommonName:         name,
 		},
 		NotBefore:             now,
-		NotAfter:              now.AddDate(0, validMonths, 0),
+		NotAfter:              now.Add(options.Validity),
 		KeyUsage:              x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
@@ -64,7 +157,7 @@ func generateClientCert(mutationID types.UID, validMonths int, caCert, caKey []b
 	}
 
 	klog.V(4).InfoS("crating x509 certificate")
-	certByte, err := x509.CreateCertificate(rand.Reader, &template, x509Ca, &clientKey.PublicKey, ca.PrivateKey)
+	certByte, err := x509.CreateCertificate(rand.Reader, &template, x509Ca, clientKey.Public(), ca.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -93,3 +186,12 @@ func generateClientCert(mutationID types.UID, validMonths int, caCert, caKey []b
 		Key: pemKey,
 	}, nil
 }
+
+// parseCertificate parses the first PEM encoded certificate in crt
+func parseCertificate(crt []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(crt)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode pem block containing certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// ClientCertSecretLabel is set on all secrets with client certificates created by the auth service
+const ClientCertSecretLabel = "akv2k8s.io/client-cert"
+
+// orphanGracePeriod is how long a client cert secret is kept before a pod using it must exist,
+// as secrets are created by the webhook before the pod
+const orphanGracePeriod = time.Hour
+
+var (
+	clientCertsRenewedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "akv2k8s_auth_client_certs_renewed_total",
+		Help: "The total number of client certificates renewed by the auth service",
+	})
+
+	clientCertsCollectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "akv2k8s_auth_client_certs_collected_total",
+		Help: "The total number of orphaned client certificate secrets deleted by the auth service",
+	})
+)
+
+// newClientCertSecret creates a Kubernetes Secret named name with a new client certificate
+func (a AuthService) newClientCertSecret(name string, namespace string, ownerReferences []metav1.OwnerReference) (*corev1.Secret, error) {
+	// Create secret containing CA cert and mTLS credentials
+	caCert, caKey := a.ca.KeyPair()
+	clientCert, err := generateClientCert(name, namespace, a.clientCertOptions, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			OwnerReferences: ownerReferences,
+			Labels: map[string]string{
+				ClientCertSecretLabel: "true",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"ca.crt":  a.ca.Bundle(),
+			"tls.crt": clientCert.Crt,
+			"tls.key": clientCert.Key,
+		},
+	}, nil
+}
+
+// secretNeedsRenewal returns true if the client certificate in secret is not issued by a trusted ca,
+// is about to expire, does not match the configured key type or is revoked
+func (a AuthService) secretNeedsRenewal(secret *corev1.Secret) bool {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return true
+	}
+
+	issuer, _, err := a.ca.Issuer(cert)
+	if err != nil {
+		return true
+	}
+
+	if a.clientCertOptions.needsRenewal(cert) {
+		return true
+	}
+	return a.revocations.IsRevoked(cert, issuer)
+}
+
+// secretIssuedByPreviousCA returns true if the client certificate in secret is issued by a previous ca
+// still trusted during ca rotation, or secret does not have the current ca
+func (a AuthService) secretIssuedByPreviousCA(secret *corev1.Secret) bool {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return false
+	}
+
+	_, current, err := a.ca.Issuer(cert)
+	if err != nil {
+		return false
+	}
+
+	caCert, _ := a.ca.KeyPair()
+	return !current || !bytes.Contains(secret.Data["ca.crt"], caCert)
+}
+
+// StartClientCertManager periodically renews client certificates about to expire, and deletes
+// client cert secrets no longer used by any pod, until ctx is done. After a ca rotation, at most
+// caRotationBatch client certificates issued by the previous ca are renewed each interval.
+func (a AuthService) StartClientCertManager(ctx context.Context, interval time.Duration) {
+	klog.InfoS("starting client certificate manager", "interval", interval)
+	go wait.UntilWithContext(ctx, a.manageClientCerts, interval)
+}
+
+func (a AuthService) manageClientCerts(ctx context.Context) {
+	secrets, err := a.kubeclient.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: ClientCertSecretLabel + "=true",
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to list client cert secrets")
+		return
+	}
+
+	rotated := 0
+	pods := make(map[string][]corev1.Pod)
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+
+		namespacePods, ok := pods[secret.Namespace]
+		if !ok {
+			podList, err := a.kubeclient.CoreV1().Pods(secret.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				klog.ErrorS(err, "failed to list pods", "namespace", secret.Namespace)
+				continue
+			}
+			namespacePods = podList.Items
+			pods[secret.Namespace] = namespacePods
+		}
+
+		if !secretUsedByPods(secret.Name, namespacePods) {
+			if time.Since(secret.CreationTimestamp.Time) > orphanGracePeriod {
+				a.deleteClientCertSecret(ctx, secret)
+			}
+			continue
+		}
+
+		if a.secretNeedsRenewal(secret) {
+			a.renewClientCertSecret(ctx, secret)
+			continue
+		}
+
+		if a.secretIssuedByPreviousCA(secret) {
+			if a.caRotationBatch > 0 && rotated >= a.caRotationBatch {
+				continue
+			}
+			a.renewClientCertSecret(ctx, secret)
+			rotated++
+		}
+	}
+
+	if rotated > 0 {
+		klog.InfoS("re-issued client certificates after ca rotation", "count", rotated)
+	}
+}
+
+func (a AuthService) deleteClientCertSecret(ctx context.Context, secret *corev1.Secret) {
+	err := a.kubeclient.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to delete orphaned client cert secret", "secret", klog.KObj(secret))
+		return
+	}
+
+	if cert, err := parseCertificate(secret.Data["tls.crt"]); err == nil {
+		a.revocations.Revoke(cert)
+	}
+
+	klog.InfoS("deleted orphaned client cert secret", "secret", klog.KObj(secret))
+	clientCertsCollectedCounter.Inc()
+}
+
+func (a AuthService) renewClientCertSecret(ctx context.Context, secret *corev1.Secret) {
+	newSecret, err := a.newClientCertSecret(secret.Name, secret.Namespace, secret.OwnerReferences)
+	if err != nil {
+		klog.ErrorS(err, "failed to create client certificate", "secret", klog.KObj(secret))
+		return
+	}
+
+	_, err = a.kubeclient.CoreV1().Secrets(secret.Namespace).Update(ctx, newSecret, metav1.UpdateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to renew client cert secret", "secret", klog.KObj(secret))
+		return
+	}
+
+	klog.InfoS("renewed client certificate", "secret", klog.KObj(secret))
+	clientCertsRenewedCounter.Inc()
+}
+
+func secretUsedByPods(name string, pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.Secret != nil && volume.Secret.SecretName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
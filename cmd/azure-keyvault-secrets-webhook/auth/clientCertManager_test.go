@@ -0,0 +1,205 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newClientCertTestService(objects ...runtime.Object) *AuthService {
+	ca, err := newCertificateAuthority(staticCALoader(caCert, caKey), time.Hour)
+	if err != nil {
+		panic(err)
+	}
+
+	return &AuthService{
+		kubeclient:        k8sfake.NewSimpleClientset(objects...),
+		clientCertOptions: testClientCertOptions,
+		revocations:       NewRevocationList(""),
+		ca:                ca,
+	}
+}
+
+func newClientCertTestSecret(t *testing.T, name string, created time.Time, options ClientCertOptions) *corev1.Secret {
+	a := newClientCertTestService()
+	a.clientCertOptions = options
+	secret, err := a.newClientCertSecret(name, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.CreationTimestamp = metav1.NewTime(created)
+	return secret
+}
+
+func newClientCertTestPod(name string, secret string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{
+					Name:         "akv2k8s-client-cert",
+					VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}},
+				},
+			},
+		},
+	}
+}
+
+func TestSecretNeedsRenewal(t *testing.T) {
+	a := newClientCertTestService()
+
+	secret := newClientCertTestSecret(t, "akv2k8s-test", time.Now(), testClientCertOptions)
+	if a.secretNeedsRenewal(secret) {
+		t.Error("expected new secret not to need renewal")
+	}
+
+	shortLived := testClientCertOptions
+	shortLived.Validity = time.Hour
+	shortLived.RenewBefore = 0
+	if !a.secretNeedsRenewal(newClientCertTestSecret(t, "akv2k8s-test", time.Now(), shortLived)) {
+		t.Error("expected secret expiring within renew before to need renewal")
+	}
+
+	otherCACert, otherCAKey := generateTestCA(t)
+	otherClientCert, err := generateClientCert("akv2k8s-test", "default", testClientCertOptions, otherCACert, otherCAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA := secret.DeepCopy()
+	otherCA.Data["tls.crt"] = otherClientCert.Crt
+	if !a.secretNeedsRenewal(otherCA) {
+		t.Error("expected secret issued by untrusted ca to need renewal")
+	}
+
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.revocations.Revoke(cert)
+	if !a.secretNeedsRenewal(secret) {
+		t.Error("expected secret with revoked certificate to need renewal")
+	}
+}
+
+func TestManageClientCerts(t *testing.T) {
+	longAgo := time.Now().Add(-2 * orphanGracePeriod)
+	shortLived := testClientCertOptions
+	shortLived.Validity = time.Hour
+	shortLived.RenewBefore = 0
+
+	inUse := newClientCertTestSecret(t, "akv2k8s-in-use", longAgo, testClientCertOptions)
+	expiring := newClientCertTestSecret(t, "akv2k8s-expiring", longAgo, shortLived)
+	orphaned := newClientCertTestSecret(t, "akv2k8s-orphaned", longAgo, testClientCertOptions)
+	recent := newClientCertTestSecret(t, "akv2k8s-recent", time.Now(), testClientCertOptions)
+	unlabeled := newClientCertTestSecret(t, "akv2k8s-unlabeled", longAgo, testClientCertOptions)
+	unlabeled.Labels = nil
+
+	a := newClientCertTestService(inUse, expiring, orphaned, recent, unlabeled,
+		newClientCertTestPod("in-use", inUse.Name),
+		newClientCertTestPod("expiring", expiring.Name))
+
+	a.manageClientCerts(context.Background())
+
+	secrets := a.kubeclient.CoreV1().Secrets("default")
+	for _, name := range []string{inUse.Name, expiring.Name, recent.Name, unlabeled.Name} {
+		if _, err := secrets.Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected secret '%s' to be kept, error: %+v", name, err)
+		}
+	}
+
+	if _, err := secrets.Get(context.Background(), orphaned.Name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected orphaned secret to be deleted, error: %+v", err)
+	}
+	orphanedCert, _ := parseCertificate(orphaned.Data["tls.crt"])
+	if _, ok := a.revocations.revoked[orphanedCert.SerialNumber.String()]; !ok {
+		t.Error("expected certificate of orphaned secret to be revoked")
+	}
+
+	renewed, err := secrets.Get(context.Background(), expiring.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(renewed.Data["tls.crt"]) == string(expiring.Data["tls.crt"]) || a.secretNeedsRenewal(renewed) {
+		t.Error("expected expiring certificate to be renewed")
+	}
+
+	kept, err := secrets.Get(context.Background(), inUse.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kept.Data["tls.crt"]) != string(inUse.Data["tls.crt"]) {
+		t.Error("expected valid certificate not to be renewed")
+	}
+}
+
+func TestManageClientCertsCARotation(t *testing.T) {
+	first := newClientCertTestSecret(t, "akv2k8s-first", time.Now(), testClientCertOptions)
+	second := newClientCertTestSecret(t, "akv2k8s-second", time.Now(), testClientCertOptions)
+
+	a := newClientCertTestService(first, second,
+		newClientCertTestPod("first", first.Name),
+		newClientCertTestPod("second", second.Name))
+	a.caRotationBatch = 1
+
+	cert, key := caCert, caKey
+	ca, err := newCertificateAuthority(rotatingCALoader(&cert, &key), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ca = ca
+
+	cert, key = generateTestCA(t)
+	if err := ca.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.secretNeedsRenewal(first) {
+		t.Error("expected secret issued by previous ca not to need renewal during rotation")
+	}
+
+	renewed := func() int {
+		count := 0
+		for _, secret := range []*corev1.Secret{first, second} {
+			current, err := a.kubeclient.CoreV1().Secrets("default").Get(context.Background(), secret.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !a.secretIssuedByPreviousCA(current) {
+				count++
+			}
+		}
+		return count
+	}
+
+	a.manageClientCerts(context.Background())
+	if count := renewed(); count != 1 {
+		t.Errorf("expected one secret re-issued by new ca, got %d", count)
+	}
+
+	a.manageClientCerts(context.Background())
+	if count := renewed(); count != 2 {
+		t.Errorf("expected all secrets re-issued by new ca, got %d", count)
+	}
+}
@@ -1,9 +1,10 @@
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"testing"
-
-	"k8s.io/apimachinery/pkg/types"
+	"time"
 )
 
 var caCert = []byte(`-----BEGIN CERTIFICATE-----
@@ -56,11 +57,17 @@ IrepX5pgiugqVP5K+xYTIiQ28X3xstrq4sZHYXuO2yAVaILlVBp5/df/riRbymbQ
 7vphGG+/JeJJ6sb2yw48kYfQBroTO49aaf+A3LjykrkL+qAecX331w==
 -----END RSA PRIVATE KEY-----`)
 
+var testClientCertOptions = ClientCertOptions{
+	KeyType:     ClientCertKeyTypeRSA,
+	KeySize:     2048,
+	Validity:    24 * time.Hour,
+	RenewBefore: 8 * time.Hour,
+}
+
 func TestCreateClientCert(t *testing.T) {
-	uid := types.UID("asdlkfjalsdjflj")
-	clientCert, err := generateClientCert(uid, 24, caCert, caKey)
+	clientCert, err := generateClientCert("akv2k8s-test", "default", testClientCertOptions, caCert, caKey)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	if clientCert == nil {
 		t.Fail()
@@ -70,7 +77,69 @@ func TestCreateClientCert(t *testing.T) {
 		t.Fail()
 	}
 
+	cert, err := parseCertificate(clientCert.Crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "akv2k8s-test" || len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "default" {
+		t.Errorf("unexpected subject '%s'", cert.Subject.String())
+	}
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); !ok || key.N.BitLen() != 2048 {
+		t.Errorf("expected rsa 2048 key, got %T", cert.PublicKey)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != testClientCertOptions.Validity {
+		t.Errorf("expected validity %s, got %s", testClientCertOptions.Validity, validity)
+	}
+	if testClientCertOptions.needsRenewal(cert) {
+		t.Error("expected new certificate not to need renewal")
+	}
+
 	t.Logf("ca: \n%s", clientCert.CA)
 	t.Logf("cert: \n%s", clientCert.Crt)
 	t.Logf("key: \n%s", clientCert.Key)
 }
+
+func TestCreateClientCertECDSA(t *testing.T) {
+	options := testClientCertOptions
+	options.KeyType = ClientCertKeyTypeECDSA
+	options.KeySize = 384
+
+	clientCert, err := generateClientCert("akv2k8s-test", "default", options, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCertificate(clientCert.Crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || key.Curve.Params().BitSize != 384 {
+		t.Errorf("expected ecdsa P-384 key, got %T", cert.PublicKey)
+	}
+
+	if !testClientCertOptions.needsRenewal(cert) {
+		t.Error("expected certificate with other key type to need renewal")
+	}
+	options.RenewBefore = options.Validity + time.Minute
+	if !options.needsRenewal(cert) {
+		t.Error("expected certificate expiring within renew before to need renewal")
+	}
+}
+
+func TestClientCertOptionsValidate(t *testing.T) {
+	invalid := []ClientCertOptions{
+		{KeyType: ClientCertKeyTypeRSA, KeySize: 1024, Validity: time.Hour},
+		{KeyType: ClientCertKeyTypeECDSA, KeySize: 2048, Validity: time.Hour},
+		{KeyType: "dsa", KeySize: 2048, Validity: time.Hour},
+		{KeyType: ClientCertKeyTypeRSA, KeySize: 2048},
+		{KeyType: ClientCertKeyTypeRSA, KeySize: 2048, Validity: time.Hour, RenewBefore: time.Hour},
+	}
+	for _, options := range invalid {
+		if err := options.Validate(); err == nil {
+			t.Errorf("expected options %+v to be invalid", options)
+		}
+	}
+
+	if err := testClientCertOptions.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// Service account annotations binding an Azure identity to the pods running as the service account
+const (
+	// ServiceAccountClientIDAnnotation is the client id of the Azure AD application or user assigned identity
+	// having a federated identity credential for the service account (same as Azure AD Workload Identity)
+	ServiceAccountClientIDAnnotation = "azure.workload.identity/client-id"
+
+	// ServiceAccountTenantIDAnnotation overrides the default tenant of the federated identity
+	ServiceAccountTenantIDAnnotation = "azure.workload.identity/tenant-id"
+
+	// ServiceAccountManagedIdentityAnnotation is the client id or resource id of a user assigned managed identity.
+	// The managed identity must also be bound to the namespace of the service account by the cluster admin.
+	ServiceAccountManagedIdentityAnnotation = "akv2k8s.io/managed-identity"
+)
+
+const (
+	federatedTokenAudience          = "api://AzureADTokenExchange"
+	federatedTokenExpirationSeconds = int64(3600)
+)
+
+// PodIdentityProvider provides Azure Key Vault credentials for the identity bound to the service account of a pod
+type PodIdentityProvider struct {
+	kubeclient        kubernetes.Interface
+	managedIdentities *credentialprovider.UserAssignedManagedIdentityProvider
+	managedBindings   identity.Bindings
+	nodeName          string
+	tenantID          string
+	cloudName         string
+}
+
+// NewPodIdentityProvider creates a provider of pod specific credentials. Federated identities use tenantID and
+// cloudName unless overridden on the service account. Managed identities are only supported if managedIdentities
+// is set, are acquired on nodeName, the node the auth service is running on, and are only issued to the namespaces
+// they are bound to in managedBindings, as service accounts can be annotated by anyone editing their namespace.
+func NewPodIdentityProvider(kubeclient kubernetes.Interface, managedIdentities *credentialprovider.UserAssignedManagedIdentityProvider, managedBindings identity.Bindings, nodeName, tenantID, cloudName string) *PodIdentityProvider {
+	return &PodIdentityProvider{
+		kubeclient:        kubeclient,
+		managedIdentities: managedIdentities,
+		managedBindings:   managedBindings,
+		nodeName:          nodeName,
+		tenantID:          tenantID,
+		cloudName:         cloudName,
+	}
+}
+
+// GetAzureKeyVaultCredentials gets credentials for the Azure identity bound to the service account of pod.
+// It never falls back to the credentials of the auth service itself.
+func (p PodIdentityProvider) GetAzureKeyVaultCredentials(ctx context.Context, pod *corev1.Pod) (credentialprovider.AzureKeyVaultCredentials, error) {
+	credentials, _, err := p.getAzureKeyVaultCredentials(ctx, pod)
+	return credentials, err
+}
+
+// getAzureKeyVaultCredentials gets credentials for the Azure identity bound to the service account of pod,
+// together with a name uniquely identifying the Azure identity
+func (p PodIdentityProvider) getAzureKeyVaultCredentials(ctx context.Context, pod *corev1.Pod) (credentialprovider.AzureKeyVaultCredentials, string, error) {
+	serviceAccount, err := p.getServiceAccount(ctx, pod)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if serviceAccount.Annotations[ServiceAccountClientIDAnnotation] != "" {
+		sp, err := p.federatedServicePrincipal(ctx, pod, serviceAccount)
+		if err != nil {
+			return nil, "", err
+		}
+
+		klog.InfoS("using federated identity of service account", "serviceAccount", klog.KObj(serviceAccount), "clientId", sp.ClientID, "pod", klog.KObj(pod))
+		credentials, err := sp.GetAzureKeyVaultCredentials()
+		return credentials, fmt.Sprintf("federated/%s/%s", sp.TenantID, sp.ClientID), err
+	}
+
+	if managedIdentity := serviceAccount.Annotations[ServiceAccountManagedIdentityAnnotation]; managedIdentity != "" {
+		if err := p.managedBindings.Authorize(serviceAccount.Namespace, managedIdentity); err != nil {
+			return nil, "", fmt.Errorf("managed identity of service account '%s/%s' refused, error: %+v", serviceAccount.Namespace, serviceAccount.Name, err)
+		}
+		if p.managedIdentities == nil {
+			return nil, "", fmt.Errorf("service account '%s/%s' has managed identity '%s', but managed identities are not enabled for the auth service", serviceAccount.Namespace, serviceAccount.Name, managedIdentity)
+		}
+
+		klog.InfoS("using managed identity of service account", "serviceAccount", klog.KObj(serviceAccount), "identity", managedIdentity, "pod", klog.KObj(pod))
+		credentials, err := p.managedIdentities.GetAzureKeyVaultCredentials(managedIdentity, p.nodeName)
+		return credentials, fmt.Sprintf("managed/%s", managedIdentity), err
+	}
+
+	return nil, "", fmt.Errorf("no azure identity bound to service account '%s/%s', expected annotation '%s' or '%s'", serviceAccount.Namespace, serviceAccount.Name, ServiceAccountClientIDAnnotation, ServiceAccountManagedIdentityAnnotation)
+}
+
+func (p PodIdentityProvider) getServiceAccount(ctx context.Context, pod *corev1.Pod) (*corev1.ServiceAccount, error) {
+	name := pod.Spec.ServiceAccountName
+	if name == "" {
+		name = "default"
+	}
+
+	serviceAccount, err := p.kubeclient.CoreV1().ServiceAccounts(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service account '%s' of pod '%s' in namespace '%s', error: %+v", name, pod.Name, pod.Namespace, err)
+	}
+	return serviceAccount, nil
+}
+
+// federatedServicePrincipal requests a token for the service account, bound to the pod, to exchange for an Azure AD token
+func (p PodIdentityProvider) federatedServicePrincipal(ctx context.Context, pod *corev1.Pod, serviceAccount *corev1.ServiceAccount) (*credentialprovider.ServicePrincipal, error) {
+	tenantID := serviceAccount.Annotations[ServiceAccountTenantIDAnnotation]
+	if tenantID == "" {
+		tenantID = p.tenantID
+	}
+	if tenantID == "" {
+		return nil, fmt.Errorf("no tenant id for service account '%s/%s', expected annotation '%s'", serviceAccount.Namespace, serviceAccount.Name, ServiceAccountTenantIDAnnotation)
+	}
+
+	expirationSeconds := federatedTokenExpirationSeconds
+	tokenRequest, err := p.kubeclient.CoreV1().ServiceAccounts(serviceAccount.Namespace).CreateToken(ctx, serviceAccount.Name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{federatedTokenAudience},
+			ExpirationSeconds: &expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				Kind:       "Pod",
+				APIVersion: "v1",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token for service account '%s/%s', error: %+v", serviceAccount.Namespace, serviceAccount.Name, err)
+	}
+
+	return &credentialprovider.ServicePrincipal{
+		Cloud:          p.cloudName,
+		TenantID:       tenantID,
+		ClientID:       serviceAccount.Annotations[ServiceAccountClientIDAnnotation],
+		FederatedToken: tokenRequest.Status.Token,
+	}, nil
+}
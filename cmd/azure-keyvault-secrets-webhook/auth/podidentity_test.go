@@ -0,0 +1,167 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
+	"github.com/gorilla/mux"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newPodIdentityTestClient(objects ...runtime.Object) (*k8sfake.Clientset, *[]*authenticationv1.TokenRequest) {
+	client := k8sfake.NewSimpleClientset(objects...)
+	requests := []*authenticationv1.TokenRequest{}
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		request := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		requests = append(requests, request)
+
+		response := request.DeepCopy()
+		response.Status.Token = "federated"
+		return true, response, nil
+	})
+	return client, &requests
+}
+
+func newPodIdentityTestPod(serviceAccount string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-pod", Namespace: "team1", UID: "uid"},
+		Spec:       corev1.PodSpec{ServiceAccountName: serviceAccount},
+	}
+}
+
+func newPodIdentityTestServiceAccount(name string, annotations map[string]string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team1", Annotations: annotations},
+	}
+}
+
+func TestPodIdentityFederatedServicePrincipal(t *testing.T) {
+	sa := newPodIdentityTestServiceAccount("app", map[string]string{ServiceAccountClientIDAnnotation: "client"})
+	client, requests := newPodIdentityTestClient(sa)
+	provider := NewPodIdentityProvider(client, nil, nil, "", "tenant", "")
+	pod := newPodIdentityTestPod("app")
+
+	sp, err := provider.federatedServicePrincipal(context.Background(), pod, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.ClientID != "client" || sp.TenantID != "tenant" || sp.FederatedToken != "federated" {
+		t.Errorf("unexpected service principal %+v", sp)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("expected one token request, got %d", len(*requests))
+	}
+	spec := (*requests)[0].Spec
+	if len(spec.Audiences) != 1 || spec.Audiences[0] != federatedTokenAudience {
+		t.Errorf("unexpected token audiences %v", spec.Audiences)
+	}
+	if spec.BoundObjectRef == nil || spec.BoundObjectRef.Kind != "Pod" || spec.BoundObjectRef.Name != "my-pod" || spec.BoundObjectRef.UID != "uid" {
+		t.Errorf("expected token bound to pod, got %+v", spec.BoundObjectRef)
+	}
+
+	sa.Annotations[ServiceAccountTenantIDAnnotation] = "other-tenant"
+	sp, err = provider.federatedServicePrincipal(context.Background(), pod, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.TenantID != "other-tenant" {
+		t.Errorf("expected tenant from service account, got '%s'", sp.TenantID)
+	}
+}
+
+func TestPodIdentityCredentials(t *testing.T) {
+	client, _ := newPodIdentityTestClient(
+		newPodIdentityTestServiceAccount("default", map[string]string{ServiceAccountClientIDAnnotation: "client"}),
+		newPodIdentityTestServiceAccount("managed", map[string]string{ServiceAccountManagedIdentityAnnotation: "identity"}),
+		newPodIdentityTestServiceAccount("none", nil),
+	)
+	provider := NewPodIdentityProvider(client, nil, nil, "", "tenant", "")
+
+	creds, err := provider.GetAzureKeyVaultCredentials(context.Background(), newPodIdentityTestPod(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := creds.Endpoint("my-vault"); endpoint != "https://my-vault.vault.azure.net" {
+		t.Errorf("unexpected endpoint '%s'", endpoint)
+	}
+
+	for _, serviceAccount := range []string{"managed", "none", "missing"} {
+		if _, err := provider.GetAzureKeyVaultCredentials(context.Background(), newPodIdentityTestPod(serviceAccount)); err == nil {
+			t.Errorf("expected error for service account '%s'", serviceAccount)
+		}
+	}
+}
+
+func TestAuthHandlerWithoutPodIdentity(t *testing.T) {
+	namespace := createNewNamespace("team1", true)
+	pod := newPodIdentityTestPod("none")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "copy-azurekeyvault-env"}}
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Command: []string{"/azure-keyvault/azure-keyvault-env"}}}
+	client, _ := newPodIdentityTestClient(namespace, pod, newPodIdentityTestServiceAccount("none", nil))
+	addTokenReviewReactor(client, "token", pod, ServiceAccountTokenAudience)
+
+	authService := AuthService{
+		kubeclient:  client,
+		podIdentity: NewPodIdentityProvider(client, nil, nil, "", "tenant", ""),
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/{namespace}/{pod}", authService.AuthHandler)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/auth/team1/my-pod", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected pod without identity to be forbidden, got status %d", recorder.Code)
+	}
+}
+
+func TestPodIdentityManagedIdentityBindings(t *testing.T) {
+	client, _ := newPodIdentityTestClient(
+		newPodIdentityTestServiceAccount("bound", map[string]string{ServiceAccountManagedIdentityAnnotation: "team1-identity"}),
+		newPodIdentityTestServiceAccount("unbound", map[string]string{ServiceAccountManagedIdentityAnnotation: "team2-identity"}),
+	)
+	bindings := identity.Bindings{"team1": {"team1-identity": true}, "team2": {"team2-identity": true}}
+	provider := NewPodIdentityProvider(client, nil, bindings, "", "tenant", "")
+
+	// managed identities are not enabled, so even bound identities fail, but only after the binding is checked
+	_, _, err := provider.getAzureKeyVaultCredentials(context.Background(), newPodIdentityTestPod("bound"))
+	if err == nil || strings.Contains(err.Error(), "not bound") {
+		t.Errorf("expected bound managed identity to be allowed, got %v", err)
+	}
+
+	_, _, err = provider.getAzureKeyVaultCredentials(context.Background(), newPodIdentityTestPod("unbound"))
+	if err == nil || !strings.Contains(err.Error(), "not bound") {
+		t.Errorf("expected managed identity of another namespace to be refused, got %v", err)
+	}
+}
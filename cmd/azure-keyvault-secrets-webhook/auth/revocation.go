@@ -0,0 +1,118 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RevocationList holds revoked client certificates. Certificates are revoked by the auth service when their
+// secret is garbage collected, or listed in a certificate revocation list (CRL) file signed by the auth service ca.
+// Certificates revoked by the auth service are only known to this instance, and are kept until they expire.
+type RevocationList struct {
+	crlFile    string
+	mutex      sync.Mutex
+	revoked    map[string]time.Time
+	crlModTime time.Time
+	crlRevoked map[string]struct{}
+}
+
+// NewRevocationList creates a revocation list, also checking the CRL in crlFile if set
+func NewRevocationList(crlFile string) *RevocationList {
+	return &RevocationList{
+		crlFile:    crlFile,
+		revoked:    make(map[string]time.Time),
+		crlRevoked: make(map[string]struct{}),
+	}
+}
+
+// Revoke revokes cert until it expires
+func (l *RevocationList) Revoke(cert *x509.Certificate) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.revoked[cert.SerialNumber.String()] = cert.NotAfter
+}
+
+// IsRevoked returns true if cert is revoked. The CRL file is reloaded when changed,
+// and must be signed by ca.
+func (l *RevocationList) IsRevoked(cert *x509.Certificate, ca *x509.Certificate) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for serial, expires := range l.revoked {
+		if now.After(expires) {
+			delete(l.revoked, serial)
+		}
+	}
+
+	if err := l.reloadCRL(ca); err != nil {
+		klog.ErrorS(err, "failed to load client certificate revocation list, using previous", "file", l.crlFile)
+	}
+
+	serial := cert.SerialNumber.String()
+	if _, ok := l.revoked[serial]; ok {
+		return true
+	}
+	_, ok := l.crlRevoked[serial]
+	return ok
+}
+
+func (l *RevocationList) reloadCRL(ca *x509.Certificate) error {
+	if l.crlFile == "" {
+		return nil
+	}
+
+	info, err := os.Stat(l.crlFile)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(l.crlModTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(l.crlFile)
+	if err != nil {
+		return err
+	}
+
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse crl, error: %+v", err)
+	}
+
+	if err := ca.CheckCRLSignature(crl); err != nil {
+		return fmt.Errorf("crl not signed by auth service ca, error: %+v", err)
+	}
+
+	revoked := make(map[string]struct{}, len(crl.TBSCertList.RevokedCertificates))
+	for _, cert := range crl.TBSCertList.RevokedCertificates {
+		revoked[cert.SerialNumber.String()] = struct{}{}
+	}
+
+	klog.InfoS("loaded client certificate revocation list", "file", l.crlFile, "revoked", len(revoked))
+	l.crlRevoked = revoked
+	l.crlModTime = info.ModTime()
+	return nil
+}
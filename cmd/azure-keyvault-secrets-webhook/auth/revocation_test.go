@@ -0,0 +1,132 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestClientCertificate(t *testing.T) *x509.Certificate {
+	clientCert, err := generateClientCert("akv2k8s-test", "default", testClientCertOptions, caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCertificate(clientCert.Crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeTestCRL(t *testing.T, file string, revoked ...*x509.Certificate) {
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x509Ca, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedCerts := []pkix.RevokedCertificate{}
+	for _, cert := range revoked {
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()})
+	}
+
+	crl, err := x509Ca.CreateCRL(rand.Reader, ca.PrivateKey, revokedCerts, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationList(t *testing.T) {
+	ca, err := parseCertificate(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := newTestClientCertificate(t)
+	other := newTestClientCertificate(t)
+
+	revocations := NewRevocationList("")
+	if revocations.IsRevoked(cert, ca) {
+		t.Error("expected certificate not to be revoked")
+	}
+
+	revocations.Revoke(cert)
+	if !revocations.IsRevoked(cert, ca) {
+		t.Error("expected certificate to be revoked")
+	}
+	if revocations.IsRevoked(other, ca) {
+		t.Error("expected other certificate not to be revoked")
+	}
+
+	expired := newTestClientCertificate(t)
+	expired.NotAfter = time.Now().Add(-time.Minute)
+	revocations.Revoke(expired)
+	revocations.IsRevoked(cert, ca)
+	if _, ok := revocations.revoked[expired.SerialNumber.String()]; ok {
+		t.Error("expected expired certificate to be removed from revocation list")
+	}
+}
+
+func TestRevocationListCRLFile(t *testing.T) {
+	ca, err := parseCertificate(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "crl.pem")
+	cert := newTestClientCertificate(t)
+	other := newTestClientCertificate(t)
+	writeTestCRL(t, file, cert)
+
+	revocations := NewRevocationList(file)
+	if !revocations.IsRevoked(cert, ca) {
+		t.Error("expected certificate in crl to be revoked")
+	}
+	if revocations.IsRevoked(other, ca) {
+		t.Error("expected certificate not in crl not to be revoked")
+	}
+
+	// A crl not signed by the ca is ignored, keeping the previous crl
+	if err := ioutil.WriteFile(file, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Minute))
+	if !revocations.IsRevoked(cert, ca) {
+		t.Error("expected previous crl to be used when crl file is invalid")
+	}
+}
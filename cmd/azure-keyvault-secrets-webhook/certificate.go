@@ -0,0 +1,147 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+var (
+	servingCertExpiryGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "akv2k8s_webhook_tls_cert_expiry_timestamp_seconds",
+		Help: "The expiry time of the webhook serving certificate in unix seconds",
+	})
+
+	servingCertReloadsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "akv2k8s_webhook_tls_cert_reloads_total",
+		Help: "The total number of times a changed webhook serving certificate was loaded",
+	})
+)
+
+// servingCertificate is the serving certificate of the webhook, reloaded from certFile and keyFile
+// when changed (e.g. renewed by cert-manager) without restarting the webhook
+type servingCertificate struct {
+	certFile   string
+	keyFile    string
+	warnBefore time.Duration
+
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	certPEM  []byte
+	keyPEM   []byte
+}
+
+// newServingCertificate loads the serving certificate from certFile and keyFile, warning when the
+// certificate expires within warnBefore
+func newServingCertificate(certFile, keyFile string, warnBefore time.Duration) (*servingCertificate, error) {
+	s := &servingCertificate{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		warnBefore: warnBefore,
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload loads the certificate if the files have changed, keeping the current certificate on errors
+func (s *servingCertificate) reload() error {
+	certPEM, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read serving cert %s, error: %+v", s.certFile, err)
+	}
+	keyPEM, err := ioutil.ReadFile(s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read serving key %s, error: %+v", s.keyFile, err)
+	}
+
+	s.mutex.RLock()
+	unchanged := bytes.Equal(certPEM, s.certPEM) && bytes.Equal(keyPEM, s.keyPEM)
+	s.mutex.RUnlock()
+	if unchanged {
+		s.checkExpiry()
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to load serving cert key pair, error: %+v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse serving cert, error: %+v", err)
+	}
+	cert.Leaf = leaf
+
+	s.mutex.Lock()
+	reloaded := s.cert != nil
+	s.cert = &cert
+	s.notAfter = leaf.NotAfter
+	s.certPEM = certPEM
+	s.keyPEM = keyPEM
+	s.mutex.Unlock()
+
+	servingCertExpiryGauge.Set(float64(leaf.NotAfter.Unix()))
+	if reloaded {
+		servingCertReloadsCounter.Inc()
+	}
+	klog.InfoS("loaded webhook serving certificate", "file", s.certFile, "subject", leaf.Subject.String(), "notAfter", leaf.NotAfter)
+
+	s.checkExpiry()
+	return nil
+}
+
+func (s *servingCertificate) checkExpiry() {
+	s.mutex.RLock()
+	notAfter := s.notAfter
+	s.mutex.RUnlock()
+
+	if remaining := time.Until(notAfter); remaining < s.warnBefore {
+		klog.Warningf("webhook serving certificate %s expires in %s (at %s) - make sure it gets renewed", s.certFile, remaining.Round(time.Second), notAfter)
+	}
+}
+
+// watch reloads the certificate every interval until ctx is done
+func (s *servingCertificate) watch(ctx context.Context, interval time.Duration) {
+	klog.InfoS("watching webhook serving certificate for changes", "file", s.certFile, "interval", interval)
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.reload(); err != nil {
+			klog.ErrorS(err, "failed to reload webhook serving certificate, using current")
+		}
+	}, interval)
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (s *servingCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.cert, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeTestServingCert(t *testing.T, dir string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "azure-key-vault-secrets-webhook"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServingCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	if _, err := newServingCertificate(certFile, keyFile, time.Hour); err == nil {
+		t.Error("expected error without serving certificate files")
+	}
+
+	firstExpiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	writeTestServingCert(t, dir, firstExpiry)
+	servingCert, err := newServingCertificate(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := servingCert.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Leaf.NotAfter.Equal(firstExpiry) {
+		t.Errorf("expected certificate expiring at %s, got %s", firstExpiry, first.Leaf.NotAfter)
+	}
+	if expiry := testutil.ToFloat64(servingCertExpiryGauge); expiry != float64(firstExpiry.Unix()) {
+		t.Errorf("expected expiry metric %d, got %f", firstExpiry.Unix(), expiry)
+	}
+
+	// Renewed certificate
+	secondExpiry := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	writeTestServingCert(t, dir, secondExpiry)
+	if err := servingCert.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := servingCert.GetCertificate(nil)
+	if !second.Leaf.NotAfter.Equal(secondExpiry) {
+		t.Errorf("expected renewed certificate expiring at %s, got %s", secondExpiry, second.Leaf.NotAfter)
+	}
+	if expiry := testutil.ToFloat64(servingCertExpiryGauge); expiry != float64(secondExpiry.Unix()) {
+		t.Errorf("expected expiry metric %d, got %f", secondExpiry.Unix(), expiry)
+	}
+
+	// Key not yet updated, e.g. while files are being written
+	if err := ioutil.WriteFile(keyFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := servingCert.reload(); err == nil {
+		t.Error("expected error reloading invalid key pair")
+	}
+	if current, _ := servingCert.GetCertificate(nil); current != second {
+		t.Error("expected current certificate to be kept when reload fails")
+	}
+}
@@ -30,8 +30,12 @@ import (
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/cmd/azure-keyvault-secrets-webhook/auth"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/conversion"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/identity"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/docker/registry"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	akvcs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -41,6 +45,7 @@ import (
 	"github.com/slok/kubewebhook/pkg/observability/metrics"
 	whcontext "github.com/slok/kubewebhook/pkg/webhook/context"
 	"github.com/slok/kubewebhook/pkg/webhook/mutating"
+	"github.com/slok/kubewebhook/pkg/webhook/validating"
 	"github.com/spf13/viper"
 	k8sCredentialProvider "github.com/vdemeester/k8s-pkg-credentialprovider"
 	jsonlogs "k8s.io/component-base/logs/json"
@@ -59,6 +64,7 @@ const (
 	oldDockerHubHost        = "docker.io"
 	injectorExecutable      = "azure-keyvault-env"
 	clientCertDir           = "/var/client-cert/"
+	authTokenDir            = "/var/run/secrets/akv2k8s/"
 	initContainerVolumeName = "azure-keyvault-env"
 )
 
@@ -78,10 +84,12 @@ type azureKeyVaultConfig struct {
 	// caKey                        []byte
 	authType                     string
 	useAuthService               bool
+	usePodIdentity               bool
 	authService                  *auth.AuthService
 	dockerImageInspectionTimeout int
 	authServiceName              string
 	kubeClient                   kubernetes.Interface
+	akvsClient                   akvcs.Interface
 	versionEnvImage              string
 	kubeconfig                   string
 	masterURL                    string
@@ -150,8 +158,8 @@ func vaultSecretsMutator(ctx context.Context, obj metav1.Object) (bool, error) {
 
 	wh := podWebHook{
 		clientset:                 config.kubeClient,
+		akvsClient:                config.akvsClient,
 		namespace:                 req.Namespace,
-		mutationID:                req.UID,
 		injectorDir:               config.injectorDir,
 		useAuthService:            config.useAuthService,
 		authServiceName:           config.authServiceName,
@@ -207,6 +215,23 @@ func initConfig() {
 	viper.SetDefault("docker_image_inspection_use_acs_credentials", true)
 	viper.SetDefault("auth_type", "cloudConfig")
 	viper.SetDefault("use_auth_service", true)
+	viper.SetDefault("auth_service_pod_identity", false)
+	viper.SetDefault("managed_identity_bindings", "")
+	viper.SetDefault("auth_service_legacy_credentials", false)
+	viper.SetDefault("auth_service_client_cert_key_type", "rsa")
+	viper.SetDefault("auth_service_client_cert_key_size", 2048)
+	viper.SetDefault("auth_service_client_cert_validity", "24h")
+	viper.SetDefault("auth_service_client_cert_renew_before", "8h")
+	viper.SetDefault("auth_service_client_cert_manager_interval", "1h")
+	viper.SetDefault("auth_service_ca_reload_interval", "1m")
+	viper.SetDefault("auth_service_ca_rotation_window", "24h")
+	viper.SetDefault("auth_service_ca_rotation_batch_size", 50)
+	viper.SetDefault("vault_cache_enabled", true)
+	viper.SetDefault("vault_cache_ttl", "30s")
+	viper.SetDefault("vault_cache_versioned_ttl", "1h")
+	viper.SetDefault("vault_cache_negative_ttl", "30s")
+	viper.SetDefault("tls_cert_reload_interval", "1m")
+	viper.SetDefault("tls_cert_expiry_warning", "168h")
 	viper.SetDefault("metrics_enabled", false)
 	viper.SetDefault("env_injector_exec_dir", "/azure-keyvault/")
 	viper.AutomaticEnv()
@@ -248,6 +273,7 @@ func main() {
 		tlsCertFile:                  fmt.Sprintf("%s/%s", viper.GetString("tls_cert_dir"), "tls.crt"),
 		tlsKeyFile:                   fmt.Sprintf("%s/%s", viper.GetString("tls_cert_dir"), "tls.key"),
 		useAuthService:               viper.GetBool("use_auth_service"),
+		usePodIdentity:               viper.GetBool("auth_service_pod_identity"),
 		authServiceName:              viper.GetString("webhook_auth_service"),
 		dockerImageInspectionTimeout: viper.GetInt("docker_image_inspection_timeout"),
 		injectorDir:                  viper.GetString("env_injector_exec_dir"),
@@ -282,6 +308,7 @@ func main() {
 	if config.useAuthService {
 		activeSettings = append(activeSettings,
 			"authServiceName", config.authServiceName,
+			"authServicePodIdentity", config.usePodIdentity,
 			"mtlsPortExternal", config.mtlsPortExternal,
 			"mtlsPort", config.mtlsPort)
 	}
@@ -294,6 +321,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	config.akvsClient, err = newAzureKeyVaultSecretClient()
+	if err != nil {
+		klog.ErrorS(err, "failed to build azurekeyvaultsecret clientset")
+		os.Exit(1)
+	}
+
 	if config.useAuthService {
 		config.credentials, config.credentialProvider, err = getCredentials()
 		if err != nil {
@@ -310,30 +343,74 @@ func main() {
 		dockerCred := credentialprovider.NewAcrDockerProvider(config.credentialProvider)
 		k8sCredentialProvider.RegisterCredentialProvider("akv2k8s", dockerCred)
 
-		authService, err := auth.NewAuthService(config.kubeClient, config.credentials)
+		var podIdentity *auth.PodIdentityProvider
+		if config.usePodIdentity {
+			podIdentity, err = newPodIdentityProvider()
+			if err != nil {
+				klog.ErrorS(err, "failed to create pod identity provider for auth service")
+				os.Exit(1)
+			}
+		}
+
+		authService, err := auth.NewAuthService(config.kubeClient, config.akvsClient, config.credentials, podIdentity)
 		if err != nil {
 			klog.ErrorS(err, "failed to create auth service")
 			os.Exit(1)
 		}
 
 		config.authService = authService
+		authService.StartCAWatcher(context.Background(), viper.GetDuration("auth_service_ca_reload_interval"))
+		authService.StartClientCertManager(context.Background(), viper.GetDuration("auth_service_client_cert_manager_interval"))
 	} else {
 		klog.InfoS("auth service disabled - azure key vault credentials must be provided manually for each pod", "useAuthService", false)
 	}
 
+	servingCert, err := newServingCertificate(config.tlsCertFile, config.tlsKeyFile, viper.GetDuration("tls_cert_expiry_warning"))
+	if err != nil {
+		klog.ErrorS(err, "failed to load webhook serving certificate", "dir", viper.GetString("tls_cert_dir"))
+		os.Exit(1)
+	}
+	servingCert.watch(context.Background(), viper.GetDuration("tls_cert_reload_interval"))
+
 	wg := new(sync.WaitGroup)
 	wg.Add(2)
 
 	config.registry = registry.NewRegistry(config.cloudConfig)
 
 	createHTTPEndpoint(wg, config.httpPort, config.useAuthService, config.authService)
-	createMTLSEndpoint(wg, config.mtlsPort, config.useAuthService, config.authService)
-	createTLSEndpoint(wg, config.tlsPort, config.tlsCertFile, config.tlsKeyFile)
+	createMTLSEndpoint(wg, config.mtlsPort, config.useAuthService, config.authService, servingCert)
+	createTLSEndpoint(wg, config.tlsPort, servingCert)
 
 	wg.Wait()
 
 }
 
+// newPodIdentityProvider creates a provider for credentials of the identity bound to the service account of each pod.
+// Managed identities bound to service accounts are only supported when running on a node named by NODE_NAME.
+func newPodIdentityProvider() (*auth.PodIdentityProvider, error) {
+	var managedIdentities *credentialprovider.UserAssignedManagedIdentityProvider
+	nodeName := viper.GetString("node_name")
+	if nodeName != "" {
+		var err error
+		managedIdentities, err = credentialprovider.NewUserAssignedManagedIdentityProvider(config.cloudConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create managed identity provider, error: %+v", err)
+		}
+	} else {
+		klog.InfoS("env var NODE_NAME not set - managed identities bound to service accounts are not supported")
+	}
+
+	managedBindings, err := identity.ParseBindings(viper.GetString("managed_identity_bindings"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid managed identity bindings in env var MANAGED_IDENTITY_BINDINGS, error: %+v", err)
+	}
+	if managedIdentities != nil {
+		klog.InfoS("managed identities enabled for service accounts", "namespaces", managedBindings.Namespaces())
+	}
+
+	return auth.NewPodIdentityProvider(config.kubeClient, managedIdentities, managedBindings, nodeName, viper.GetString("azure_tenant_id"), viper.GetString("azure_environment")), nil
+}
+
 func newKubeClient() (kubernetes.Interface, error) {
 	cfg, err := kubernetesConfig.GetConfig() //clientcmd.BuildConfigFromFlags(params.masterURL, params.kubeconfig)
 	if err != nil {
@@ -344,7 +421,27 @@ func newKubeClient() (kubernetes.Interface, error) {
 	return kubernetes.NewForConfig(cfg)
 }
 
+func newAzureKeyVaultSecretClient() (akvcs.Interface, error) {
+	cfg, err := kubernetesConfig.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return akvcs.NewForConfig(cfg)
+}
+
 func getCredentials() (credentialprovider.Credentials, credentialprovider.CredentialProvider, error) {
+	if config.authType == "workloadIdentity" {
+		klog.V(4).InfoS("using workload identity for auth - exchanging federated token for azure key vault credentials")
+		cProvider, err := credentialprovider.NewFromWorkloadIdentity()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		credentials, err := cProvider.GetAzureKeyVaultCredentials()
+		return credentials, cProvider, err
+	}
+
 	if config.authType != "azureCloudConfig" {
 		klog.V(4).InfoS("not using cloudConfig for auth - looking for azure key vault credentials in envrionment")
 		cProvider, err := credentialprovider.NewFromEnvironment()
@@ -406,11 +503,12 @@ func createHTTPEndpoint(wg *sync.WaitGroup, port string, useAuthService bool, au
 
 }
 
-func createTLSEndpoint(wg *sync.WaitGroup, port, tlsCertFile, tlsKeyFile string) {
+func createTLSEndpoint(wg *sync.WaitGroup, port string, servingCert *servingCertificate) {
 	mutator := mutating.MutatorFunc(vaultSecretsMutator)
 	metricsRecorder := metrics.NewPrometheus(prometheus.DefaultRegisterer)
 	internalLogger := &internalLog.Std{Debug: config.klogLevel >= 4}
 	podHandler := handlerFor(mutating.WebhookConfig{Name: "azurekeyvault-secrets-pods", Obj: &corev1.Pod{}}, mutator, metricsRecorder, internalLogger)
+	akvsHandler := validatingHandlerFor(validating.WebhookConfig{Name: "azurekeyvaultsecrets-validation", Obj: &akv.AzureKeyVaultSecret{}}, validating.ValidatorFunc(azureKeyVaultSecretValidator), metricsRecorder, internalLogger)
 
 	router := mux.NewRouter()
 	tlsURL := fmt.Sprintf(":%s", port)
@@ -418,12 +516,20 @@ func createTLSEndpoint(wg *sync.WaitGroup, port, tlsCertFile, tlsKeyFile string)
 	router.Handle("/pods", podHandler)
 	klog.InfoS("serving encrypted webhook endpoint", "path", fmt.Sprintf("%s/pods", tlsURL))
 
+	router.Handle("/azurekeyvaultsecrets", akvsHandler)
+	klog.InfoS("serving encrypted webhook endpoint", "path", fmt.Sprintf("%s/azurekeyvaultsecrets", tlsURL))
+
+	router.Handle("/convert", conversion.NewWebhook())
+	klog.InfoS("serving encrypted conversion webhook endpoint", "path", fmt.Sprintf("%s/convert", tlsURL))
+
 	router.HandleFunc("/healthz", healthHandler)
 	klog.InfoS("serving encrypted healthz endpoint", "path", fmt.Sprintf("%s/healthz", tlsURL))
 
 	go func() {
-		server := createServer(router, tlsURL, nil)
-		err := server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		server := createServer(router, tlsURL, &tls.Config{
+			GetCertificate: servingCert.GetCertificate,
+		})
+		err := server.ListenAndServeTLS("", "")
 		if err != nil {
 			klog.ErrorS(err, "error serving endpoint", "port", tlsURL)
 			os.Exit(1)
@@ -432,18 +538,21 @@ func createTLSEndpoint(wg *sync.WaitGroup, port, tlsCertFile, tlsKeyFile string)
 	}()
 }
 
-func createMTLSEndpoint(wg *sync.WaitGroup, port string, useAuthService bool, authService *auth.AuthService) {
+func createMTLSEndpoint(wg *sync.WaitGroup, port string, useAuthService bool, authService *auth.AuthService, servingCert *servingCertificate) {
 	if useAuthService {
 		wg.Add(1)
 		authURL := fmt.Sprintf(":%s", port)
 		authRouter := mux.NewRouter()
 
 		authRouter.HandleFunc("/auth/{namespace}/{pod}", authService.AuthHandler)
+		authRouter.HandleFunc("/secrets/{namespace}/{pod}", authService.SecretsHandler)
 		authServer := authService.NewMTLSServer(authRouter, authURL)
+		authServer.TLSConfig.GetCertificate = servingCert.GetCertificate
 		klog.InfoS("serving encrypted auth endpoint", "path", fmt.Sprintf("%s/auth", authURL))
+		klog.InfoS("serving encrypted secrets endpoint", "path", fmt.Sprintf("%s/secrets", authURL))
 
 		go func() {
-			err := authServer.ListenAndServeTLS(config.tlsCertFile, config.tlsKeyFile)
+			err := authServer.ListenAndServeTLS("", "")
 			if err != nil {
 				klog.ErrorS(err, "error serving auth", "port", authURL)
 				os.Exit(1)
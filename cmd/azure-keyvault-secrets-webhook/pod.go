@@ -20,6 +20,7 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -28,25 +29,40 @@ import (
 	"strings"
 
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/cmd/azure-keyvault-secrets-webhook/auth"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
 	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/docker/registry"
+	akvcs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned"
 	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
 const (
 	authSecretVolumeName  = "akv2k8s-client-cert"
+	authTokenVolumeName   = "akv2k8s-token"
 	keyVaultEnvVolumeName = "azure-keyvault-env"
+	secretFilesVolumeName = "akv2k8s-secret-files"
+
+	secretFilesRefresherContainerName = "akv2k8s-secret-files-refresher"
+
+	authTokenFile              = "token"
+	authTokenExpirationSeconds = int64(600)
 )
 
+// podSecretFiles are the secret files to write in the containers of a pod
+type podSecretFiles struct {
+	dir     string
+	files   []injector.SecretFile
+	refresh *injector.SecretFilesRefresh
+}
+
 type podWebHook struct {
 	clientset                 kubernetes.Interface
+	akvsClient                akvcs.Interface
 	namespace                 string
-	mutationID                types.UID
 	injectorDir               string
 	authService               *auth.AuthService
 	useAuthService            bool
@@ -78,7 +94,7 @@ func (p podWebHook) getInitContainers() []corev1.Container {
 	return []corev1.Container{container}
 }
 
-func (p podWebHook) getVolumes(authSecret *corev1.Secret) []corev1.Volume {
+func (p podWebHook) getVolumes(authSecret *corev1.Secret, secretFiles *podSecretFiles) []corev1.Volume {
 	volumes := []corev1.Volume{
 		{
 			Name: keyVaultEnvVolumeName,
@@ -90,8 +106,20 @@ func (p podWebHook) getVolumes(authSecret *corev1.Secret) []corev1.Volume {
 		},
 	}
 
+	if secretFiles != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: secretFilesVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					Medium: corev1.StorageMediumMemory,
+				},
+			},
+		})
+	}
+
 	if p.useAuthService {
 		mode := int32(420)
+		expirationSeconds := authTokenExpirationSeconds
 		volumes = append(volumes, []corev1.Volume{
 			{
 				Name: authSecretVolumeName,
@@ -102,13 +130,222 @@ func (p podWebHook) getVolumes(authSecret *corev1.Secret) []corev1.Volume {
 					},
 				},
 			},
+			{
+				Name: authTokenVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					Projected: &corev1.ProjectedVolumeSource{
+						Sources: []corev1.VolumeProjection{
+							{
+								ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+									Audience:          auth.ServiceAccountTokenAudience,
+									ExpirationSeconds: &expirationSeconds,
+									Path:              authTokenFile,
+								},
+							},
+						},
+						DefaultMode: &mode,
+					},
+				},
+			},
 		}...)
 	}
 
 	return volumes
 }
 
-func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Container, podSpec *corev1.PodSpec, authServiceSecret *corev1.Secret) (bool, error) {
+// getSecretFiles returns the secret files in the annotations of pod, or nil if none
+func getSecretFiles(pod *corev1.Pod) (*podSecretFiles, error) {
+	annotation, ok := pod.Annotations[injector.SecretFilesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	files, err := injector.ParseSecretFiles(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation %s, error: %+v", injector.SecretFilesAnnotation, err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	dir := injector.DefaultSecretFilesDir
+	if annotatedDir, ok := pod.Annotations[injector.SecretFilesDirAnnotation]; ok {
+		if !filepath.IsAbs(annotatedDir) {
+			return nil, fmt.Errorf("invalid annotation %s, '%s' must be an absolute path", injector.SecretFilesDirAnnotation, annotatedDir)
+		}
+		dir = annotatedDir
+	}
+
+	refresh, err := injector.ParseSecretFilesRefresh(pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]bool, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		containers[container.Name] = true
+	}
+	for _, file := range files {
+		for _, container := range file.Containers {
+			if !containers[container] {
+				return nil, fmt.Errorf("secret file '%s' targets container '%s', which is not in the pod", file.Path, container)
+			}
+		}
+	}
+
+	return &podSecretFiles{dir: dir, files: files, refresh: refresh}, nil
+}
+
+// forContainer returns the secret files written by the container named name, or nil if none,
+// so that only the containers the files are targeted at are wrapped by the env-injector
+func (f *podSecretFiles) forContainer(name string, first bool) *podSecretFiles {
+	if f == nil {
+		return nil
+	}
+
+	var files []injector.SecretFile
+	for _, file := range f.files {
+		if file.WrittenBy(name, first) {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return &podSecretFiles{dir: f.dir, files: files, refresh: f.refresh}
+}
+
+// getSecretFilesRefresher returns a sidecar container running the env-injector in refresh mode,
+// rewriting secret files on the shared volume when their secrets are rotated
+func (p podWebHook) getSecretFilesRefresher(authServiceSecret *corev1.Secret, secretFiles *podSecretFiles) (corev1.Container, error) {
+	container := corev1.Container{
+		Name:            secretFilesRefresherContainerName,
+		Image:           viper.GetString("azurekeyvault_env_image"),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{filepath.Join("/usr/local/bin", injectorExecutable)},
+		Env: append(p.getPodEnvVars(p.useAuthService), []corev1.EnvVar{
+			{
+				Name:  "ENV_INJECTOR_REFRESH_INTERVAL",
+				Value: secretFiles.refresh.Interval.String(),
+			},
+			{
+				Name:  "ENV_INJECTOR_RELOAD_SIGNAL",
+				Value: secretFiles.refresh.ReloadSignal,
+			},
+			{
+				Name:  "ENV_INJECTOR_RELOAD_PROCESS",
+				Value: secretFiles.refresh.ReloadProcess,
+			},
+			{
+				Name:  "ENV_INJECTOR_RELOAD_URL",
+				Value: secretFiles.refresh.ReloadURL,
+			},
+		}...),
+	}
+
+	if err := p.mountSecretFiles(&container, secretFiles); err != nil {
+		return container, err
+	}
+
+	if p.useAuthService {
+		p.mountAuthService(&container, authServiceSecret)
+	}
+
+	return container, nil
+}
+
+// getPodEnvVars returns the env vars identifying the pod to the env-injector
+func (p podWebHook) getPodEnvVars(useAuthService bool) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name:  "ENV_INJECTOR_USE_AUTH_SERVICE",
+			Value: strconv.FormatBool(useAuthService),
+		},
+		{
+			Name: "ENV_INJECTOR_POD_NAMESPACE",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "metadata.namespace",
+				},
+			},
+		},
+		{
+			Name: "ENV_INJECTOR_POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "metadata.name",
+				},
+			},
+		},
+	}
+}
+
+// mountSecretFiles mounts the secret files volume in container and tells the env-injector which files to write
+func (p podWebHook) mountSecretFiles(container *corev1.Container, secretFiles *podSecretFiles) error {
+	files, err := json.Marshal(secretFiles.files)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret files, error: %+v", err)
+	}
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      secretFilesVolumeName,
+		MountPath: secretFiles.dir,
+	})
+	klog.V(4).InfoS("mounting volume", "volume", secretFilesVolumeName, "path", secretFiles.dir, "container", klog.KRef(p.namespace, container.Name))
+
+	container.Env = append(container.Env, []corev1.EnvVar{
+		{
+			Name:  "ENV_INJECTOR_SECRET_FILES_DIR",
+			Value: secretFiles.dir,
+		},
+		{
+			Name:  "ENV_INJECTOR_SECRET_FILES",
+			Value: string(files),
+		},
+	}...)
+	return nil
+}
+
+// mountAuthService mounts the client certificate and token used with the auth service in container
+func (p podWebHook) mountAuthService(container *corev1.Container, authServiceSecret *corev1.Secret) {
+	container.VolumeMounts = append(container.VolumeMounts, []corev1.VolumeMount{
+		{
+			Name:      authSecretVolumeName,
+			MountPath: clientCertDir,
+			ReadOnly:  true,
+		},
+		{
+			Name:      authTokenVolumeName,
+			MountPath: authTokenDir,
+			ReadOnly:  true,
+		},
+	}...)
+
+	container.Env = append(container.Env, []corev1.EnvVar{
+		{
+			Name:  "ENV_INJECTOR_CLIENT_CERT_DIR",
+			Value: clientCertDir,
+		},
+		{
+			Name:  "ENV_INJECTOR_AUTH_SERVICE",
+			Value: fmt.Sprintf("https://%s.%s.svc:%s", p.authServiceName, p.currentNamespace(), p.authServicePort),
+		},
+		{
+			Name:  "ENV_INJECTOR_AUTH_SERVICE_VALIDATION",
+			Value: fmt.Sprintf("http://%s.%s.svc:%s", p.authServiceName, p.currentNamespace(), p.authServiceValidationPort),
+		},
+		{
+			Name:  "ENV_INJECTOR_AUTH_SERVICE_SECRET",
+			Value: authServiceSecret.Name,
+		},
+		{
+			Name:  "ENV_INJECTOR_AUTH_SERVICE_TOKEN_FILE",
+			Value: filepath.Join(authTokenDir, authTokenFile),
+		},
+	}...)
+}
+
+func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Container, podSpec *corev1.PodSpec, authServiceSecret *corev1.Secret, secretFiles *podSecretFiles, envFromOptions *injector.EnvFromOptions) (bool, error) {
 	mutated := false
 
 	for i, container := range containers {
@@ -118,7 +355,13 @@ func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Co
 		var envVars []corev1.EnvVar
 		klog.InfoS("checking for env vars to inject", "container", klog.KRef(p.namespace, container.Name))
 		for _, env := range container.Env {
-			if strings.Contains(env.Value, envVarReplacementKey) {
+			if injector.IsTemplate(env.Value) {
+				if err := p.validateTemplate(ctx, env.Value); err != nil {
+					return false, fmt.Errorf("invalid template in env var %s of container %s, error: %+v", env.Name, container.Name, err)
+				}
+				klog.InfoS("found env var with template to inject", "env", env.Name, "container", klog.KRef(p.namespace, container.Name))
+				envVars = append(envVars, env)
+			} else if strings.Contains(env.Value, envVarReplacementKey) {
 				klog.InfoS("found env var to inject", "env", env.Value, "container", klog.KRef(p.namespace, container.Name))
 				envVars = append(envVars, env)
 			}
@@ -135,8 +378,9 @@ func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Co
 			}
 		}
 
-		if len(envVars) == 0 {
-			klog.Info("found no env vars to inject", "container", klog.KRef(p.namespace, container.Name))
+		containerSecretFiles := secretFiles.forContainer(container.Name, i == 0)
+		if len(envVars) == 0 && containerSecretFiles == nil {
+			klog.Info("found no env vars or secret files to inject", "container", klog.KRef(p.namespace, container.Name))
 			continue
 		}
 
@@ -178,27 +422,25 @@ func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Co
 				Name:  "ENV_INJECTOR_ARGS_KEY",
 				Value: base64.StdEncoding.EncodeToString([]byte(keys.key)),
 			},
-			{
-				Name:  "ENV_INJECTOR_USE_AUTH_SERVICE",
-				Value: strconv.FormatBool(useAuthService),
-			},
-			{
-				Name: "ENV_INJECTOR_POD_NAMESPACE",
-				ValueFrom: &corev1.EnvVarSource{
-					FieldRef: &corev1.ObjectFieldSelector{
-						FieldPath: "metadata.namespace",
-					},
-				},
-			},
-			{
-				Name: "ENV_INJECTOR_POD_NAME",
-				ValueFrom: &corev1.EnvVarSource{
-					FieldRef: &corev1.ObjectFieldSelector{
-						FieldPath: "metadata.name",
-					},
-				},
-			},
 		}...)
+		container.Env = append(container.Env, p.getPodEnvVars(useAuthService)...)
+
+		if envFromOptions != nil {
+			options, err := json.Marshal(envFromOptions)
+			if err != nil {
+				return false, fmt.Errorf("failed to marshal env from options, error: %+v", err)
+			}
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "ENV_INJECTOR_ENV_FROM_OPTIONS",
+				Value: string(options),
+			})
+		}
+
+		if containerSecretFiles != nil {
+			if err := p.mountSecretFiles(&container, containerSecretFiles); err != nil {
+				return false, err
+			}
+		}
 
 		if useAuthService {
 			_, err := p.clientset.CoreV1().Secrets(p.namespace).Create(context.TODO(), authServiceSecret, metav1.CreateOptions{})
@@ -213,32 +455,7 @@ func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Co
 				}
 			}
 
-			container.VolumeMounts = append(container.VolumeMounts, []corev1.VolumeMount{
-				{
-					Name:      authSecretVolumeName,
-					MountPath: clientCertDir,
-					ReadOnly:  true,
-				},
-			}...)
-
-			container.Env = append(container.Env, []corev1.EnvVar{
-				{
-					Name:  "ENV_INJECTOR_CLIENT_CERT_DIR",
-					Value: clientCertDir,
-				},
-				{
-					Name:  "ENV_INJECTOR_AUTH_SERVICE",
-					Value: fmt.Sprintf("https://%s.%s.svc:%s", p.authServiceName, p.currentNamespace(), p.authServicePort),
-				},
-				{
-					Name:  "ENV_INJECTOR_AUTH_SERVICE_VALIDATION",
-					Value: fmt.Sprintf("http://%s.%s.svc:%s", p.authServiceName, p.currentNamespace(), p.authServiceValidationPort),
-				},
-				{
-					Name:  "ENV_INJECTOR_AUTH_SERVICE_SECRET",
-					Value: authServiceSecret.Name,
-				},
-			}...)
+			p.mountAuthService(&container, authServiceSecret)
 		}
 
 		containers[i] = container
@@ -247,6 +464,45 @@ func (p podWebHook) mutateContainers(ctx context.Context, containers []corev1.Co
 	return mutated, nil
 }
 
+// validateTemplate checks that the template in value parses and only references AzureKeyVaultSecrets
+// that exist in the namespace of the pod. If an AzureKeyVaultSecret cannot be looked up, like when the
+// env-injector is not allowed to get it, the pod is allowed and unresolved references are reported
+// by the env-injector in the container instead.
+func (p podWebHook) validateTemplate(ctx context.Context, value string) error {
+	tmpl, err := injector.ParseTemplate(value)
+	if err != nil {
+		return err
+	}
+	if p.akvsClient == nil {
+		return nil
+	}
+
+	var missing []string
+	checked := make(map[string]bool)
+	for _, reference := range tmpl.References() {
+		ref, err := injector.ParseReference(reference)
+		if err != nil {
+			return err
+		}
+		if checked[ref.Name] {
+			continue
+		}
+		checked[ref.Name] = true
+
+		_, err = p.akvsClient.AzureKeyVaultV2beta1().AzureKeyVaultSecrets(p.namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			missing = append(missing, ref.Name)
+		} else if err != nil {
+			klog.ErrorS(err, "failed to get azurekeyvaultsecret referenced in template, skipping check", "azurekeyvaultsecret", klog.KRef(p.namespace, ref.Name))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("template references azurekeyvaultsecrets not found in namespace '%s': %s", p.namespace, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 type argsSignature struct {
 	signature string
 	key       string
@@ -283,27 +539,53 @@ func (p podWebHook) mutatePodSpec(ctx context.Context, pod *corev1.Pod) error {
 
 	if p.useAuthService {
 		klog.InfoS("creating client certificate to use with auth service", klog.KRef(p.namespace, pod.Name))
-		authServiceSecret, err = p.authService.NewPodSecret(pod, p.namespace, p.mutationID)
+		authServiceSecret, err = p.authService.NewPodSecret(pod, p.namespace)
 		if err != nil {
 			return err
 		}
 	}
 
+	secretFiles, err := getSecretFiles(pod)
+	if err != nil {
+		return err
+	}
+
+	envFromOptions, err := injector.ParseEnvFromOptions(pod.Annotations)
+	if err != nil {
+		return err
+	}
+
 	klog.InfoS("mutate init-containers", klog.KRef(p.namespace, pod.Name))
-	initContainersMutated, err := p.mutateContainers(ctx, podSpec.InitContainers, podSpec, authServiceSecret)
+	initContainersMutated, err := p.mutateContainers(ctx, podSpec.InitContainers, podSpec, authServiceSecret, nil, envFromOptions)
 	if err != nil {
 		return err
 	}
 
+	// Secret files are only written by containers, as init containers may not be able to run the env-injector
 	klog.InfoS("mutate containers", klog.KRef(p.namespace, pod.Name))
-	containersMutated, err := p.mutateContainers(ctx, podSpec.Containers, podSpec, authServiceSecret)
+	containersMutated, err := p.mutateContainers(ctx, podSpec.Containers, podSpec, authServiceSecret, secretFiles, envFromOptions)
 	if err != nil {
 		return err
 	}
 
 	if initContainersMutated || containersMutated {
 		podSpec.InitContainers = append(p.getInitContainers(), podSpec.InitContainers...)
-		podSpec.Volumes = append(podSpec.Volumes, p.getVolumes(authServiceSecret)...)
+		podSpec.Volumes = append(podSpec.Volumes, p.getVolumes(authServiceSecret, secretFiles)...)
+
+		if containersMutated && secretFiles != nil && secretFiles.refresh != nil {
+			refresher, err := p.getSecretFilesRefresher(authServiceSecret, secretFiles)
+			if err != nil {
+				return err
+			}
+			podSpec.Containers = append(podSpec.Containers, refresher)
+
+			// Signaling the main process requires seeing it from the sidecar
+			if secretFiles.refresh.ReloadSignal != "" {
+				shareProcessNamespace := true
+				podSpec.ShareProcessNamespace = &shareProcessNamespace
+			}
+			klog.InfoS("added sidecar refreshing secret files", "pod", klog.KRef(p.namespace, pod.Name), "interval", secretFiles.refresh.Interval)
+		}
 		klog.InfoS("containers mutated and pod updated with init-container and volumes", "pod", klog.KRef(p.namespace, pod.Name))
 		podsMutatedCounter.Inc()
 	} else {
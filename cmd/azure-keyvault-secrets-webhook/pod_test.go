@@ -5,18 +5,24 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/injector"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	akvfake "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/client/clientset/versioned/fake"
 	cmp "github.com/google/go-cmp/cmp"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestMutateContainers(t *testing.T) {
 	t.SkipNow()
 
 	pw := podWebHook{
-		clientset:  fake.NewSimpleClientset(),
-		mutationID: "lasjdf",
-		namespace:  "my-namespace",
+		clientset: fake.NewSimpleClientset(),
+		namespace: "my-namespace",
 	}
 
 	pod := corev1.Pod{
@@ -165,3 +171,169 @@ func TestMutateContainers(t *testing.T) {
 	// 	t.Errorf("mutatingWebhook.mutateContainers() = diff %v", cmp.Diff(tt.args.containers, tt.wantedContainers))
 	// }
 }
+
+func TestGetSecretFiles(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}}
+	if files, err := getSecretFiles(pod); err != nil || files != nil {
+		t.Errorf("expected no secret files without annotation, got %+v, error: %+v", files, err)
+	}
+
+	pod.Annotations = map[string]string{
+		injector.SecretFilesAnnotation: `[{"path": "tls.key", "secret": "my-cert?tls.key"}]`,
+	}
+	files, err := getSecretFiles(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.dir != injector.DefaultSecretFilesDir || len(files.files) != 1 {
+		t.Errorf("unexpected secret files %+v", files)
+	}
+
+	pod.Annotations[injector.SecretFilesDirAnnotation] = "/etc/nginx/tls"
+	if files, err = getSecretFiles(pod); err != nil || files.dir != "/etc/nginx/tls" {
+		t.Errorf("expected secret files dir from annotation, got %+v, error: %+v", files, err)
+	}
+
+	pod.Annotations[injector.SecretFilesDirAnnotation] = "relative"
+	if _, err = getSecretFiles(pod); err == nil {
+		t.Error("expected error for relative secret files dir")
+	}
+
+	delete(pod.Annotations, injector.SecretFilesDirAnnotation)
+	pod.Annotations[injector.SecretFilesAnnotation] = `[{"path": "tls.key", "secret": "my-cert?tls.key", "containers": ["nginx"]}]`
+	if _, err = getSecretFiles(pod); err == nil {
+		t.Error("expected error for secret file targeting a container not in the pod")
+	}
+}
+
+func TestPodSecretFilesForContainer(t *testing.T) {
+	var none *podSecretFiles
+	if files := none.forContainer("app", true); files != nil {
+		t.Errorf("expected no secret files without annotation, got %+v", files)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod",
+			Annotations: map[string]string{
+				injector.SecretFilesAnnotation: `[
+					{"path": "password", "secret": "my-secret"},
+					{"path": "tls.key", "secret": "my-cert?tls.key", "containers": ["nginx"]}
+				]`,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}, {Name: "nginx"}, {Name: "sidecar"}},
+		},
+	}
+	secretFiles, err := getSecretFiles(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		container string
+		first     bool
+		paths     []string
+	}{
+		{container: "app", first: true, paths: []string{"password"}},
+		{container: "nginx", paths: []string{"tls.key"}},
+		{container: "sidecar"},
+	}
+	for _, tt := range tests {
+		files := secretFiles.forContainer(tt.container, tt.first)
+		if len(tt.paths) == 0 {
+			if files != nil {
+				t.Errorf("expected container %s not to write secret files, got %+v", tt.container, files.files)
+			}
+			continue
+		}
+		if files == nil || len(files.files) != len(tt.paths) || files.files[0].Path != tt.paths[0] || files.dir != secretFiles.dir {
+			t.Errorf("expected container %s to write %v, got %+v", tt.container, tt.paths, files)
+		}
+	}
+}
+
+func TestGetSecretFilesRefresher(t *testing.T) {
+	pw := podWebHook{namespace: "my-namespace"}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "pod",
+		Annotations: map[string]string{
+			injector.SecretFilesAnnotation:                `[{"path": "tls.key", "secret": "my-cert?tls.key"}]`,
+			injector.SecretFilesRefreshIntervalAnnotation: "5m",
+			injector.SecretFilesReloadSignalAnnotation:    "sighup",
+			injector.SecretFilesReloadProcessAnnotation:   "nginx",
+		},
+	}}
+
+	files, err := getSecretFiles(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.refresh == nil || files.refresh.ReloadSignal != "SIGHUP" {
+		t.Fatalf("expected secret files refresh from annotations, got %+v", files.refresh)
+	}
+
+	refresher, err := pw.getSecretFilesRefresher(nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresher.Name != secretFilesRefresherContainerName || len(refresher.Args) != 0 {
+		t.Errorf("unexpected refresher container %+v", refresher)
+	}
+
+	env := make(map[string]string)
+	for _, e := range refresher.Env {
+		env[e.Name] = e.Value
+	}
+	if env["ENV_INJECTOR_REFRESH_INTERVAL"] != "5m0s" || env["ENV_INJECTOR_RELOAD_SIGNAL"] != "SIGHUP" || env["ENV_INJECTOR_RELOAD_PROCESS"] != "nginx" {
+		t.Errorf("expected refresh configuration in env, got %v", env)
+	}
+	if env["ENV_INJECTOR_SECRET_FILES_DIR"] != injector.DefaultSecretFilesDir || env["ENV_INJECTOR_SECRET_FILES"] == "" {
+		t.Errorf("expected secret files in env, got %v", env)
+	}
+	if len(refresher.VolumeMounts) != 1 || refresher.VolumeMounts[0].Name != secretFilesVolumeName || refresher.VolumeMounts[0].ReadOnly {
+		t.Errorf("expected writable secret files volume mounted, got %+v", refresher.VolumeMounts)
+	}
+
+	delete(pod.Annotations, injector.SecretFilesRefreshIntervalAnnotation)
+	if _, err := getSecretFiles(pod); err == nil {
+		t.Error("expected error for reload signal without refresh interval")
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	pw := podWebHook{
+		namespace: "my-namespace",
+		akvsClient: akvfake.NewSimpleClientset(&akv.AzureKeyVaultSecret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-creds", Namespace: "my-namespace"},
+		}),
+	}
+
+	if err := pw.validateTemplate(context.Background(), `postgres://{{ akvs "db-creds?username" }}:{{ akvs "db-creds?password" }}@host/db`); err != nil {
+		t.Errorf("expected template to be valid, error: %+v", err)
+	}
+
+	invalid := []string{
+		`Server=db;Password={{ akvs "missing" }}`,
+		`Server=db;Password={{ akvs "db-creds" }`,
+		`Server=db;Password={{ akvs .Password }}`,
+	}
+	for _, value := range invalid {
+		if err := pw.validateTemplate(context.Background(), value); err == nil {
+			t.Errorf("expected error validating template '%s'", value)
+		}
+	}
+}
+
+func TestValidateTemplateAllowsFailedLookup(t *testing.T) {
+	client := akvfake.NewSimpleClientset()
+	client.PrependReactor("get", "azurekeyvaultsecrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(akv.Resource("azurekeyvaultsecrets"), "db-creds", fmt.Errorf("not allowed"))
+	})
+	pw := podWebHook{namespace: "my-namespace", akvsClient: client}
+
+	if err := pw.validateTemplate(context.Background(), `Password={{ akvs "db-creds" }}`); err != nil {
+		t.Errorf("expected template to be allowed when azurekeyvaultsecret cannot be looked up, error: %+v", err)
+	}
+}
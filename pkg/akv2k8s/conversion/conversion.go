@@ -0,0 +1,121 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conversion converts AzureKeyVaultSecrets between API versions,
+// converting every version through the hub version v2beta1
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const kind = "AzureKeyVaultSecret"
+
+// Convertible is an AzureKeyVaultSecret version that can be converted to and from the hub version
+type Convertible interface {
+	runtime.Object
+	ConvertTo(hub *v2beta1.AzureKeyVaultSecret) error
+	ConvertFrom(hub *v2beta1.AzureKeyVaultSecret) error
+}
+
+// newConvertible returns an empty AzureKeyVaultSecret of a version other than the hub
+func newConvertible(apiVersion string) (Convertible, error) {
+	switch apiVersion {
+	case v1alpha1.SchemeGroupVersion.String():
+		return &v1alpha1.AzureKeyVaultSecret{}, nil
+	case v1.SchemeGroupVersion.String():
+		return &v1.AzureKeyVaultSecret{}, nil
+	case v2alpha1.SchemeGroupVersion.String():
+		return &v2alpha1.AzureKeyVaultSecret{}, nil
+	default:
+		return nil, fmt.Errorf("api version '%s' not supported for %s", apiVersion, kind)
+	}
+}
+
+// ToHub converts a json encoded AzureKeyVaultSecret of any version to the hub version
+func ToHub(raw []byte) (*v2beta1.AzureKeyVaultSecret, error) {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, fmt.Errorf("failed to decode object, error: %+v", err)
+	}
+	if typeMeta.Kind != kind {
+		return nil, fmt.Errorf("kind '%s' not supported, only %s", typeMeta.Kind, kind)
+	}
+
+	hub := &v2beta1.AzureKeyVaultSecret{}
+	if typeMeta.APIVersion == v2beta1.SchemeGroupVersion.String() {
+		if err := json.Unmarshal(raw, hub); err != nil {
+			return nil, fmt.Errorf("failed to decode %s, error: %+v", typeMeta.APIVersion, err)
+		}
+		return hub, nil
+	}
+
+	obj, err := newConvertible(typeMeta.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return nil, fmt.Errorf("failed to decode %s, error: %+v", typeMeta.APIVersion, err)
+	}
+	if err := obj.ConvertTo(hub); err != nil {
+		return nil, fmt.Errorf("failed to convert from %s, error: %+v", typeMeta.APIVersion, err)
+	}
+	if err := restoreUnsupportedFields(hub, typeMeta.APIVersion); err != nil {
+		return nil, fmt.Errorf("failed to restore fields not supported by %s, error: %+v", typeMeta.APIVersion, err)
+	}
+	return hub, nil
+}
+
+// FromHub converts the hub version to the given api version
+func FromHub(hub *v2beta1.AzureKeyVaultSecret, apiVersion string) (runtime.Object, error) {
+	if apiVersion == v2beta1.SchemeGroupVersion.String() {
+		out := hub.DeepCopy()
+		out.TypeMeta = metav1.TypeMeta{APIVersion: apiVersion, Kind: kind}
+		return out, nil
+	}
+
+	obj, err := newConvertible(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.ConvertFrom(hub); err != nil {
+		return nil, fmt.Errorf("failed to convert to %s, error: %+v", apiVersion, err)
+	}
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	return obj, nil
+}
+
+// Convert converts a json encoded AzureKeyVaultSecret to the given api version
+func Convert(raw []byte, apiVersion string) ([]byte, error) {
+	hub, err := ToHub(raw)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := FromHub(hub, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var spokeVersions = []string{
+	v1alpha1.SchemeGroupVersion.String(),
+	v1.SchemeGroupVersion.String(),
+	v2alpha1.SchemeGroupVersion.String(),
+}
+
+func newHub() *v2beta1.AzureKeyVaultSecret {
+	expires := metav1.NewTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	return &v2beta1.AzureKeyVaultSecret{
+		TypeMeta: metav1.TypeMeta{APIVersion: v2beta1.SchemeGroupVersion.String(), Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-secret",
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "my-app"},
+			Annotations: map[string]string{"owner": "team"},
+		},
+		Spec: v2beta1.AzureKeyVaultSecretSpec{
+			Vault: v2beta1.AzureKeyVault{
+				Name: "my-vault",
+				Object: v2beta1.AzureKeyVaultObject{
+					Name:                "my-secret",
+					Type:                v2beta1.AzureKeyVaultObjectTypeSecret,
+					VersionHistoryLimit: 3,
+				},
+				AzureIdentity: v2beta1.AzureIdentity{Name: "my-identity"},
+			},
+			Output: v2beta1.AzureKeyVaultOutput{
+				Secret: v2beta1.AzureKeyVaultOutputSecret{
+					Name:       "my-secret",
+					Type:       corev1.SecretTypeOpaque,
+					DataKey:    "value",
+					ChainOrder: "ensureserverfirst",
+					KeyFormat:  v2beta1.AzureKeyVaultKeyFormatPem,
+				},
+				ConfigMap: v2beta1.AzureKeyVaultOutputConfigMap{
+					Name:      "my-configmap",
+					DataKey:   "value",
+					KeyFormat: v2beta1.AzureKeyVaultKeyFormatJWK,
+				},
+				Transform: []string{"trim"},
+			},
+			RefreshInterval: &metav1.Duration{Duration: time.Minute},
+		},
+		Status: v2beta1.AzureKeyVaultSecretStatus{
+			SecretHash:         "abc",
+			SecretName:         "my-secret",
+			ConfigMapHash:      "def",
+			ConfigMapName:      "my-configmap",
+			ObjectVersion:      "1",
+			ObjectExpires:      &expires,
+			ObjectVersions:     []string{"1", "0"},
+			ObservedGeneration: 2,
+			Conditions: []metav1.Condition{{
+				Type:               v2beta1.AzureKeyVaultSecretConditionReady,
+				Status:             metav1.ConditionTrue,
+				Reason:             "Synced",
+				LastTransitionTime: expires,
+			}},
+		},
+	}
+}
+
+func roundTrip(t *testing.T, obj runtime.Object, apiVersion string) []byte {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := Convert(raw, apiVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return converted
+}
+
+func TestHubRoundTripThroughSpokes(t *testing.T) {
+	for _, apiVersion := range spokeVersions {
+		hub := newHub()
+		spoke := roundTrip(t, hub, apiVersion)
+
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(spoke, &typeMeta); err != nil {
+			t.Fatal(err)
+		}
+		if typeMeta.APIVersion != apiVersion || typeMeta.Kind != kind {
+			t.Errorf("%s: expected converted object of %s %s, got %+v", apiVersion, apiVersion, kind, typeMeta)
+		}
+
+		restored, err := ToHub(spoke)
+		if err != nil {
+			t.Fatalf("%s: %+v", apiVersion, err)
+		}
+		if !equality.Semantic.DeepEqual(hub.ObjectMeta, restored.ObjectMeta) {
+			t.Errorf("%s: expected metadata %+v, got %+v", apiVersion, hub.ObjectMeta, restored.ObjectMeta)
+		}
+		if !equality.Semantic.DeepEqual(hub.Spec, restored.Spec) {
+			t.Errorf("%s: expected spec %+v, got %+v", apiVersion, hub.Spec, restored.Spec)
+		}
+		if !equality.Semantic.DeepEqual(hub.Status, restored.Status) {
+			t.Errorf("%s: expected status %+v, got %+v", apiVersion, hub.Status, restored.Status)
+		}
+	}
+}
+
+func TestSpokeRoundTripThroughHub(t *testing.T) {
+	spoke := &v1alpha1.AzureKeyVaultSecret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "default"},
+		Spec: v1alpha1.AzureKeyVaultSecretSpec{
+			Vault: v1alpha1.AzureKeyVault{
+				Name: "my-vault",
+				Object: v1alpha1.AzureKeyVaultObject{
+					Name: "my-secret",
+					Type: v1alpha1.AzureKeyVaultObjectTypeSecret,
+				},
+			},
+			Output: v1alpha1.AzureKeyVaultOutput{
+				Secret:     v1alpha1.AzureKeyVaultOutputSecret{Name: "my-secret", DataKey: "value"},
+				Transforms: []string{"base64decode"},
+			},
+		},
+	}
+
+	hubRaw := roundTrip(t, spoke, v2beta1.SchemeGroupVersion.String())
+	hub := &v2beta1.AzureKeyVaultSecret{}
+	if err := json.Unmarshal(hubRaw, hub); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.Spec.Output.Transform) != 1 || hub.Spec.Output.Transform[0] != "base64decode" {
+		t.Errorf("expected transforms to be converted to transform, got %+v", hub.Spec.Output.Transform)
+	}
+
+	restored := &v1alpha1.AzureKeyVaultSecret{}
+	if err := json.Unmarshal(roundTrip(t, hub, v1alpha1.SchemeGroupVersion.String()), restored); err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(spoke.Spec, restored.Spec) {
+		t.Errorf("expected spec %+v, got %+v", spoke.Spec, restored.Spec)
+	}
+}
+
+func TestSpokeChangesArePreserved(t *testing.T) {
+	spoke := &v1.AzureKeyVaultSecret{}
+	if err := json.Unmarshal(roundTrip(t, newHub(), v1.SchemeGroupVersion.String()), spoke); err != nil {
+		t.Fatal(err)
+	}
+
+	// An older client changes a field supported by its version
+	spoke.Spec.Output.Secret.DataKey = "changed"
+
+	hub, err := ToHub(mustMarshal(t, spoke))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hub.Spec.Output.Secret.DataKey != "changed" {
+		t.Errorf("expected data key changed by older client, got '%s'", hub.Spec.Output.Secret.DataKey)
+	}
+	if hub.Spec.Output.ConfigMap.Name != "my-configmap" || hub.Spec.Vault.AzureIdentity.Name != "my-identity" {
+		t.Errorf("expected configmap output and azure identity to be restored, got %+v", hub.Spec)
+	}
+	if _, ok := hub.Annotations[v2beta1.ConversionDataAnnotation]; ok {
+		t.Error("expected conversion data annotation to be removed")
+	}
+}
+
+func TestConvertUnsupportedVersion(t *testing.T) {
+	raw := mustMarshal(t, newHub())
+	if _, err := Convert(raw, "spv.no/v3"); err == nil {
+		t.Error("expected error converting to unsupported version")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	review := ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request: &ConversionRequest{
+			UID:               "123",
+			DesiredAPIVersion: v1.SchemeGroupVersion.String(),
+			Objects:           []runtime.RawExtension{{Raw: mustMarshal(t, newHub())}},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	NewWebhook().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(mustMarshal(t, review))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var result ConversionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || result.Response.UID != "123" || result.Response.Result.Status != metav1.StatusSuccess {
+		t.Fatalf("expected successful response for request, got %+v", result.Response)
+	}
+	if len(result.Response.ConvertedObjects) != 1 {
+		t.Fatalf("expected 1 converted object, got %d", len(result.Response.ConvertedObjects))
+	}
+
+	converted := &v1.AzureKeyVaultSecret{}
+	if err := json.Unmarshal(result.Response.ConvertedObjects[0].Raw, converted); err != nil {
+		t.Fatal(err)
+	}
+	if converted.APIVersion != v1.SchemeGroupVersion.String() || converted.Spec.Vault.Name != "my-vault" {
+		t.Errorf("expected converted v1 object, got %+v", converted)
+	}
+}
+
+func mustMarshal(t *testing.T, obj interface{}) []byte {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,78 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conversion
+
+import (
+	v1 "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v1alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2alpha1"
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+)
+
+// restoreFunc copies fields of the hub version from restored, the hub version an older version was converted from
+type restoreFunc func(dst, restored *v2beta1.AzureKeyVaultSecret)
+
+// unsupportedFields are the fields of the hub version each older version does not have
+var unsupportedFields = map[string][]restoreFunc{
+	v1alpha1.SchemeGroupVersion.String(): {restoreV2beta1Fields, restoreV2alpha1Fields, restoreChainOrder},
+	v1.SchemeGroupVersion.String():       {restoreV2beta1Fields, restoreV2alpha1Fields},
+	v2alpha1.SchemeGroupVersion.String(): {restoreV2beta1Fields},
+}
+
+// restoreUnsupportedFields restores the fields of hub not supported by apiVersion from the conversion
+// data stored when hub was converted to apiVersion. Fields supported by apiVersion are kept as they
+// are, so changes made by clients of the older version are preserved.
+func restoreUnsupportedFields(hub *v2beta1.AzureKeyVaultSecret, apiVersion string) error {
+	restored, err := v2beta1.UnmarshalConversionData(hub)
+	if err != nil || restored == nil {
+		return err
+	}
+
+	for _, restore := range unsupportedFields[apiVersion] {
+		restore(hub, restored)
+	}
+	return nil
+}
+
+// restoreV2beta1Fields restores the fields added in v2beta1
+func restoreV2beta1Fields(dst, restored *v2beta1.AzureKeyVaultSecret) {
+	dst.Spec.RefreshInterval = restored.Spec.RefreshInterval
+	dst.Spec.Vault.Object.VersionHistoryLimit = restored.Spec.Vault.Object.VersionHistoryLimit
+	dst.Spec.Vault.Object.Selector = restored.Spec.Vault.Object.Selector
+	dst.Spec.Output.Secret.KeyFormat = restored.Spec.Output.Secret.KeyFormat
+	dst.Spec.Output.ConfigMap.KeyFormat = restored.Spec.Output.ConfigMap.KeyFormat
+	dst.Status.ObjectVersion = restored.Status.ObjectVersion
+	dst.Status.ObjectExpires = restored.Status.ObjectExpires
+	dst.Status.ObjectVersions = restored.Status.ObjectVersions
+	dst.Status.ObservedGeneration = restored.Status.ObservedGeneration
+	dst.Status.Conditions = restored.Status.Conditions
+	dst.Status.LastError = restored.Status.LastError
+	dst.Status.FailureCount = restored.Status.FailureCount
+}
+
+// restoreV2alpha1Fields restores the fields added in v2alpha1
+func restoreV2alpha1Fields(dst, restored *v2beta1.AzureKeyVaultSecret) {
+	dst.Spec.Vault.AzureIdentity = restored.Spec.Vault.AzureIdentity
+	dst.Spec.Output.ConfigMap = restored.Spec.Output.ConfigMap
+	dst.Status.ConfigMapHash = restored.Status.ConfigMapHash
+	dst.Status.ConfigMapName = restored.Status.ConfigMapName
+}
+
+// restoreChainOrder restores the chain order added in v1
+func restoreChainOrder(dst, restored *v2beta1.AzureKeyVaultSecret) {
+	dst.Spec.Output.Secret.ChainOrder = restored.Spec.Output.Secret.ChainOrder
+}
@@ -0,0 +1,80 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package identity binds Azure identities to the namespaces allowed to use them. Bindings are
+// configured by cluster admins on the akv2k8s components, never by resources in the namespaces,
+// so a namespace cannot use an identity of another namespace by naming it.
+package identity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Bindings are the Azure identities each namespace is allowed to use
+type Bindings map[string]map[string]bool
+
+// ParseBindings parses bindings on the form namespace=identity[,identity...][;namespace=...], e.g.
+//
+//	team1=team1-identity;team2=team2-identity,shared-identity
+//
+// An empty value binds no identities to any namespace.
+func ParseBindings(value string) (Bindings, error) {
+	bindings := make(Bindings)
+	for _, binding := range strings.Split(value, ";") {
+		binding = strings.TrimSpace(binding)
+		if binding == "" {
+			continue
+		}
+
+		split := strings.SplitN(binding, "=", 2)
+		namespace := strings.TrimSpace(split[0])
+		if len(split) != 2 || namespace == "" {
+			return nil, fmt.Errorf("identity binding '%s' must be on the form namespace=identity[,identity...]", binding)
+		}
+
+		for _, identity := range strings.Split(split[1], ",") {
+			identity = strings.TrimSpace(identity)
+			if identity == "" {
+				return nil, fmt.Errorf("identity binding '%s' has an empty identity", binding)
+			}
+			if bindings[namespace] == nil {
+				bindings[namespace] = make(map[string]bool)
+			}
+			bindings[namespace][identity] = true
+		}
+	}
+	return bindings, nil
+}
+
+// Authorize returns an error unless identity is bound to namespace
+func (b Bindings) Authorize(namespace, identity string) error {
+	if b[namespace][identity] {
+		return nil
+	}
+	return fmt.Errorf("azure identity '%s' is not bound to namespace '%s'", identity, namespace)
+}
+
+// Namespaces returns the namespaces with bound identities, sorted
+func (b Bindings) Namespaces() []string {
+	namespaces := make([]string, 0, len(b))
+	for namespace := range b {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
@@ -0,0 +1,65 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package identity
+
+import (
+	"testing"
+)
+
+func TestParseBindings(t *testing.T) {
+	bindings, err := ParseBindings(" team1=team1-identity ; team2=team2-identity, shared-identity;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		namespace string
+		identity  string
+		allowed   bool
+	}{
+		{"team1", "team1-identity", true},
+		{"team2", "team2-identity", true},
+		{"team2", "shared-identity", true},
+		{"team1", "team2-identity", false},
+		{"team1", "shared-identity", false},
+		{"team3", "team1-identity", false},
+	}
+	for _, test := range tests {
+		if err := bindings.Authorize(test.namespace, test.identity); (err == nil) != test.allowed {
+			t.Errorf("expected identity '%s' allowed in namespace '%s' to be %t, got error %v", test.identity, test.namespace, test.allowed, err)
+		}
+	}
+	if namespaces := bindings.Namespaces(); len(namespaces) != 2 || namespaces[0] != "team1" || namespaces[1] != "team2" {
+		t.Errorf("expected namespaces team1 and team2, got %v", namespaces)
+	}
+}
+
+func TestParseBindingsInvalid(t *testing.T) {
+	for _, value := range []string{"team1", "=identity", "team1=", "team1=identity,"} {
+		if _, err := ParseBindings(value); err == nil {
+			t.Errorf("expected error for '%s'", value)
+		}
+	}
+
+	bindings, err := ParseBindings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bindings.Authorize("team1", "identity"); err == nil {
+		t.Error("expected no identities to be bound")
+	}
+}
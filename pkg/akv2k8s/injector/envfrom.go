@@ -0,0 +1,154 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// EnvFromQuery is the query expanding every key of a multi-key-value secret into an env var,
+	// named by the key prefixed with the name of the referencing env var, e.g. DB_=my-akvs@azurekeyvault?*
+	EnvFromQuery = "*"
+
+	// EnvFromKeyCaseAnnotation is a pod annotation setting the case of keys expanded into env var names -
+	// preserve (default), upper or lower
+	EnvFromKeyCaseAnnotation = "akv2k8s.io/env-from-key-case"
+
+	// EnvFromKeyReplacementAnnotation is a pod annotation replacing characters of keys not valid in
+	// env var names, default _
+	EnvFromKeyReplacementAnnotation = "akv2k8s.io/env-from-key-replacement"
+
+	defaultEnvFromKeyReplacement = "_"
+)
+
+// EnvFromKeyCase is the case of keys expanded into env var names
+type EnvFromKeyCase string
+
+const (
+	// EnvFromKeyCasePreserve keeps the case of the key
+	EnvFromKeyCasePreserve EnvFromKeyCase = "preserve"
+
+	// EnvFromKeyCaseUpper converts the key to upper case
+	EnvFromKeyCaseUpper EnvFromKeyCase = "upper"
+
+	// EnvFromKeyCaseLower converts the key to lower case
+	EnvFromKeyCaseLower EnvFromKeyCase = "lower"
+)
+
+// EnvFromOptions are the rules for naming env vars expanded from the keys of a multi-key-value secret
+type EnvFromOptions struct {
+	// KeyCase is the case of keys in env var names
+	KeyCase EnvFromKeyCase `json:"keyCase"`
+
+	// Replacement replaces each character of keys not valid in env var names, may be empty to remove them
+	Replacement string `json:"replacement"`
+}
+
+// DefaultEnvFromOptions returns the options used when not set by annotations
+func DefaultEnvFromOptions() EnvFromOptions {
+	return EnvFromOptions{
+		KeyCase:     EnvFromKeyCasePreserve,
+		Replacement: defaultEnvFromKeyReplacement,
+	}
+}
+
+// ParseEnvFromOptions parses the env from options in the annotations of a pod, returning nil if not annotated
+func ParseEnvFromOptions(annotations map[string]string) (*EnvFromOptions, error) {
+	keyCase, keyCaseOk := annotations[EnvFromKeyCaseAnnotation]
+	replacement, replacementOk := annotations[EnvFromKeyReplacementAnnotation]
+	if !keyCaseOk && !replacementOk {
+		return nil, nil
+	}
+
+	options := DefaultEnvFromOptions()
+	if keyCaseOk {
+		options.KeyCase = EnvFromKeyCase(strings.ToLower(keyCase))
+	}
+	if replacementOk {
+		options.Replacement = replacement
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// Validate checks that the options are valid
+func (o EnvFromOptions) Validate() error {
+	switch o.KeyCase {
+	case EnvFromKeyCasePreserve, EnvFromKeyCaseUpper, EnvFromKeyCaseLower:
+	default:
+		return fmt.Errorf("unsupported env from key case '%s', must be '%s', '%s' or '%s'", o.KeyCase, EnvFromKeyCasePreserve, EnvFromKeyCaseUpper, EnvFromKeyCaseLower)
+	}
+
+	if strings.IndexFunc(o.Replacement, func(r rune) bool { return !isEnvNameRune(r) }) >= 0 {
+		return fmt.Errorf("env from key replacement '%s' must only contain letters, digits and '_'", o.Replacement)
+	}
+	return nil
+}
+
+// EnvName returns the name of the env var for key, prefixed with prefix
+func (o EnvFromOptions) EnvName(prefix, key string) string {
+	switch o.KeyCase {
+	case EnvFromKeyCaseUpper:
+		key = strings.ToUpper(key)
+	case EnvFromKeyCaseLower:
+		key = strings.ToLower(key)
+	}
+
+	var name strings.Builder
+	name.WriteString(prefix)
+	for _, r := range key {
+		if isEnvNameRune(r) {
+			name.WriteRune(r)
+		} else {
+			name.WriteString(o.Replacement)
+		}
+	}
+	return name.String()
+}
+
+// ExpandEnvFrom expands value, the resolved keys of a multi-key-value secret, into env vars prefixed with prefix
+func ExpandEnvFrom(prefix, value string, options EnvFromOptions) (map[string]string, error) {
+	var keys map[string]string
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse keys of secret, error: %+v", err)
+	}
+
+	env := make(map[string]string, len(keys))
+	sources := make(map[string]string, len(keys))
+	for key, val := range keys {
+		name := options.EnvName(prefix, key)
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			return nil, fmt.Errorf("key '%s' gives invalid env var name '%s' - use a prefix", key, name)
+		}
+		if other, ok := sources[name]; ok {
+			return nil, fmt.Errorf("keys '%s' and '%s' both give env var name '%s'", other, key, name)
+		}
+		sources[name] = key
+		env[name] = val
+	}
+	return env, nil
+}
+
+func isEnvNameRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,86 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import "testing"
+
+func TestExpandEnvFrom(t *testing.T) {
+	value := `{"host": "db.local", "port": "5432", "user.name": "admin", "Pass-Word": "secret"}`
+
+	tests := []struct {
+		options EnvFromOptions
+		want    map[string]string
+	}{
+		{
+			options: DefaultEnvFromOptions(),
+			want:    map[string]string{"DB_host": "db.local", "DB_port": "5432", "DB_user_name": "admin", "DB_Pass_Word": "secret"},
+		},
+		{
+			options: EnvFromOptions{KeyCase: EnvFromKeyCaseUpper, Replacement: ""},
+			want:    map[string]string{"DB_HOST": "db.local", "DB_PORT": "5432", "DB_USERNAME": "admin", "DB_PASSWORD": "secret"},
+		},
+		{
+			options: EnvFromOptions{KeyCase: EnvFromKeyCaseLower, Replacement: "__"},
+			want:    map[string]string{"DB_host": "db.local", "DB_port": "5432", "DB_user__name": "admin", "DB_pass__word": "secret"},
+		},
+	}
+
+	for _, test := range tests {
+		env, err := ExpandEnvFrom("DB_", value, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(env) != len(test.want) {
+			t.Errorf("expected %v with options %+v, got %v", test.want, test.options, env)
+		}
+		for name, val := range test.want {
+			if env[name] != val {
+				t.Errorf("expected %s=%s with options %+v, got %v", name, val, test.options, env)
+			}
+		}
+	}
+
+	if _, err := ExpandEnvFrom("DB_", `{"user.name": "a", "user_name": "b"}`, DefaultEnvFromOptions()); err == nil {
+		t.Error("expected error for keys giving the same env var name")
+	}
+	if _, err := ExpandEnvFrom("DB_", "user=admin", DefaultEnvFromOptions()); err == nil {
+		t.Error("expected error for value not containing keys")
+	}
+}
+
+func TestParseEnvFromOptions(t *testing.T) {
+	if options, err := ParseEnvFromOptions(map[string]string{}); err != nil || options != nil {
+		t.Errorf("expected no options without annotations, got %+v, error: %+v", options, err)
+	}
+
+	options, err := ParseEnvFromOptions(map[string]string{EnvFromKeyCaseAnnotation: "Upper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.KeyCase != EnvFromKeyCaseUpper || options.Replacement != defaultEnvFromKeyReplacement {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	for _, annotations := range []map[string]string{
+		{EnvFromKeyCaseAnnotation: "camel"},
+		{EnvFromKeyReplacementAnnotation: "-"},
+	} {
+		if _, err := ParseEnvFromOptions(annotations); err == nil {
+			t.Errorf("expected error parsing env from options %v", annotations)
+		}
+	}
+}
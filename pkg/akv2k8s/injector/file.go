@@ -0,0 +1,309 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// SecretFilesAnnotation is a pod annotation listing AzureKeyVaultSecrets to write to files, e.g.
+	//
+	//   akv2k8s.io/secret-files: |
+	//     - path: tls/server.pfx
+	//       secret: my-certificate
+	//       format: pfx
+	//       mode: "0400"
+	//       owner: "101:101"
+	//       containers: [nginx]
+	SecretFilesAnnotation = "akv2k8s.io/secret-files"
+
+	// SecretFilesDirAnnotation is a pod annotation overriding the directory secret files are written to
+	SecretFilesDirAnnotation = "akv2k8s.io/secret-files-dir"
+
+	// SecretFilesRefreshIntervalAnnotation is a pod annotation enabling a sidecar refreshing the secret files
+	// every interval (e.g. 5m), so that rotated secrets reach running processes
+	SecretFilesRefreshIntervalAnnotation = "akv2k8s.io/secret-files-refresh-interval"
+
+	// SecretFilesReloadSignalAnnotation is a pod annotation naming a signal (e.g. SIGHUP) to send to the
+	// process in SecretFilesReloadProcessAnnotation when refreshed secret files change
+	SecretFilesReloadSignalAnnotation = "akv2k8s.io/secret-files-reload-signal"
+
+	// SecretFilesReloadProcessAnnotation is a pod annotation with the name of the process to signal
+	SecretFilesReloadProcessAnnotation = "akv2k8s.io/secret-files-reload-process"
+
+	// SecretFilesReloadURLAnnotation is a pod annotation with a url to POST to when refreshed secret files change
+	SecretFilesReloadURLAnnotation = "akv2k8s.io/secret-files-reload-url"
+
+	// MinSecretFilesRefreshInterval is the shortest refresh interval allowed, to avoid throttling by Azure Key Vault
+	MinSecretFilesRefreshInterval = time.Minute
+
+	// DefaultSecretFilesDir is the directory secret files are written to, unless overridden by SecretFilesDirAnnotation
+	DefaultSecretFilesDir = "/azure-keyvault-secrets/"
+
+	defaultSecretFileMode = os.FileMode(0400)
+)
+
+// SecretFileFormat is the format of a secret file
+type SecretFileFormat string
+
+const (
+	// SecretFileFormatRaw writes the value as is
+	SecretFileFormatRaw SecretFileFormat = "raw"
+
+	// SecretFileFormatPEM writes the value as is, after validating it contains PEM blocks
+	SecretFileFormatPEM SecretFileFormat = "pem"
+
+	// SecretFileFormatPFX writes a certificate and its private key as stored in Azure Key Vault in PKCS #12 format
+	SecretFileFormatPFX SecretFileFormat = "pfx"
+)
+
+// SecretFile is a file written with the value of an AzureKeyVaultSecret before the process is started
+type SecretFile struct {
+	// Path of the file, relative to the secret files directory
+	Path string `json:"path"`
+
+	// Secret is the name of the AzureKeyVaultSecret, optionally with a query, e.g. my-certificate?tls.key
+	Secret string `json:"secret"`
+
+	// Format of the file - raw (default), pem or pfx
+	Format SecretFileFormat `json:"format,omitempty"`
+
+	// Mode is the octal file mode, default 0400
+	Mode string `json:"mode,omitempty"`
+
+	// Owner is the uid, optionally followed by :gid, owning the file
+	Owner string `json:"owner,omitempty"`
+
+	// Containers are the names of the containers writing the file, default the first container of the pod
+	Containers []string `json:"containers,omitempty"`
+}
+
+// ReloadSignals are the signals that can be sent to a process when refreshed secret files change
+var ReloadSignals = []string{"SIGHUP", "SIGUSR1", "SIGUSR2", "SIGINT", "SIGQUIT", "SIGTERM"}
+
+// SecretFilesRefresh configures refreshing of secret files after the process is started
+type SecretFilesRefresh struct {
+	Interval      time.Duration
+	ReloadSignal  string
+	ReloadProcess string
+	ReloadURL     string
+}
+
+// ParseSecretFilesRefresh parses the refresh configuration in the annotations of a pod,
+// returning nil if refresh is not enabled
+func ParseSecretFilesRefresh(annotations map[string]string) (*SecretFilesRefresh, error) {
+	interval, ok := annotations[SecretFilesRefreshIntervalAnnotation]
+	if !ok {
+		for _, annotation := range []string{SecretFilesReloadSignalAnnotation, SecretFilesReloadProcessAnnotation, SecretFilesReloadURLAnnotation} {
+			if _, ok := annotations[annotation]; ok {
+				return nil, fmt.Errorf("annotation %s requires %s", annotation, SecretFilesRefreshIntervalAnnotation)
+			}
+		}
+		return nil, nil
+	}
+
+	refresh := &SecretFilesRefresh{
+		ReloadSignal:  strings.ToUpper(annotations[SecretFilesReloadSignalAnnotation]),
+		ReloadProcess: annotations[SecretFilesReloadProcessAnnotation],
+		ReloadURL:     annotations[SecretFilesReloadURLAnnotation],
+	}
+
+	var err error
+	if refresh.Interval, err = time.ParseDuration(interval); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s, error: %+v", SecretFilesRefreshIntervalAnnotation, err)
+	}
+	if err := refresh.Validate(); err != nil {
+		return nil, err
+	}
+	return refresh, nil
+}
+
+// Validate checks that the refresh configuration is valid
+func (r SecretFilesRefresh) Validate() error {
+	if r.Interval < MinSecretFilesRefreshInterval {
+		return fmt.Errorf("secret files refresh interval %s is shorter than the minimum of %s", r.Interval, MinSecretFilesRefreshInterval)
+	}
+
+	if r.ReloadSignal != "" {
+		supported := false
+		for _, signal := range ReloadSignals {
+			supported = supported || signal == r.ReloadSignal
+		}
+		if !supported {
+			return fmt.Errorf("unsupported reload signal '%s', must be one of %s", r.ReloadSignal, strings.Join(ReloadSignals, ", "))
+		}
+		if r.ReloadProcess == "" {
+			return fmt.Errorf("reload signal '%s' requires the name of the process to signal in annotation %s", r.ReloadSignal, SecretFilesReloadProcessAnnotation)
+		}
+	} else if r.ReloadProcess != "" {
+		return fmt.Errorf("reload process '%s' requires a signal to send in annotation %s", r.ReloadProcess, SecretFilesReloadSignalAnnotation)
+	}
+
+	if r.ReloadURL != "" {
+		u, err := url.Parse(r.ReloadURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("reload url '%s' must be an absolute http or https url", r.ReloadURL)
+		}
+	}
+	return nil
+}
+
+// ParseSecretFiles parses the secret files in the yaml or json value of SecretFilesAnnotation
+func ParseSecretFiles(value string) ([]SecretFile, error) {
+	var files []SecretFile
+	if err := yaml.UnmarshalStrict([]byte(value), &files); err != nil {
+		return nil, fmt.Errorf("failed to parse secret files, error: %+v", err)
+	}
+
+	paths := make(map[string]bool)
+	for _, file := range files {
+		if err := file.Validate(); err != nil {
+			return nil, err
+		}
+		if paths[path.Clean(file.Path)] {
+			return nil, fmt.Errorf("secret file '%s' defined more than once", file.Path)
+		}
+		paths[path.Clean(file.Path)] = true
+	}
+	return files, nil
+}
+
+// Validate checks that the secret file is valid
+func (f SecretFile) Validate() error {
+	if f.Path == "" || path.IsAbs(f.Path) || path.Clean(f.Path) == "." || strings.HasPrefix(path.Clean(f.Path), "..") {
+		return fmt.Errorf("secret file path '%s' must be relative to the secret files directory", f.Path)
+	}
+
+	ref, err := ParseReference(f.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid secret for secret file '%s', error: %+v", f.Path, err)
+	}
+
+	switch f.Format {
+	case "", SecretFileFormatRaw, SecretFileFormatPEM:
+	case SecretFileFormatPFX:
+		if ref.Query != "" {
+			return fmt.Errorf("secret file '%s' in pfx format cannot have a query", f.Path)
+		}
+	default:
+		return fmt.Errorf("secret file '%s' has unsupported format '%s', must be '%s', '%s' or '%s'", f.Path, f.Format, SecretFileFormatRaw, SecretFileFormatPEM, SecretFileFormatPFX)
+	}
+
+	if _, err := f.FileMode(); err != nil {
+		return err
+	}
+	if _, _, err := f.Ownership(); err != nil {
+		return err
+	}
+	for _, container := range f.Containers {
+		if container == "" {
+			return fmt.Errorf("secret file '%s' has an empty container name", f.Path)
+		}
+	}
+	return nil
+}
+
+// WrittenBy returns true if the file is written by the container named name, where first tells
+// if it is the first container of the pod
+func (f SecretFile) WrittenBy(name string, first bool) bool {
+	if len(f.Containers) == 0 {
+		return first
+	}
+	for _, container := range f.Containers {
+		if container == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reference returns the AzureKeyVaultSecret reference to resolve for the value of the file
+func (f SecretFile) Reference() string {
+	if f.Format == SecretFileFormatPFX {
+		return fmt.Sprintf("%s%s?%s", f.Secret, EnvLookupKey, CertificateQueryPFX)
+	}
+
+	ref, err := ParseReference(f.Secret)
+	if err != nil || ref.Query == "" {
+		return f.Secret + EnvLookupKey
+	}
+	return fmt.Sprintf("%s%s?%s", ref.Name, EnvLookupKey, ref.Query)
+}
+
+// FileMode returns the mode of the file
+func (f SecretFile) FileMode() (os.FileMode, error) {
+	if f.Mode == "" {
+		return defaultSecretFileMode, nil
+	}
+
+	mode, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("secret file '%s' has invalid mode '%s', must be octal permission bits, e.g. 0400", f.Path, f.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Ownership returns the uid and gid owning the file, -1 if not set
+func (f SecretFile) Ownership() (int, int, error) {
+	if f.Owner == "" {
+		return -1, -1, nil
+	}
+
+	owner := strings.SplitN(f.Owner, ":", 2)
+	uid, err := strconv.Atoi(owner[0])
+	if err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("secret file '%s' has invalid owner '%s', must be uid or uid:gid", f.Path, f.Owner)
+	}
+
+	gid := -1
+	if len(owner) == 2 {
+		if gid, err = strconv.Atoi(owner[1]); err != nil || gid < 0 {
+			return 0, 0, fmt.Errorf("secret file '%s' has invalid owner '%s', must be uid or uid:gid", f.Path, f.Owner)
+		}
+	}
+	return uid, gid, nil
+}
+
+// Content converts the resolved value of Reference to the content of the file
+func (f SecretFile) Content(value string) ([]byte, error) {
+	switch f.Format {
+	case SecretFileFormatPEM:
+		if block, _ := pem.Decode([]byte(value)); block == nil {
+			return nil, fmt.Errorf("value of secret file '%s' is not in pem format", f.Path)
+		}
+		return []byte(value), nil
+	case SecretFileFormatPFX:
+		pfx, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode pfx of secret file '%s', error: %+v", f.Path, err)
+		}
+		return pfx, nil
+	default:
+		return []byte(value), nil
+	}
+}
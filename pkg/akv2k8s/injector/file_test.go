@@ -0,0 +1,167 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSecretFiles(t *testing.T) {
+	files, err := ParseSecretFiles(`
+- path: tls/tls.crt
+  secret: my-cert?tls.crt
+  format: pem
+- path: keystore.pfx
+  secret: my-cert
+  format: pfx
+  mode: "0440"
+  owner: "101:102"
+- path: password
+  secret: my-secret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 secret files, got %d", len(files))
+	}
+
+	references := []string{"my-cert@azurekeyvault?tls.crt", "my-cert@azurekeyvault?pfx", "my-secret@azurekeyvault"}
+	for i, file := range files {
+		if ref := file.Reference(); ref != references[i] {
+			t.Errorf("expected reference '%s', got '%s'", references[i], ref)
+		}
+	}
+
+	if mode, _ := files[1].FileMode(); mode != os.FileMode(0440) {
+		t.Errorf("expected mode 0440, got %o", mode)
+	}
+	if uid, gid, _ := files[1].Ownership(); uid != 101 || gid != 102 {
+		t.Errorf("expected owner 101:102, got %d:%d", uid, gid)
+	}
+	if mode, _ := files[2].FileMode(); mode != defaultSecretFileMode {
+		t.Errorf("expected default mode %o, got %o", defaultSecretFileMode, mode)
+	}
+	if uid, gid, _ := files[2].Ownership(); uid != -1 || gid != -1 {
+		t.Errorf("expected no owner, got %d:%d", uid, gid)
+	}
+}
+
+func TestParseInvalidSecretFiles(t *testing.T) {
+	invalid := []string{
+		`- path: /etc/secret
+  secret: my-secret`,
+		`- path: ../secret
+  secret: my-secret`,
+		`- path: secret`,
+		`- path: secret
+  secret: my-secret
+  format: jks`,
+		`- path: secret
+  secret: my-cert?tls.key
+  format: pfx`,
+		`- path: secret
+  secret: my-secret
+  mode: "0999"`,
+		`- path: secret
+  secret: my-secret
+  owner: root`,
+		`- path: secret
+  secret: my-secret
+- path: ./secret
+  secret: other-secret`,
+		`- path: secret
+  secret: my-secret
+  unknown: field`,
+	}
+
+	for _, value := range invalid {
+		if _, err := ParseSecretFiles(value); err == nil {
+			t.Errorf("expected error parsing secret files:\n%s", value)
+		}
+	}
+}
+
+func TestSecretFileWrittenBy(t *testing.T) {
+	file := SecretFile{Path: "password", Secret: "my-secret"}
+	if !file.WrittenBy("app", true) || file.WrittenBy("sidecar", false) {
+		t.Error("expected file without containers to be written by the first container only")
+	}
+
+	file.Containers = []string{"nginx", "sidecar"}
+	if file.WrittenBy("app", true) || !file.WrittenBy("nginx", false) || !file.WrittenBy("sidecar", false) {
+		t.Error("expected file to be written by its containers only")
+	}
+}
+
+func TestSecretFileContent(t *testing.T) {
+	pemFile := SecretFile{Path: "tls.crt", Secret: "my-cert", Format: SecretFileFormatPEM}
+	if _, err := pemFile.Content("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"); err != nil {
+		t.Errorf("expected pem to be valid, error: %+v", err)
+	}
+	if _, err := pemFile.Content("not pem"); err == nil {
+		t.Error("expected error for value not in pem format")
+	}
+
+	pfxFile := SecretFile{Path: "keystore.pfx", Secret: "my-cert", Format: SecretFileFormatPFX}
+	content, err := pfxFile.Content("AQID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "\x01\x02\x03" {
+		t.Errorf("expected pfx to be base64 decoded, got %v", content)
+	}
+
+	rawFile := SecretFile{Path: "password", Secret: "my-secret"}
+	if content, _ := rawFile.Content("AQID"); string(content) != "AQID" {
+		t.Errorf("expected raw value, got '%s'", content)
+	}
+}
+
+func TestParseSecretFilesRefresh(t *testing.T) {
+	if refresh, err := ParseSecretFilesRefresh(map[string]string{}); err != nil || refresh != nil {
+		t.Errorf("expected no refresh without annotation, got %+v, error: %+v", refresh, err)
+	}
+
+	refresh, err := ParseSecretFilesRefresh(map[string]string{
+		SecretFilesRefreshIntervalAnnotation: "10m",
+		SecretFilesReloadURLAnnotation:       "http://localhost:8080/-/reload",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh.Interval != 10*time.Minute || refresh.ReloadURL != "http://localhost:8080/-/reload" {
+		t.Errorf("unexpected refresh %+v", refresh)
+	}
+
+	invalid := []map[string]string{
+		{SecretFilesRefreshIntervalAnnotation: "often"},
+		{SecretFilesRefreshIntervalAnnotation: "5s"},
+		{SecretFilesRefreshIntervalAnnotation: "5m", SecretFilesReloadSignalAnnotation: "SIGKILL", SecretFilesReloadProcessAnnotation: "nginx"},
+		{SecretFilesRefreshIntervalAnnotation: "5m", SecretFilesReloadSignalAnnotation: "SIGHUP"},
+		{SecretFilesRefreshIntervalAnnotation: "5m", SecretFilesReloadProcessAnnotation: "nginx"},
+		{SecretFilesRefreshIntervalAnnotation: "5m", SecretFilesReloadURLAnnotation: "localhost:8080"},
+		{SecretFilesReloadURLAnnotation: "http://localhost:8080/-/reload"},
+	}
+	for _, annotations := range invalid {
+		if _, err := ParseSecretFilesRefresh(annotations); err == nil {
+			t.Errorf("expected error parsing secret files refresh %v", annotations)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
+	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+)
+
+// EnvLookupKey marks an env var value as a reference to an AzureKeyVaultSecret
+const EnvLookupKey = "@azurekeyvault"
+
+// Reference is a reference to an AzureKeyVaultSecret in an env var,
+// e.g. my-akvs@azurekeyvault?some-sub-key
+type Reference struct {
+	Name  string
+	Query string
+}
+
+// IsReference returns true if value references an AzureKeyVaultSecret
+func IsReference(value string) bool {
+	return strings.Contains(value, EnvLookupKey)
+}
+
+// ParseReference parses the AzureKeyVaultSecret reference in value
+func ParseReference(value string) (*Reference, error) {
+	// e.g. my-akv-secret-name?some-sub-key
+	name := strings.Join(strings.Split(value, EnvLookupKey), "")
+	if name == "" {
+		return nil, fmt.Errorf("error extracting secret name from '%s'", value)
+	}
+
+	ref := &Reference{Name: name}
+	if query := strings.Split(name, "?"); len(query) > 1 {
+		if len(query) > 2 {
+			return nil, fmt.Errorf("multiple query elements defined with '?' in '%s' - only one supported", value)
+		}
+		ref.Name = query[0]
+		ref.Query = query[1]
+	}
+	return ref, nil
+}
+
+// IsEnvFrom returns true if the reference expands every key of a multi-key-value secret into env vars
+func (r Reference) IsEnvFrom() bool {
+	return r.Query == EnvFromQuery
+}
+
+// GetSecretFromKeyVault gets the value of the Azure Key Vault object of azureKeyVaultSecret, formatted according to query
+func GetSecretFromKeyVault(ctx context.Context, azureKeyVaultSecret *akv.AzureKeyVaultSecret, query string, vaultService vault.Service) (string, error) {
+	var secretHandler EnvSecretHandler
+
+	switch azureKeyVaultSecret.Spec.Vault.Object.Type {
+	case akv.AzureKeyVaultObjectTypeSecret:
+		transformator, err := transformers.CreateTransformator(&azureKeyVaultSecret.Spec.Output)
+		if err != nil {
+			return "", err
+		}
+		secretHandler = NewAzureKeyVaultSecretHandler(azureKeyVaultSecret, query, *transformator, vaultService)
+	case akv.AzureKeyVaultObjectTypeCertificate:
+		secretHandler = NewAzureKeyVaultCertificateHandler(azureKeyVaultSecret, query, vaultService)
+	case akv.AzureKeyVaultObjectTypeKey:
+		secretHandler = NewAzureKeyVaultKeyHandler(azureKeyVaultSecret, query, vaultService)
+	case akv.AzureKeyVaultObjectTypeMultiKeyValueSecret:
+		secretHandler = NewAzureKeyVaultMultiKeySecretHandler(azureKeyVaultSecret, query, vaultService)
+	default:
+		return "", fmt.Errorf("azure key vault object type '%s' not currently supported", azureKeyVaultSecret.Spec.Vault.Object.Type)
+	}
+	return secretHandler.Handle(ctx)
+}
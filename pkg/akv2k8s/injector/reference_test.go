@@ -0,0 +1,53 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		value string
+		name  string
+		query string
+	}{
+		{value: "my-secret@azurekeyvault", name: "my-secret"},
+		{value: "my-secret@azurekeyvault?username", name: "my-secret", query: "username"},
+		{value: "my-secret@azurekeyvault?*", name: "my-secret", query: EnvFromQuery},
+	}
+	for _, test := range tests {
+		if !IsReference(test.value) {
+			t.Errorf("expected '%s' to be a reference", test.value)
+		}
+		ref, err := ParseReference(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Name != test.name || ref.Query != test.query {
+			t.Errorf("expected name '%s' and query '%s' for '%s', got %+v", test.name, test.query, test.value, ref)
+		}
+	}
+
+	for _, value := range []string{"@azurekeyvault", "my-secret@azurekeyvault?a?b"} {
+		if _, err := ParseReference(value); err == nil {
+			t.Errorf("expected error parsing '%s'", value)
+		}
+	}
+
+	if IsReference("my-secret") {
+		t.Error("expected plain value not to be a reference")
+	}
+}
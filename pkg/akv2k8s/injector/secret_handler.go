@@ -14,9 +14,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package injector
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -25,13 +27,18 @@ import (
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
 
+	"golang.org/x/crypto/pkcs12"
 	yaml "gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
 )
 
+// CertificateQueryPFX is the query for a certificate and its private key in PKCS #12 format, base64 encoded.
+// Only supported for certificates stored as pfx in Azure Key Vault.
+const CertificateQueryPFX = "pfx"
+
 // EnvSecretHandler handles getting and formatting secrets from Azure Key Vault to environment variables
 type EnvSecretHandler interface {
-	Handle() (string, error)
+	Handle(ctx context.Context) (string, error)
 }
 
 // AzureKeyVaultSecretHandler handles getting and formatting Azure Key Vault Secret from Azure Key Vault to environment variables
@@ -101,13 +108,13 @@ func NewAzureKeyVaultMultiKeySecretHandler(secretSpec *akv.AzureKeyVaultSecret,
 }
 
 // Handle getting and formating Azure Key Vault Secret from Azure Key Vault to Kubernetes
-func (h *AzureKeyVaultSecretHandler) Handle() (string, error) {
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+func (h *AzureKeyVaultSecretHandler) Handle(ctx context.Context) (string, error) {
+	azureSecret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
 		return "", err
 	}
 
-	secret, err = h.transformator.Transform(secret)
+	secret, err := h.transformator.Transform(azureSecret.Value)
 	if err != nil {
 		return "", err
 	}
@@ -135,13 +142,13 @@ func (h *AzureKeyVaultSecretHandler) Handle() (string, error) {
 }
 
 // Handle getting and formating Azure Key Vault Certificate from Azure Key Vault to Kubernetes
-func (h *AzureKeyVaultCertificateHandler) Handle() (string, error) {
+func (h *AzureKeyVaultCertificateHandler) Handle(ctx context.Context) (string, error) {
 	options := vault.CertificateOptions{
-		ExportPrivateKey:  h.query == corev1.TLSPrivateKeyKey,
+		ExportPrivateKey:  h.query == corev1.TLSPrivateKeyKey || h.query == CertificateQueryPFX,
 		EnsureServerFirst: h.secretSpec.Spec.Output.Secret.ChainOrder == "ensureserverfirst",
 	}
 
-	cert, err := h.vaultService.GetCertificate(&h.secretSpec.Spec.Vault, &options)
+	cert, err := h.vaultService.GetCertificate(ctx, &h.secretSpec.Spec.Vault, &options)
 
 	if err != nil {
 		return "", err
@@ -151,6 +158,13 @@ func (h *AzureKeyVaultCertificateHandler) Handle() (string, error) {
 		return string(cert.ExportRaw()), nil
 	}
 
+	if h.query == CertificateQueryPFX {
+		if _, err := pkcs12.ToPEM(cert.ExportRaw(), ""); err != nil {
+			return "", fmt.Errorf("certificate '%s' is not stored as pfx in azure key vault, error: %+v", h.secretSpec.Spec.Vault.Object.Name, err)
+		}
+		return base64.StdEncoding.EncodeToString(cert.ExportRaw()), nil
+	}
+
 	var privKey []byte
 	var pubKey []byte
 
@@ -168,44 +182,79 @@ func (h *AzureKeyVaultCertificateHandler) Handle() (string, error) {
 }
 
 // Handle getting and formating Azure Key Vault Key from Azure Key Vault to Kubernetes
-func (h *AzureKeyVaultKeyHandler) Handle() (string, error) {
-	key, err := h.vaultService.GetKey(&h.secretSpec.Spec.Vault)
+func (h *AzureKeyVaultKeyHandler) Handle(ctx context.Context) (string, error) {
+	key, err := h.vaultService.GetKey(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
 		return "", err
 	}
 
-	return key, nil
+	var value []byte
+	switch akv.AzureKeyVaultKeyFormat(h.query) {
+	case "":
+		if key.JSONWebKey.N == nil {
+			return "", fmt.Errorf("key '%s' has no rsa modulus - use query 'pem', 'jwk' or 'jwks' to export other key types", h.secretSpec.Spec.Vault.Object.Name)
+		}
+		return *key.JSONWebKey.N, nil
+	case akv.AzureKeyVaultKeyFormatPem:
+		value, err = key.ExportPublicKeyAsPem()
+	case akv.AzureKeyVaultKeyFormatJWK:
+		value, err = key.ExportPublicKeyAsJWK()
+	case akv.AzureKeyVaultKeyFormatJWKS:
+		value, err = key.ExportPublicKeyAsJWKS()
+	default:
+		return "", fmt.Errorf("unable to handle azure key vault key with query '%s' - query is not valid", h.query)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
 }
 
 // Handle getting and formating Azure Key Vault Secret containing mulitple values from Azure Key Vault to Kubernetes
-func (h *AzureKeyVaultMultiValueSecretHandler) Handle() (string, error) {
-	if h.secretSpec.Spec.Vault.Object.ContentType == "" {
-		return "", fmt.Errorf("cannot use '%s' without also specifying content type", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret)
-	}
-
-	secret, err := h.vaultService.GetSecret(&h.secretSpec.Spec.Vault)
+func (h *AzureKeyVaultMultiValueSecretHandler) Handle(ctx context.Context) (string, error) {
+	secret, err := h.vaultService.GetSecret(ctx, &h.secretSpec.Spec.Vault)
 	if err != nil {
 		return "", err
 	}
 
+	// Fall back to the content type set on the secret in Azure Key Vault
+	contentType := h.secretSpec.Spec.Vault.Object.ContentType
+	if contentType == "" {
+		switch akv.AzureKeyVaultObjectContentType(secret.ContentType) {
+		case akv.AzureKeyVaultObjectContentTypeJSON, akv.AzureKeyVaultObjectContentTypeYaml:
+			contentType = akv.AzureKeyVaultObjectContentType(secret.ContentType)
+		default:
+			return "", fmt.Errorf("cannot use '%s' without also specifying content type", akv.AzureKeyVaultObjectTypeMultiKeyValueSecret)
+		}
+	}
+
 	var dat map[string]string
 
-	switch h.secretSpec.Spec.Vault.Object.ContentType {
+	switch contentType {
 	case akv.AzureKeyVaultObjectContentTypeJSON:
-		if err := json.Unmarshal([]byte(secret), &dat); err != nil {
+		if err := json.Unmarshal([]byte(secret.Value), &dat); err != nil {
 			return "", err
 		}
 	case akv.AzureKeyVaultObjectContentTypeYaml:
-		if err := yaml.Unmarshal([]byte(secret), &dat); err != nil {
+		if err := yaml.Unmarshal([]byte(secret.Value), &dat); err != nil {
 			return "", err
 		}
 	default:
-		return "", fmt.Errorf("content type '%s' not supported", h.secretSpec.Spec.Vault.Object.ContentType)
+		return "", fmt.Errorf("content type '%s' not supported", contentType)
+	}
+
+	// All keys are returned as json, for the env-injector to expand into env vars
+	if h.query == EnvFromQuery {
+		all, err := json.Marshal(dat)
+		if err != nil {
+			return "", err
+		}
+		return string(all), nil
 	}
 
 	if val, ok := dat[h.query]; ok {
 		return val, nil
 	}
 
-	return "", fmt.Errorf("key '%s' not found in azure key vault secret '%s' of type '%s'", h.query, h.secretSpec.Spec.Vault.Object.Name, h.secretSpec.Spec.Vault.Object.ContentType)
+	return "", fmt.Errorf("key '%s' not found in azure key vault secret '%s' of type '%s'", h.query, h.secretSpec.Spec.Vault.Object.Name, contentType)
 }
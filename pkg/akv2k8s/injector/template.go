@@ -0,0 +1,183 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package injector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFunc is the template function referencing an AzureKeyVaultSecret
+const templateFunc = "akvs"
+
+var templatePattern = regexp.MustCompile(`\{\{-?\s*` + templateFunc + `\s`)
+
+// Template is an env var value mixing literal text with references to AzureKeyVaultSecrets, e.g.
+//
+//	postgres://{{ akvs "db-user" }}:{{ akvs "db-pass" }}@host/db
+//
+// The name of an AzureKeyVaultSecret can have a query, e.g. {{ akvs "db-creds?password" }}
+type Template struct {
+	tmpl       *template.Template
+	references []string
+}
+
+// IsTemplate returns true if value is a template referencing AzureKeyVaultSecrets
+func IsTemplate(value string) bool {
+	return templatePattern.MatchString(value)
+}
+
+// ParseTemplate parses the template in value, failing unless every reference is a literal AzureKeyVaultSecret name
+func ParseTemplate(value string) (*Template, error) {
+	tmpl, err := template.New("env").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{templateFunc: func(string) (string, error) { return "", nil }}).
+		Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template, error: %+v", err)
+	}
+
+	t := &Template{tmpl: tmpl}
+	seen := make(map[string]bool)
+	var walkErr error
+	walkTemplate(tmpl.Tree.Root, func(cmd *parse.CommandNode) {
+		if walkErr != nil {
+			return
+		}
+		if len(cmd.Args) != 2 {
+			walkErr = fmt.Errorf("'%s' in template must have exactly one argument, the name of an azurekeyvaultsecret", cmd)
+			return
+		}
+		name, ok := cmd.Args[1].(*parse.StringNode)
+		if !ok {
+			walkErr = fmt.Errorf("'%s' in template must reference an azurekeyvaultsecret by a quoted name", cmd)
+			return
+		}
+
+		ref, err := templateReference(name.Text)
+		if err != nil {
+			walkErr = fmt.Errorf("invalid reference '%s' in template, error: %+v", name.Text, err)
+			return
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			t.references = append(t.references, ref)
+		}
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if len(t.references) == 0 {
+		return nil, fmt.Errorf("template does not reference any azurekeyvaultsecret")
+	}
+	return t, nil
+}
+
+// References returns the AzureKeyVaultSecret references of the template, e.g. db-creds@azurekeyvault?password
+func (t *Template) References() []string {
+	return t.references
+}
+
+// Execute renders the template with the resolved values of its references, keyed by reference
+func (t *Template) Execute(values map[string]string) (string, error) {
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+
+	tmpl.Funcs(template.FuncMap{templateFunc: func(name string) (string, error) {
+		ref, err := templateReference(name)
+		if err != nil {
+			return "", err
+		}
+		value, ok := values[ref]
+		if !ok {
+			return "", fmt.Errorf("reference '%s' not resolved", ref)
+		}
+		return value, nil
+	}})
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, nil); err != nil {
+		return "", fmt.Errorf("failed to render template, error: %+v", err)
+	}
+	return out.String(), nil
+}
+
+// templateReference returns the reference for name in a template, in the same form as in an env var
+func templateReference(name string) (string, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(name, EnvLookupKey) {
+		return "", fmt.Errorf("name must not contain '%s'", EnvLookupKey)
+	}
+	if ref.IsEnvFrom() {
+		return "", fmt.Errorf("query '%s' is not supported in templates", EnvFromQuery)
+	}
+	if ref.Query == "" {
+		return ref.Name + EnvLookupKey, nil
+	}
+	return fmt.Sprintf("%s%s?%s", ref.Name, EnvLookupKey, ref.Query), nil
+}
+
+// walkTemplate calls fn for each command calling templateFunc in the tree of node
+func walkTemplate(node parse.Node, fn func(*parse.CommandNode)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplate(child, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplate(cmd, fn)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 0 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == templateFunc {
+				fn(n)
+			}
+		}
+		for _, arg := range n.Args {
+			walkTemplate(arg, fn)
+		}
+	case *parse.IfNode:
+		walkTemplate(n.Pipe, fn)
+		walkTemplate(n.List, fn)
+		walkTemplate(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplate(n.Pipe, fn)
+		walkTemplate(n.List, fn)
+		walkTemplate(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplate(n.Pipe, fn)
+		walkTemplate(n.List, fn)
+		walkTemplate(n.ElseList, fn)
+	}
+}
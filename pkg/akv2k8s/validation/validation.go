@@ -0,0 +1,242 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation validates AzureKeyVaultSecret resources using the same
+// rules as the controller applies when syncing them, so invalid resources can
+// be rejected when applied instead of failing at sync time.
+//
+// A multi-key-value-secret without content type is allowed, as the content
+// type may be set on the secret in Azure Key Vault.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/transformers"
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var supportedObjectTypes = []string{
+	string(akv.AzureKeyVaultObjectTypeSecret),
+	string(akv.AzureKeyVaultObjectTypeCertificate),
+	string(akv.AzureKeyVaultObjectTypeKey),
+	string(akv.AzureKeyVaultObjectTypeMultiKeyValueSecret),
+}
+
+var supportedContentTypes = []string{
+	string(akv.AzureKeyVaultObjectContentTypeJSON),
+	string(akv.AzureKeyVaultObjectContentTypeYaml),
+}
+
+var supportedKeyFormats = []string{
+	string(akv.AzureKeyVaultKeyFormatPem),
+	string(akv.AzureKeyVaultKeyFormatJWK),
+	string(akv.AzureKeyVaultKeyFormatJWKS),
+}
+
+// secretTypesForSecret are the Kubernetes Secret types a single Azure Key Vault secret can be synced to
+var secretTypesForSecret = []string{
+	string(corev1.SecretTypeOpaque),
+	string(corev1.SecretTypeBasicAuth),
+	string(corev1.SecretTypeDockerConfigJson),
+	string(corev1.SecretTypeDockercfg),
+	string(corev1.SecretTypeSSHAuth),
+	string(corev1.SecretTypeTLS),
+}
+
+// ValidateAzureKeyVaultSecret validates the spec of an AzureKeyVaultSecret
+func ValidateAzureKeyVaultSecret(akvs *akv.AzureKeyVaultSecret) field.ErrorList {
+	return ValidateAzureKeyVaultSecretSpec(&akvs.Spec, field.NewPath("spec"))
+}
+
+// ValidateAzureKeyVaultSecretSpec validates an AzureKeyVaultSecretSpec
+func ValidateAzureKeyVaultSecretSpec(spec *akv.AzureKeyVaultSecretSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	vaultPath := fldPath.Child("vault")
+	if spec.Vault.Name == "" {
+		allErrs = append(allErrs, field.Required(vaultPath.Child("name"), "name of azure key vault must be specified"))
+	}
+	allErrs = append(allErrs, validateObject(&spec.Vault.Object, vaultPath.Child("object"))...)
+	allErrs = append(allErrs, validateOutput(spec, fldPath.Child("output"))...)
+
+	if spec.RefreshInterval != nil && spec.RefreshInterval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("refreshInterval"), spec.RefreshInterval.Duration.String(), "must be greater than zero"))
+	}
+	return allErrs
+}
+
+func validateObject(object *akv.AzureKeyVaultObject, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if !contains(supportedObjectTypes, string(object.Type)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), object.Type, supportedObjectTypes))
+	}
+
+	if object.Selector == nil && object.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), "name of azure key vault object must be specified unless selector is set"))
+	}
+
+	if object.ContentType != "" && !contains(supportedContentTypes, string(object.ContentType)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("contentType"), object.ContentType, supportedContentTypes))
+	}
+
+	if object.VersionHistoryLimit < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("versionHistoryLimit"), object.VersionHistoryLimit, "must be greater than zero"))
+	}
+	if object.VersionHistoryLimit > 1 {
+		if object.Type != akv.AzureKeyVaultObjectTypeSecret {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("versionHistoryLimit"), fmt.Sprintf("only supported when type is '%s'", akv.AzureKeyVaultObjectTypeSecret)))
+		}
+		if object.Version != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("version"), "cannot use versionHistoryLimit together with a specific version"))
+		}
+	}
+
+	if object.Selector != nil {
+		allErrs = append(allErrs, validateSelector(object, fldPath)...)
+	}
+	return allErrs
+}
+
+func validateSelector(object *akv.AzureKeyVaultObject, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	selectorPath := fldPath.Child("selector")
+
+	if object.Type != akv.AzureKeyVaultObjectTypeSecret {
+		allErrs = append(allErrs, field.Forbidden(selectorPath, fmt.Sprintf("only supported when type is '%s'", akv.AzureKeyVaultObjectTypeSecret)))
+	}
+	if object.Name != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("name"), "cannot use name together with selector"))
+	}
+	if object.Version != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("version"), "cannot use version together with selector"))
+	}
+	if object.VersionHistoryLimit > 1 {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("versionHistoryLimit"), "cannot use versionHistoryLimit together with selector"))
+	}
+	if object.Selector.NameRegex != "" {
+		if _, err := regexp.Compile(object.Selector.NameRegex); err != nil {
+			allErrs = append(allErrs, field.Invalid(selectorPath.Child("nameRegex"), object.Selector.NameRegex, fmt.Sprintf("invalid regular expression: %v", err)))
+		}
+	}
+	return allErrs
+}
+
+func validateOutput(spec *akv.AzureKeyVaultSecretSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if _, err := transformers.CreateTransformator(&spec.Output); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("transform"), spec.Output.Transform, err.Error()))
+	}
+
+	if spec.Output.Secret.Name != "" {
+		allErrs = append(allErrs, validateOutputSecret(&spec.Vault.Object, &spec.Output.Secret, fldPath.Child("secret"))...)
+	}
+
+	if spec.Output.ConfigMap.Name != "" {
+		allErrs = append(allErrs, validateOutputConfigMap(&spec.Vault.Object, &spec.Output.ConfigMap, fldPath.Child("configMap"))...)
+	}
+	return allErrs
+}
+
+func validateOutputSecret(object *akv.AzureKeyVaultObject, secret *akv.AzureKeyVaultOutputSecret, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	secretType := secret.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+	typePath := fldPath.Child("type")
+	dataKeyPath := fldPath.Child("dataKey")
+
+	switch object.Type {
+	case akv.AzureKeyVaultObjectTypeSecret:
+		if object.Selector != nil || object.VersionHistoryLimit > 1 {
+			if secretType != corev1.SecretTypeOpaque {
+				allErrs = append(allErrs, field.NotSupported(typePath, secret.Type, []string{string(corev1.SecretTypeOpaque)}))
+			}
+			if object.VersionHistoryLimit > 1 && secret.DataKey == "" {
+				allErrs = append(allErrs, field.Required(dataKeyPath, "data key must be specified when using versionHistoryLimit"))
+			}
+			break
+		}
+		if !contains(secretTypesForSecret, string(secretType)) {
+			allErrs = append(allErrs, field.NotSupported(typePath, secret.Type, secretTypesForSecret))
+		} else if secretType == corev1.SecretTypeOpaque && secret.DataKey == "" {
+			allErrs = append(allErrs, field.Required(dataKeyPath, "data key must be specified for secret of type Opaque"))
+		}
+
+	case akv.AzureKeyVaultObjectTypeCertificate:
+		if secretType != corev1.SecretTypeTLS && secret.DataKey == "" {
+			allErrs = append(allErrs, field.Required(dataKeyPath, fmt.Sprintf("data key must be specified unless type is '%s'", corev1.SecretTypeTLS)))
+		}
+
+	case akv.AzureKeyVaultObjectTypeKey:
+		if secretType != corev1.SecretTypeOpaque {
+			allErrs = append(allErrs, field.NotSupported(typePath, secret.Type, []string{string(corev1.SecretTypeOpaque)}))
+		}
+		if secret.DataKey == "" {
+			allErrs = append(allErrs, field.Required(dataKeyPath, "data key must be specified for keys"))
+		}
+	}
+
+	usesChainOrder := object.Type == akv.AzureKeyVaultObjectTypeCertificate ||
+		(object.Type == akv.AzureKeyVaultObjectTypeSecret && object.Selector == nil && object.VersionHistoryLimit <= 1 && secretType == corev1.SecretTypeTLS)
+	if secret.ChainOrder != "" && !usesChainOrder {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("chainOrder"), fmt.Sprintf("only used for certificates or secrets of type '%s'", corev1.SecretTypeTLS)))
+	}
+
+	allErrs = append(allErrs, validateKeyFormat(object, secret.KeyFormat, fldPath.Child("keyFormat"))...)
+	return allErrs
+}
+
+func validateKeyFormat(object *akv.AzureKeyVaultObject, keyFormat akv.AzureKeyVaultKeyFormat, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if keyFormat == "" {
+		return allErrs
+	}
+
+	if !contains(supportedKeyFormats, string(keyFormat)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath, keyFormat, supportedKeyFormats))
+	} else if object.Type != akv.AzureKeyVaultObjectTypeKey {
+		allErrs = append(allErrs, field.Forbidden(fldPath, fmt.Sprintf("only used when type is '%s'", akv.AzureKeyVaultObjectTypeKey)))
+	}
+	return allErrs
+}
+
+func validateOutputConfigMap(object *akv.AzureKeyVaultObject, cm *akv.AzureKeyVaultOutputConfigMap, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	usesOwnKeys := object.Type == akv.AzureKeyVaultObjectTypeMultiKeyValueSecret ||
+		(object.Type == akv.AzureKeyVaultObjectTypeSecret && object.Selector != nil)
+	if !usesOwnKeys && cm.DataKey == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("dataKey"), "data key must be specified for output configmap"))
+	}
+	allErrs = append(allErrs, validateKeyFormat(object, cm.KeyFormat, fldPath.Child("keyFormat"))...)
+	return allErrs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"testing"
+
+	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func newAzureKeyVaultSecret(object akv.AzureKeyVaultObject, output akv.AzureKeyVaultOutput) *akv.AzureKeyVaultSecret {
+	return &akv.AzureKeyVaultSecret{
+		Spec: akv.AzureKeyVaultSecretSpec{
+			Vault: akv.AzureKeyVault{
+				Name:   "my-vault",
+				Object: object,
+			},
+			Output: output,
+		},
+	}
+}
+
+func TestValidateAzureKeyVaultSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		akvs     *akv.AzureKeyVaultSecret
+		expected map[string]field.ErrorType
+	}{
+		{
+			name: "valid secret",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret", DataKey: "value"}},
+			),
+		},
+		{
+			name: "env injection only",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{},
+			),
+		},
+		{
+			name: "valid tls certificate",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-cert", Type: akv.AzureKeyVaultObjectTypeCertificate},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-cert", Type: corev1.SecretTypeTLS, ChainOrder: "ensureserverfirst"}},
+			),
+		},
+		{
+			name: "missing data key",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{
+					Secret:    akv.AzureKeyVaultOutputSecret{Name: "my-secret"},
+					ConfigMap: akv.AzureKeyVaultOutputConfigMap{Name: "my-configmap"},
+				},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.secret.dataKey":    field.ErrorTypeRequired,
+				"spec.output.configMap.dataKey": field.ErrorTypeRequired,
+			},
+		},
+		{
+			name: "multi key value secret uses own keys",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeMultiKeyValueSecret, ContentType: akv.AzureKeyVaultObjectContentTypeJSON},
+				akv.AzureKeyVaultOutput{ConfigMap: akv.AzureKeyVaultOutputConfigMap{Name: "my-configmap"}},
+			),
+		},
+		{
+			name: "multi key value secret with content type from azure key vault",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeMultiKeyValueSecret},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret"}},
+			),
+		},
+		{
+			name: "chain order on key",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-key", Type: akv.AzureKeyVaultObjectTypeKey},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-key", DataKey: "key", ChainOrder: "ensureserverfirst"}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.secret.chainOrder": field.ErrorTypeForbidden,
+			},
+		},
+		{
+			name: "unknown transform",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{Transform: []string{"trim", "rot13"}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.transform": field.ErrorTypeInvalid,
+			},
+		},
+		{
+			name: "secret type not fitting key",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-key", Type: akv.AzureKeyVaultObjectTypeKey},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-key", Type: corev1.SecretTypeTLS, DataKey: "key"}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.secret.type": field.ErrorTypeNotSupported,
+			},
+		},
+		{
+			name: "key format on secret",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret", DataKey: "value", KeyFormat: akv.AzureKeyVaultKeyFormatPem}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.secret.keyFormat": field.ErrorTypeForbidden,
+			},
+		},
+		{
+			name: "key format on configmap",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret},
+				akv.AzureKeyVaultOutput{ConfigMap: akv.AzureKeyVaultOutputConfigMap{Name: "my-configmap", DataKey: "value", KeyFormat: akv.AzureKeyVaultKeyFormatPem}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.output.configMap.keyFormat": field.ErrorTypeForbidden,
+			},
+		},
+		{
+			name: "version history with version",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret, Version: "abc", VersionHistoryLimit: 3},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret", DataKey: "value"}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.vault.object.version": field.ErrorTypeForbidden,
+			},
+		},
+		{
+			name: "selector with name and invalid regex",
+			akvs: newAzureKeyVaultSecret(
+				akv.AzureKeyVaultObject{Name: "my-secret", Type: akv.AzureKeyVaultObjectTypeSecret, Selector: &akv.AzureKeyVaultObjectSelector{NameRegex: "app-("}},
+				akv.AzureKeyVaultOutput{Secret: akv.AzureKeyVaultOutputSecret{Name: "my-secret"}},
+			),
+			expected: map[string]field.ErrorType{
+				"spec.vault.object.name":               field.ErrorTypeForbidden,
+				"spec.vault.object.selector.nameRegex": field.ErrorTypeInvalid,
+			},
+		},
+		{
+			name: "missing vault and object name",
+			akvs: &akv.AzureKeyVaultSecret{
+				Spec: akv.AzureKeyVaultSecretSpec{
+					Vault: akv.AzureKeyVault{Object: akv.AzureKeyVaultObject{Type: "blob"}},
+				},
+			},
+			expected: map[string]field.ErrorType{
+				"spec.vault.name":        field.ErrorTypeRequired,
+				"spec.vault.object.name": field.ErrorTypeRequired,
+				"spec.vault.object.type": field.ErrorTypeNotSupported,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		errs := ValidateAzureKeyVaultSecret(test.akvs)
+
+		actual := map[string]field.ErrorType{}
+		for _, err := range errs {
+			actual[err.Field] = err.Type
+		}
+		if len(actual) != len(test.expected) {
+			t.Errorf("%s: expected %d errors, got %v", test.name, len(test.expected), errs)
+			continue
+		}
+		for fieldPath, errType := range test.expected {
+			if actual[fieldPath] != errType {
+				t.Errorf("%s: expected %s error for %s, got %v", test.name, errType, fieldPath, errs)
+			}
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/adal"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -67,40 +68,61 @@ func (c azureKeyVaultCredentials) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// GetAzureKeyVaultCredentials will get Azure credentials
+// GetAzureKeyVaultCredentials will get Azure credentials for the user assigned managed identity azureIdentity.
+// If azureIdentity is the resource id of the identity, it is assigned to the VM or VMSS of hostname if not
+// already assigned. Otherwise azureIdentity is used as the client id of an identity already assigned to the node.
 func (c UserAssignedManagedIdentityProvider) GetAzureKeyVaultCredentials(azureIdentity string, hostname string) (AzureKeyVaultCredentials, error) {
-	err := c.aadClient.Init()
-	if err != nil {
-		return nil, err
+	if azureIdentity == "" {
+		return nil, fmt.Errorf("azure identity not set")
 	}
 
 	resourceSplit := strings.SplitAfterN(c.environment.ResourceIdentifiers.KeyVault, "https://", 2)
 	endpoint := resourceSplit[0] + "%s." + resourceSplit[1]
-	msiExists := false
 
-	msis, err := c.aadClient.GetUserMSIs(hostname, c.config.VMType == vmTypeVMSS)
+	if !isIdentityResourceID(azureIdentity) {
+		token, err := getServicePrincipalTokenFromMSI(azureIdentity, c.environment.ResourceIdentifiers.KeyVault)
+		if err != nil {
+			return nil, err
+		}
+
+		return &azureKeyVaultCredentials{
+			ClientID:        azureIdentity,
+			Token:           token,
+			EndpointPartial: endpoint,
+		}, nil
+	}
+
+	isVMSS := c.config.VMType == vmTypeVMSS
+	msis, err := c.aadClient.GetUserMSIs(hostname, isVMSS)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get user assigned identities for '%s', error: %+v", hostname, err)
 	}
 
+	msiExists := false
 	for _, msi := range msis {
-		if msi == azureIdentity {
+		// Azure resource ids are case insensitive
+		if strings.EqualFold(msi, azureIdentity) {
 			msiExists = true
 			break
 		}
 	}
 
 	if !msiExists {
-		ids := []string{azureIdentity}
-		err := c.aadClient.UpdateUserMSI(ids, []string{}, hostname, c.config.VMType == vmTypeVMSS)
+		klog.InfoS("assigning user assigned identity", "identity", azureIdentity, "host", hostname)
+		err := c.aadClient.UpdateUserMSI([]string{azureIdentity}, []string{}, hostname, isVMSS)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to assign identity '%s' to '%s', error: %+v", azureIdentity, hostname, err)
 		}
 	}
 
-	token, err := getServicePrincipalTokenFromMSI(azureIdentity, c.environment.ResourceIdentifiers.KeyVault)
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed getting the managed service identity endpoint: %+v", err)
+	}
+
+	token, err := adal.NewServicePrincipalTokenFromMSIWithIdentityResourceID(msiEndpoint, c.environment.ResourceIdentifiers.KeyVault, azureIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user assigned msi token from endpoint '%s': %+v", msiEndpoint, err)
 	}
 
 	return &azureKeyVaultCredentials{
@@ -109,6 +131,12 @@ func (c UserAssignedManagedIdentityProvider) GetAzureKeyVaultCredentials(azureId
 	}, nil
 }
 
+// isIdentityResourceID returns true if azureIdentity is an Azure resource id
+// (/subscriptions/<id>/resourcegroups/<group>/providers/Microsoft.ManagedIdentity/userAssignedIdentities/<name>)
+func isIdentityResourceID(azureIdentity string) bool {
+	return strings.HasPrefix(strings.ToLower(azureIdentity), "/subscriptions/")
+}
+
 // GetAzureKeyVaultCredentials will get Azure credentials
 func (c CloudConfigCredentialProvider) GetAzureKeyVaultCredentials() (AzureKeyVaultCredentials, error) {
 	resourceSplit := strings.SplitAfterN(c.environment.ResourceIdentifiers.KeyVault, "https://", 2)
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
@@ -129,14 +130,33 @@ type azureToken struct {
 // 	}
 // }
 
+// NewUserAssignedManagedIdentityProvider parses the specified azure config file and returns a UserAssignedManagedIdentityProvider
 func NewUserAssignedManagedIdentityProvider(azureConfigFile string) (*UserAssignedManagedIdentityProvider, error) {
+	f, err := os.Open(azureConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading cloud config, error: %+v", err)
+	}
+	defer f.Close()
+
+	config, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading cloud config, error: %+v", err)
+	}
+
+	env, err := parseAzureEnvironment(config.Cloud)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse environment from cloud config, error: %+v", err)
+	}
+
 	aadClient, err := aadProvider.NewCloudProvider(azureConfigFile, 2, time.Second*30)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating aad cloud provider, error: %+v", err)
 	}
 
 	return &UserAssignedManagedIdentityProvider{
-		aadClient: aadClient,
+		config:      config,
+		environment: env,
+		aadClient:   aadClient,
 	}, nil
 }
 
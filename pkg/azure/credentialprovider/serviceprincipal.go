@@ -0,0 +1,175 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentialprovider
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"k8s.io/klog/v2"
+)
+
+// ServicePrincipal has what is needed to authenticate with Azure AD as a
+// service principal, using exactly one of ClientSecret, ClientCertificate
+// or FederatedToken
+type ServicePrincipal struct {
+	Cloud    string
+	TenantID string
+	ClientID string
+
+	ClientSecret string
+
+	// ClientCertificate is a PEM encoded certificate and RSA private key,
+	// or a PKCS #12 archive protected by ClientCertificatePassword
+	ClientCertificate         []byte
+	ClientCertificatePassword string
+
+	// FederatedToken is a JWT issued by an identity provider trusted by the service principal
+	FederatedToken string
+}
+
+// GetAzureKeyVaultCredentials will get Azure credentials for the service principal
+func (sp ServicePrincipal) GetAzureKeyVaultCredentials() (AzureKeyVaultCredentials, error) {
+	if sp.TenantID == "" {
+		return nil, fmt.Errorf("tenant id not set for service principal")
+	}
+	if sp.ClientID == "" {
+		return nil, fmt.Errorf("client id not set for service principal")
+	}
+
+	env, err := parseAzureEnvironment(sp.Cloud)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse azure environment '%s', error: %+v", sp.Cloud, err)
+	}
+
+	resourceSplit := strings.SplitAfterN(env.ResourceIdentifiers.KeyVault, "https://", 2)
+	endpoint := resourceSplit[0] + "%s." + resourceSplit[1]
+
+	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, sp.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("creating the OAuth config: %v", err)
+	}
+
+	token, err := sp.servicePrincipalToken(*oauthConfig, env.ResourceIdentifiers.KeyVault)
+	if err != nil {
+		return nil, err
+	}
+
+	return azureKeyVaultCredentials{
+		ClientID:        sp.ClientID,
+		Token:           token,
+		EndpointPartial: endpoint,
+	}, nil
+}
+
+func (sp ServicePrincipal) servicePrincipalToken(oauthConfig adal.OAuthConfig, resource string) (*adal.ServicePrincipalToken, error) {
+	switch {
+	case sp.ClientSecret != "":
+		klog.V(4).InfoS("azure: using client_id+client_secret to retrieve access token", "id", sp.ClientID)
+		return adal.NewServicePrincipalToken(oauthConfig, sp.ClientID, sp.ClientSecret, resource)
+	case len(sp.ClientCertificate) > 0:
+		klog.V(4).InfoS("azure: using jwt client_assertion (client_cert+client_private_key) to retrieve access token", "id", sp.ClientID)
+		certificate, privateKey, err := decodeClientCertificate(sp.ClientCertificate, sp.ClientCertificatePassword)
+		if err != nil {
+			return nil, fmt.Errorf("decoding the client certificate: %v", err)
+		}
+		return adal.NewServicePrincipalTokenFromCertificate(oauthConfig, sp.ClientID, certificate, privateKey, resource)
+	case sp.FederatedToken != "":
+		klog.V(4).InfoS("azure: using federated token client_assertion to retrieve access token", "id", sp.ClientID)
+		return adal.NewServicePrincipalTokenWithSecret(oauthConfig, sp.ClientID, resource, newFederatedTokenSecret(func() (string, error) {
+			return sp.FederatedToken, nil
+		}))
+	default:
+		return nil, fmt.Errorf("no client secret, client certificate or federated token set for service principal '%s'", sp.ClientID)
+	}
+}
+
+// federatedTokenSecret authenticates with a federated token as client assertion,
+// getting the token on every refresh as federated tokens are short lived
+type federatedTokenSecret struct {
+	getToken func() (string, error)
+}
+
+func newFederatedTokenSecret(getToken func() (string, error)) *federatedTokenSecret {
+	return &federatedTokenSecret{getToken: getToken}
+}
+
+// SetAuthenticationValues is a method of the interface ServicePrincipalSecret
+func (s *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+	v.Set("client_assertion", token)
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
+
+// decodeClientCertificate decodes a PEM encoded certificate and private key,
+// falling back to PKCS #12 if data is not PEM encoded
+func decodeClientCertificate(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var certificate *x509.Certificate
+	var privateKey *rsa.PrivateKey
+
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate != nil {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certificate = cert
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			privateKey = key
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("private key is not an RSA private key")
+			}
+			privateKey = rsaKey
+		}
+	}
+
+	if certificate == nil && privateKey == nil {
+		return decodePkcs12(data, password)
+	}
+	if certificate == nil || privateKey == nil {
+		return nil, nil, fmt.Errorf("PEM encoded client certificate must contain both a certificate and a private key")
+	}
+	return certificate, privateKey, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialprovider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+func newTestClientCertificate(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "akv2k8s"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+}
+
+func TestDecodeClientCertificate(t *testing.T) {
+	data := newTestClientCertificate(t)
+
+	cert, key, err := decodeClientCertificate(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "akv2k8s" || key == nil {
+		t.Errorf("unexpected certificate '%s' or missing private key", cert.Subject.CommonName)
+	}
+
+	certOnly, _ := pem.Decode(data)
+	if _, _, err := decodeClientCertificate(pem.EncodeToMemory(certOnly), ""); err == nil {
+		t.Error("expected error for PEM without private key")
+	}
+}
+
+func TestServicePrincipalFederatedToken(t *testing.T) {
+	var assertion, assertionType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		assertion = r.PostForm.Get("client_assertion")
+		assertionType = r.PostForm.Get("client_assertion_type")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access","token_type":"Bearer","expires_in":"3600","expires_on":"%d","resource":"https://vault.azure.net"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	oauthConfig, err := adal.NewOAuthConfig(server.URL, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp := ServicePrincipal{TenantID: "tenant", ClientID: "client", FederatedToken: "federated"}
+	token, err := sp.servicePrincipalToken(*oauthConfig, "https://vault.azure.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if assertion != "federated" || assertionType != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+		t.Errorf("expected federated token as client assertion, got '%s' of type '%s'", assertion, assertionType)
+	}
+	if token.OAuthToken() != "access" {
+		t.Errorf("expected access token 'access', got '%s'", token.OAuthToken())
+	}
+}
+
+func TestServicePrincipalCredentials(t *testing.T) {
+	tests := []ServicePrincipal{
+		{ClientID: "client", ClientSecret: "secret"},
+		{TenantID: "tenant", ClientSecret: "secret"},
+		{TenantID: "tenant", ClientID: "client"},
+		{TenantID: "tenant", ClientID: "client", ClientSecret: "secret", Cloud: "UnknownCloud"},
+	}
+	for _, sp := range tests {
+		if _, err := sp.GetAzureKeyVaultCredentials(); err == nil {
+			t.Errorf("expected error for service principal %+v", sp)
+		}
+	}
+
+	sp := ServicePrincipal{TenantID: "tenant", ClientID: "client", ClientSecret: "secret"}
+	creds, err := sp.GetAzureKeyVaultCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := creds.Endpoint("my-vault"); endpoint != "https://my-vault.vault.azure.net" {
+		t.Errorf("unexpected endpoint '%s'", endpoint)
+	}
+}
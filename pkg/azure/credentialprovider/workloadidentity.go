@@ -0,0 +1,153 @@
+// Copyright © 2020 Sparebanken Vest
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentialprovider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	k8sCredentialProvider "github.com/vdemeester/k8s-pkg-credentialprovider"
+	"k8s.io/klog/v2"
+)
+
+// Environment variables set by Azure AD Workload Identity
+const (
+	WorkloadIdentityClientIDEnv           = "AZURE_CLIENT_ID"
+	WorkloadIdentityTenantIDEnv           = "AZURE_TENANT_ID"
+	WorkloadIdentityFederatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+	WorkloadIdentityAuthorityHostEnv      = "AZURE_AUTHORITY_HOST"
+	azureEnvironmentEnv                   = "AZURE_ENVIRONMENT"
+)
+
+// WorkloadIdentityCredentialProvider provides credentials for Azure by exchanging a
+// projected service account token for an Azure AD token (Azure AD Workload Identity)
+type WorkloadIdentityCredentialProvider struct {
+	clientID      string
+	tenantID      string
+	tokenFile     string
+	authorityHost string
+	environment   *azure.Environment
+}
+
+// IsWorkloadIdentityEnvironment returns true if a federated token file is set in the environment
+func IsWorkloadIdentityEnvironment() bool {
+	return os.Getenv(WorkloadIdentityFederatedTokenFileEnv) != ""
+}
+
+// NewFromWorkloadIdentity creates a credentials provider from the environment variables set by Azure AD Workload Identity
+func NewFromWorkloadIdentity() (*WorkloadIdentityCredentialProvider, error) {
+	return NewWorkloadIdentityCredentialProvider(
+		os.Getenv(WorkloadIdentityClientIDEnv),
+		os.Getenv(WorkloadIdentityTenantIDEnv),
+		os.Getenv(WorkloadIdentityFederatedTokenFileEnv),
+		os.Getenv(WorkloadIdentityAuthorityHostEnv),
+		os.Getenv(azureEnvironmentEnv))
+}
+
+// NewWorkloadIdentityCredentialProvider creates a credentials provider exchanging the federated token in
+// tokenFile for an Azure AD token. If authorityHost is empty, the Azure AD endpoint of cloudName is used
+func NewWorkloadIdentityCredentialProvider(clientID, tenantID, tokenFile, authorityHost, cloudName string) (*WorkloadIdentityCredentialProvider, error) {
+	if clientID == "" {
+		return nil, fmt.Errorf("client id not set for workload identity, expected env var %s", WorkloadIdentityClientIDEnv)
+	}
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant id not set for workload identity, expected env var %s", WorkloadIdentityTenantIDEnv)
+	}
+	if tokenFile == "" {
+		return nil, fmt.Errorf("federated token file not set for workload identity, expected env var %s", WorkloadIdentityFederatedTokenFileEnv)
+	}
+
+	env, err := parseAzureEnvironment(cloudName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse azure environment '%s', error: %+v", cloudName, err)
+	}
+
+	if authorityHost == "" {
+		authorityHost = env.ActiveDirectoryEndpoint
+	}
+
+	return &WorkloadIdentityCredentialProvider{
+		clientID:      clientID,
+		tenantID:      tenantID,
+		tokenFile:     tokenFile,
+		authorityHost: authorityHost,
+		environment:   env,
+	}, nil
+}
+
+// GetAzureKeyVaultCredentials will get Azure credentials
+func (c WorkloadIdentityCredentialProvider) GetAzureKeyVaultCredentials() (AzureKeyVaultCredentials, error) {
+	resourceSplit := strings.SplitAfterN(c.environment.ResourceIdentifiers.KeyVault, "https://", 2)
+	endpoint := resourceSplit[0] + "%s." + resourceSplit[1]
+
+	token, err := c.getServicePrincipalToken(c.environment.ResourceIdentifiers.KeyVault)
+	if err != nil {
+		return nil, err
+	}
+
+	return azureKeyVaultCredentials{
+		ClientID:        c.clientID,
+		Token:           token,
+		EndpointPartial: endpoint,
+	}, nil
+}
+
+// GetAcrCredentials will get Docker credentials for Azure Container Registry
+func (c WorkloadIdentityCredentialProvider) GetAcrCredentials(image string) (k8sCredentialProvider.DockerConfigEntry, error) {
+	cred := k8sCredentialProvider.DockerConfigEntry{
+		Username: "",
+		Password: "",
+	}
+
+	loginServer := parseACRLoginServerFromImage(image, c.environment)
+	if loginServer == "" {
+		klog.V(4).InfoS("image is not from acr, skip workload identity auth", "image", image)
+		return cred, nil
+	}
+
+	token, err := c.getServicePrincipalToken(c.environment.ServiceManagementEndpoint)
+	if err != nil {
+		return cred, err
+	}
+
+	return getACRDockerEntryFromARMToken(c.tenantID, *c.environment, token, loginServer)
+}
+
+// IsAcrRegistry checks if an image blongs to a ACR registry
+func (c WorkloadIdentityCredentialProvider) IsAcrRegistry(image string) bool {
+	return parseACRLoginServerFromImage(image, c.environment) != ""
+}
+
+func (c WorkloadIdentityCredentialProvider) getServicePrincipalToken(resource string) (*adal.ServicePrincipalToken, error) {
+	oauthConfig, err := adal.NewOAuthConfig(c.authorityHost, c.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("creating the OAuth config: %v", err)
+	}
+
+	klog.V(4).InfoS("azure: using workload identity federated token to retrieve access token", "id", c.clientID, "file", c.tokenFile)
+
+	// The projected service account token is rotated by kubelet, so it is read on every refresh
+	return adal.NewServicePrincipalTokenWithSecret(*oauthConfig, c.clientID, resource, newFederatedTokenSecret(func() (string, error) {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read federated token file '%s', error: %+v", c.tokenFile, err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}))
+}
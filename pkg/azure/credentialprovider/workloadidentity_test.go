@@ -0,0 +1,177 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credentialprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTokenEndpoint is a local Azure AD token endpoint recording the client assertions it receives
+type fakeTokenEndpoint struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	paths      []string
+	clientIDs  []string
+	assertions []string
+	resources  []string
+}
+
+func newFakeTokenEndpoint(t *testing.T) *fakeTokenEndpoint {
+	endpoint := &fakeTokenEndpoint{}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.PostForm.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		endpoint.mutex.Lock()
+		endpoint.paths = append(endpoint.paths, r.URL.Path)
+		endpoint.clientIDs = append(endpoint.clientIDs, r.PostForm.Get("client_id"))
+		endpoint.assertions = append(endpoint.assertions, r.PostForm.Get("client_assertion"))
+		endpoint.resources = append(endpoint.resources, r.PostForm.Get("resource"))
+		count := len(endpoint.assertions)
+		endpoint.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":"3600","expires_on":"%d","resource":"%s"}`,
+			count, time.Now().Add(time.Hour).Unix(), r.PostForm.Get("resource"))
+	}))
+	return endpoint
+}
+
+func writeTokenFile(t *testing.T, file, token string) {
+	if err := ioutil.WriteFile(file, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkloadIdentityCredentials(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(t)
+	defer endpoint.Close()
+
+	dir, err := ioutil.TempDir("", "workload-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	writeTokenFile(t, tokenFile, "federated-1")
+
+	provider, err := NewWorkloadIdentityCredentialProvider("client", "tenant", tokenFile, endpoint.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := provider.GetAzureKeyVaultCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := creds.Endpoint("my-vault"); endpoint != "https://my-vault.vault.azure.net" {
+		t.Errorf("unexpected endpoint '%s'", endpoint)
+	}
+
+	// Marshalling refreshes the token, as done by the auth service handing out tokens to pods
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oauth OAuthCredentials
+	if err := json.Unmarshal(data, &oauth); err != nil {
+		t.Fatal(err)
+	}
+	if oauth.OAuthToken != "access-1" {
+		t.Errorf("expected access token 'access-1', got '%s'", oauth.OAuthToken)
+	}
+
+	// The projected token is rotated by kubelet
+	writeTokenFile(t, tokenFile, "federated-2")
+	if _, err := json.Marshal(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	if strings.Join(endpoint.assertions, ",") != "federated-1,federated-2" {
+		t.Errorf("expected federated tokens read from file on every refresh, got %v", endpoint.assertions)
+	}
+	for i := range endpoint.assertions {
+		if endpoint.clientIDs[i] != "client" || endpoint.paths[i] != "/tenant/oauth2/token" || endpoint.resources[i] != "https://vault.azure.net" {
+			t.Errorf("unexpected token request for client '%s' to '%s' for resource '%s'", endpoint.clientIDs[i], endpoint.paths[i], endpoint.resources[i])
+		}
+	}
+}
+
+func TestWorkloadIdentityMissingTokenFile(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(t)
+	defer endpoint.Close()
+
+	provider, err := NewWorkloadIdentityCredentialProvider("client", "tenant", "/does/not/exist", endpoint.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := provider.GetAzureKeyVaultCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := json.Marshal(creds); err == nil {
+		t.Error("expected error refreshing token without federated token file")
+	}
+}
+
+func TestNewFromWorkloadIdentity(t *testing.T) {
+	env := map[string]string{
+		WorkloadIdentityClientIDEnv:           "client",
+		WorkloadIdentityTenantIDEnv:           "tenant",
+		WorkloadIdentityFederatedTokenFileEnv: "/var/run/secrets/azure/tokens/azure-identity-token",
+		WorkloadIdentityAuthorityHostEnv:      "https://login.microsoftonline.com/",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	if !IsWorkloadIdentityEnvironment() {
+		t.Error("expected workload identity environment")
+	}
+
+	provider, err := NewFromWorkloadIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.clientID != "client" || provider.tenantID != "tenant" || provider.authorityHost != "https://login.microsoftonline.com/" {
+		t.Errorf("unexpected provider %+v", provider)
+	}
+
+	os.Unsetenv(WorkloadIdentityClientIDEnv)
+	if _, err := NewFromWorkloadIdentity(); err == nil {
+		t.Errorf("expected error without %s", WorkloadIdentityClientIDEnv)
+	}
+}
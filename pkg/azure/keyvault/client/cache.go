@@ -0,0 +1,337 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	akvs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "akv2k8s_vault_cache_hits_total",
+		Help: "The total number of Azure Key Vault requests served from cache",
+	}, []string{"object"})
+
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "akv2k8s_vault_cache_misses_total",
+		Help: "The total number of Azure Key Vault requests not found in cache",
+	}, []string{"object"})
+
+	cacheJoined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "akv2k8s_vault_cache_joined_total",
+		Help: "The total number of Azure Key Vault requests not found in cache, waiting for the same request already sent to Azure Key Vault",
+	}, []string{"object"})
+)
+
+// CacheOptions has options for caching objects from Azure Key Vault
+type CacheOptions struct {
+	// TTL is how long objects without a pinned version are cached
+	TTL time.Duration
+
+	// VersionedTTL is how long objects with a pinned version are cached.
+	// A version in Azure Key Vault never changes value, so this can
+	// safely be much longer than TTL.
+	VersionedTTL time.Duration
+
+	// NegativeTTL is how long objects not found in Azure Key Vault are
+	// remembered as not found. Zero disables negative caching.
+	NegativeTTL time.Duration
+
+	// FetchTimeout limits requests to Azure Key Vault. They are not cancelled
+	// with the request missing the cache, as other requests may be waiting for
+	// them. Defaults to DefaultCacheFetchTimeout.
+	FetchTimeout time.Duration
+}
+
+// DefaultCacheFetchTimeout is the default timeout of requests to Azure Key Vault made by the cache
+const DefaultCacheFetchTimeout = time.Minute
+
+// cacheEntry is an object, or the error for an object not found, in Azure Key Vault
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	fetched time.Time
+}
+
+type maxAgeKey struct{}
+
+// WithMaxAge returns a context for requests only accepting objects without a pinned version from
+// the cache if fetched from Azure Key Vault within maxAge, regardless of the TTL of the cache.
+// Use it when objects must be refreshed more often than the TTL.
+func WithMaxAge(ctx context.Context, maxAge time.Duration) context.Context {
+	return context.WithValue(ctx, maxAgeKey{}, maxAge)
+}
+
+// maxAge returns the max age of unversioned objects for requests with ctx, or zero if not limited
+func maxAge(ctx context.Context) time.Duration {
+	maxAge, _ := ctx.Value(maxAgeKey{}).(time.Duration)
+	return maxAge
+}
+
+// detachedContext has the values of its parent, but is never cancelled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// inflightFetch is a fetch from Azure Key Vault that concurrent misses for the same key wait for
+type inflightFetch struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Cache caches objects from Azure Key Vault for one or more Services. Concurrent misses
+// for the same object are collapsed into a single request to Azure Key Vault.
+type Cache struct {
+	options CacheOptions
+	cache   *cache.Cache
+
+	mutex    sync.Mutex
+	inflight map[string]*inflightFetch
+}
+
+// NewCache creates a cache for objects from Azure Key Vault
+func NewCache(options CacheOptions) *Cache {
+	return &Cache{
+		options:  options,
+		cache:    cache.New(options.TTL, 10*time.Minute),
+		inflight: make(map[string]*inflightFetch),
+	}
+}
+
+// Service returns a Service caching objects returned by service in the cache. Objects are
+// cached per partition, which must identify the credentials of service if the cache is shared
+// by services with different access to Azure Key Vault.
+func (c *Cache) Service(service Service, partition string) Service {
+	return &cachedService{
+		service:   service,
+		cache:     c,
+		partition: partition,
+	}
+}
+
+type cachedService struct {
+	service   Service
+	cache     *Cache
+	partition string
+}
+
+// NewCachedService creates a Service caching secrets, keys and certificates
+// returned by service, keyed by vault, object and version
+func NewCachedService(service Service, options CacheOptions) Service {
+	return NewCache(options).Service(service, "")
+}
+
+// GetSecret returns a secret from cache or Azure Key Vault
+func (c *cachedService) GetSecret(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Secret, error) {
+	key := cacheKey("secret", vaultSpec)
+	value, err := c.get(ctx, key, vaultSpec, func(ctx context.Context) (interface{}, error) {
+		return c.service.GetSecret(ctx, vaultSpec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copySecret(value.(*Secret)), nil
+}
+
+// GetSecretVersions returns the latest versions of a secret from cache or Azure Key Vault
+func (c *cachedService) GetSecretVersions(ctx context.Context, vaultSpec *akvs.AzureKeyVault, limit int) ([]*Secret, error) {
+	key := fmt.Sprintf("%s/%d", cacheKey("secret-versions", vaultSpec), limit)
+	value, err := c.get(ctx, key, vaultSpec, func(ctx context.Context) (interface{}, error) {
+		return c.service.GetSecretVersions(ctx, vaultSpec, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]*Secret, 0, len(value.([]*Secret)))
+	for _, secret := range value.([]*Secret) {
+		secrets = append(secrets, copySecret(secret))
+	}
+	return secrets, nil
+}
+
+// ListSecrets returns metadata of all secrets in a vault from cache or Azure Key Vault
+func (c *cachedService) ListSecrets(ctx context.Context, vaultSpec *akvs.AzureKeyVault) ([]ObjectMetadata, error) {
+	key := fmt.Sprintf("secret-list/%s", vaultSpec.Name)
+	value, err := c.get(ctx, key, vaultSpec, func(ctx context.Context) (interface{}, error) {
+		return c.service.ListSecrets(ctx, vaultSpec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]ObjectMetadata, 0, len(value.([]ObjectMetadata)))
+	for _, secret := range value.([]ObjectMetadata) {
+		secrets = append(secrets, secret.copy())
+	}
+	return secrets, nil
+}
+
+// GetKey returns a key from cache or Azure Key Vault
+func (c *cachedService) GetKey(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Key, error) {
+	key := cacheKey("key", vaultSpec)
+	value, err := c.get(ctx, key, vaultSpec, func(ctx context.Context) (interface{}, error) {
+		return c.service.GetKey(ctx, vaultSpec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyKey(value.(*Key)), nil
+}
+
+// GetCertificate returns a certificate from cache or Azure Key Vault
+func (c *cachedService) GetCertificate(ctx context.Context, vaultSpec *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error) {
+	key := cacheKey("certificate", vaultSpec)
+	if options != nil {
+		key = fmt.Sprintf("%s/%t/%t", key, options.ExportPrivateKey, options.EnsureServerFirst)
+	}
+	value, err := c.get(ctx, key, vaultSpec, func(ctx context.Context) (interface{}, error) {
+		return c.service.GetCertificate(ctx, vaultSpec, options)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyCertificate(value.(*Certificate)), nil
+}
+
+func (c *cachedService) get(ctx context.Context, key string, vaultSpec *akvs.AzureKeyVault, fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	return c.cache.get(ctx, c.partition+"|"+key, strings.SplitN(key, "/", 2)[0], vaultSpec, fetch)
+}
+
+func (c *Cache) get(ctx context.Context, key, objectType string, vaultSpec *akvs.AzureKeyVault, fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if value, found := c.cache.Get(key); found {
+		entry := value.(*cacheEntry)
+		if maxAge := maxAge(ctx); vaultSpec.Object.Version != "" || maxAge <= 0 || time.Since(entry.fetched) <= maxAge {
+			cacheHits.WithLabelValues(objectType).Inc()
+			return entry.value, entry.err
+		}
+	}
+
+	c.mutex.Lock()
+	call, ok := c.inflight[key]
+	if ok {
+		cacheJoined.WithLabelValues(objectType).Inc()
+	} else {
+		call = &inflightFetch{done: make(chan struct{})}
+		c.inflight[key] = call
+		cacheMisses.WithLabelValues(objectType).Inc()
+		go c.fetch(ctx, key, vaultSpec, call, fetch)
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch gets an object from Azure Key Vault for call. It is detached from the request missing the
+// cache, so that cancelling it does not fail the other requests waiting for the object.
+func (c *Cache) fetch(ctx context.Context, key string, vaultSpec *akvs.AzureKeyVault, call *inflightFetch, fetch func(ctx context.Context) (interface{}, error)) {
+	timeout := c.options.FetchTimeout
+	if timeout <= 0 {
+		timeout = DefaultCacheFetchTimeout
+	}
+	fetchCtx, cancel := context.WithTimeout(detachedContext{parent: ctx}, timeout)
+	defer cancel()
+
+	fetched := time.Now()
+	call.value, call.err = fetch(fetchCtx)
+	if call.err != nil {
+		if c.options.NegativeTTL > 0 && isNotFound(call.err) {
+			c.cache.Set(key, &cacheEntry{err: call.err, fetched: fetched}, c.options.NegativeTTL)
+		}
+	} else {
+		ttl := c.options.TTL
+		if vaultSpec.Object.Version != "" && c.options.VersionedTTL > 0 {
+			ttl = c.options.VersionedTTL
+		}
+		c.cache.Set(key, &cacheEntry{value: call.value, fetched: fetched}, ttl)
+	}
+
+	c.mutex.Lock()
+	delete(c.inflight, key)
+	c.mutex.Unlock()
+	close(call.done)
+}
+
+// copySecret returns a copy of a cached secret, so that callers changing it do not change the cache
+func copySecret(secret *Secret) *Secret {
+	if secret == nil {
+		return nil
+	}
+	copied := *secret
+	copied.ObjectMetadata = secret.ObjectMetadata.copy()
+	return &copied
+}
+
+// copyKey returns a copy of a cached key, so that callers changing it do not change the cache
+func copyKey(key *Key) *Key {
+	if key == nil {
+		return nil
+	}
+	copied := *key
+	copied.ObjectMetadata = key.ObjectMetadata.copy()
+	return &copied
+}
+
+// copyCertificate returns a copy of a cached certificate, so that callers changing it do not change the cache
+func copyCertificate(cert *Certificate) *Certificate {
+	if cert == nil {
+		return nil
+	}
+	copied := *cert
+	copied.ObjectMetadata = cert.ObjectMetadata.copy()
+	copied.Certificates = append([]*x509.Certificate(nil), cert.Certificates...)
+	copied.PrivateKeyRaw = append([]byte(nil), cert.PrivateKeyRaw...)
+	copied.raw = append([]byte(nil), cert.raw...)
+	return &copied
+}
+
+func cacheKey(objectType string, vaultSpec *akvs.AzureKeyVault) string {
+	return fmt.Sprintf("%s/%s/%s/%s", objectType, vaultSpec.Name, vaultSpec.Object.Name, vaultSpec.Object.Version)
+}
+
+// isNotFound returns true if err is a 404 response from Azure Key Vault
+func isNotFound(err error) bool {
+	var detailedErr autorest.DetailedError
+	if errors.As(err, &detailedErr) {
+		if statusCode, ok := detailedErr.StatusCode.(int); ok {
+			return statusCode == http.StatusNotFound
+		}
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	akvs "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type countingService struct {
+	calls int
+	err   error
+}
+
+func (s *countingService) GetSecret(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Secret, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Secret{Value: fmt.Sprintf("%s-%d", vaultSpec.Object.Name, s.calls)}, nil
+}
+
+func (s *countingService) GetSecretVersions(ctx context.Context, vaultSpec *akvs.AzureKeyVault, limit int) ([]*Secret, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []*Secret{{Value: fmt.Sprintf("%s-%d", vaultSpec.Object.Name, s.calls)}}, nil
+}
+
+func (s *countingService) ListSecrets(ctx context.Context, vaultSpec *akvs.AzureKeyVault) ([]ObjectMetadata, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []ObjectMetadata{{Name: "my-secret"}}, nil
+}
+
+func (s *countingService) GetKey(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Key, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Key{}, nil
+}
+
+func (s *countingService) GetCertificate(ctx context.Context, vaultSpec *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Certificate{}, nil
+}
+
+func newVaultSpec(name, version string) *akvs.AzureKeyVault {
+	return &akvs.AzureKeyVault{
+		Name: "my-vault",
+		Object: akvs.AzureKeyVaultObject{
+			Name:    name,
+			Type:    akvs.AzureKeyVaultObjectTypeSecret,
+			Version: version,
+		},
+	}
+}
+
+func TestCachedServiceCachesSecrets(t *testing.T) {
+	service := &countingService{}
+	cached := NewCachedService(service, CacheOptions{TTL: time.Minute})
+
+	first, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Value != second.Value {
+		t.Errorf("expected cached value '%s', got '%s'", first.Value, second.Value)
+	}
+	if service.calls != 1 {
+		t.Errorf("expected 1 call to Azure Key Vault, got %d", service.calls)
+	}
+
+	if _, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", "v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.GetKey(context.Background(), newVaultSpec("my-secret", "")); err != nil {
+		t.Fatal(err)
+	}
+	if service.calls != 3 {
+		t.Errorf("expected versions and object types to be cached separately, got %d calls", service.calls)
+	}
+}
+
+func TestCachedServiceExpires(t *testing.T) {
+	service := &countingService{}
+	cached := NewCachedService(service, CacheOptions{TTL: 10 * time.Millisecond})
+
+	if _, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", "")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if service.calls != 2 {
+		t.Errorf("expected 2 calls to Azure Key Vault after expiry, got %d", service.calls)
+	}
+}
+
+func TestCachedServiceMaxAge(t *testing.T) {
+	service := &countingService{}
+	cached := NewCachedService(service, CacheOptions{TTL: time.Minute})
+	ctx := WithMaxAge(context.Background(), 10*time.Millisecond)
+
+	for _, version := range []string{"", "v1"} {
+		if _, err := cached.GetSecret(ctx, newVaultSpec("my-secret", version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	for _, version := range []string{"", "v1"} {
+		if _, err := cached.GetSecret(ctx, newVaultSpec("my-secret", version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if service.calls != 3 {
+		t.Errorf("expected only the unversioned secret to be fetched again after max age, got %d calls", service.calls)
+	}
+}
+
+func TestCachedServiceNegativeCaching(t *testing.T) {
+	notFound := autorest.DetailedError{StatusCode: http.StatusNotFound, Message: "not found"}
+	service := &countingService{err: fmt.Errorf("failed to get certificate from azure key vault, error: %w", notFound)}
+	cached := NewCachedService(service, CacheOptions{TTL: time.Minute, NegativeTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if _, err := cached.GetCertificate(context.Background(), newVaultSpec("my-cert", ""), &CertificateOptions{}); err == nil {
+			t.Fatal("expected error for object not found")
+		}
+	}
+	if service.calls != 1 {
+		t.Errorf("expected not found to be cached, got %d calls", service.calls)
+	}
+
+	service.err = autorest.DetailedError{StatusCode: http.StatusTooManyRequests, Message: "throttled"}
+	for i := 0; i < 2; i++ {
+		if _, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", "")); err == nil {
+			t.Fatal("expected error for throttled request")
+		}
+	}
+	if service.calls != 3 {
+		t.Errorf("expected other errors not to be cached, got %d calls", service.calls)
+	}
+}
+
+// blockingService returns secrets once release is closed, counting concurrent calls
+type blockingService struct {
+	countingService
+	mutex   sync.Mutex
+	release chan struct{}
+}
+
+func (s *blockingService) GetSecret(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Secret, error) {
+	<-s.release
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.countingService.GetSecret(ctx, vaultSpec)
+}
+
+func TestCachedServiceCollapsesConcurrentMisses(t *testing.T) {
+	service := &blockingService{release: make(chan struct{})}
+	cached := NewCachedService(service, CacheOptions{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	values := make([]string, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			secret, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", ""))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values[i] = secret.Value
+		}(i)
+	}
+
+	// let the requests reach the cache before the first fetch completes
+	time.Sleep(50 * time.Millisecond)
+	close(service.release)
+	wg.Wait()
+
+	if service.calls != 1 {
+		t.Errorf("expected concurrent misses to be collapsed into 1 call to Azure Key Vault, got %d", service.calls)
+	}
+	for _, value := range values {
+		if value != values[0] {
+			t.Errorf("expected all requests to get '%s', got '%s'", values[0], value)
+		}
+	}
+}
+
+func TestCachePartitions(t *testing.T) {
+	cache := NewCache(CacheOptions{TTL: time.Minute})
+	first := &countingService{}
+	second := &countingService{}
+
+	for _, service := range []Service{cache.Service(first, "identity-a"), cache.Service(first, "identity-a"), cache.Service(second, "identity-b")} {
+		if _, err := service.GetSecret(context.Background(), newVaultSpec("my-secret", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("expected objects to be cached per partition, got %d and %d calls", first.calls, second.calls)
+	}
+}
+
+func TestCachedServiceFetchIsNotCancelledWithFirstRequest(t *testing.T) {
+	service := &blockingService{release: make(chan struct{})}
+	cached := NewCachedService(service, CacheOptions{TTL: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cached.GetSecret(ctx, newVaultSpec("my-secret", ""))
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	joinedBefore := testutil.ToFloat64(cacheJoined.WithLabelValues("secret"))
+	hitsBefore := testutil.ToFloat64(cacheHits.WithLabelValues("secret"))
+	second := make(chan error)
+	go func() {
+		_, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", ""))
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected cancelled request to fail with %v, got %v", context.Canceled, err)
+	}
+	close(service.release)
+	if err := <-second; err != nil {
+		t.Errorf("expected request waiting for the fetch of a cancelled request to succeed, got %+v", err)
+	}
+
+	if joined := testutil.ToFloat64(cacheJoined.WithLabelValues("secret")) - joinedBefore; joined != 1 {
+		t.Errorf("expected request waiting for a fetch to be counted as joined, got %v", joined)
+	}
+	if hits := testutil.ToFloat64(cacheHits.WithLabelValues("secret")) - hitsBefore; hits != 0 {
+		t.Errorf("expected request waiting for a fetch not to be counted as a hit, got %v", hits)
+	}
+}
+
+func TestCachedServiceReturnsCopies(t *testing.T) {
+	cached := NewCachedService(&countingService{}, CacheOptions{TTL: time.Minute})
+
+	secret, err := cached.GetSecret(context.Background(), newVaultSpec("my-secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := secret.Value
+	secret.Value = "changed"
+
+	versions, err := cached.GetSecretVersions(context.Background(), newVaultSpec("my-secret", ""), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions[0].Value = "changed"
+
+	list, err := cached.ListSecrets(context.Background(), newVaultSpec("my-secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list[0].Name = "changed"
+
+	if secret, _ := cached.GetSecret(context.Background(), newVaultSpec("my-secret", "")); secret.Value != value {
+		t.Errorf("expected cached secret '%s' not to be changed by caller, got '%s'", value, secret.Value)
+	}
+	if versions, _ := cached.GetSecretVersions(context.Background(), newVaultSpec("my-secret", ""), 1); versions[0].Value == "changed" {
+		t.Error("expected cached secret versions not to be changed by caller")
+	}
+	if list, _ := cached.ListSecrets(context.Background(), newVaultSpec("my-secret", "")); list[0].Name != "my-secret" {
+		t.Error("expected cached list of secrets not to be changed by caller")
+	}
+}
+
+func TestObjectMetadataCopy(t *testing.T) {
+	enabled := true
+	expires := time.Now()
+	metadata := ObjectMetadata{Enabled: &enabled, Expires: &expires, Tags: map[string]string{"env": "prod"}}
+
+	copied := metadata.copy()
+	*copied.Enabled = false
+	*copied.Expires = time.Time{}
+	copied.Tags["env"] = "dev"
+
+	if !*metadata.Enabled || metadata.Expires.IsZero() || metadata.Tags["env"] != "prod" {
+		t.Errorf("expected copy not to share pointers and tags, got %+v", metadata)
+	}
+}
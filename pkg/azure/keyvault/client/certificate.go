@@ -41,6 +41,8 @@ const (
 
 // Certificate handles data on Certificates from Azure Key Vault
 type Certificate struct {
+	ObjectMetadata
+
 	// Has the complete certificate with both public and private keys, if both exists
 	Certificates []*x509.Certificate
 
@@ -17,6 +17,12 @@ limitations under the License.
 package fake
 
 import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
 	vault "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/keyvault/client"
 	akv "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
 )
@@ -24,18 +30,78 @@ import (
 // AkvsService is a fake service used for testing
 type AkvsService struct {
 	FakeSecret string
-	FakeKey    string
-	FakeCert   *vault.Certificate
+	// FakeSecretVersions are versions of FakeSecret, newest first
+	FakeSecretVersions []string
+	FakeKey            string
+	FakeCert           *vault.Certificate
+
+	// Delay is the latency of every call, like a request to Azure Key Vault
+	Delay time.Duration
+	// Errors are returned instead of the fake objects, by object name
+	Errors map[string]error
+
+	calls int64
+}
+
+// Calls returns the number of calls to the service
+func (s *AkvsService) Calls() int {
+	return int(atomic.LoadInt64(&s.calls))
+}
+
+// call counts a call for the object named name, waits for Delay and returns its error, if any
+func (s *AkvsService) call(ctx context.Context, name string) error {
+	atomic.AddInt64(&s.calls, 1)
+	if s.Delay > 0 {
+		select {
+		case <-time.After(s.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.Errors[name]
+}
+
+func (s *AkvsService) GetSecret(ctx context.Context, secret *akv.AzureKeyVault) (*vault.Secret, error) {
+	if err := s.call(ctx, secret.Object.Name); err != nil {
+		return nil, err
+	}
+	return &vault.Secret{Value: s.FakeSecret}, nil
+}
+
+func (s *AkvsService) GetSecretVersions(ctx context.Context, secret *akv.AzureKeyVault, limit int) ([]*vault.Secret, error) {
+	if err := s.call(ctx, secret.Object.Name); err != nil {
+		return nil, err
+	}
+	var secrets []*vault.Secret
+	for i, value := range s.FakeSecretVersions {
+		if limit > 0 && i >= limit {
+			break
+		}
+		secrets = append(secrets, &vault.Secret{
+			ObjectMetadata: vault.ObjectMetadata{Version: fmt.Sprintf("v%d", len(s.FakeSecretVersions)-i)},
+			Value:          value,
+		})
+	}
+	return secrets, nil
 }
 
-func (s *AkvsService) GetSecret(secret *akv.AzureKeyVault) (string, error) {
-	return s.FakeSecret, nil
+func (s *AkvsService) ListSecrets(ctx context.Context, secret *akv.AzureKeyVault) ([]vault.ObjectMetadata, error) {
+	if err := s.call(ctx, secret.Object.Name); err != nil {
+		return nil, err
+	}
+	return []vault.ObjectMetadata{{Name: secret.Object.Name}}, nil
 }
 
-func (s *AkvsService) GetKey(secret *akv.AzureKeyVault) (string, error) {
-	return s.FakeKey, nil
+func (s *AkvsService) GetKey(ctx context.Context, secret *akv.AzureKeyVault) (*vault.Key, error) {
+	if err := s.call(ctx, secret.Object.Name); err != nil {
+		return nil, err
+	}
+	return &vault.Key{JSONWebKey: keyvault.JSONWebKey{N: &s.FakeKey}}, nil
 }
 
-func (s *AkvsService) GetCertificate(secret *akv.AzureKeyVault, options *vault.CertificateOptions) (*vault.Certificate, error) {
+func (s *AkvsService) GetCertificate(ctx context.Context, secret *akv.AzureKeyVault, options *vault.CertificateOptions) (*vault.Certificate, error) {
+	if err := s.call(ctx, secret.Object.Name); err != nil {
+		return nil, err
+	}
 	return s.FakeCert, nil
 }
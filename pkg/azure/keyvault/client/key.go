@@ -0,0 +1,178 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// publicJSONWebKey is the public part of a key as defined in RFC 7517
+type publicJSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type publicJSONWebKeySet struct {
+	Keys []publicJSONWebKey `json:"keys"`
+}
+
+// PublicKey returns the public key as a *rsa.PublicKey or *ecdsa.PublicKey
+func (k *Key) PublicKey() (crypto.PublicKey, error) {
+	switch k.JSONWebKey.Kty {
+	case keyvault.RSA, keyvault.RSAHSM:
+		n, err := decodeKeyParameter("n", k.JSONWebKey.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeKeyParameter("e", k.JSONWebKey.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case keyvault.EC, keyvault.ECHSM:
+		curve, err := ellipticCurve(k.JSONWebKey.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := decodeKeyParameter("x", k.JSONWebKey.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeKeyParameter("y", k.JSONWebKey.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("key type '%s' currently not supported for export", k.JSONWebKey.Kty)
+	}
+}
+
+// ExportPublicKeyAsPem returns the public key pem formatted as PKIX
+func (k *Key) ExportPublicKeyAsPem() ([]byte, error) {
+	publicKey, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key, error: %+v", err)
+	}
+
+	pubKeyBlock := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}
+	return pem.EncodeToMemory(pubKeyBlock), nil
+}
+
+// ExportPublicKeyAsJWK returns the public key as a JSON Web Key
+func (k *Key) ExportPublicKeyAsJWK() ([]byte, error) {
+	jwk, err := k.publicJSONWebKey()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwk)
+}
+
+// ExportPublicKeyAsJWKS returns the public key as a JSON Web Key Set containing only this key
+func (k *Key) ExportPublicKeyAsJWKS() ([]byte, error) {
+	jwk, err := k.publicJSONWebKey()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(publicJSONWebKeySet{Keys: []publicJSONWebKey{*jwk}})
+}
+
+func (k *Key) publicJSONWebKey() (*publicJSONWebKey, error) {
+	// Validate the key before exporting it
+	if _, err := k.PublicKey(); err != nil {
+		return nil, err
+	}
+
+	jwk := &publicJSONWebKey{
+		Kid: stringValue(k.JSONWebKey.Kid),
+	}
+
+	switch k.JSONWebKey.Kty {
+	case keyvault.RSA, keyvault.RSAHSM:
+		jwk.Kty = string(keyvault.RSA)
+		jwk.N = trimKeyParameter(k.JSONWebKey.N)
+		jwk.E = trimKeyParameter(k.JSONWebKey.E)
+	case keyvault.EC, keyvault.ECHSM:
+		jwk.Kty = string(keyvault.EC)
+		jwk.Crv = string(k.JSONWebKey.Crv)
+		jwk.X = trimKeyParameter(k.JSONWebKey.X)
+		jwk.Y = trimKeyParameter(k.JSONWebKey.Y)
+	}
+	return jwk, nil
+}
+
+func ellipticCurve(crv keyvault.JSONWebKeyCurveName) (elliptic.Curve, error) {
+	switch crv {
+	case keyvault.P256:
+		return elliptic.P256(), nil
+	case keyvault.P384:
+		return elliptic.P384(), nil
+	case keyvault.P521:
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("elliptic curve '%s' currently not supported for export", crv)
+	}
+}
+
+// decodeKeyParameter decodes a base64url encoded key parameter, with or without padding
+func decodeKeyParameter(name string, value *string) ([]byte, error) {
+	if value == nil || *value == "" {
+		return nil, fmt.Errorf("key parameter '%s' is missing", name)
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(trimKeyParameter(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key parameter '%s', error: %+v", name, err)
+	}
+	return decoded, nil
+}
+
+// trimKeyParameter removes any padding, as RFC 7518 requires base64url without padding
+func trimKeyParameter(value *string) string {
+	return strings.TrimRight(stringValue(value), "=")
+}
@@ -0,0 +1,182 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+func encodeKeyParameter(b []byte) *string {
+	value := base64.RawURLEncoding.EncodeToString(b)
+	return &value
+}
+
+func newRsaKey(t *testing.T) (*Key, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := "https://my-vault.vault.azure.net/keys/my-key/1"
+	return &Key{
+		JSONWebKey: keyvault.JSONWebKey{
+			Kid: &kid,
+			Kty: keyvault.RSAHSM,
+			N:   encodeKeyParameter(privateKey.N.Bytes()),
+			E:   encodeKeyParameter(big.NewInt(int64(privateKey.E)).Bytes()),
+		},
+	}, privateKey
+}
+
+func newEcKey(t *testing.T, curve elliptic.Curve, crv keyvault.JSONWebKeyCurveName) (*Key, *ecdsa.PrivateKey) {
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{
+		JSONWebKey: keyvault.JSONWebKey{
+			Kty: keyvault.EC,
+			Crv: crv,
+			X:   encodeKeyParameter(privateKey.X.Bytes()),
+			Y:   encodeKeyParameter(privateKey.Y.Bytes()),
+		},
+	}, privateKey
+}
+
+func parsePemPublicKey(t *testing.T, pemKey []byte) interface{} {
+	block, _ := pem.Decode(pemKey)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("expected pem block of type PUBLIC KEY, got '%s'", pemKey)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey
+}
+
+func TestExportRsaKeyAsPem(t *testing.T) {
+	key, privateKey := newRsaKey(t)
+
+	pemKey, err := key.ExportPublicKeyAsPem()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, ok := parsePemPublicKey(t, pemKey).(*rsa.PublicKey)
+	if !ok {
+		t.Fatal("expected rsa public key")
+	}
+	if !publicKey.Equal(&privateKey.PublicKey) {
+		t.Error("exported public key does not match")
+	}
+}
+
+func TestExportEcKeyAsPem(t *testing.T) {
+	curves := map[keyvault.JSONWebKeyCurveName]elliptic.Curve{
+		keyvault.P256: elliptic.P256(),
+		keyvault.P384: elliptic.P384(),
+		keyvault.P521: elliptic.P521(),
+	}
+
+	for crv, curve := range curves {
+		key, privateKey := newEcKey(t, curve, crv)
+
+		pemKey, err := key.ExportPublicKeyAsPem()
+		if err != nil {
+			t.Fatalf("%s: %+v", crv, err)
+		}
+
+		publicKey, ok := parsePemPublicKey(t, pemKey).(*ecdsa.PublicKey)
+		if !ok {
+			t.Fatalf("%s: expected ecdsa public key", crv)
+		}
+		if !publicKey.Equal(&privateKey.PublicKey) {
+			t.Errorf("%s: exported public key does not match", crv)
+		}
+	}
+}
+
+func TestExportKeyAsJWK(t *testing.T) {
+	key, _ := newRsaKey(t)
+
+	jwkRaw, err := key.ExportPublicKeyAsJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jwk map[string]string
+	if err := json.Unmarshal(jwkRaw, &jwk); err != nil {
+		t.Fatal(err)
+	}
+	if jwk["kty"] != "RSA" {
+		t.Errorf("expected kty 'RSA', got '%s'", jwk["kty"])
+	}
+	if jwk["kid"] != *key.JSONWebKey.Kid {
+		t.Errorf("expected kid '%s', got '%s'", *key.JSONWebKey.Kid, jwk["kid"])
+	}
+	if jwk["n"] != *key.JSONWebKey.N || jwk["e"] != *key.JSONWebKey.E {
+		t.Error("expected rsa modulus and exponent in jwk")
+	}
+	if _, ok := jwk["d"]; ok {
+		t.Error("jwk should not contain private key parameters")
+	}
+}
+
+func TestExportKeyAsJWKS(t *testing.T) {
+	key, _ := newEcKey(t, elliptic.P256(), keyvault.P256)
+
+	jwksRaw, err := key.ExportPublicKeyAsJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(jwksRaw, &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("expected 1 key in jwks, got %d", len(jwks.Keys))
+	}
+	if jwks.Keys[0]["kty"] != "EC" || jwks.Keys[0]["crv"] != "P-256" {
+		t.Errorf("expected EC key on curve P-256, got %+v", jwks.Keys[0])
+	}
+}
+
+func TestExportUnsupportedKey(t *testing.T) {
+	key, _ := newEcKey(t, elliptic.P256(), keyvault.SECP256K1)
+	if _, err := key.ExportPublicKeyAsPem(); err == nil {
+		t.Error("expected error for unsupported curve")
+	}
+
+	key = &Key{JSONWebKey: keyvault.JSONWebKey{Kty: keyvault.Oct}}
+	if _, err := key.ExportPublicKeyAsJWK(); err == nil {
+		t.Error("expected error for symmetric key")
+	}
+}
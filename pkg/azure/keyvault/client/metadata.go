@@ -0,0 +1,195 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+// ObjectMetadata has metadata about an object in Azure Key Vault
+type ObjectMetadata struct {
+	// ID is the Azure Key Vault identifier of the object, including version
+	ID string
+
+	// Name is the name of the object
+	Name string
+
+	// Version is the resolved version of the object
+	Version string
+
+	// ContentType is the content type of the object, if set
+	ContentType string
+
+	// Enabled is false if the object is disabled in Azure Key Vault
+	Enabled *bool
+
+	// NotBefore is the time before which the object is not valid, if set
+	NotBefore *time.Time
+
+	// Expires is the time the object expires, if set
+	Expires *time.Time
+
+	// Created is the time the object version was created
+	Created *time.Time
+
+	// Updated is the time the object version was last updated, which is
+	// also set on objects listed without version
+	Updated *time.Time
+
+	// Tags are the tags set on the object in Azure Key Vault
+	Tags map[string]string
+}
+
+// Secret is a secret value from Azure Key Vault
+type Secret struct {
+	ObjectMetadata
+	Value string
+}
+
+// Key is the public part of a key from Azure Key Vault
+type Key struct {
+	ObjectMetadata
+	JSONWebKey keyvault.JSONWebKey
+}
+
+// copy returns a copy of the metadata not sharing its pointers and tags
+func (m ObjectMetadata) copy() ObjectMetadata {
+	copied := m
+	if m.Enabled != nil {
+		enabled := *m.Enabled
+		copied.Enabled = &enabled
+	}
+	for _, t := range []**time.Time{&copied.NotBefore, &copied.Expires, &copied.Created, &copied.Updated} {
+		if *t != nil {
+			value := **t
+			*t = &value
+		}
+	}
+	if m.Tags != nil {
+		copied.Tags = make(map[string]string, len(m.Tags))
+		for name, value := range m.Tags {
+			copied.Tags[name] = value
+		}
+	}
+	return copied
+}
+
+// IsEnabled returns false if the object is explicitly disabled in Azure Key Vault
+func (m ObjectMetadata) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+func newObjectMetadata(id, contentType *string, enabled *bool, notBefore, expires *date.UnixTime, tags map[string]*string) ObjectMetadata {
+	metadata := ObjectMetadata{
+		ID:          stringValue(id),
+		Name:        nameFromID(stringValue(id)),
+		Version:     versionFromID(stringValue(id)),
+		ContentType: stringValue(contentType),
+		Enabled:     enabled,
+		NotBefore:   timeValue(notBefore),
+		Expires:     timeValue(expires),
+	}
+
+	if len(tags) > 0 {
+		metadata.Tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			metadata.Tags[k] = stringValue(v)
+		}
+	}
+	return metadata
+}
+
+func newSecretMetadata(bundle keyvault.SecretBundle) ObjectMetadata {
+	var attributes keyvault.SecretAttributes
+	if bundle.Attributes != nil {
+		attributes = *bundle.Attributes
+	}
+	metadata := newObjectMetadata(bundle.ID, bundle.ContentType, attributes.Enabled, attributes.NotBefore, attributes.Expires, bundle.Tags)
+	metadata.Created = timeValue(attributes.Created)
+	metadata.Updated = timeValue(attributes.Updated)
+	return metadata
+}
+
+func newSecretItemMetadata(item keyvault.SecretItem) ObjectMetadata {
+	var attributes keyvault.SecretAttributes
+	if item.Attributes != nil {
+		attributes = *item.Attributes
+	}
+	metadata := newObjectMetadata(item.ID, item.ContentType, attributes.Enabled, attributes.NotBefore, attributes.Expires, item.Tags)
+	metadata.Created = timeValue(attributes.Created)
+	metadata.Updated = timeValue(attributes.Updated)
+	return metadata
+}
+
+func newKeyMetadata(bundle keyvault.KeyBundle) ObjectMetadata {
+	var attributes keyvault.KeyAttributes
+	if bundle.Attributes != nil {
+		attributes = *bundle.Attributes
+	}
+	var id *string
+	if bundle.Key != nil {
+		id = bundle.Key.Kid
+	}
+	return newObjectMetadata(id, nil, attributes.Enabled, attributes.NotBefore, attributes.Expires, bundle.Tags)
+}
+
+func newCertificateMetadata(bundle keyvault.CertificateBundle) ObjectMetadata {
+	var attributes keyvault.CertificateAttributes
+	if bundle.Attributes != nil {
+		attributes = *bundle.Attributes
+	}
+	return newObjectMetadata(bundle.ID, bundle.ContentType, attributes.Enabled, attributes.NotBefore, attributes.Expires, bundle.Tags)
+}
+
+// nameFromID returns the name part of a Azure Key Vault object id
+// like https://myvault.vault.azure.net/secrets/my-secret/<version>
+func nameFromID(id string) string {
+	parts := strings.Split(strings.TrimSuffix(id, "/"), "/")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+// versionFromID returns the version part of a Azure Key Vault object id
+// like https://myvault.vault.azure.net/secrets/my-secret/<version>
+func versionFromID(id string) string {
+	parts := strings.Split(strings.TrimSuffix(id, "/"), "/")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func timeValue(t *date.UnixTime) *time.Time {
+	if t == nil {
+		return nil
+	}
+	value := time.Time(*t)
+	return &value
+}
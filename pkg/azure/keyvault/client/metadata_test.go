@@ -0,0 +1,84 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+func TestNewSecretMetadata(t *testing.T) {
+	id := "https://my-vault.vault.azure.net/secrets/my-secret/4387e9f3d6e14c459867679a90fd0f79"
+	contentType := "application/x-json"
+	enabled := false
+	expires := date.UnixTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	env := "test"
+
+	metadata := newSecretMetadata(keyvault.SecretBundle{
+		ID:          &id,
+		ContentType: &contentType,
+		Attributes: &keyvault.SecretAttributes{
+			Enabled: &enabled,
+			Expires: &expires,
+		},
+		Tags: map[string]*string{"env": &env},
+	})
+
+	if metadata.Name != "my-secret" {
+		t.Errorf("expected name from id, got '%s'", metadata.Name)
+	}
+	if metadata.Version != "4387e9f3d6e14c459867679a90fd0f79" {
+		t.Errorf("expected version from id, got '%s'", metadata.Version)
+	}
+	if metadata.ContentType != contentType {
+		t.Errorf("expected content type '%s', got '%s'", contentType, metadata.ContentType)
+	}
+	if metadata.IsEnabled() {
+		t.Error("expected secret to be disabled")
+	}
+	if metadata.Expires == nil || !metadata.Expires.Equal(time.Time(expires)) {
+		t.Errorf("expected expires '%v', got '%v'", time.Time(expires), metadata.Expires)
+	}
+	if metadata.NotBefore != nil {
+		t.Errorf("expected no not before, got '%v'", metadata.NotBefore)
+	}
+	if metadata.Tags["env"] != env {
+		t.Errorf("expected tag env '%s', got '%s'", env, metadata.Tags["env"])
+	}
+}
+
+func TestNewSecretMetadataWithoutAttributes(t *testing.T) {
+	metadata := newSecretMetadata(keyvault.SecretBundle{})
+	if metadata.Version != "" || metadata.Expires != nil || !metadata.IsEnabled() {
+		t.Errorf("expected empty metadata, got %+v", metadata)
+	}
+}
+
+func TestNewSecretItemMetadataWithoutVersion(t *testing.T) {
+	id := "https://my-vault.vault.azure.net/secrets/my-secret"
+	updated := date.UnixTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	metadata := newSecretItemMetadata(keyvault.SecretItem{ID: &id, Attributes: &keyvault.SecretAttributes{Updated: &updated}})
+	if metadata.Name != "my-secret" || metadata.Version != "" {
+		t.Errorf("expected name without version, got %+v", metadata)
+	}
+	if metadata.Updated == nil || !metadata.Updated.Equal(time.Time(updated)) {
+		t.Errorf("expected updated '%v', got '%v'", time.Time(updated), metadata.Updated)
+	}
+}
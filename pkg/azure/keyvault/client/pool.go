@@ -0,0 +1,76 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var identityServices = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "akv2k8s_vault_identity_services",
+	Help: "The number of azure identities with a Azure Key Vault service in the pool",
+})
+
+// ServiceFactory creates a Service authenticating with azureIdentity
+type ServiceFactory func(azureIdentity string) (Service, error)
+
+// IdentityServicePool holds one Service per azure identity, so objects
+// fetched with one identity are never shared with another identity
+type IdentityServicePool struct {
+	factory  ServiceFactory
+	mutex    sync.Mutex
+	services map[string]Service
+}
+
+// NewIdentityServicePool creates a pool creating services with factory
+// the first time an azure identity is used
+func NewIdentityServicePool(factory ServiceFactory) *IdentityServicePool {
+	return &IdentityServicePool{
+		factory:  factory,
+		services: make(map[string]Service),
+	}
+}
+
+// Get returns the Service for azureIdentity, creating it if not in the pool.
+// Services failing to be created are not added, so creation is retried on next Get
+func (p *IdentityServicePool) Get(azureIdentity string) (Service, error) {
+	if azureIdentity == "" {
+		return nil, fmt.Errorf("azure identity not set")
+	}
+
+	// Creating credentials may assign the identity to the node, so creation
+	// is serialized to avoid concurrent updates of the same VM or VMSS
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if service, ok := p.services[azureIdentity]; ok {
+		return service, nil
+	}
+
+	service, err := p.factory(azureIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure key vault service for azure identity '%s', error: %+v", azureIdentity, err)
+	}
+
+	p.services[azureIdentity] = service
+	identityServices.Set(float64(len(p.services)))
+	return service, nil
+}
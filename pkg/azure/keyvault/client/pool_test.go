@@ -0,0 +1,72 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIdentityServicePool(t *testing.T) {
+	created := map[string]int{}
+	fail := true
+	pool := NewIdentityServicePool(func(azureIdentity string) (Service, error) {
+		created[azureIdentity]++
+		if azureIdentity == "broken" && fail {
+			return nil, fmt.Errorf("no access")
+		}
+		return &countingService{}, nil
+	})
+
+	team1, err := pool.Get("team1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := pool.Get("team1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team1 != again {
+		t.Error("expected same service for same azure identity")
+	}
+
+	team2, err := pool.Get("team2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team1 == team2 {
+		t.Error("expected separate services for different azure identities")
+	}
+	if created["team1"] != 1 || created["team2"] != 1 {
+		t.Errorf("expected each service created once, got %+v", created)
+	}
+
+	if _, err := pool.Get("broken"); err == nil {
+		t.Error("expected error when service creation fails")
+	}
+	fail = false
+	if _, err := pool.Get("broken"); err != nil {
+		t.Errorf("expected failed service creation to be retried, got error: %+v", err)
+	}
+	if created["broken"] != 2 {
+		t.Errorf("expected failed service to be created again, got %d", created["broken"])
+	}
+
+	if _, err := pool.Get(""); err == nil {
+		t.Error("expected error for empty azure identity")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
@@ -32,21 +33,32 @@ const (
 	certificateTypePfx        = "application/x-pkcs12"
 )
 
+// DefaultRequestTimeout is the default timeout for requests to Azure Key Vault
+const DefaultRequestTimeout = 30 * time.Second
+
 // Service is an interface for implementing vaults
 type Service interface {
-	GetSecret(secret *akvs.AzureKeyVault) (string, error)
-	GetKey(secret *akvs.AzureKeyVault) (string, error)
-	GetCertificate(secret *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error)
+	GetSecret(ctx context.Context, secret *akvs.AzureKeyVault) (*Secret, error)
+	GetSecretVersions(ctx context.Context, secret *akvs.AzureKeyVault, limit int) ([]*Secret, error)
+	ListSecrets(ctx context.Context, secret *akvs.AzureKeyVault) ([]ObjectMetadata, error)
+	GetKey(ctx context.Context, secret *akvs.AzureKeyVault) (*Key, error)
+	GetCertificate(ctx context.Context, secret *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error)
 }
 
 type azureKeyVaultService struct {
-	credentials credentialprovider.AzureKeyVaultCredentials
+	credentials    credentialprovider.AzureKeyVaultCredentials
+	requestTimeout time.Duration
 }
 
-// NewService creates a new AzureKeyVaultService
-func NewService(credentials credentialprovider.AzureKeyVaultCredentials) Service {
+// NewService creates a new AzureKeyVaultService, where each request to
+// Azure Key Vault is cancelled after requestTimeout or when ctx is done
+func NewService(credentials credentialprovider.AzureKeyVaultCredentials, requestTimeout time.Duration) Service {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
 	return &azureKeyVaultService{
-		credentials: credentials,
+		credentials:    credentials,
+		requestTimeout: requestTimeout,
 	}
 }
 
@@ -57,77 +69,196 @@ type CertificateOptions struct {
 }
 
 // GetSecret download secrets from Azure Key Vault
-func (a *azureKeyVaultService) GetSecret(vaultSpec *akvs.AzureKeyVault) (string, error) {
+func (a *azureKeyVaultService) GetSecret(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Secret, error) {
 	if vaultSpec.Object.Name == "" {
-		return "", fmt.Errorf("azurekeyvaultsecret.spec.vault.object.name not set")
+		return nil, fmt.Errorf("azurekeyvaultsecret.spec.vault.object.name not set")
 	}
 
 	//Get secret value from Azure Key Vault
 	vaultClient, err := a.getClient()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
 	defer cancel()
 
 	baseURL := a.credentials.Endpoint(vaultSpec.Name)
 	secretBundle, err := vaultClient.GetSecret(ctx, baseURL, vaultSpec.Object.Name, vaultSpec.Object.Version)
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return &Secret{
+		ObjectMetadata: newSecretMetadata(secretBundle),
+		Value:          stringValue(secretBundle.Value),
+	}, nil
+}
+
+// GetSecretVersions download the latest enabled versions of a secret from Azure Key Vault,
+// newest first, returning at most limit versions
+func (a *azureKeyVaultService) GetSecretVersions(ctx context.Context, vaultSpec *akvs.AzureKeyVault, limit int) ([]*Secret, error) {
+	if vaultSpec.Object.Name == "" {
+		return nil, fmt.Errorf("azurekeyvaultsecret.spec.vault.object.name not set")
+	}
+
+	vaultClient, err := a.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
+	defer cancel()
+
+	baseURL := a.credentials.Endpoint(vaultSpec.Name)
+	it, err := vaultClient.GetSecretVersionsComplete(ctx, baseURL, vaultSpec.Object.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of secret from azure key vault, error: %w", err)
+	}
+
+	var versions []ObjectMetadata
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of secret from azure key vault, error: %w", err)
+		}
+		metadata := newSecretItemMetadata(it.Value())
+		if metadata.IsEnabled() {
+			versions = append(versions, metadata)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of secret from azure key vault, error: %w", err)
+	}
+
+	sortNewestFirst(versions)
+	if limit > 0 && len(versions) > limit {
+		versions = versions[:limit]
+	}
+
+	secrets := make([]*Secret, 0, len(versions))
+	for _, version := range versions {
+		secretBundle, err := vaultClient.GetSecret(ctx, baseURL, vaultSpec.Object.Name, version.Version)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &Secret{
+			ObjectMetadata: newSecretMetadata(secretBundle),
+			Value:          stringValue(secretBundle.Value),
+		})
+	}
+	return secrets, nil
+}
+
+// ListSecrets lists metadata of all enabled secrets in Azure Key Vault, excluding
+// secrets managed by Azure Key Vault like those backing certificates
+func (a *azureKeyVaultService) ListSecrets(ctx context.Context, vaultSpec *akvs.AzureKeyVault) ([]ObjectMetadata, error) {
+	vaultClient, err := a.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
+	defer cancel()
+
+	baseURL := a.credentials.Endpoint(vaultSpec.Name)
+	it, err := vaultClient.GetSecretsComplete(ctx, baseURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets from azure key vault, error: %w", err)
+	}
+
+	var secrets []ObjectMetadata
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets from azure key vault, error: %w", err)
+		}
+		item := it.Value()
+		if item.Managed != nil && *item.Managed {
+			continue
+		}
+		metadata := newSecretItemMetadata(item)
+		if metadata.IsEnabled() {
+			secrets = append(secrets, metadata)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets from azure key vault, error: %w", err)
 	}
-	return *secretBundle.Value, nil
+	return secrets, nil
+}
+
+// sortNewestFirst sorts versions by creation time, newest first
+func sortNewestFirst(versions []ObjectMetadata) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Created == nil || versions[j].Created == nil {
+			return versions[j].Created == nil && versions[i].Created != nil
+		}
+		return versions[i].Created.After(*versions[j].Created)
+	})
 }
 
 // GetKey download encryption keys from Azure Key Vault
-func (a *azureKeyVaultService) GetKey(vaultSpec *akvs.AzureKeyVault) (string, error) {
+func (a *azureKeyVaultService) GetKey(ctx context.Context, vaultSpec *akvs.AzureKeyVault) (*Key, error) {
 	if vaultSpec.Object.Name == "" {
-		return "", fmt.Errorf("azurekeyvaultsecret.spec.vault.object.name not set")
+		return nil, fmt.Errorf("azurekeyvaultsecret.spec.vault.object.name not set")
 	}
 
 	vaultClient, err := a.getClient()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
 	defer cancel()
 
 	baseURL := a.credentials.Endpoint(vaultSpec.Name)
 	keyBundle, err := vaultClient.GetKey(ctx, baseURL, vaultSpec.Object.Name, vaultSpec.Object.Version)
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if keyBundle.Key == nil {
+		return nil, fmt.Errorf("no key returned from azure key vault for '%s'", vaultSpec.Object.Name)
 	}
 
-	return *keyBundle.Key.N, nil
+	return &Key{
+		ObjectMetadata: newKeyMetadata(keyBundle),
+		JSONWebKey:     *keyBundle.Key,
+	}, nil
 }
 
 // GetCertificate download public/private certificates from Azure Key Vault
-func (a *azureKeyVaultService) GetCertificate(vaultSpec *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error) {
+func (a *azureKeyVaultService) GetCertificate(ctx context.Context, vaultSpec *akvs.AzureKeyVault, options *CertificateOptions) (*Certificate, error) {
 	vaultClient, err := a.getClient()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout)
 	defer cancel()
 
 	baseURL := a.credentials.Endpoint(vaultSpec.Name)
 
 	certBundle, err := vaultClient.GetCertificate(ctx, baseURL, vaultSpec.Object.Name, vaultSpec.Object.Version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get certificate from azure key vault, error: %+v", err)
+		return nil, fmt.Errorf("failed to get certificate from azure key vault, error: %w", err)
+	}
+
+	cert, err := a.exportCertificate(ctx, vaultClient, baseURL, vaultSpec, certBundle, options)
+	if err != nil {
+		return nil, err
 	}
+	cert.ObjectMetadata = newCertificateMetadata(certBundle)
+	return cert, nil
+}
 
-	if options.ExportPrivateKey {
+func (a *azureKeyVaultService) exportCertificate(ctx context.Context, vaultClient *keyvault.BaseClient, baseURL string, vaultSpec *akvs.AzureKeyVault, certBundle keyvault.CertificateBundle, options *CertificateOptions) (*Certificate, error) {
+	if options != nil && options.ExportPrivateKey {
 		if !*certBundle.Policy.KeyProperties.Exportable {
 			return nil, fmt.Errorf("cannot export private key because key is not exportable in azure key vault")
 		}
 		secretBundle, err := vaultClient.GetSecret(ctx, baseURL, vaultSpec.Object.Name, vaultSpec.Object.Version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get private certificate from azure key vault, error: %+v", err)
+			return nil, fmt.Errorf("failed to get private certificate from azure key vault, error: %w", err)
 		}
 
 		switch *secretBundle.ContentType {
@@ -138,10 +269,7 @@ func (a *azureKeyVaultService) GetCertificate(vaultSpec *akvs.AzureKeyVault, opt
 			if err != nil {
 				return nil, fmt.Errorf("failed to decode base64 encoded pfx, error: %+v", err)
 			}
-			if options.EnsureServerFirst {
-				return NewCertificateFromPfx(pfxRaw, true)
-			}
-			return NewCertificateFromPfx(pfxRaw, false)
+			return NewCertificateFromPfx(pfxRaw, options.EnsureServerFirst)
 		default:
 			return nil, fmt.Errorf("failed to get certificate from azure key vault - unknown content type '%s'", *secretBundle.ContentType)
 		}
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	akv2k8sTesting "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/akv2k8s/testing"
 	auth "github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/azure/credentialprovider"
@@ -88,15 +90,15 @@ func TestIntegrationGetSecret(t *testing.T) {
 		t.Error(err)
 	}
 
-	srvc := NewService(creds)
+	srvc := NewService(creds, DefaultRequestTimeout)
 	akvSecret := newAzureKeyVaultSecret("mySecret", "akv2k8s-test", "my-secret")
 
-	secret, err := srvc.GetSecret(&akvSecret.Spec.Vault)
+	secret, err := srvc.GetSecret(context.Background(), &akvSecret.Spec.Vault)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if secret == "" {
+	if secret.Value == "" {
 		t.Fail()
 	}
 
@@ -115,16 +117,34 @@ func TestIntegrationEnvironmentGetSecret(t *testing.T) {
 		t.Error(err)
 	}
 
-	srvc := NewService(creds)
+	srvc := NewService(creds, DefaultRequestTimeout)
 	akvSecret := newAzureKeyVaultSecret("mySecret", "akv2k8s-test", "my-secret")
 
-	secret, err := srvc.GetSecret(&akvSecret.Spec.Vault)
+	secret, err := srvc.GetSecret(context.Background(), &akvSecret.Spec.Vault)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if secret == "" {
+	if secret.Value == "" {
 		t.Fail()
 	}
 
 }
+
+func TestSortNewestFirst(t *testing.T) {
+	now := time.Now()
+	older := now.Add(-time.Hour)
+	versions := []ObjectMetadata{
+		{Version: "unknown"},
+		{Version: "older", Created: &older},
+		{Version: "newest", Created: &now},
+	}
+
+	sortNewestFirst(versions)
+
+	for i, expected := range []string{"newest", "older", "unknown"} {
+		if versions[i].Version != expected {
+			t.Errorf("expected version '%s' at %d, got '%s'", expected, i, versions[i].Version)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/SparebankenVest/azure-key-vault-to-kubernetes/pkg/k8s/apis/azurekeyvault/v2beta1"
+)
+
+// ConvertTo converts this AzureKeyVaultSecret to the hub version (v2beta1). Fields not supported by
+// v1alpha1 are restored from the conversion data annotation by the conversion package.
+func (src *AzureKeyVaultSecret) ConvertTo(dst *v2beta1.AzureKeyVaultSecret) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec = v2beta1.AzureKeyVaultSecretSpec{
+		Vault: v2beta1.AzureKeyVault{
+			Name: src.Spec.Vault.Name,
+			Object: v2beta1.AzureKeyVaultObject{
+				Name:        src.Spec.Vault.Object.Name,
+				Type:        v2beta1.AzureKeyVaultObjectType(src.Spec.Vault.Object.Type),
+				Version:     src.Spec.Vault.Object.Version,
+				ContentType: v2beta1.AzureKeyVaultObjectContentType(src.Spec.Vault.Object.ContentType),
+			},
+		},
+		Output: v2beta1.AzureKeyVaultOutput{
+			Secret: v2beta1.AzureKeyVaultOutputSecret{
+				Name:    src.Spec.Output.Secret.Name,
+				Type:    src.Spec.Output.Secret.Type,
+				DataKey: src.Spec.Output.Secret.DataKey,
+			},
+			Transform: copyStrings(src.Spec.Output.Transforms),
+		},
+	}
+
+	dst.Status = v2beta1.AzureKeyVaultSecretStatus{
+		SecretHash:      src.Status.SecretHash,
+		SecretName:      src.Status.SecretName,
+		LastAzureUpdate: src.Status.LastAzureUpdate,
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v2beta1) to this AzureKeyVaultSecret
+func (dst *AzureKeyVaultSecret) ConvertFrom(src *v2beta1.AzureKeyVaultSecret) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec = AzureKeyVaultSecretSpec{
+		Vault: AzureKeyVault{
+			Name: src.Spec.Vault.Name,
+			Object: AzureKeyVaultObject{
+				Name:        src.Spec.Vault.Object.Name,
+				Type:        AzureKeyVaultObjectType(src.Spec.Vault.Object.Type),
+				Version:     src.Spec.Vault.Object.Version,
+				ContentType: AzureKeyVaultObjectContentType(src.Spec.Vault.Object.ContentType),
+			},
+		},
+		Output: AzureKeyVaultOutput{
+			Secret: AzureKeyVaultOutputSecret{
+				Name:    src.Spec.Output.Secret.Name,
+				Type:    src.Spec.Output.Secret.Type,
+				DataKey: src.Spec.Output.Secret.DataKey,
+			},
+			Transforms: copyStrings(src.Spec.Output.Transform),
+		},
+	}
+
+	dst.Status = AzureKeyVaultSecretStatus{
+		SecretHash:      src.Status.SecretHash,
+		SecretName:      src.Status.SecretName,
+		LastAzureUpdate: src.Status.LastAzureUpdate,
+	}
+
+	return v2beta1.MarshalConversionData(src, dst)
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	out := make([]string, len(values))
+	copy(out, values)
+	return out
+}
@@ -0,0 +1,79 @@
+/*
+Copyright Sparebanken Vest
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2beta1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConversionDataAnnotation holds the spec and status of an AzureKeyVaultSecret
+// converted to an older version, so fields the older version does not support
+// are not lost when the object is converted back
+const ConversionDataAnnotation = "spv.no/conversion-data"
+
+// Hub marks this version as the one all other versions are converted through
+func (*AzureKeyVaultSecret) Hub() {}
+
+type conversionData struct {
+	Spec   AzureKeyVaultSecretSpec   `json:"spec"`
+	Status AzureKeyVaultSecretStatus `json:"status,omitempty"`
+}
+
+// MarshalConversionData stores the spec and status of src in an annotation on dst
+func MarshalConversionData(src *AzureKeyVaultSecret, dst metav1.Object) error {
+	data, err := json.Marshal(conversionData{Spec: src.Spec, Status: src.Status})
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversion data, error: %+v", err)
+	}
+
+	annotations := dst.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[ConversionDataAnnotation] = string(data)
+	dst.SetAnnotations(annotations)
+	return nil
+}
+
+// UnmarshalConversionData returns the spec and status stored by MarshalConversionData
+// and removes the annotation from obj. Returns nil if no conversion data is stored
+func UnmarshalConversionData(obj metav1.Object) (*AzureKeyVaultSecret, error) {
+	annotations := obj.GetAnnotations()
+	data, ok := annotations[ConversionDataAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var restored conversionData
+	if err := json.Unmarshal([]byte(data), &restored); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal conversion data, error: %+v", err)
+	}
+
+	delete(annotations, ConversionDataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+
+	return &AzureKeyVaultSecret{
+		Spec:   restored.Spec,
+		Status: restored.Status,
+	}, nil
+}
@@ -46,6 +46,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&AzureKeyVaultSecret{},
 		&AzureKeyVaultSecretList{},
+		&AzureKeyVaultCredential{},
+		&AzureKeyVaultCredentialList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
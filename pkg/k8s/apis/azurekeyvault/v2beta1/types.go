@@ -56,6 +56,9 @@ type AzureKeyVaultSecretList struct {
 type AzureKeyVaultSecretSpec struct {
 	Vault  AzureKeyVault       `json:"vault"`
 	Output AzureKeyVaultOutput `json:"output,omitempty"`
+	// +optional
+	// How often to check Azure Key Vault for changes, defaults to the controller default refresh interval
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
 }
 
 // AzureKeyVault contains information needed to get the
@@ -78,7 +81,8 @@ type AzureIdentity struct {
 // AzureKeyVaultObject has information about the Azure Key Vault
 // object to get from Azure Key Vault
 type AzureKeyVaultObject struct {
-	// The object name in Azure Key Vault
+	// +optional
+	// The object name in Azure Key Vault, required unless selector is set
 	Name string                  `json:"name"`
 	Type AzureKeyVaultObjectType `json:"type"`
 	// +optional
@@ -86,6 +90,35 @@ type AzureKeyVaultObject struct {
 	Version string `json:"version"`
 	// +optional
 	ContentType AzureKeyVaultObjectContentType `json:"contentType"`
+	// +optional
+	// Number of latest enabled versions of a secret to sync. Older versions are written
+	// to data keys suffixed with -previous, -previous-2 and so on
+	// +kubebuilder:validation:Minimum=1
+	VersionHistoryLimit int `json:"versionHistoryLimit,omitempty"`
+	// +optional
+	// Select multiple secrets in Azure Key Vault instead of a single named object,
+	// only used when type is secret
+	Selector *AzureKeyVaultObjectSelector `json:"selector,omitempty"`
+}
+
+// AzureKeyVaultObjectSelector selects secrets in Azure Key Vault to sync into
+// one Kubernetes Secret or ConfigMap, using the secret name as data key unless mapped
+type AzureKeyVaultObjectSelector struct {
+	// +optional
+	// Only select secrets with names starting with this prefix
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// +optional
+	// Only select secrets with names matching this regular expression
+	NameRegex string `json:"nameRegex,omitempty"`
+	// +optional
+	// Only select secrets with all these tags set in Azure Key Vault
+	Tags map[string]string `json:"tags,omitempty"`
+	// +optional
+	// Remove namePrefix from the secret names when used as data keys
+	TrimPrefix bool `json:"trimPrefix,omitempty"`
+	// +optional
+	// Data keys to use for specific secret names, overriding the default
+	KeyMapping map[string]string `json:"keyMapping,omitempty"`
 }
 
 // AzureKeyVaultObjectType defines which Object type to get from Azure Key Vault
@@ -142,8 +175,27 @@ type AzureKeyVaultOutputSecret struct {
 	// By setting chainOrder to ensureserverfirst the server certificate will be moved first in the chain
 	// +kubebuilder:validation:Enum=ensureserverfirst
 	ChainOrder string `json:"chainOrder,omitempty"`
+	// +optional
+	// Format to output keys in. If not set, only the base64url encoded RSA modulus is used
+	KeyFormat AzureKeyVaultKeyFormat `json:"keyFormat,omitempty"`
 }
 
+// AzureKeyVaultKeyFormat defines what format to output the public part of a key in,
+// only used when type is key
+// +kubebuilder:validation:Enum=pem;jwk;jwks
+type AzureKeyVaultKeyFormat string
+
+const (
+	// AzureKeyVaultKeyFormatPem - output key as pem formatted PKIX public key
+	AzureKeyVaultKeyFormatPem AzureKeyVaultKeyFormat = "pem"
+
+	// AzureKeyVaultKeyFormatJWK - output key as JSON Web Key
+	AzureKeyVaultKeyFormatJWK AzureKeyVaultKeyFormat = "jwk"
+
+	// AzureKeyVaultKeyFormatJWKS - output key as JSON Web Key Set
+	AzureKeyVaultKeyFormatJWKS AzureKeyVaultKeyFormat = "jwks"
+)
+
 // AzureKeyVaultOutputConfigMap has information needed to output
 // a secret from Azure Key Vault to Kubertnetes as a ConfigMap resource
 type AzureKeyVaultOutputConfigMap struct {
@@ -151,6 +203,9 @@ type AzureKeyVaultOutputConfigMap struct {
 	Name string `json:"name"`
 	// The key to use in Kubernetes ConfigMap when setting the value from Azure Keyv Vault object data
 	DataKey string `json:"dataKey"`
+	// +optional
+	// Format to output keys in. If not set, only the base64url encoded RSA modulus is used
+	KeyFormat AzureKeyVaultKeyFormat `json:"keyFormat,omitempty"`
 }
 
 // AzureKeyVaultSecretStatus is the status for a AzureKeyVaultSecret resource
@@ -160,4 +215,126 @@ type AzureKeyVaultSecretStatus struct {
 	ConfigMapHash   string      `json:"configMapHash,omitempty"`
 	ConfigMapName   string      `json:"configMapName,omitempty"`
 	LastAzureUpdate metav1.Time `json:"lastAzureUpdate,omitempty"`
+	// +optional
+	// The resolved version of the object in Azure Key Vault
+	ObjectVersion string `json:"objectVersion,omitempty"`
+	// +optional
+	// When the object in Azure Key Vault expires, if set
+	ObjectExpires *metav1.Time `json:"objectExpires,omitempty"`
+	// +optional
+	// The versions of the object in Azure Key Vault synced when using versionHistoryLimit, newest first,
+	// or of each secret synced when using a selector, as name/version@updated
+	ObjectVersions []string `json:"objectVersions,omitempty"`
+	// +optional
+	// The generation of the AzureKeyVaultSecret last processed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// Conditions describing the current state of the AzureKeyVaultSecret
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// +optional
+	// The error from the last failed sync, cleared on successful sync
+	LastError string `json:"lastError,omitempty"`
+	// +optional
+	// The number of consecutive failed syncs, reset on successful sync
+	FailureCount int `json:"failureCount,omitempty"`
+}
+
+const (
+	// AzureKeyVaultSecretConditionReady is true when the output Secret or ConfigMap
+	// is in sync with Azure Key Vault
+	AzureKeyVaultSecretConditionReady = "Ready"
+
+	// AzureKeyVaultSecretConditionSyncedFromAzure is true when the object was
+	// successfully retrieved from Azure Key Vault
+	AzureKeyVaultSecretConditionSyncedFromAzure = "SyncedFromAzure"
+
+	// AzureKeyVaultSecretConditionOutputConflict is true when the output Secret or
+	// ConfigMap exists and cannot be managed by the AzureKeyVaultSecret
+	AzureKeyVaultSecretConditionOutputConflict = "OutputConflict"
+)
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:shortName=akvc,categories=all
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`,description="Type of credential"
+// +kubebuilder:printcolumn:name="Secret Name",type=string,JSONPath=`.spec.secretRef.name`,description="Which Kubernetes Secret holds the credential"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`,description="Time since this resource was created"
+
+// AzureKeyVaultCredential is a service principal used to sync AzureKeyVaultSecrets
+// in the same namespace, instead of the credentials of the controller
+type AzureKeyVaultCredential struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AzureKeyVaultCredentialSpec `json:"spec"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AzureKeyVaultCredentialList is a list of AzureKeyVaultCredential resources
+type AzureKeyVaultCredentialList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []AzureKeyVaultCredential `json:"items"`
+}
+
+// AzureKeyVaultCredentialSpec is the spec for a AzureKeyVaultCredential resource
+type AzureKeyVaultCredentialSpec struct {
+	// +kubebuilder:validation:Enum=clientSecret;clientCertificate;federatedToken
+	// How the service principal authenticates with Azure AD
+	Type AzureKeyVaultCredentialType `json:"type"`
+	// The Azure AD tenant of the service principal
+	TenantID string `json:"tenantId"`
+	// +optional
+	// The Azure cloud name, defaults to AzurePublicCloud
+	Cloud string `json:"cloud,omitempty"`
+	// Kubernetes Secret in the same namespace holding the clientId and
+	// the clientSecret, clientCertificate or federatedToken of the service principal
+	SecretRef AzureKeyVaultCredentialSecretRef `json:"secretRef"`
 }
+
+// AzureKeyVaultCredentialSecretRef references a Kubernetes Secret
+// in the same namespace as the AzureKeyVaultCredential
+type AzureKeyVaultCredentialSecretRef struct {
+	// Name of the Kubernetes Secret
+	Name string `json:"name"`
+}
+
+// AzureKeyVaultCredentialType is how a service principal authenticates with Azure AD
+type AzureKeyVaultCredentialType string
+
+const (
+	// AzureKeyVaultCredentialTypeClientSecret authenticates with a client secret
+	AzureKeyVaultCredentialTypeClientSecret AzureKeyVaultCredentialType = "clientSecret"
+
+	// AzureKeyVaultCredentialTypeClientCertificate authenticates with a client certificate
+	AzureKeyVaultCredentialTypeClientCertificate AzureKeyVaultCredentialType = "clientCertificate"
+
+	// AzureKeyVaultCredentialTypeFederatedToken authenticates with a federated token
+	AzureKeyVaultCredentialTypeFederatedToken AzureKeyVaultCredentialType = "federatedToken"
+)
+
+// Keys in the Kubernetes Secret referenced by an AzureKeyVaultCredential
+const (
+	// AzureKeyVaultCredentialClientIDKey holds the client id of the service principal
+	AzureKeyVaultCredentialClientIDKey = "clientId"
+
+	// AzureKeyVaultCredentialClientSecretKey holds the client secret
+	AzureKeyVaultCredentialClientSecretKey = "clientSecret"
+
+	// AzureKeyVaultCredentialClientCertificateKey holds the client certificate and
+	// private key, either PEM encoded or as PKCS #12
+	AzureKeyVaultCredentialClientCertificateKey = "clientCertificate"
+
+	// AzureKeyVaultCredentialClientCertificatePasswordKey holds the password of a
+	// PKCS #12 client certificate, if any
+	AzureKeyVaultCredentialClientCertificatePasswordKey = "clientCertificatePassword"
+
+	// AzureKeyVaultCredentialFederatedTokenKey holds a federated token (JWT)
+	// trusted by the service principal
+	AzureKeyVaultCredentialFederatedTokenKey = "federatedToken"
+)
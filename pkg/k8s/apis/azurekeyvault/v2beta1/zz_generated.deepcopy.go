@@ -24,6 +24,7 @@ limitations under the License.
 package v2beta1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -46,7 +47,7 @@ func (in *AzureIdentity) DeepCopy() *AzureIdentity {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureKeyVault) DeepCopyInto(out *AzureKeyVault) {
 	*out = *in
-	out.Object = in.Object
+	in.Object.DeepCopyInto(&out.Object)
 	out.AzureIdentity = in.AzureIdentity
 	return
 }
@@ -61,9 +62,107 @@ func (in *AzureKeyVault) DeepCopy() *AzureKeyVault {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureKeyVaultCredential) DeepCopyInto(out *AzureKeyVaultCredential) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureKeyVaultCredential.
+func (in *AzureKeyVaultCredential) DeepCopy() *AzureKeyVaultCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureKeyVaultCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureKeyVaultCredential) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureKeyVaultCredentialList) DeepCopyInto(out *AzureKeyVaultCredentialList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AzureKeyVaultCredential, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureKeyVaultCredentialList.
+func (in *AzureKeyVaultCredentialList) DeepCopy() *AzureKeyVaultCredentialList {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureKeyVaultCredentialList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AzureKeyVaultCredentialList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureKeyVaultCredentialSecretRef) DeepCopyInto(out *AzureKeyVaultCredentialSecretRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureKeyVaultCredentialSecretRef.
+func (in *AzureKeyVaultCredentialSecretRef) DeepCopy() *AzureKeyVaultCredentialSecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureKeyVaultCredentialSecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureKeyVaultCredentialSpec) DeepCopyInto(out *AzureKeyVaultCredentialSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureKeyVaultCredentialSpec.
+func (in *AzureKeyVaultCredentialSpec) DeepCopy() *AzureKeyVaultCredentialSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureKeyVaultCredentialSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureKeyVaultObject) DeepCopyInto(out *AzureKeyVaultObject) {
 	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(AzureKeyVaultObjectSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -77,6 +176,36 @@ func (in *AzureKeyVaultObject) DeepCopy() *AzureKeyVaultObject {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureKeyVaultObjectSelector) DeepCopyInto(out *AzureKeyVaultObjectSelector) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.KeyMapping != nil {
+		in, out := &in.KeyMapping, &out.KeyMapping
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureKeyVaultObjectSelector.
+func (in *AzureKeyVaultObjectSelector) DeepCopy() *AzureKeyVaultObjectSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureKeyVaultObjectSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureKeyVaultOutput) DeepCopyInto(out *AzureKeyVaultOutput) {
 	*out = *in
@@ -196,8 +325,13 @@ func (in *AzureKeyVaultSecretList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureKeyVaultSecretSpec) DeepCopyInto(out *AzureKeyVaultSecretSpec) {
 	*out = *in
-	out.Vault = in.Vault
+	in.Vault.DeepCopyInto(&out.Vault)
 	in.Output.DeepCopyInto(&out.Output)
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	return
 }
 
@@ -215,6 +349,22 @@ func (in *AzureKeyVaultSecretSpec) DeepCopy() *AzureKeyVaultSecretSpec {
 func (in *AzureKeyVaultSecretStatus) DeepCopyInto(out *AzureKeyVaultSecretStatus) {
 	*out = *in
 	in.LastAzureUpdate.DeepCopyInto(&out.LastAzureUpdate)
+	if in.ObjectExpires != nil {
+		in, out := &in.ObjectExpires, &out.ObjectExpires
+		*out = (*in).DeepCopy()
+	}
+	if in.ObjectVersions != nil {
+		in, out := &in.ObjectVersions, &out.ObjectVersions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
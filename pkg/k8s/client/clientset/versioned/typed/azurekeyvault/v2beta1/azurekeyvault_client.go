@@ -29,6 +29,7 @@ import (
 
 type AzureKeyVaultV2beta1Interface interface {
 	RESTClient() rest.Interface
+	AzureKeyVaultCredentialsGetter
 	AzureKeyVaultSecretsGetter
 }
 
@@ -37,6 +38,10 @@ type AzureKeyVaultV2beta1Client struct {
 	restClient rest.Interface
 }
 
+func (c *AzureKeyVaultV2beta1Client) AzureKeyVaultCredentials(namespace string) AzureKeyVaultCredentialInterface {
+	return newAzureKeyVaultCredentials(c, namespace)
+}
+
 func (c *AzureKeyVaultV2beta1Client) AzureKeyVaultSecrets(namespace string) AzureKeyVaultSecretInterface {
 	return newAzureKeyVaultSecrets(c, namespace)
 }
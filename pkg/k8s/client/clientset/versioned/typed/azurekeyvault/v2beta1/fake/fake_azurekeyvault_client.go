@@ -31,6 +31,10 @@ type FakeAzureKeyVaultV2beta1 struct {
 	*testing.Fake
 }
 
+func (c *FakeAzureKeyVaultV2beta1) AzureKeyVaultCredentials(namespace string) v2beta1.AzureKeyVaultCredentialInterface {
+	return &FakeAzureKeyVaultCredentials{c, namespace}
+}
+
 func (c *FakeAzureKeyVaultV2beta1) AzureKeyVaultSecrets(namespace string) v2beta1.AzureKeyVaultSecretInterface {
 	return &FakeAzureKeyVaultSecrets{c, namespace}
 }
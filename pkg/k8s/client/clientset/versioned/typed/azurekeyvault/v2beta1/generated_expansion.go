@@ -21,4 +21,6 @@ limitations under the License.
 
 package v2beta1
 
+type AzureKeyVaultCredentialExpansion interface{}
+
 type AzureKeyVaultSecretExpansion interface{}
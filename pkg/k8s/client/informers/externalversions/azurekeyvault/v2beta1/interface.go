@@ -27,6 +27,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// AzureKeyVaultCredentials returns a AzureKeyVaultCredentialInformer.
+	AzureKeyVaultCredentials() AzureKeyVaultCredentialInformer
 	// AzureKeyVaultSecrets returns a AzureKeyVaultSecretInformer.
 	AzureKeyVaultSecrets() AzureKeyVaultSecretInformer
 }
@@ -42,6 +44,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// AzureKeyVaultCredentials returns a AzureKeyVaultCredentialInformer.
+func (v *version) AzureKeyVaultCredentials() AzureKeyVaultCredentialInformer {
+	return &azureKeyVaultCredentialInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // AzureKeyVaultSecrets returns a AzureKeyVaultSecretInformer.
 func (v *version) AzureKeyVaultSecrets() AzureKeyVaultSecretInformer {
 	return &azureKeyVaultSecretInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
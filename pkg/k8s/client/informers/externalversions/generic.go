@@ -71,6 +71,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 		return &genericInformer{resource: resource.GroupResource(), informer: f.AzureKeyVault().V2alpha1().AzureKeyVaultSecrets().Informer()}, nil
 
 		// Group=spv.no, Version=v2beta1
+	case v2beta1.SchemeGroupVersion.WithResource("azurekeyvaultcredentials"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.AzureKeyVault().V2beta1().AzureKeyVaultCredentials().Informer()}, nil
 	case v2beta1.SchemeGroupVersion.WithResource("azurekeyvaultsecrets"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.AzureKeyVault().V2beta1().AzureKeyVaultSecrets().Informer()}, nil
 
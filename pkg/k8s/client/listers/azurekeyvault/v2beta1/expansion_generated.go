@@ -21,6 +21,14 @@ limitations under the License.
 
 package v2beta1
 
+// AzureKeyVaultCredentialListerExpansion allows custom methods to be added to
+// AzureKeyVaultCredentialLister.
+type AzureKeyVaultCredentialListerExpansion interface{}
+
+// AzureKeyVaultCredentialNamespaceListerExpansion allows custom methods to be added to
+// AzureKeyVaultCredentialNamespaceLister.
+type AzureKeyVaultCredentialNamespaceListerExpansion interface{}
+
 // AzureKeyVaultSecretListerExpansion allows custom methods to be added to
 // AzureKeyVaultSecretLister.
 type AzureKeyVaultSecretListerExpansion interface{}